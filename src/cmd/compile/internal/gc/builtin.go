@@ -149,6 +149,7 @@ var runtimeDecls = [...]struct {
 	{"racewriterange", funcTag, 114},
 	{"msanread", funcTag, 114},
 	{"msanwrite", funcTag, 114},
+	{"gosecureRegister", funcTag, 7},
 	{"support_popcnt", varTag, 11},
 	{"support_sse41", varTag, 11},
 }
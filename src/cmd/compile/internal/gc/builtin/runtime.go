@@ -194,6 +194,9 @@ func racewriterange(addr, size uintptr)
 func msanread(addr, size uintptr)
 func msanwrite(addr, size uintptr)
 
+// gosecure targets
+func gosecureRegister(fn interface{})
+
 // architecture variants
 var support_popcnt bool
 var support_sse41 bool
@@ -962,6 +962,9 @@ func (n *Node) stmtfmt(s fmt.State, mode fmtMode) {
 	case OPROC:
 		mode.Fprintf(s, "go %v", n.Left)
 
+	case OGOSECURE:
+		mode.Fprintf(s, "gosecure %v", n.Left)
+
 	case ODEFER:
 		mode.Fprintf(s, "defer %v", n.Left)
 
@@ -1183,6 +1186,7 @@ var opprec = []int{
 	OFALL:       -1,
 	OFOR:        -1,
 	OFORUNTIL:   -1,
+	OGOSECURE:   -1,
 	OGOTO:       -1,
 	OIF:         -1,
 	OLABEL:      -1,
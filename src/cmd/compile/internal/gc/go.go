@@ -279,6 +279,7 @@ var (
 	Newproc,
 	Deferproc,
 	Gosecload,
+	GosecloadResult,
 	Deferreturn,
 	Duffcopy,
 	Duffzero,
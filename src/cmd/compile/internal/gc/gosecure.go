@@ -1,13 +1,20 @@
 package gc
 
+import (
+	"cmd/compile/internal/types"
+	"fmt"
+)
+
 // Global variables for the  current state.
 
-// Contains the k: fndcl, v: list of call sites.
-var targetMap map[*Node]Nodes
+// gosecureInit contains the registration of the targets of the gosecure calls
+// of the package. fninit adds it to the init function of the package, so that
+// the enclave, which links the same packages, can find them.
+var gosecureInit []*Node
 
 // Generic functions that I can instrument.
 
-// genwalker is a generic walker for the Node type thgosecure callat visits all the children.
+// genwalker is a generic walker for the Node type that visits all the children.
 // It takes as parameter a cond func that decides whether or not to apply
 // the act function on the node.
 func genwalker(n *Node, cond func(n *Node) bool, act func(n *Node)) {
@@ -43,61 +50,170 @@ func isGosecureNode(n *Node) bool {
 	return n.Op == OGOSECURE
 }
 
-// findGosecureDef finds the callee of a gosecure node n.
-func findGosecureDef(n *Node) {
-	if n == nil {
-		return
+// findGosecureDef returns the function value of the callee of a gosecure
+// node n, i.e., a function of any package, a method expression or the
+// function of a literal. The callee must be static, as the enclave looks it
+// up by name.
+func findGosecureDef(n *Node) *Node {
+	call := n.Left
+	switch call.Op {
+	case OCALLFUNC:
+		fn := call.Left
+		switch {
+		case fn.Op == ONAME && fn.Class() == PFUNC:
+			return fn
+		case fn.Op == OCLOSURE:
+			// The captured variables would be shared across domains.
+			if !hasemptycvars(fn) {
+				yyerrorl(n.Pos, "function literal in gosecure cannot capture variables")
+				return nil
+			}
+			return fn.Func.Closure.Func.Nname
+		}
+	case OCALLMETH:
+		// The receiver is the first argument of the method expression,
+		// the same symbol is called by gosecure, see (*state).call.
+		fn := call.Left
+		m := newname(fn.Sym)
+		m.SetClass(PFUNC)
+		m.Type = methodfunc(fn.Type, fn.Type.Recv().Type)
+		m.SetTypecheck(1)
+		return m
+	case OCALLINTER:
+		yyerrorl(n.Pos, "gosecure target cannot be an interface method: %v", call)
+		return nil
 	}
+	yyerrorl(n.Pos, "gosecure target must be a function, a method or a function literal: %v", call)
+	return nil
+}
 
-	if _, ok := targetMap[n]; ok {
-		yyerror("OGOSECURE node already in the map.")
+// gosecureArgs checks that the arguments of the call of the gosecure node n
+// can cross the enclave boundary. gosecommon copies them deeply into the
+// domain of the callee, and the elements sent on their channels too, but it
+// cannot copy what a func or an unsafe.Pointer refers to. An interface
+// crosses if the concrete type of its value is registered in both domains
+// with gosecommon.Register, which only the copy can check: the call then
+// fails with a gosecommon.CopyError in Err, and a send on a channel panics
+// with it.
+func gosecureArgs(n *Node) {
+	t := n.Left.Left.Type
+	if t == nil || t.Etype != TFUNC {
 		return
 	}
+	if n.Left.Op == OCALLMETH {
+		gosecureArg(n, "receiver", t.Recv().Type)
+	}
+	for i, f := range t.Params().FieldSlice() {
+		gosecureArg(n, fmt.Sprintf("arg %d", i+1), f.Type)
+	}
+}
 
-	if n.Left == nil || n.Left.Left == nil || n.Left.Left.Name == nil {
-		yyerror("OCALLFUNC or ONAME or Name node is nil in gosecure.")
+// gosecureArg reports the first part of the argument arg of type t that
+// cannot cross the enclave boundary, see gosecureArgs.
+func gosecureArg(n *Node, arg string, t *types.Type) {
+	path, what := gosecureCrossing(t, "", make(map[*types.Type]bool))
+	switch {
+	case what == "":
 		return
+	case path == "":
+		yyerrorl(n.Pos, "gosecure argument cannot cross the enclave boundary: %s is %s", arg, what)
+	case path[0] == '[' || path[0] == '<':
+		yyerrorl(n.Pos, "gosecure argument cannot cross the enclave boundary: %s: element %s is %s", arg, path, what)
+	default:
+		yyerrorl(n.Pos, "gosecure argument cannot cross the enclave boundary: %s: field %s is %s", arg, path, what)
 	}
+}
 
-	defn := n.Left.Left.Name.Defn
-	if defn == nil {
-		//The function is from another package.
-		yyerror("Target of gosecure is in another package.")
+// gosecureCrossing returns the path, from a value of type t, to the first
+// part of it that cannot cross the enclave boundary, and what that part is,
+// or "" if it can cross. The path is made of the names of the fields, [] for
+// the elements of arrays and slices, and the keys and elements of maps, and <-
+// for the elements of channels. The types in seen are being checked, or can
+// cross.
+func gosecureCrossing(t *types.Type, path string, seen map[*types.Type]bool) (string, string) {
+	if t == nil || seen[t] {
+		return "", ""
+	}
+	seen[t] = true
+	switch t.Etype {
+	case TFUNC:
+		return path, "a func"
+	case TUNSAFEPTR:
+		return path, "an unsafe.Pointer"
+	case TPTR32, TPTR64:
+		// The pointer is copied with what it points to.
+		return gosecureCrossing(t.Elem(), path, seen)
+	case TARRAY, TSLICE:
+		return gosecureCrossing(t.Elem(), path+"[]", seen)
+	case TMAP:
+		if p, what := gosecureCrossing(t.Key(), path+"[]", seen); what != "" {
+			return p, what
+		}
+		return gosecureCrossing(t.Val(), path+"[]", seen)
+	case TCHAN:
+		// The channel is shared, the elements sent on it cross.
+		return gosecureCrossing(t.Elem(), path+"<-", seen)
+	case TSTRUCT:
+		for _, f := range t.Fields().Slice() {
+			p := f.Sym.Name
+			if path != "" {
+				p = path + "." + p
+			}
+			if p, what := gosecureCrossing(f.Type, p, seen); what != "" {
+				return p, what
+			}
+		}
 	}
-	entry := targetMap[defn]
-	entry.Append(n)
+	return "", ""
 }
 
-// getCopy highjacks the inliner mechanism to generate a copy of the node.
-func getCopy(n *Node) *Node {
-	return inlcopy(n)
+// gosecureResult returns the type of a gosecure expression calling a function
+// of type t: a receive-only channel of a struct with the results R0, ..., Rn-1
+// of the call, and Err, which holds the panic of the call if any, or the
+// gosecommon.CopyError of the arguments or results that cannot cross.
+func gosecureResult(t *types.Type) *types.Type {
+	var l []*Node
+	for i, r := range t.Results().FieldSlice() {
+		l = append(l, namedfield(fmt.Sprintf("R%d", i), r.Type))
+	}
+	l = append(l, namedfield("Err", types.Errortype))
+	return types.NewChan(tostruct(l), types.Crecv)
 }
 
-// Non generic version of the walker
-
-func gosecureWalker(n *Node) {
-	genwalker(n, isGosecureNode, findGosecureDef)
+// gosecInit returns a call to the init function of gosec, which serves the
+// gosecure calls of the package even if the package does not import it.
+// gosec initializes itself once.
+func gosecInit() *Node {
+	n := newname(Gosecpkg.Lookup("init"))
+	n.SetClass(PFUNC)
+	n.Type = functype(nil, nil, nil)
+	return nod(OCALL, n, nil)
 }
 
-// findSecureNodes calls the walker on the ttop nodes.
+// GosecurePhase checks the gosecure calls in ttop and their arguments, and
+// registers their targets in gosecureInit. It must run after capturevars.
 func GosecurePhase(ttop []*Node) {
-	if targetMap != nil {
-		yyerror("The target map wasn't nil before starting.")
+	seen := make(map[*types.Sym]bool)
+	register := func(n *Node) {
+		def := findGosecureDef(n)
+		if def == nil {
+			return
+		}
+		gosecureArgs(n)
+		if seen[def.Sym] {
+			return
+		}
+		seen[def.Sym] = true
+		call := nod(OCALL, syslook("gosecureRegister"), nil)
+		call.List.Set1(def)
+		gosecureInit = append(gosecureInit, call)
 	}
-
-	targetMap = make(map[*Node]Nodes)
 	for _, n := range ttop {
-		gosecureWalker(n)
+		genwalker(n, isGosecureNode, register)
 	}
-
-	// At that point we need to replace the targetMap with a copy.
-	// TODO aghosn check memory and liveliness for code below.
-	replacement := make(map[*Node]Nodes)
-	for k, v := range targetMap {
-		replacement[getCopy(k)] = v
+	// gosecure calls gosec.Gosecload, make sure the linker loads gosec
+	// even if the package does not import it.
+	if len(seen) > 0 {
+		Ctxt.AddImport("gosec")
 	}
-	targetMap = replacement
-
-	// Package the code.
-	// /enclpkg := obj.NewPkg("go.enclave", "enclave")
 }
@@ -0,0 +1,105 @@
+package gc
+
+import (
+	"fmt"
+	"internal/testenv"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const gosecureArgsSrc = `package main
+
+import "unsafe"
+
+type Config struct {
+	Name  string
+	Cache map[string]int
+	Hook  func(string)
+}
+
+type Settings struct {
+	N   int
+	Cfg Config
+}
+
+type List struct {
+	Next  *List
+	Items []struct{ F func() }
+}
+
+type Tree struct {
+	Left, Right *Tree
+	Keys        [4]struct{ S string }
+	Out         chan *Tree
+	Attrs       map[string]interface{}
+}
+
+type T struct {
+	M map[int]int
+	P unsafe.Pointer
+}
+
+func (t T) Run() {}
+
+func settings(n int, s Settings)          {}
+func pointer(p unsafe.Pointer)            {}
+func list(l *List)                        {}
+func index(m map[string][]unsafe.Pointer) {}
+func tree(t *Tree, c chan []byte)         {}
+func iface(c chan interface{}, err error) {}
+
+func result(err error, v interface{}) (interface{}, error) {
+	return v, err
+}
+
+func main() {
+	gosecure settings(1, Settings{}) // arg 2: field Cfg.Hook is a func
+	gosecure pointer(nil)            // arg 1 is an unsafe.Pointer
+	gosecure list(nil)               // arg 1: field Items[].F is a func
+	gosecure index(nil)              // arg 1: element [][] is an unsafe.Pointer
+	gosecure T{}.Run()               // receiver: field P is an unsafe.Pointer
+	gosecure func(f func()) {}(nil)  // arg 1 is a func
+	gosecure tree(nil, nil)
+	gosecure iface(nil, nil)
+	gosecure result(nil, 1)
+	_ = <-gosecure result(nil, nil)
+	gosecure func(m map[int]int) {}(nil)
+}
+`
+
+// TestGosecureArgs checks that the compiler rejects the gosecure calls whose
+// arguments cannot cross the enclave boundary, and names the culprit. The
+// maps and the interfaces of the last calls can cross, in the arguments and
+// in the results.
+func TestGosecureArgs(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	dir, err := ioutil.TempDir("", "gosecure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "x.go")
+	if err := ioutil.WriteFile(src, []byte(gosecureArgsSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(testenv.GoToolPath(t), "tool", "compile", "-o", filepath.Join(dir, "x.o"), "x.go")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("compilation succeeded:\n%s", out)
+	}
+	// The comments of the gosecure calls are their errors.
+	var want []string
+	for i, l := range strings.Split(gosecureArgsSrc, "\n") {
+		if j := strings.Index(l, "// "); j >= 0 && strings.Contains(l, "gosecure ") {
+			want = append(want, fmt.Sprintf("x.go:%d:2: gosecure argument cannot cross the enclave boundary: %s", i+1, l[j+3:]))
+		}
+	}
+	if got := strings.TrimSpace(string(out)); got != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+}
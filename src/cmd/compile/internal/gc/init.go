@@ -51,6 +51,11 @@ func anyinit(n []*Node) bool {
 		}
 	}
 
+	// are there any gosecure targets
+	if len(gosecureInit) > 0 {
+		return true
+	}
+
 	// then none
 	return false
 }
@@ -68,7 +73,9 @@ func anyinit(n []*Node) bool {
 //              initdone· = 1                           (5)
 //              // over all matching imported symbols
 //                      <pkg>.init()                    (6)
+//              gosec.init() // if gosecure targets     (6a)
 //              { <init stmts> }                        (7)
+//              gosecureRegister(<target>) // if any    (7a)
 //              init.<n>() // if any                    (8)
 //              initdone· = 2                           (9)
 //              return                                  (10)
@@ -123,9 +130,17 @@ func fninit(n []*Node) {
 		}
 	}
 
+	// (6a)
+	if len(gosecureInit) > 0 {
+		r = append(r, gosecInit())
+	}
+
 	// (7)
 	r = append(r, nf...)
 
+	// (7a)
+	r = append(r, gosecureInit...)
+
 	// (8)
 
 	// maxInlineInitCalls is the threshold at which we switch
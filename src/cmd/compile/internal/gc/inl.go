@@ -334,6 +334,7 @@ func (v *hairyVisitor) visit(n *Node) bool {
 		OSELECT,
 		OTYPESW,
 		OPROC,
+		OGOSECURE,
 		ODEFER,
 		ODCLTYPE, // can't print yet
 		OBREAK,
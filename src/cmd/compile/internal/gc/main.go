@@ -539,13 +539,19 @@ func Main(archInit func(*Arch)) {
 
 	Curfn = nil
 
+	// Phase 4.5: Check the arguments of gosecure calls and register
+	// their targets.
+	// This needs to run after capturevars, function literals
+	// that capture variables cannot be gosecure targets.
+	timings.Start("fe", "gosecure")
+	GosecurePhase(xtop)
+
 	if nsavederrors+nerrors != 0 {
 		errorexit()
 	}
 
 	//TODO aghosn
 	//aghosnInspect(xtop)
-	//GosecurePhase(xtop)
 
 	// Phase 5: Inlining
 	timings.Start("fe", "inlining")
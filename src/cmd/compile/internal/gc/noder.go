@@ -557,6 +557,8 @@ func (p *noder) expr(expr syntax.Expr) *Node {
 			return p.nod(expr, p.unOp(expr.Op), x, nil)
 		}
 		return p.nod(expr, p.binOp(expr.Op), x, p.expr(expr.Y))
+	case *syntax.GosecureExpr:
+		return p.nod(expr, OGOSECURE, p.expr(expr.Call), nil)
 	case *syntax.CallExpr:
 		n := p.nod(expr, OCALL, p.expr(expr.Fun), nil)
 		n.List.Set(p.exprs(expr.ArgList))
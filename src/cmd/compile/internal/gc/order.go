@@ -1195,6 +1195,20 @@ func orderexpr(n *Node, order *Order, lhs *Node) *Node {
 		n.Left = orderexpr(n.Left, order, nil)
 		n = ordercopyexpr(n, n.Type, order, 1)
 
+	// gosecure f(x) as an expression becomes
+	//	ch := make(chan T, 1)
+	//	gosecure f(x) // results sent on ch
+	// and evaluates to ch.
+	case OGOSECURE:
+		ch := ordertemp(types.NewChan(n.Type.Elem(), types.Cboth), order, false)
+		mk := nod(OMAKE, nil, nil)
+		mk.List.Set2(typenod(ch.Type), nodintconst(1))
+		orderstmt(typecheck(nod(OAS, ch, mk), Etop), order)
+		call := nod(OGOSECURE, n.Left, ch)
+		call.SetTypecheck(1)
+		orderstmt(call, order)
+		n = conv(ch, n.Type)
+
 	case OEQ, ONE:
 		n.Left = orderexpr(n.Left, order, nil)
 		n.Right = orderexpr(n.Right, order, nil)
@@ -80,6 +80,7 @@ func initssaconfig() {
 	Newproc = sysfunc("newproc")
 	Deferproc = sysfunc("deferproc")
 	Gosecload = Gosecpkg.Lookup("Gosecload").Linksym()
+	GosecloadResult = Gosecpkg.Lookup("GosecloadResult").Linksym()
 	Deferreturn = sysfunc("deferreturn")
 	Duffcopy = sysfunc("duffcopy")
 	Duffzero = sysfunc("duffzero")
@@ -639,8 +640,11 @@ func (s *state) stmt(n *Node) {
 		s.call(n.Left, callGo)
 
 	case OGOSECURE:
-		//TODO aghosn For the moment make it a go routine.
-		s.call(n.Left, callGosecure)
+		if n.Right != nil {
+			s.call(n.Left, callGosecureResult)
+		} else {
+			s.call(n.Left, callGosecure)
+		}
 
 	case OAS2DOTTYPE:
 		res, resok := s.dottype(n.Rlist.First(), true)
@@ -2606,6 +2610,7 @@ const (
 	callDefer
 	callGo
 	callGosecure
+	callGosecureResult
 )
 
 type sfRtCallDef struct {
@@ -3421,6 +3426,10 @@ func (s *state) call(n *Node, k callKind) *ssa.Value {
 		//TODO @aghosn here we can pass the shitty shit to gosecure. Write an ID instead?
 		s.vars[&memVar] = s.newValue3A(ssa.OpStore, types.TypeMem, types.Types[TUINTPTR], addr, closure, s.mem())
 		stksize += 2 * int64(Widthptr)
+		if k == callGosecureResult {
+			// The result channel, stored with the arguments.
+			stksize += int64(Widthptr)
+		}
 	}
 
 	// call target
@@ -3432,7 +3441,8 @@ func (s *state) call(n *Node, k callKind) *ssa.Value {
 		call = s.newValue1A(ssa.OpStaticCall, types.TypeMem, Newproc, s.mem())
 	case k == callGosecure:
 		call = s.newValue1A(ssa.OpStaticCall, types.TypeMem, Gosecload, s.mem())
-		//call = s.newValue1A(ssa.OpStaticCall, types.TypeMem, Newproc, s.mem())
+	case k == callGosecureResult:
+		call = s.newValue1A(ssa.OpStaticCall, types.TypeMem, GosecloadResult, s.mem())
 	case closure != nil:
 		codeptr = s.newValue2(ssa.OpLoad, types.Types[TUINTPTR], closure, s.mem())
 		call = s.newValue3(ssa.OpClosureCall, types.TypeMem, codeptr, closure, s.mem())
@@ -2026,6 +2026,11 @@ func typecheck1(n *Node, top int) *Node {
 		ok |= Etop
 		n.Left = typecheck(n.Left, Etop|Erv)
 		checkdefergo(n)
+		// As an expression, gosecure f(x) is the channel of the results of f.
+		if top&Erv != 0 && n.Left.Left != nil && n.Left.Left.Type != nil && n.Left.Left.Type.Etype == TFUNC {
+			ok |= Erv
+			n.Type = gosecureResult(n.Left.Left.Type)
+		}
 
 	case OFOR, OFORUNTIL:
 		ok |= Etop
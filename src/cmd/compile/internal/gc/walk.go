@@ -290,14 +290,26 @@ func walkstmt(n *Node) *Node {
 
 	case OGOSECURE:
 		switch n.Left.Op {
-		case OCALLFUNC:
+		case OCALLFUNC, OCALLMETH:
 			n.Left = walkexpr(n.Left, &n.Ninit)
 		default:
 			Dump("nottop", n)
 		}
 
-		// make roo for size & fn arguments.
-		adjustargs(n, 2*Widthptr)
+		// make room for size & fn arguments, and the result channel if any.
+		if n.Right == nil {
+			adjustargs(n, 2*Widthptr)
+			break
+		}
+		adjustargs(n, 3*Widthptr)
+		res := nod(OINDREGSP, nil, nil)
+		res.Type = n.Right.Type
+		res.Xoffset = Ctxt.FixedFrameSize() + 2*int64(Widthptr)
+		res.SetTypecheck(1)
+		res.SetAddrtaken(true)
+		a := nod(OAS, res, n.Right)
+		a.SetTypecheck(1)
+		n.Left.List.Append(a)
 
 	case ORETURN:
 		walkexprlist(n.List.Slice(), &n.Ninit)
@@ -220,6 +220,12 @@ type (
 		expr
 	}
 
+	// gosecure Call
+	GosecureExpr struct {
+		Call *CallExpr
+		expr
+	}
+
 	// ElemList[0], ElemList[1], ...
 	ListExpr struct {
 		ElemList []Expr
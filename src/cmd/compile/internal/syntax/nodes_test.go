@@ -113,6 +113,9 @@ var exprs = []test{
 	{"CallExpr", `obj.f@(1, 2, 3)`},
 	{"CallExpr", `func(x int) int { return x + 1 }@(y)`},
 
+	{"GosecureExpr", `@gosecure f(x)`},
+	{"GosecureExpr", `@gosecure obj.f(1, 2, 3)`},
+
 	// ListExpr: tested via multi-value const/var declarations
 }
 
@@ -186,6 +189,7 @@ var stmts = []test{
 
 	{"CallStmt", `@defer f()`},
 	{"CallStmt", `@go f()`},
+	{"CallStmt", `@gosecure f()`},
 
 	{"ReturnStmt", `@return`},
 	{"ReturnStmt", `@return x`},
@@ -619,6 +619,13 @@ func (p *parser) unaryExpr() Expr {
 			return x
 		}
 
+	case _Gosecure:
+		// gosecure call as an expression, the channel of its results
+		x := new(GosecureExpr)
+		x.pos = p.pos()
+		x.Call = p.callStmt().Call
+		return x
+
 	case _Arrow:
 		// receive op (<-x) or receive-only channel (<-chan E)
 		pos := p.pos()
@@ -691,7 +698,7 @@ func (p *parser) callStmt() *CallStmt {
 
 	s := new(CallStmt)
 	s.pos = p.pos()
-	s.Tok = p.tok // _Defer or _Go
+	s.Tok = p.tok // _Defer, _Go or _Gosecure
 	p.next()
 
 	x := p.pexpr(p.tok == _Lparen) // keep_parens so we can report error below
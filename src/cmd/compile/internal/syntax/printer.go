@@ -401,6 +401,9 @@ func (p *printer) printRawNode(n Node) {
 		}
 		p.print(_Rparen)
 
+	case *GosecureExpr:
+		p.print(_Gosecure, blank, n.Call)
+
 	case *CallExpr:
 		p.print(n.Fun, _Lparen)
 		p.printExprList(n.ArgList)
@@ -0,0 +1,16 @@
+/*
+Enclavemeasure computes the measurement (MRENCLAVE) of the enclave embedded
+in a binary built with gosecure routines, without SGX hardware.
+
+Usage:
+	go tool enclavemeasure [-q] binary
+
+It prints the MRENCLAVE and the layout of the enclave, in the order pages are
+added by the loader. Each page whose content is measured is listed with the
+sha256 of its content. Consecutive pages whose content is not measured, e.g.,
+stacks and heap, are listed as a single range. Diffing the output for two
+builds shows which pages explain a different measurement.
+
+With -q, only the MRENCLAVE is printed.
+*/
+package main
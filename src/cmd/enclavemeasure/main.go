@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"gosec"
+	"log"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: go tool enclavemeasure [-q] binary\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+var qflag = flag.Bool("q", false, "only print the measurement")
+
+// Secinfo flags (ref 38.11).
+const (
+	secinfoR   = 0x1
+	secinfoW   = 0x2
+	secinfoX   = 0x4
+	secinfoTCS = 0x100
+)
+
+func main() {
+	log.SetPrefix("enclavemeasure: ")
+	log.SetFlags(0)
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+	}
+
+	encl, err := gosec.ReadEnclave(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	m, err := gosec.MeasureEnclave(encl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("mrenclave: %x\n", m.MrEnclave)
+	if *qflag {
+		return
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	fmt.Fprintf(w, "base: %#x size: %#x pages: %d\n", m.Base, m.Size, len(m.Pages))
+	for i := 0; i < len(m.Pages); {
+		p := m.Pages[i]
+		if p.Extended {
+			fmt.Fprintf(w, "%#010x %6d %s %s %x\n", p.Offset, 1, flags(p.Flags), p.Region, p.Digest)
+			i++
+			continue
+		}
+		// Merge the following unmeasured pages of the same region.
+		j := i + 1
+		for ; j < len(m.Pages); j++ {
+			q := m.Pages[j]
+			if q.Extended || q.Region != p.Region || q.Flags != p.Flags || q.Offset != p.Offset+uint64(j-i)*0x1000 {
+				break
+			}
+		}
+		fmt.Fprintf(w, "%#010x %6d %s %s -\n", p.Offset, j-i, flags(p.Flags), p.Region)
+		i = j
+	}
+}
+
+func flags(f uint64) string {
+	if f&secinfoTCS != 0 {
+		return "tcs"
+	}
+	b := []byte("---")
+	if f&secinfoR != 0 {
+		b[0] = 'r'
+	}
+	if f&secinfoW != 0 {
+		b[1] = 'w'
+	}
+	if f&secinfoX != 0 {
+		b[2] = 'x'
+	}
+	return string(b)
+}
@@ -0,0 +1,29 @@
+/*
+Enclavesign signs the enclave embedded in a binary built with gosecure
+routines, producing the SIGSTRUCT checked by EINIT.
+
+Usage:
+	go tool enclavesign genkey [-out key.pem]
+	go tool enclavesign sign -key key.pem [-out file] binary
+	go tool enclavesign gendata [-out file] binary
+	go tool enclavesign catsig -data file -sig file -pubkey pub.pem [-out file] binary
+	go tool enclavesign verify [-sig file] [-pubkey pub.pem] binary
+
+Keys are RSA-3072 keys with public exponent 3, in PEM format, e.g., generated
+by genkey or by "openssl genrsa -3 3072".
+
+The sign command measures the enclave (MRENCLAVE) and signs its SIGSTRUCT with
+the private key. The result is written next to the binary, in binary.sigstruct,
+where the loader looks for it. GOSEC_SIGSTRUCT overrides that location at load
+time. An unsigned enclave loads on hardware only if GOSEC_DEV_KEY names a key,
+e.g., generated by genkey, with which the loader signs it.
+
+When the key is not available locally, e.g., in an HSM, gendata exports the
+data to sign. The data must be signed with RSA PKCS#1 v1.5 and SHA-256, and the
+big endian signature imported with catsig, along with the public key.
+
+The verify command checks the signature of a SIGSTRUCT and that it matches the
+enclave of the binary. With -pubkey, it also checks the signer. It prints
+MRENCLAVE and MRSIGNER.
+*/
+package main
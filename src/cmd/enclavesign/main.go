@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"gosec"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: go tool enclavesign genkey [-out key.pem]
+       go tool enclavesign sign -key key.pem [-out file] binary
+       go tool enclavesign gendata [-out file] binary
+       go tool enclavesign catsig -data file -sig file -pubkey pub.pem [-out file] binary
+       go tool enclavesign verify [-sig file] [-pubkey pub.pem] binary
+`)
+	os.Exit(2)
+}
+
+func main() {
+	log.SetPrefix("enclavesign: ")
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "genkey":
+		genkey(args)
+	case "sign":
+		sign(args)
+	case "gendata":
+		gendata(args)
+	case "catsig":
+		catsig(args)
+	case "verify":
+		verify(args)
+	default:
+		usage()
+	}
+}
+
+// parseFlags parses the flags of cmd and returns the binary, if nbin is set.
+func parseFlags(fs *flag.FlagSet, args []string, nbin bool) string {
+	fs.Usage = usage
+	fs.Parse(args)
+	if !nbin {
+		if fs.NArg() != 0 {
+			usage()
+		}
+		return ""
+	}
+	if fs.NArg() != 1 {
+		usage()
+	}
+	return fs.Arg(0)
+}
+
+func genkey(args []string) {
+	fs := flag.NewFlagSet("genkey", flag.ExitOnError)
+	out := fs.String("out", "key.pem", "output `file`")
+	parseFlags(fs, args, false)
+	key, err := gosec.GenerateSigningKey(rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	write(*out, b, 0600)
+}
+
+func sign(args []string) {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	keyf := fs.String("key", "", "private key `file`")
+	out := fs.String("out", "", "output `file` (default binary.sigstruct)")
+	bin := parseFlags(fs, args, true)
+	if *keyf == "" {
+		usage()
+	}
+	key, err := readPrivateKey(*keyf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := newSigStruct(bin)
+	if err := s.Sign(key); err != nil {
+		log.Fatal(err)
+	}
+	write(outPath(*out, bin, gosec.SigStructSuffix), s.Bytes(), 0644)
+}
+
+func gendata(args []string) {
+	fs := flag.NewFlagSet("gendata", flag.ExitOnError)
+	out := fs.String("out", "", "output `file` (default binary.sigdata)")
+	bin := parseFlags(fs, args, true)
+	s := newSigStruct(bin)
+	write(outPath(*out, bin, ".sigdata"), s.SigningData(), 0644)
+}
+
+func catsig(args []string) {
+	fs := flag.NewFlagSet("catsig", flag.ExitOnError)
+	dataf := fs.String("data", "", "signing data `file` produced by gendata")
+	sigf := fs.String("sig", "", "signature `file`")
+	pubf := fs.String("pubkey", "", "public key `file`")
+	out := fs.String("out", "", "output `file` (default binary.sigstruct)")
+	bin := parseFlags(fs, args, true)
+	if *dataf == "" || *sigf == "" || *pubf == "" {
+		usage()
+	}
+	pub, err := readPublicKey(*pubf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := newSigStruct(bin)
+	if err := s.SetSigningData(read(*dataf)); err != nil {
+		log.Fatal(err)
+	}
+	if err := s.SetSignature(pub, read(*sigf)); err != nil {
+		log.Fatal(err)
+	}
+	write(outPath(*out, bin, gosec.SigStructSuffix), s.Bytes(), 0644)
+}
+
+func verify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	sigf := fs.String("sig", "", "sigstruct `file` (default binary.sigstruct)")
+	pubf := fs.String("pubkey", "", "expected public key `file`")
+	bin := parseFlags(fs, args, true)
+	s, err := gosec.ParseSigStruct(read(outPath(*sigf, bin, gosec.SigStructSuffix)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := s.Verify(); err != nil {
+		log.Fatal(err)
+	}
+	if s.MrEnclave() != newSigStruct(bin).MrEnclave() {
+		log.Fatal("the sigstruct does not match the enclave of ", bin)
+	}
+	if *pubf != "" {
+		pub, err := readPublicKey(*pubf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if pub.N.Cmp(s.PublicKey().N) != 0 || pub.E != s.PublicKey().E {
+			log.Fatal("the sigstruct is not signed by ", *pubf)
+		}
+	}
+	fmt.Printf("mrenclave: %x\nmrsigner: %x\n", s.MrEnclave(), s.MrSigner())
+}
+
+func newSigStruct(bin string) *gosec.SigStruct {
+	encl, err := gosec.ReadEnclave(bin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := gosec.NewSigStruct(encl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return s
+}
+
+func outPath(out, bin, suffix string) string {
+	if out != "" {
+		return out
+	}
+	return bin + suffix
+}
+
+func read(path string) []byte {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return b
+}
+
+func write(path string, b []byte, perm os.FileMode) {
+	if err := ioutil.WriteFile(path, b, perm); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	block, _ := pem.Decode(read(path))
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
+
+func readPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if rk, ok := k.(*rsa.PrivateKey); ok {
+			return rk, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: not an RSA private key", path)
+}
+
+// readPublicKey accepts public keys as well as private ones.
+func readPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if rk, ok := k.(*rsa.PublicKey); ok {
+			return rk, nil
+		}
+		return nil, errors.New(path + ": not an RSA public key")
+	}
+	k, err := readPrivateKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return &k.PublicKey, nil
+}
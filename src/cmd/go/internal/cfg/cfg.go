@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"runtime"
 
+	"cmd/internal/enclave"
 	"cmd/internal/objabi"
 )
 
@@ -38,8 +39,9 @@ var (
 	BuildX                 bool // -x flag
 
 	//@aghosn added for enclave relocation.
-	Relocencl bool
-	CmdName   string // "build", "install", "list", etc.
+	Relocencl   enclave.Flag // -relocencl flag, set by the build of the enclave
+	Gosecroutes string       // -gosecroutes flag, set by the build of the enclave, see gosecommon.EnclaveOf
+	CmdName     string       // "build", "install", "list", etc.
 
 	DebugActiongraph string // -debug-actiongraph flag (undocumented, unstable)
 )
@@ -98,10 +98,11 @@ type PackagePublic struct {
 	XTestImports []string `json:",omitempty"` // imports from XTestGoFiles
 
 	// Gosecure dependencies
-	Gosectargets map[string][]string `json:",omitempty"`  // this package calls the following methods.
-	Goseccallees []string            `json:", omitempty"` // the callees within that package.
-	Efile        string              `json:", omitempty"` // the enclave binary
-	Relocencl    bool                `json:", omitempty"` // false if no relocation, true if reloc
+	Gosectargets []string          `json:",omitempty"`  // callees of the gosecure calls in this package
+	Gosecenclave string            `json:",omitempty"`  // enclave of the gosecure targets in this package, from //gosec:enclave
+	Efiles       map[string]string `json:",omitempty"`  // the enclave binaries by name, "" for the default one, set before the link
+	Relocencl    string            `json:", omitempty"` // manifest of the enclave, if the package is one
+	Gosecroutes  string            `json:",omitempty"`  // enclaves of the packages of the program, see gosecommon.EnclaveOf
 }
 
 // AllFiles returns the names of all the files considered for the package.
@@ -259,6 +260,7 @@ func (p *Package) copyBuild(pp *build.Package) {
 	p.XTestImports = pp.XTestImports
 	// @aghosn copy the gosec callees. TODO(aghosn) maybe find where this is called and then do the propagation
 	p.Gosectargets = pp.Gosectargets
+	p.Gosecenclave = pp.Gosecenclave
 	if IgnoreImports {
 		p.Imports = nil
 		p.TestImports = nil
@@ -336,20 +336,9 @@ func (b *Builder) CompileAction(mode, depMode BuildMode, p *load.Package) *Actio
 		}
 
 		for _, p1 := range p.Internal.Imports {
-			if p.Gosectargets != nil {
-				if callees, ok := p.Gosectargets[p1.Name]; ok {
-					p1.Goseccallees = append(p1.Goseccallees, callees...)
-				}
-			}
 			a.Deps = append(a.Deps, b.CompileAction(depMode, depMode, p1))
 		}
 
-		if p.Name == "main" && len(p.Gosectargets) > 0 {
-			//TODO(aghosn) create my action here to generate the main
-			// and trigger the build for the enclave executable.
-			a.Deps = append(a.Deps, b.CreateEnclaveExec(p))
-		}
-
 		if p.Standard {
 			switch p.ImportPath {
 			case "builtin", "unsafe":
@@ -462,6 +451,12 @@ func (b *Builder) LinkAction(mode, depMode BuildMode, p *load.Package) *Action {
 		// dependencies of a (except a1) must have completed building and have
 		// recorded their build IDs.
 		a1.Deps = append(a1.Deps, &Action{Mode: "nop", Deps: a.Deps[1:]})
+
+		// The enclave executable is built from the main package once it
+		// compiles, and embedded by the linker.
+		if needsEnclave(p) {
+			a.Deps = append(a.Deps, b.CreateEnclaveExec(p, a1))
+		}
 		return a
 	})
 
@@ -152,7 +152,8 @@ func init() {
 	CmdBuild.Flag.BoolVar(&cfg.BuildI, "i", false, "")
 	CmdBuild.Flag.StringVar(&cfg.BuildO, "o", "", "output file")
 
-	CmdBuild.Flag.BoolVar(&cfg.Relocencl, "relocencl", false, "")
+	CmdBuild.Flag.Var(&cfg.Relocencl, "relocencl", "")
+	CmdBuild.Flag.StringVar(&cfg.Gosecroutes, "gosecroutes", "", "")
 	CmdInstall.Flag.BoolVar(&cfg.BuildI, "i", false, "")
 
 	AddBuildFlags(CmdBuild)
@@ -322,7 +323,10 @@ func runBuild(cmd *base.Command, args []string) {
 		p.Target = cfg.BuildO
 		p.Stale = true // must build - not up to date
 		p.StaleReason = "build -o flag in use"
-		p.Relocencl = cfg.Relocencl
+		if cfg.Relocencl.On {
+			p.Relocencl = cfg.Relocencl.Manifest.String()
+			p.Gosecroutes = cfg.Gosecroutes
+		}
 		a := b.AutoAction(ModeInstall, depMode, p)
 		b.Do(a)
 		return
@@ -782,6 +782,17 @@ func (b *Builder) linkActionID(a *Action) cache.ActionID {
 		}
 	}
 
+	// The layout of an enclave, and the enclave of a gosecure program.
+	if p.Relocencl != "" {
+		fmt.Fprintf(h, "relocencl %s\n", p.Relocencl)
+	}
+	for _, name := range sortedEnclaves(p.Efiles) {
+		fmt.Fprintf(h, "enclave %q %s\n", name, b.fileHash(p.Efiles[name]))
+	}
+	if p.Gosecroutes != "" {
+		fmt.Fprintf(h, "gosecroutes %s\n", p.Gosecroutes)
+	}
+
 	return h.Sum()
 }
 
@@ -460,11 +460,20 @@ func (gcToolchain) ld(b *Builder, root *Action, out, importcfg, mainpkg string)
 
 	//TODO(aghosn) adding this to handle the linking of the enclave .out file.
 	args := []interface{}{cfg.BuildToolexec, base.Tool("link"), "-o", out, "-importcfg", importcfg}
-	if root.Package.PackagePublic.Efile != "" {
-		args = append(args, "-lkenclave", root.Package.PackagePublic.Efile)
+	// The default enclave, and the enclaves named by //gosec:enclave.
+	efiles := root.Package.PackagePublic.Efiles
+	for _, name := range sortedEnclaves(efiles) {
+		if name == "" {
+			args = append(args, "-lkenclave", efiles[name])
+		} else {
+			args = append(args, "-lkenclave", name+"="+efiles[name])
+		}
+	}
+	if routes := root.Package.PackagePublic.Gosecroutes; routes != "" {
+		args = append(args, "-X", "gosecommon.routes="+routes)
 	}
-	if root.Package.PackagePublic.Relocencl {
-		args = append(args, "-relocencl")
+	if root.Package.PackagePublic.Relocencl != "" {
+		args = append(args, "-relocencl="+root.Package.PackagePublic.Relocencl)
 		args = append(args, "-E", "_encl0_amd64")
 	}
 	args = append(args, ldflags, mainpkg)
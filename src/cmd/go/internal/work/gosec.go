@@ -1,94 +1,173 @@
 package work
 
 import (
+	"cmd/go/internal/cfg"
 	"cmd/go/internal/load"
+	"cmd/internal/enclave"
+	"cmd/internal/objabi"
 	"fmt"
-	"log"
+	"io/ioutil"
 	"os"
 	"path/filepath"
-	"text/template"
+	"sort"
+	"strings"
 )
 
-type Entry struct {
-	Functions []string
-	Imports   []string
-}
-
 const (
-	gosectmpl = `
-package main
-
-import(
-	"gosecu"
-	//"runtime"
-	{{range .Imports}}
-	{{ printf "%q" . }}{{end}}
-)
+	gosecfile = "gosecure_enclave.go"
 
-func main() {
-	// Starting the functions.
+	// The enclave is the main package with this file. It registers the
+	// targets of gosecure, recorded by the init of the packages that call
+	// them and that run in the enclave, and serves ecalls instead of running the
+	// main function.
+	gosecsrc = `// Code generated by go build for the enclave executable. DO NOT EDIT.
 
-	{{range .Functions}}
+package main
 
-	gosecu.RegisterSecureFunction({{ . }})
+import (
+	_gosecu "gosecu"
+	_runtime "runtime"
+)
 
-	{{end}}
-	gosecu.EcallServer()
+func init() {
+	_gosecu.RegisterSecureFunctions()
+	_runtime.SetEnclaveMain(_gosecu.EcallServer)
 }
 `
 )
 
-// generateMain creates a temporary file _encl.o that corresponds contains
-// a main with go calls to all the gosecure targets.
-func generateMain(outfile string, functions, packages []string) string {
-	if len(functions) == 0 {
-		log.Fatalf("Missing target callees for gosecure keyword.")
+// needsEnclave reports whether the command p makes gosecure calls, directly
+// or through its dependencies, and must be linked with an enclave executable.
+func needsEnclave(p *load.Package) bool {
+	// The enclave itself and test binaries do not get one.
+	if p.Name != "main" || p.Internal.ForceLibrary || cfg.Relocencl.On || strings.HasSuffix(p.ImportPath, " (testmain)") {
+		return false
 	}
-
-	if outfile == "" {
-		log.Fatalf("Missing argument `outfile` for gosec command")
+	for _, p1 := range load.PackageList([]*load.Package{p}) {
+		if len(p1.Gosectargets) > 0 {
+			return true
+		}
 	}
+	return false
+}
 
-	data := Entry{functions, packages}
-	tmpl, err := template.New("gosec").Parse(gosectmpl)
-	if tmpl == nil || err != nil {
-		log.Fatalf(`gosec: parsing error "%s"`, err)
+// generateMain copies the go files of the main package p in dir, along with
+// the file that turns it into the enclave executable. It returns the files.
+func generateMain(dir string, p *load.Package) ([]string, error) {
+	if len(p.CgoFiles) > 0 {
+		return nil, fmt.Errorf("gosec: %s uses cgo, which is not available in the enclave", p.ImportPath)
 	}
-
-	f, e := os.Create(outfile)
-	defer f.Close()
-	if e != nil {
-		log.Fatalf(`gosec creating outfile failed: "%s"`, e)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
 	}
-
-	if err = tmpl.Execute(f, data); err != nil {
-		log.Fatalf(`"%s"`, err)
+	var files []string
+	for _, f := range p.GoFiles {
+		src, err := ioutil.ReadFile(filepath.Join(p.Dir, f))
+		if err != nil {
+			return nil, err
+		}
+		dst := filepath.Join(dir, f)
+		if err := ioutil.WriteFile(dst, src, 0666); err != nil {
+			return nil, err
+		}
+		files = append(files, dst)
 	}
-	if _, err := os.Stat(outfile); os.IsNotExist(err) {
-		log.Fatalf("The file doesn't exist.")
+	dst := filepath.Join(dir, gosecfile)
+	if err := ioutil.WriteFile(dst, []byte(gosecsrc), 0666); err != nil {
+		return nil, err
 	}
+	return append(files, dst), nil
+}
 
-	return outfile
+// enclaves returns the names of the enclaves of the main package p: the
+// default one, "", and those of the //gosec:enclave comments of the packages
+// of p, sorted. It also returns the routes of the program, see
+// gosecommon.EnclaveOf.
+func enclaves(p *load.Package) ([]string, string, error) {
+	names := []string{""}
+	var routes []string
+	seen := make(map[string]bool)
+	for _, p1 := range load.PackageList([]*load.Package{p}) {
+		name := p1.Gosecenclave
+		if name == "" {
+			continue
+		}
+		if !enclave.ValidName(name) {
+			return nil, "", fmt.Errorf("gosec: %s: invalid enclave name %q", p1.ImportPath, name)
+		}
+		// The functions of the main package are named main.
+		pkg := "main"
+		if p1.Name != "main" {
+			pkg = objabi.PathToPrefix(p1.ImportPath)
+		}
+		routes = append(routes, pkg+"="+name)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(routes)
+	return names, strings.Join(routes, ";"), nil
+}
+
+// sortedEnclaves returns the names of the enclave binaries efiles, sorted.
+func sortedEnclaves(efiles map[string]string) []string {
+	var names []string
+	for name := range efiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
-// gosec generates calls generateMain to create the main go file for the enclave
-// executable.
-func (b *Builder) gosec(a *Action) (err error) {
-	var functions, packages []string
-	ofile := filepath.Dir(a.Objdir) + "encl.go"
-	for p, m := range a.Package.PackagePublic.Gosectargets {
-		if p == "main" {
-			return fmt.Errorf("Invalid gosecure callee defined in main.")
+// manifests returns the layouts of the enclaves names of the main package p,
+// as set by their manifest files. The enclaves without manifest file follow
+// the default layout in the address space, in the order of names.
+func manifests(p *load.Package, names []string) ([]enclave.Manifest, error) {
+	ms := make([]enclave.Manifest, len(names))
+	for i, name := range names {
+		file := filepath.Join(p.Dir, enclave.FileOf(name))
+		data, err := ioutil.ReadFile(file)
+		switch {
+		case os.IsNotExist(err):
+			ms[i] = enclave.Default
+			ms[i].Base += uint64(i) * enclave.Default.Size
+		case err != nil:
+			return nil, err
+		default:
+			if ms[i], err = enclave.ParseJSON(data); err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+		}
+		ms[i].Name = name
+		if err := ms[i].Check(); err != nil {
+			return nil, fmt.Errorf("gosec: enclave %q: %v", name, err)
 		}
-		packages = append(packages, p)
-		if len(m) != 0 {
-			for _, call := range m {
-				functions = append(functions, p+"."+call)
+		for j := range ms[:i] {
+			if ms[i].Overlaps(ms[j]) {
+				return nil, fmt.Errorf("gosec: the enclaves %q and %q overlap", names[j], name)
 			}
 		}
 	}
-	fname := generateMain(ofile, functions, packages)
-	if _, err := os.Stat(fname); os.IsNotExist(err) {
+	return ms, nil
+}
+
+// gosec builds the enclave executables from the main package p: the targets
+// of gosecure may be anywhere in the program, including in main, and function
+// literals are named after the function that contains them. Each enclave is
+// built from the whole program, and registers the targets routed to it.
+func (b *Builder) gosec(a *Action, p *load.Package) (err error) {
+	names, routes, err := enclaves(p)
+	if err != nil {
+		return err
+	}
+	ms, err := manifests(p, names)
+	if err != nil {
+		return err
+	}
+	files, err := generateMain(filepath.Join(a.Objdir, "encl"), p)
+	if err != nil {
 		return err
 	}
 	// Define the output dir.
@@ -96,26 +175,34 @@ func (b *Builder) gosec(a *Action) (err error) {
 	if err != nil {
 		return err
 	}
-	efile := dir + "/enclave.out"
-	args := []string{"-o", efile, "-relocencl", fname}
-	cmd := CmdBuild
-	cmd.Flag.Parse(args)
-	args = cmd.Flag.Args()
-	cmd.Run(cmd, args)
-	a.Package.PackagePublic.Efile = efile
+	p.PackagePublic.Efiles = make(map[string]string)
+	for i, name := range names {
+		efile := dir + "/enclave.out"
+		if name != "" {
+			efile = dir + "/enclave." + name + ".out"
+		}
+		args := []string{"-o", efile, "-relocencl=" + ms[i].String(), "-gosecroutes=" + routes}
+		cmd := CmdBuild
+		cmd.Flag.Parse(append(args, files...))
+		args = cmd.Flag.Args()
+		cmd.Run(cmd, args)
+		p.PackagePublic.Efiles[name] = efile
+	}
+	p.PackagePublic.Gosecroutes = routes
 	return nil
 }
 
 // CreateEnclaveExec returns an Action that creates the temporary files necessary
-// to create the enclave executable.
-func (b *Builder) CreateEnclaveExec(p *load.Package) *Action {
-	a := &Action{
-		Mode:    "build",
-		Package: p,
-		Func:    (*Builder).gosec,
-		Objdir:  b.NewObjdir(),
+// to create the enclave executable of p, after the build a1 of p succeeds.
+// It has no package, as it is a dependency of the link of p and does not
+// produce a package file.
+func (b *Builder) CreateEnclaveExec(p *load.Package, a1 *Action) *Action {
+	return &Action{
+		Mode: "gosec",
+		Func: func(b *Builder, a *Action) error {
+			return b.gosec(a, p)
+		},
+		Deps:   []*Action{a1},
+		Objdir: b.NewObjdir(),
 	}
-	a.Target = a.Objdir + "_pkg_.a"
-	a.built = a.Target
-	return a
 }
@@ -0,0 +1,326 @@
+// Package enclave describes the layout of the enclave of a program.
+//
+// The layout is set by the manifest of the program, an enclave.json file in
+// the directory of its main package, e.g.,
+//
+//	{
+//		"base": "0x040000000000",
+//		"size": "0x001000000000",
+//		"heap": "128M",
+//		"tcs": 4,
+//		"unsafe": "2000K",
+//		"membuf": "5600K",
+//		"redact": true,
+//		"backend": "sgx"
+//	}
+//
+// Missing entries have their default value. backend is the backend that
+// loads the enclave, see Backends: without it, the loader uses the default
+// backend of the program, and SIM selects the simulation anyway. go build
+// passes the manifest to the linker with -relocencl in the form of
+// Manifest.String, and the linker embeds it in the enclave, where the runtime
+// and the loader read it.
+//
+// A program may have several enclaves: the gosecure targets of the packages
+// annotated with a //gosec:enclave comment, e.g.,
+//
+//	//gosec:enclave keys
+//	package keys
+//
+// run in the enclave of that name, and the others in the default enclave.
+// The manifest of the enclave keys is the file enclave.keys.json. Without
+// manifest, the named enclaves follow the default one in the address space,
+// in the order of their names.
+package enclave
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// File is the name of the manifest of the default enclave, next to the main
+// package.
+const File = "enclave.json"
+
+// FileOf returns the name of the manifest of the enclave name.
+func FileOf(name string) string {
+	if name == "" {
+		return File
+	}
+	return "enclave." + name + ".json"
+}
+
+// ValidName reports whether name can name an enclave: a lower case letter,
+// followed by lower case letters, digits and underscores.
+func ValidName(name string) bool {
+	for i, c := range name {
+		switch {
+		case 'a' <= c && c <= 'z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '_'):
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+const (
+	pageSize = 0x1000
+
+	// The loader maps the enclave image at stagingBase before adding it,
+	// see gosec.MMMASK.
+	stagingBase = 0x050000000000
+
+	// MinTcs is the number of threads the runtime of the enclave needs: the
+	// main thread, sysmon and one to hand off the P of a blocked thread.
+	MinTcs = 3
+
+	// MaxTcs is the number of threads the runtime reserves for the enclave,
+	// see runtime.EnclaveMaxTls.
+	MaxTcs = 8
+)
+
+// Backends are the backends that load an enclave, see gosec.Backend: the sgx
+// driver of the kernel, the out-of-tree isgx driver and the simulation.
+var Backends = []string{"sgx", "isgx", "sim"}
+
+// ValidBackend reports whether name is one of Backends.
+func ValidBackend(name string) bool {
+	for _, b := range Backends {
+		if b == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Manifest is the layout of an enclave.
+type Manifest struct {
+	Base    uint64 // start of the enclave address range, aligned on Size.
+	Size    uint64 // size of the enclave address range, a power of 2.
+	Heap    uint64 // size of the enclave heap, a power of 2.
+	Tcs     int    // number of threads that can be in the enclave.
+	Unsafe  uint64 // size of the memory shared by the enclave and the program.
+	Membuf  uint64 // size of the buffer for the mmaps of the enclave.
+	Redact  bool   // the crash reports of the enclave omit its message and stack.
+	Name    string // the name of the enclave, empty for the default one.
+	Backend string // the backend that loads the enclave, empty for the default one.
+}
+
+// Default is the layout of enclaves without manifest.
+var Default = Manifest{
+	Base:   0x040000000000,
+	Size:   0x001000000000,
+	Heap:   1 << 27,
+	Tcs:    4,
+	Unsafe: pageSize * 500,
+	Membuf: pageSize * 1400,
+}
+
+// String returns m in the form read by Parse and by the runtime, e.g.,
+// base=0x40000000000,size=0x1000000000,heap=0x8000000,tcs=4,unsafe=0x1f4000,membuf=0x578000.
+// The redact, name and backend entries are only present when set.
+func (m Manifest) String() string {
+	s := fmt.Sprintf("base=%#x,size=%#x,heap=%#x,tcs=%d,unsafe=%#x,membuf=%#x",
+		m.Base, m.Size, m.Heap, m.Tcs, m.Unsafe, m.Membuf)
+	if m.Redact {
+		s += ",redact=1"
+	}
+	if m.Name != "" {
+		s += ",name=" + m.Name
+	}
+	if m.Backend != "" {
+		s += ",backend=" + m.Backend
+	}
+	return s
+}
+
+// Overlaps reports whether the address ranges of m and o overlap.
+func (m Manifest) Overlaps(o Manifest) bool {
+	return m.Base < o.Base+o.Size && o.Base < m.Base+m.Size
+}
+
+// Parse parses a manifest in the form of String. Missing entries have their
+// default value.
+func Parse(s string) (Manifest, error) {
+	m := Default
+	if s == "" {
+		return m, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return m, fmt.Errorf("enclave manifest: malformed entry %q", kv)
+		}
+		if err := m.set(kv[:i], kv[i+1:]); err != nil {
+			return m, err
+		}
+	}
+	return m, m.Check()
+}
+
+// ParseJSON parses the content of a manifest file. The sizes are numbers, or
+// strings in any base with an optional K, M or G suffix. redact is a boolean,
+// and backend a string.
+func ParseJSON(data []byte) (Manifest, error) {
+	m := Default
+	var entries map[string]interface{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return m, fmt.Errorf("enclave manifest: %v", err)
+	}
+	for k, v := range entries {
+		if k == "name" {
+			return m, fmt.Errorf("enclave manifest: name: set by the name of the manifest")
+		}
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case float64:
+			if k == "backend" {
+				return m, fmt.Errorf("enclave manifest: %s: invalid value %v", k, v)
+			}
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			if k != "redact" {
+				return m, fmt.Errorf("enclave manifest: %s: invalid value %v", k, v)
+			}
+			s = strconv.FormatBool(v)
+		default:
+			return m, fmt.Errorf("enclave manifest: %s: invalid value %v", k, v)
+		}
+		if err := m.set(k, s); err != nil {
+			return m, err
+		}
+	}
+	return m, m.Check()
+}
+
+// set sets the entry k of m to the value s.
+func (m *Manifest) set(k, s string) error {
+	if k == "tcs" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("enclave manifest: tcs: invalid number %q", s)
+		}
+		m.Tcs = n
+		return nil
+	}
+	if k == "name" {
+		m.Name = s
+		return nil
+	}
+	if k == "backend" {
+		m.Backend = s
+		return nil
+	}
+	if k == "redact" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("enclave manifest: redact: invalid boolean %q", s)
+		}
+		m.Redact = b
+		return nil
+	}
+	var p *uint64
+	switch k {
+	case "base":
+		p = &m.Base
+	case "size":
+		p = &m.Size
+	case "heap":
+		p = &m.Heap
+	case "unsafe":
+		p = &m.Unsafe
+	case "membuf":
+		p = &m.Membuf
+	default:
+		return fmt.Errorf("enclave manifest: unknown entry %q", k)
+	}
+	unit := uint64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		unit = 1 << 30
+	}
+	if unit != 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseUint(s, 0, 64)
+	if err != nil || v*unit/unit != v {
+		return fmt.Errorf("enclave manifest: %s: invalid size %q", k, s)
+	}
+	*p = v * unit
+	return nil
+}
+
+func isPow2(v uint64) bool {
+	return v != 0 && v&(v-1) == 0
+}
+
+// Check reports whether m is a layout the loader can create: SGX requires
+// the range of the enclave to be naturally aligned, and the heap, the stacks
+// and the mmap buffer of the enclave must fit in it.
+func (m Manifest) Check() error {
+	switch {
+	case m.Name != "" && !ValidName(m.Name):
+		return fmt.Errorf("enclave manifest: invalid name %q", m.Name)
+	case m.Backend != "" && !ValidBackend(m.Backend):
+		return fmt.Errorf("enclave manifest: unknown backend %q, want one of %s", m.Backend, strings.Join(Backends, ", "))
+	case !isPow2(m.Size) || m.Size < 1<<30:
+		return fmt.Errorf("enclave manifest: size %#x is not a power of 2 of at least 1G", m.Size)
+	case m.Base%m.Size != 0:
+		return fmt.Errorf("enclave manifest: base %#x is not aligned on size %#x", m.Base, m.Size)
+	case m.Base < 1<<40:
+		// Below, the range may overlap the heap of the program.
+		return fmt.Errorf("enclave manifest: base %#x is below %#x", m.Base, uint64(1<<40))
+	case m.Base+m.Size > stagingBase || stagingBase+m.Size > 1<<47:
+		return fmt.Errorf("enclave manifest: range [%#x, %#x) overlaps the loader at %#x", m.Base, m.Base+m.Size, uint64(stagingBase))
+	case !isPow2(m.Heap) || m.Heap < 1<<20:
+		return fmt.Errorf("enclave manifest: heap %#x is not a power of 2 of at least 1M", m.Heap)
+	case m.Tcs < MinTcs || m.Tcs > MaxTcs:
+		return fmt.Errorf("enclave manifest: tcs %d is not between %d and %d", m.Tcs, MinTcs, MaxTcs)
+	case m.Unsafe == 0 || m.Unsafe%pageSize != 0:
+		return fmt.Errorf("enclave manifest: unsafe %#x is not a positive multiple of the page size", m.Unsafe)
+	case m.Membuf == 0 || m.Membuf%pageSize != 0:
+		return fmt.Errorf("enclave manifest: membuf %#x is not a positive multiple of the page size", m.Membuf)
+	case m.Heap+m.Membuf > m.Size/2:
+		// The binary, the stacks and the TCSs use the rest.
+		return fmt.Errorf("enclave manifest: heap and membuf do not fit in half of size %#x", m.Size)
+	}
+	return nil
+}
+
+// Flag is the value of the -relocencl flags of go build and of the linker,
+// which build an enclave. Alone, the flag builds the default layout.
+type Flag struct {
+	On       bool
+	Manifest Manifest
+}
+
+func (f *Flag) IsBoolFlag() bool { return true }
+
+func (f *Flag) String() string {
+	if f == nil || !f.On {
+		return "false"
+	}
+	return f.Manifest.String()
+}
+
+func (f *Flag) Set(s string) (err error) {
+	switch s {
+	case "false":
+		*f = Flag{}
+	case "true":
+		*f = Flag{On: true, Manifest: Default}
+	default:
+		f.Manifest, err = Parse(s)
+		f.On = err == nil
+	}
+	return err
+}
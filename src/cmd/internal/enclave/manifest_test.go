@@ -0,0 +1,109 @@
+package enclave
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseString(t *testing.T) {
+	for _, m := range []Manifest{
+		Default,
+		{Base: 0x020000000000, Size: 0x004000000000, Heap: 1 << 30, Tcs: 8, Unsafe: 0x400000, Membuf: 0x1000},
+		{Base: Default.Base, Size: Default.Size, Heap: Default.Heap, Tcs: 3, Unsafe: Default.Unsafe, Membuf: Default.Membuf, Redact: true},
+		{Base: 0x042000000000, Size: Default.Size, Heap: Default.Heap, Tcs: 4, Unsafe: Default.Unsafe, Membuf: Default.Membuf, Name: "keys"},
+		{Base: Default.Base, Size: Default.Size, Heap: Default.Heap, Tcs: 4, Unsafe: Default.Unsafe, Membuf: Default.Membuf, Backend: "sim"},
+	} {
+		got, err := Parse(m.String())
+		if err != nil || got != m {
+			t.Errorf("Parse(%q) = %v, %v, want %v", m.String(), got, err, m)
+		}
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	for _, tt := range []struct {
+		json string
+		want Manifest
+		err  string
+	}{
+		{json: `{}`, want: Default},
+		{
+			json: `{"base": "0x020000000000", "heap": "256M", "tcs": 3, "unsafe": 8192, "membuf": "64K"}`,
+			want: Manifest{Base: 0x020000000000, Size: Default.Size, Heap: 256 << 20, Tcs: 3, Unsafe: 8192, Membuf: 64 << 10},
+		},
+		{
+			json: `{"redact": true}`,
+			want: Manifest{Base: Default.Base, Size: Default.Size, Heap: Default.Heap, Tcs: Default.Tcs, Unsafe: Default.Unsafe, Membuf: Default.Membuf, Redact: true},
+		},
+		{
+			json: `{"backend": "isgx"}`,
+			want: Manifest{Base: Default.Base, Size: Default.Size, Heap: Default.Heap, Tcs: Default.Tcs, Unsafe: Default.Unsafe, Membuf: Default.Membuf, Backend: "isgx"},
+		},
+		{json: `{"heap": "100M"}`, err: "heap"},
+		{json: `{"backend": "tdx"}`, err: "unknown backend"},
+		{json: `{"backend": 1}`, err: "invalid value"},
+		{json: `{"redact": "maybe"}`, err: "boolean"},
+		{json: `{"base": "0x040000001000"}`, err: "aligned"},
+		{json: `{"base": "0x050000000000"}`, err: "overlaps"},
+		{json: `{"tcs": 9}`, err: "tcs"},
+		{json: `{"tcs": 2}`, err: "tcs"},
+		{json: `{"unsafe": 100}`, err: "page size"},
+		{json: `{"stack": 1}`, err: "unknown"},
+		{json: `{"size": true}`, err: "invalid value"},
+		{json: `{"heap": "1T"}`, err: "invalid size"},
+		{json: `{"heap": "16G", "size": "16G"}`, err: "half"},
+		{json: `{"name": "keys"}`, err: "name"},
+	} {
+		got, err := ParseJSON([]byte(tt.json))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseJSON(%s): error %v, want %q", tt.json, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseJSON(%s) = %v, %v, want %v", tt.json, got, err, tt.want)
+		}
+	}
+}
+
+func TestFlag(t *testing.T) {
+	var f Flag
+	if err := f.Set("true"); err != nil || !f.On || f.Manifest != Default {
+		t.Errorf("-relocencl: %+v, %v", f, err)
+	}
+	if err := f.Set("tcs=3"); err != nil || !f.On || f.Manifest.Tcs != 3 {
+		t.Errorf("-relocencl=tcs=3: %+v, %v", f, err)
+	}
+	if err := f.Set("false"); err != nil || f.On {
+		t.Errorf("-relocencl=false: %+v, %v", f, err)
+	}
+	if err := f.Set("tcs"); err == nil {
+		t.Errorf("-relocencl=tcs: no error")
+	}
+}
+
+func TestNames(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"keys": true, "k2": true, "req_proc": true,
+		"": false, "2k": false, "_k": false, "Keys": false, "k-v": false, "k.v": false,
+	} {
+		if ValidName(name) != valid {
+			t.Errorf("ValidName(%q) = %v", name, !valid)
+		}
+	}
+	if f := FileOf(""); f != File {
+		t.Errorf("FileOf(\"\") = %q, want %q", f, File)
+	}
+	if f := FileOf("keys"); f != "enclave.keys.json" {
+		t.Errorf("FileOf(\"keys\") = %q", f)
+	}
+	if _, err := Parse("name=Keys"); err == nil {
+		t.Errorf("Parse(name=Keys): no error")
+	}
+	a, b := Default, Default
+	b.Base += b.Size
+	if a.Overlaps(b) || !a.Overlaps(a) {
+		t.Errorf("Overlaps: %v and %v", a, b)
+	}
+}
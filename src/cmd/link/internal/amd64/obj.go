@@ -37,8 +37,6 @@ import (
 	"fmt"
 )
 
-const ENCLMASK = 0x040000000000
-
 func Init() (*sys.Arch, ld.Arch) {
 	arch := sys.ArchAMD64
 	if objabi.GOARCH == "amd64p32" {
@@ -115,10 +113,9 @@ func archinit(ctxt *ld.Link) {
 
 		ld.HEADR = ld.ELFRESERVE
 		if *ld.FlagTextAddr == -1 {
-			if *ld.Relocencl {
-				// @aghosn here we set the address for the enclave.
-				//*ld.FlagTextAddr = 0x100000 + int64(ld.HEADR)
-				*ld.FlagTextAddr = ENCLMASK + int64(ld.HEADR)
+			if ld.Relocencl.On {
+				// The enclave starts at the base of its manifest.
+				*ld.FlagTextAddr = int64(ld.Relocencl.Manifest.Base) + int64(ld.HEADR)
 			} else {
 				*ld.FlagTextAddr = (1 << 22) + int64(ld.HEADR)
 			}
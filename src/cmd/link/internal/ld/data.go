@@ -1784,9 +1784,9 @@ func (ctxt *Link) textbuildid() {
 }
 
 //TODO(aghosn) added this.
-// addEnclave creates a section with the content of the enclave binary.
-func (ctxt *Link) addenclave() {
-	addsection(ctxt.Arch, &SegEnclave, ".encl", 05)
+// addEnclave creates a section with the content of the enclave binary e.
+func (ctxt *Link) addenclave(e enclaveFile) {
+	addsection(ctxt.Arch, &SegEnclave, e.section(), 05)
 }
 
 //TODO(aghosn) here we see how to add the section.
@@ -1696,16 +1696,16 @@ func (ctxt *Link) doelf() {
 		addgonote(ctxt, ".note.go.buildid", ELF_NOTE_GOBUILDID_TAG, []byte(*flagBuildid))
 	}
 
-	if *Lkenclave != "" {
-		Addstring(shstrtab, ".encl")
-		encl, err := ioutil.ReadFile(*Lkenclave)
+	for _, e := range Lkenclave {
+		Addstring(shstrtab, e.section())
+		encl, err := ioutil.ReadFile(e.file)
 		if err != nil {
 			log.Fatalf(err.Error())
 		}
-		if err = os.Remove(*Lkenclave); err != nil {
+		if err = os.Remove(e.file); err != nil {
 			log.Fatalf(err.Error())
 		}
-		addgonote(ctxt, ".encl", 5, encl)
+		addgonote(ctxt, e.section(), 5, encl)
 	}
 }
 
@@ -1823,8 +1823,8 @@ func Asmbelf(ctxt *Link, symo int64) {
 			sh.flags = SHF_ALLOC
 		}
 
-		if *Lkenclave != "" {
-			sh := elfshname(".encl")
+		for _, e := range Lkenclave {
+			sh := elfshname(e.section())
 			sh.type_ = SHT_NOTE
 			sh.flags = SHF_ALLOC
 		}
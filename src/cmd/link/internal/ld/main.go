@@ -32,9 +32,11 @@ package ld
 
 import (
 	"bufio"
+	"cmd/internal/enclave"
 	"cmd/internal/objabi"
 	"cmd/internal/sys"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"runtime"
@@ -95,10 +97,15 @@ var (
 	memprofilerate = flag.Int64("memprofilerate", 0, "set runtime.MemProfileRate to `rate`")
 
 	//TODO(aghosn) flags for the enclave.
-	Lkenclave = flag.String("lkenclave", "", "existing enclave binary to include.")
-	Relocencl = flag.Bool("relocencl", false, "relocate the enclave base address.")
+	Lkenclave enclaveFiles
+	Relocencl enclave.Flag
 )
 
+func init() {
+	flag.Var(&Lkenclave, "lkenclave", "include the existing enclave binary `[name=]file`, can be repeated.")
+	flag.Var(&Relocencl, "relocencl", "link an enclave, with the `layout` of its manifest.")
+}
+
 // Main is the main entry point for the linker code.
 func Main(arch *sys.Arch, theArch Arch) {
 	Thearch = theArch
@@ -134,6 +141,9 @@ func Main(arch *sys.Arch, theArch Arch) {
 	objabi.Flagfn1("importcfg", "read import configuration from `file`", ctxt.readImportCfg)
 
 	objabi.Flagparse(usage)
+	if Relocencl.On {
+		addstrdata1(ctxt, "runtime.enclaveManifest="+Relocencl.Manifest.String())
+	}
 
 	switch *flagHeadType {
 	case "":
@@ -239,14 +249,58 @@ func Main(arch *sys.Arch, theArch Arch) {
 	}
 
 	//TODO(aghosn) adding the enclave to the elf if lkenclave option is passed.
-	if *Lkenclave != "" {
-		ctxt.addenclave()
+	for _, e := range Lkenclave {
+		ctxt.addenclave(e)
 	}
 	ctxt.Bso.Flush()
 
 	errorexit()
 }
 
+// enclaveFile is an enclave binary included by -lkenclave.
+type enclaveFile struct {
+	name string // empty for the default enclave.
+	file string
+}
+
+// section returns the name of the ELF section of the enclave e.
+func (e enclaveFile) section() string {
+	if e.name == "" {
+		return ".encl"
+	}
+	return ".encl." + e.name
+}
+
+// enclaveFiles is the -lkenclave flag. A program has a default enclave, and
+// enclaves named by the //gosec:enclave comments of its packages.
+type enclaveFiles []enclaveFile
+
+func (f *enclaveFiles) Set(val string) error {
+	e := enclaveFile{file: val}
+	if i := strings.Index(val, "="); i > 0 && enclave.ValidName(val[:i]) {
+		e.name, e.file = val[:i], val[i+1:]
+	}
+	for _, o := range *f {
+		if o.name == e.name {
+			return fmt.Errorf("enclave %q included twice", e.name)
+		}
+	}
+	*f = append(*f, e)
+	return nil
+}
+
+func (f *enclaveFiles) String() string {
+	var s []string
+	for _, e := range *f {
+		if e.name == "" {
+			s = append(s, e.file)
+		} else {
+			s = append(s, e.name+"="+e.file)
+		}
+	}
+	return strings.Join(s, ",")
+}
+
 type Rpath struct {
 	set bool
 	val string
@@ -27,6 +27,14 @@ Supported profile types are:
 Then, you can use the pprof tool to analyze the profile:
 	go tool pprof TYPE.pprof
 
+The trace of a program with an enclave, and the one of its enclave, written
+by gosec.StartTrace, are viewed together with:
+	go tool trace -enclave=enclave.out trace.out
+The goroutines and Ps of the enclave show in a process of their own, and the
+boundary crossings as events: the gosecure calls and their dispatch in the
+enclave, the requests of the enclave to the host, the cross domain wake ups
+and the spawns of enclave threads.
+
 Note that while the various profiles available when launching
 'go tool trace' work on every browser, the trace viewer itself
 (the 'view trace' page) comes from the Chrome/Chromium project
@@ -43,6 +43,7 @@ Flags:
 	-http=addr: HTTP service address (e.g., ':6060')
 	-pprof=type: print a pprof-like profile instead
 	-d: print debug info such as parsed events
+	-enclave=file: merge the trace of the enclave in file, see gosec.StartTrace
 
 Note that while the various profiles available when launching
 'go tool trace' work on every browser, the trace viewer itself
@@ -54,6 +55,7 @@ var (
 	httpFlag  = flag.String("http", "localhost:0", "HTTP service address (e.g., ':6060')")
 	pprofFlag = flag.String("pprof", "", "print a pprof-like profile instead")
 	debugFlag = flag.Bool("d", false, "print debug information such as parsed events list")
+	enclFlag  = flag.String("enclave", "", "merge the trace of the enclave in this file")
 
 	// The binary file name, left here for serveSVGProfile.
 	programBinary string
@@ -167,7 +169,19 @@ func parseTrace() (trace.ParseResult, error) {
 		defer tracef.Close()
 
 		// Parse and symbolize.
-		res, err := trace.Parse(bufio.NewReader(tracef), programBinary)
+		var res trace.ParseResult
+		if *enclFlag != "" {
+			var enclf *os.File
+			enclf, err = os.Open(*enclFlag)
+			if err != nil {
+				loader.err = fmt.Errorf("failed to open enclave trace file: %v", err)
+				return
+			}
+			defer enclf.Close()
+			res, err = trace.ParseEnclave(bufio.NewReader(tracef), bufio.NewReader(enclf), programBinary)
+		} else {
+			res, err = trace.Parse(bufio.NewReader(tracef), programBinary)
+		}
 		if err != nil {
 			loader.err = fmt.Errorf("failed to parse trace: %v", err)
 			return
@@ -365,7 +365,7 @@ func generateTrace(params *traceParams) (ViewerData, error) {
 	ctx.frameTree.children = make(map[uint64]frameNode)
 	ctx.data.Frames = make(map[string]ViewerFrame)
 	ctx.data.TimeUnit = "ns"
-	maxProc := 0
+	maxProc, maxEnclProc := 0, -1
 	ginfos := make(map[uint64]*gInfo)
 	stacks := params.parsed.Stacks
 
@@ -420,6 +420,9 @@ func generateTrace(params *traceParams) (ViewerData, error) {
 
 			fname := stk[0].Fn
 			info.name = fmt.Sprintf("G%v %s", newG, fname)
+			if ev.Enclave {
+				info.name = fmt.Sprintf("enclave G%v %s", newG-trace.EnclaveID, fname)
+			}
 			info.isSystemG = strings.HasPrefix(fname, "runtime.") && fname != "runtime.main"
 
 			ctx.gcount++
@@ -486,7 +489,9 @@ func generateTrace(params *traceParams) (ViewerData, error) {
 			continue
 		}
 
-		if ev.P < trace.FakeP && ev.P > maxProc {
+		if ev.P < trace.FakeP && ev.Enclave && ev.P > maxEnclProc {
+			maxEnclProc = ev.P
+		} else if ev.P < trace.FakeP && !ev.Enclave && ev.P > maxProc {
 			maxProc = ev.P
 		}
 
@@ -560,6 +565,25 @@ func generateTrace(params *traceParams) (ViewerData, error) {
 			ctx.emitInstant(ev, "syscall")
 		case trace.EvGoSysExit:
 			ctx.emitArrow(ev, "sysexit")
+		case trace.EvGosecEcall:
+			ctx.emitInstant(ev, "ecall "+ev.SArgs[0])
+			ctx.emitArrow(ev, "ecall")
+		case trace.EvGosecDispatch:
+			ctx.emitInstant(ev, "dispatch "+ev.SArgs[0])
+		case trace.EvGosecOcall:
+			if ev.Link != nil {
+				ctx.emitSlice(ev, ocallName(ev))
+			}
+		case trace.EvGosecCrossWakeup:
+			if ev.Args[0] != 0 {
+				ctx.emitInstant(ev, "cross wakeup (enclave)")
+			} else {
+				ctx.emitInstant(ev, "cross wakeup (host)")
+			}
+		case trace.EvGosecMigrate:
+			ctx.emitInstant(ev, fmt.Sprintf("cross migrate (%v)", ev.Args[0]))
+		case trace.EvGosecSpawn:
+			ctx.emitInstant(ev, fmt.Sprintf("enclave thread %v", ev.Args[0]))
 		}
 		// Emit any counter updates.
 		ctx.emitThreadCounters(ev)
@@ -593,12 +617,25 @@ func generateTrace(params *traceParams) (ViewerData, error) {
 		}
 	}
 
+	if maxEnclProc >= 0 {
+		ctx.emit(&ViewerEvent{Name: "process_name", Phase: "M", Pid: enclavePid, Arg: &NameArg{"ENCLAVE"}})
+		ctx.emit(&ViewerEvent{Name: "process_sort_index", Phase: "M", Pid: enclavePid, Arg: &SortIndexArg{2}})
+		for i := 0; !ctx.gtrace && i <= maxEnclProc; i++ {
+			ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: enclavePid, Tid: uint64(i), Arg: &NameArg{fmt.Sprintf("Proc %v", i)}})
+			ctx.emit(&ViewerEvent{Name: "thread_sort_index", Phase: "M", Pid: enclavePid, Tid: uint64(i), Arg: &SortIndexArg{i}})
+		}
+	}
+
 	if ctx.gtrace && ctx.gs != nil {
 		for k, v := range ginfos {
 			if !ctx.gs[k] {
 				continue
 			}
-			ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: 0, Tid: k, Arg: &NameArg{v.name}})
+			pid := uint64(0)
+			if k >= trace.EnclaveID {
+				pid = enclavePid
+			}
+			ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: pid, Tid: k, Arg: &NameArg{v.name}})
 		}
 		ctx.emit(&ViewerEvent{Name: "thread_sort_index", Phase: "M", Pid: 0, Tid: ctx.maing, Arg: &SortIndexArg{-2}})
 		ctx.emit(&ViewerEvent{Name: "thread_sort_index", Phase: "M", Pid: 0, Tid: 0, Arg: &SortIndexArg{-1}})
@@ -624,12 +661,39 @@ func (ctx *traceContext) proc(ev *trace.Event) uint64 {
 	}
 }
 
+// enclavePid is the process of the events of the enclave.
+const enclavePid = 2
+
+func (ctx *traceContext) pid(ev *trace.Event) uint64 {
+	if ev.Enclave {
+		return enclavePid
+	}
+	return 0
+}
+
+// ocallTypes are the names of the types of the requests of the enclave, see
+// runtime.SysType.
+var ocallTypes = [...]string{"syscall", "syscall", "raw syscall", "raw syscall", "malloc", "free", "grow"}
+
+// ocallName names the slice of the request of the enclave ev.
+func ocallName(ev *trace.Event) string {
+	if ev.Args[0] >= uint64(len(ocallTypes)) {
+		return fmt.Sprintf("ocall %v", ev.Args[0])
+	}
+	name := "ocall " + ocallTypes[ev.Args[0]]
+	if ev.Args[0] <= 3 {
+		name += fmt.Sprintf(" %v", ev.Args[1])
+	}
+	return name
+}
+
 func (ctx *traceContext) emitSlice(ev *trace.Event, name string) *ViewerEvent {
 	sl := &ViewerEvent{
 		Name:     name,
 		Phase:    "X",
 		Time:     ctx.time(ev),
 		Dur:      ctx.time(ev.Link) - ctx.time(ev),
+		Pid:      ctx.pid(ev),
 		Tid:      ctx.proc(ev),
 		Stack:    ctx.stack(ev.Stk),
 		EndStack: ctx.stack(ev.Link.Stk),
@@ -701,7 +765,7 @@ func (ctx *traceContext) emitInstant(ev *trace.Event, name string) {
 		}
 		arg = &Arg{ev.Args[0]}
 	}
-	ctx.emit(&ViewerEvent{Name: name, Phase: "I", Scope: "t", Time: ctx.time(ev), Tid: ctx.proc(ev), Stack: ctx.stack(ev.Stk), Arg: arg})
+	ctx.emit(&ViewerEvent{Name: name, Phase: "I", Scope: "t", Time: ctx.time(ev), Pid: ctx.pid(ev), Tid: ctx.proc(ev), Stack: ctx.stack(ev.Stk), Arg: arg})
 }
 
 func (ctx *traceContext) emitArrow(ev *trace.Event, name string) {
@@ -717,12 +781,12 @@ func (ctx *traceContext) emitArrow(ev *trace.Event, name string) {
 	if ev.P == trace.NetpollP || ev.P == trace.TimerP || ev.P == trace.SyscallP {
 		// Trace-viewer discards arrows if they don't start/end inside of a slice or instant.
 		// So emit a fake instant at the start of the arrow.
-		ctx.emitInstant(&trace.Event{P: ev.P, Ts: ev.Ts}, "unblock")
+		ctx.emitInstant(&trace.Event{P: ev.P, Ts: ev.Ts, Enclave: ev.Enclave}, "unblock")
 	}
 
 	ctx.arrowSeq++
-	ctx.emit(&ViewerEvent{Name: name, Phase: "s", Tid: ctx.proc(ev), ID: ctx.arrowSeq, Time: ctx.time(ev), Stack: ctx.stack(ev.Stk)})
-	ctx.emit(&ViewerEvent{Name: name, Phase: "t", Tid: ctx.proc(ev.Link), ID: ctx.arrowSeq, Time: ctx.time(ev.Link)})
+	ctx.emit(&ViewerEvent{Name: name, Phase: "s", Pid: ctx.pid(ev), Tid: ctx.proc(ev), ID: ctx.arrowSeq, Time: ctx.time(ev), Stack: ctx.stack(ev.Stk)})
+	ctx.emit(&ViewerEvent{Name: name, Phase: "t", Pid: ctx.pid(ev.Link), Tid: ctx.proc(ev.Link), ID: ctx.arrowSeq, Time: ctx.time(ev.Link)})
 }
 
 func (ctx *traceContext) stack(stk []*trace.Frame) int {
@@ -167,3 +167,50 @@ func TestPreemptedMarkAssist(t *testing.T) {
 		t.Errorf("Got %v MARK ASSIST events, want %v", marks, 2)
 	}
 }
+
+// TestEnclaveEvents tests that the events of a trace merged with the one of
+// its enclave are rendered in the process of their domain.
+func TestEnclaveEvents(t *testing.T) {
+	done := &trace.Event{Type: trace.EvGosecOcallDone, Ts: 40, Enclave: true}
+	ocall := &trace.Event{Type: trace.EvGosecOcall, Ts: 30, Enclave: true, Args: [3]uint64{1, 202}, Link: done}
+	dispatch := &trace.Event{Type: trace.EvGosecDispatch, Ts: 20, Enclave: true, SArgs: []string{"main.f"}}
+	ecall := &trace.Event{Type: trace.EvGosecEcall, Ts: 10, SArgs: []string{"main.f"}, Link: dispatch}
+
+	params := &traceParams{
+		parsed:  trace.ParseResult{Events: []*trace.Event{ecall, dispatch, ocall, done}},
+		endTime: int64(1<<63 - 1),
+	}
+	viewerData, err := generateTrace(params)
+	if err != nil {
+		t.Fatalf("generateTrace failed: %v", err)
+	}
+
+	want := map[string]uint64{
+		"ecall main.f":      0,
+		"ecall s":           0,
+		"ecall t":           enclavePid,
+		"dispatch main.f":   enclavePid,
+		"ocall syscall 202": enclavePid,
+		"process_name":      enclavePid,
+	}
+	for _, ev := range viewerData.Events {
+		name := ev.Name
+		if name == "ecall" {
+			name += " " + ev.Phase
+		}
+		if name == "process_name" && ev.Arg.(*NameArg).Name != "ENCLAVE" {
+			continue
+		}
+		pid, ok := want[name]
+		if !ok {
+			continue
+		}
+		if ev.Pid != pid {
+			t.Errorf("%v in process %v, want %v", name, ev.Pid, pid)
+		}
+		delete(want, name)
+	}
+	for name := range want {
+		t.Errorf("missing %v", name)
+	}
+}
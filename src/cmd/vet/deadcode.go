@@ -70,6 +70,7 @@ func (d *deadState) findLabels(stmt ast.Stmt) {
 		*ast.EmptyStmt,
 		*ast.ExprStmt,
 		*ast.GoStmt,
+		*ast.GosecStmt,
 		*ast.IncDecStmt,
 		*ast.ReturnStmt,
 		*ast.SendStmt:
@@ -188,6 +189,7 @@ func (d *deadState) findDead(stmt ast.Stmt) {
 		*ast.DeferStmt,
 		*ast.EmptyStmt,
 		*ast.GoStmt,
+		*ast.GosecStmt,
 		*ast.IncDecStmt,
 		*ast.SendStmt:
 		// no control flow
@@ -32,6 +32,7 @@ start:
 		*ast.SendStmt,
 		*ast.IncDecStmt,
 		*ast.GoStmt,
+		*ast.GosecStmt,
 		*ast.DeferStmt,
 		*ast.EmptyStmt,
 		*ast.AssignStmt:
@@ -76,6 +76,8 @@ func checkLoop(f *File, node ast.Node) {
 	switch s := body.List[len(body.List)-1].(type) {
 	case *ast.GoStmt:
 		last = s.Call
+	case *ast.GosecStmt:
+		last = s.Call
 	case *ast.DeferStmt:
 		last = s.Call
 	default:
@@ -15,6 +15,8 @@ import "io"
 // On OpenBSD, Reader uses getentropy(2).
 // On other Unix-like systems, Reader reads from /dev/urandom.
 // On Windows systems, Reader uses the CryptGenRandom API.
+// In the enclave, Reader uses a CTR_DRBG seeded by RDSEED and RDRAND, and
+// panics if the cpu fails to provide them.
 var Reader io.Reader
 
 // Read is a helper function that calls Reader.Read using io.ReadFull.
@@ -0,0 +1,191 @@
+package rand
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"sync"
+)
+
+/* The Reader of the enclave. The random bytes of getrandom(2) and of
+ * /dev/urandom are chosen by the untrusted side, and the enclave does not
+ * ask for them: it draws them from the cpu instead. A CTR_DRBG with AES-256
+ * and without derivation function, see NIST SP 800-90A, is seeded by RDSEED
+ * and reseeded every reseedInterval requests. Each request adds samples of
+ * RDRAND as additional input. The samples go through health tests, and the
+ * Reader panics when the cpu keeps failing to provide them or fails a test:
+ * it never falls back to the untrusted side.
+ *
+ * The enclave cannot execute cpuid, and would not trust the answer of the
+ * untrusted side anyway: the instructions are executed without checking for
+ * them, all the cpus with sgx have both. The simulation takes the same path.
+ */
+
+const (
+	seedLen        = 48      // key and block of AES-256.
+	maxRequest     = 1 << 16 // bytes generated by a request, 2^19 bits at most.
+	reseedInterval = 1 << 20 // requests between two reseeds.
+
+	// RDRAND underflows only under heavy load, Intel recommends 10 retries.
+	// RDSEED fails whenever its entropy is depleted, and recovers quickly.
+	rdrandRetries = 10
+	rdseedRetries = 1000
+)
+
+// newEnclaveReader returns the Reader of the enclave.
+func newEnclaveReader() io.Reader {
+	return &enclaveReader{
+		seed: hwSource{name: "RDSEED", sample: hwSeed, retries: rdseedRetries},
+		rand: hwSource{name: "RDRAND", sample: hwRand, retries: rdrandRetries},
+	}
+}
+
+type enclaveReader struct {
+	mu   sync.Mutex
+	seed hwSource // the entropy of the seeds.
+	rand hwSource // the additional input of the requests.
+	drbg *ctrDRBG // nil until the first Read.
+}
+
+func (r *enclaveReader) Read(b []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var entropy, add [seedLen]byte
+	if r.drbg == nil {
+		r.seed.read(entropy[:])
+		r.drbg = newCtrDRBG(&entropy, nil)
+	}
+	for n < len(b) {
+		if r.drbg.counter > reseedInterval {
+			r.seed.read(entropy[:])
+			r.drbg.reseed(&entropy, nil)
+		}
+		r.rand.read(add[:])
+		m := len(b) - n
+		if m > maxRequest {
+			m = maxRequest
+		}
+		r.drbg.generate(b[n:n+m], &add)
+		n += m
+	}
+	return n, nil
+}
+
+// hwSource is an entropy source of the cpu, with its health tests.
+type hwSource struct {
+	name    string
+	sample  func(v *uint64) bool // one attempt, nil if the cpu has none.
+	retries int
+	last    uint64 // the previous sample, 0 before the first one.
+}
+
+// read fills b with samples of s.
+func (s *hwSource) read(b []byte) {
+	for len(b) > 0 {
+		v := s.next()
+		for i := 0; i < 8 && len(b) > 0; i++ {
+			b[0] = byte(v >> (8 * uint(i)))
+			b = b[1:]
+		}
+	}
+}
+
+// next returns a sample of s that passed the health tests.
+func (s *hwSource) next() uint64 {
+	if s.sample == nil {
+		panic("crypto/rand: the cpu has no " + s.name + " for the enclave")
+	}
+	var v uint64
+	for i := 0; !s.sample(&v); i++ {
+		if i == s.retries {
+			panic("crypto/rand: " + s.name + " keeps failing in the enclave")
+		}
+	}
+	// Some cpus return all ones, with success, once they resumed from
+	// suspend. A sample that repeats the previous one fails the
+	// repetition count test of NIST SP 800-90B, whose cutoff is 2 for
+	// samples of 64 bits of entropy.
+	switch v {
+	case 0, ^uint64(0):
+		panic("crypto/rand: " + s.name + " is stuck in the enclave")
+	case s.last:
+		panic("crypto/rand: " + s.name + " repeated a sample in the enclave")
+	}
+	s.last = v
+	return v
+}
+
+// ctrDRBG is the CTR_DRBG of NIST SP 800-90A with AES-256, without
+// derivation function and without prediction resistance. The entropy, the
+// personalization string and the additional input are seedLen bytes, nil
+// when there are none.
+type ctrDRBG struct {
+	block   cipher.Block
+	v       [aes.BlockSize]byte
+	counter uint64 // the reseed counter.
+}
+
+func newCtrDRBG(entropy, personalization *[seedLen]byte) *ctrDRBG {
+	d := &ctrDRBG{block: newAES(make([]byte, 32))}
+	d.reseed(entropy, personalization)
+	return d
+}
+
+func newAES(key []byte) cipher.Block {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic("crypto/rand: " + err.Error())
+	}
+	return block
+}
+
+func (d *ctrDRBG) reseed(entropy, add *[seedLen]byte) {
+	seed := *entropy
+	if add != nil {
+		for i := range seed {
+			seed[i] ^= add[i]
+		}
+	}
+	d.update(&seed)
+	d.counter = 1
+}
+
+// update is CTR_DRBG_Update.
+func (d *ctrDRBG) update(provided *[seedLen]byte) {
+	var temp [seedLen]byte
+	for i := 0; i < seedLen; i += aes.BlockSize {
+		d.next(temp[i:])
+	}
+	if provided != nil {
+		for i := range temp {
+			temp[i] ^= provided[i]
+		}
+	}
+	d.block = newAES(temp[:32])
+	copy(d.v[:], temp[32:])
+}
+
+// next increments V and encrypts it into b.
+func (d *ctrDRBG) next(b []byte) {
+	for i := len(d.v) - 1; i >= 0; i-- {
+		d.v[i]++
+		if d.v[i] != 0 {
+			break
+		}
+	}
+	d.block.Encrypt(b, d.v[:])
+}
+
+// generate fills b, of maxRequest bytes at most.
+func (d *ctrDRBG) generate(b []byte, add *[seedLen]byte) {
+	if add != nil {
+		d.update(add)
+	}
+	var block [aes.BlockSize]byte
+	for len(b) > 0 {
+		d.next(block[:])
+		b = b[copy(b, block[:]):]
+	}
+	d.update(add)
+	d.counter++
+}
@@ -0,0 +1,121 @@
+package rand
+
+import (
+	"bytes"
+	"encoding/hex"
+	"internal/cpu"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func seq(from byte) *[seedLen]byte {
+	var b [seedLen]byte
+	for i := range b {
+		b[i] = from + byte(i)
+	}
+	return &b
+}
+
+func TestCtrDRBG(t *testing.T) {
+	d := newCtrDRBG(seq(0x00), nil)
+	for _, tt := range []struct {
+		reseed *[seedLen]byte
+		add    *[seedLen]byte
+		n      int
+		want   string
+	}{
+		{nil, nil, 64, "061550234d158c5ec95595fe04ef7a25767f2e24cc2bc479d09d86dc9abcfde7056a8c266f9ef97ed08541dbd2e1ffa19810f5392d076276ef41277c3ab6e94a"},
+		{nil, seq(0x40), 40, "def80f8582cbaacd46b4d24c09c4c22b9d93fef6e762303d3d5278b37523d7daf03ac9874424eafe"},
+		{seq(0x80), nil, 64, "315f157bb5c16ce1d9e685de4ae52eb82bcbe95fc543b01d68c428042464d3146f428752b84ade4d9337376f5e2d73119fc39f3793028af01330c33d12c19395"},
+	} {
+		if tt.reseed != nil {
+			d.reseed(tt.reseed, nil)
+		}
+		b := make([]byte, tt.n)
+		d.generate(b, tt.add)
+		if got := hex.EncodeToString(b); got != tt.want {
+			t.Errorf("generate:\n got %s\nwant %s", got, tt.want)
+		}
+	}
+}
+
+// fakeSource returns a source that fails fails times before each sample of
+// samples.
+func fakeSource(fails int, samples ...uint64) hwSource {
+	n := 0
+	return hwSource{name: "FAKE", retries: 3, sample: func(v *uint64) bool {
+		if n%(fails+1) < fails {
+			n++
+			return false
+		}
+		*v = samples[n/(fails+1)%len(samples)]
+		n++
+		return true
+	}}
+}
+
+func TestHwSource(t *testing.T) {
+	s := fakeSource(3, 0x0706050403020100, 0x0f0e0d0c0b0a0908)
+	b := make([]byte, 12)
+	s.read(b)
+	if want := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}; !bytes.Equal(b, want) {
+		t.Errorf("read: %v, want %v", b, want)
+	}
+	for _, tt := range []struct {
+		s    hwSource
+		want string
+	}{
+		{hwSource{name: "FAKE"}, "has no FAKE"},
+		{fakeSource(4, 1, 2), "keeps failing"},
+		{fakeSource(0, 0), "is stuck"},
+		{fakeSource(0, ^uint64(0)), "is stuck"},
+		{fakeSource(0, 1, 1), "repeated a sample"},
+	} {
+		func() {
+			defer func() {
+				if err, _ := recover().(string); !strings.Contains(err, tt.want) {
+					t.Errorf("panic %q, want %q", err, tt.want)
+				}
+			}()
+			tt.s.read(make([]byte, 16))
+		}()
+	}
+}
+
+func TestEnclaveReaderReseed(t *testing.T) {
+	r := &enclaveReader{
+		seed: fakeSource(0, 1, 2, 3, 4, 5, 6, 7),
+		rand: fakeSource(1, 8, 9, 10),
+	}
+	b := make([]byte, maxRequest+1)
+	if n, err := r.Read(b); n != len(b) || err != nil {
+		t.Fatalf("Read: %d, %v", n, err)
+	}
+	if r.drbg.counter != 3 {
+		t.Errorf("%d requests after a Read of %d bytes, want 2", r.drbg.counter-1, len(b))
+	}
+	v := r.drbg.v
+	r.drbg.counter = reseedInterval + 1
+	r.Read(b[:1])
+	if r.drbg.counter != 2 || r.drbg.v == v {
+		t.Errorf("the reader did not reseed after %d requests", reseedInterval)
+	}
+}
+
+func TestEnclaveReader(t *testing.T) {
+	if runtime.GOARCH != "amd64" || !cpu.X86.HasRDRAND || !cpu.X86.HasRDSEED {
+		t.Skip("the cpu has no RDRAND or no RDSEED")
+	}
+	r := newEnclaveReader()
+	b1, b2 := make([]byte, 2*maxRequest), make([]byte, 2*maxRequest)
+	if _, err := r.Read(b1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(b2); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b1, b2) || bytes.Equal(b1[:maxRequest], b1[maxRequest:]) {
+		t.Errorf("the reader repeats itself")
+	}
+}
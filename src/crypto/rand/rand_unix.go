@@ -26,9 +26,12 @@ const urandomDevice = "/dev/urandom"
 // This is sufficient on Linux, OS X, and FreeBSD.
 
 func init() {
-	if runtime.GOOS == "plan9" {
+	switch {
+	case runtime.IsEnclave():
+		Reader = newEnclaveReader()
+	case runtime.GOOS == "plan9":
 		Reader = newReader(nil)
-	} else {
+	default:
 		Reader = &devReader{name: urandomDevice}
 	}
 }
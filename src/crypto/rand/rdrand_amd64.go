@@ -0,0 +1,8 @@
+package rand
+
+// rdrand and rdseed execute RDRAND and RDSEED once. They store the value in
+// *v and report whether the cpu had one.
+func rdrand(v *uint64) bool
+func rdseed(v *uint64) bool
+
+var hwRand, hwSeed = rdrand, rdseed
@@ -0,0 +1,20 @@
+#include "textflag.h"
+
+// The assembler does not know RDRAND and RDSEED: they are encoded by hand,
+// with AX as destination.
+
+// func rdrand(v *uint64) bool
+TEXT ·rdrand(SB), NOSPLIT, $0-9
+	MOVQ v+0(FP), BX
+	BYTE $0x48; BYTE $0x0f; BYTE $0xc7; BYTE $0xf0 // RDRAND AX
+	SETCS ret+8(FP)
+	MOVQ AX, 0(BX)
+	RET
+
+// func rdseed(v *uint64) bool
+TEXT ·rdseed(SB), NOSPLIT, $0-9
+	MOVQ v+0(FP), BX
+	BYTE $0x48; BYTE $0x0f; BYTE $0xc7; BYTE $0xf8 // RDSEED AX
+	SETCS ret+8(FP)
+	MOVQ AX, 0(BX)
+	RET
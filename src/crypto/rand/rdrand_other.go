@@ -0,0 +1,6 @@
+// +build !amd64
+
+package rand
+
+// The cpu has no entropy source the enclave can use.
+var hwRand, hwSeed func(v *uint64) bool
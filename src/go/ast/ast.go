@@ -321,6 +321,13 @@ type (
 		Rparen   token.Pos // position of ")"
 	}
 
+	// A GosecExpr node represents a gosecure call used as an expression,
+	// the channel of the results of the call.
+	GosecExpr struct {
+		Gosecure token.Pos // position of "gosecure" keyword
+		Call     *CallExpr
+	}
+
 	// A StarExpr node represents an expression of the form "*" Expression.
 	// Semantically it could be a unary "*" expression, or a pointer type.
 	//
@@ -436,6 +443,7 @@ func (x *IndexExpr) Pos() token.Pos      { return x.X.Pos() }
 func (x *SliceExpr) Pos() token.Pos      { return x.X.Pos() }
 func (x *TypeAssertExpr) Pos() token.Pos { return x.X.Pos() }
 func (x *CallExpr) Pos() token.Pos       { return x.Fun.Pos() }
+func (x *GosecExpr) Pos() token.Pos      { return x.Gosecure }
 func (x *StarExpr) Pos() token.Pos       { return x.Star }
 func (x *UnaryExpr) Pos() token.Pos      { return x.OpPos }
 func (x *BinaryExpr) Pos() token.Pos     { return x.X.Pos() }
@@ -469,6 +477,7 @@ func (x *IndexExpr) End() token.Pos      { return x.Rbrack + 1 }
 func (x *SliceExpr) End() token.Pos      { return x.Rbrack + 1 }
 func (x *TypeAssertExpr) End() token.Pos { return x.Rparen + 1 }
 func (x *CallExpr) End() token.Pos       { return x.Rparen + 1 }
+func (x *GosecExpr) End() token.Pos      { return x.Call.End() }
 func (x *StarExpr) End() token.Pos       { return x.X.End() }
 func (x *UnaryExpr) End() token.Pos      { return x.X.End() }
 func (x *BinaryExpr) End() token.Pos     { return x.Y.End() }
@@ -500,6 +509,7 @@ func (*IndexExpr) exprNode()      {}
 func (*SliceExpr) exprNode()      {}
 func (*TypeAssertExpr) exprNode() {}
 func (*CallExpr) exprNode()       {}
+func (*GosecExpr) exprNode()      {}
 func (*StarExpr) exprNode()       {}
 func (*UnaryExpr) exprNode()      {}
 func (*BinaryExpr) exprNode()     {}
@@ -990,15 +1000,15 @@ func (*FuncDecl) declNode() {}
 // are "free-floating" (see also issues #18593, #20744).
 //
 type File struct {
-	Doc        *CommentGroup       // associated documentation; or nil
-	Package    token.Pos           // position of "package" keyword
-	Name       *Ident              // package name
-	Decls      []Decl              // top-level declarations; or nil
-	Scope      *Scope              // package scope (this file only)
-	Imports    []*ImportSpec       // imports in this file
-	Unresolved []*Ident            // unresolved identifiers in this file
-	Comments   []*CommentGroup     // list of all comments in the source file
-	GosecCalls map[string][]string //@aghosn holds the gosecure call nodes
+	Doc        *CommentGroup   // associated documentation; or nil
+	Package    token.Pos       // position of "package" keyword
+	Name       *Ident          // package name
+	Decls      []Decl          // top-level declarations; or nil
+	Scope      *Scope          // package scope (this file only)
+	Imports    []*ImportSpec   // imports in this file
+	Unresolved []*Ident        // unresolved identifiers in this file
+	Comments   []*CommentGroup // list of all comments in the source file
+	GosecCalls []string        //@aghosn holds the callees of gosecure calls
 }
 
 func (f *File) Pos() token.Pos { return f.Package }
@@ -136,6 +136,9 @@ func Walk(v Visitor, node Node) {
 		Walk(v, n.Fun)
 		walkExprList(v, n.Args)
 
+	case *GosecExpr:
+		Walk(v, n.Call)
+
 	case *StarExpr:
 		Walk(v, n.X)
 
@@ -209,6 +212,9 @@ func Walk(v Visitor, node Node) {
 	case *GoStmt:
 		Walk(v, n.Call)
 
+	case *GosecStmt:
+		Walk(v, n.Call)
+
 	case *DeferStmt:
 		Walk(v, n.Call)
 
@@ -427,7 +427,8 @@ type Package struct {
 	XTestImportPos map[string][]token.Position // line information for XTestImports
 
 	// Gosecure information
-	Gosectargets map[string][]string // The gosecure nodes sorted by package
+	Gosectargets []string // callees of the gosecure calls of the package
+	Gosecenclave string   // enclave of the gosecure targets of the package, from //gosec:enclave
 }
 
 // IsCommand reports whether the package is considered a
@@ -893,7 +894,17 @@ Found:
 		}
 
 		// @aghosn add the gosecure callees.
-		p.Gosectargets = pf.GosecCalls
+		p.Gosectargets = append(p.Gosectargets, pf.GosecCalls...)
+		if encl, line := findGosecEnclave(fset, pf); line != 0 {
+			switch {
+			case encl == "":
+				badFile(fmt.Errorf("%s:%d: malformed //gosec:enclave comment", filename, line))
+			case p.Gosecenclave != "" && encl != p.Gosecenclave:
+				badFile(fmt.Errorf("%s:%d: enclave %s, but another file of the package has enclave %s", filename, line, encl, p.Gosecenclave))
+			default:
+				p.Gosecenclave = encl
+			}
+		}
 	}
 	if badGoError != nil {
 		return p, badGoError
@@ -908,7 +919,7 @@ Found:
 	sort.Strings(p.AllTags)
 
 	p.Imports, p.ImportPos = cleanImports(imported)
-	if p.Gosectargets != nil && len(p.Gosectargets) > 0 {
+	if _, ok := imported["gosec"]; !ok && len(p.Gosectargets) > 0 {
 		p.Imports = append(p.Imports, "gosec")
 	}
 	p.TestImports, p.TestImportPos = cleanImports(testImported)
@@ -1104,7 +1115,8 @@ func (ctxt *Context) matchFile(dir, name string, allTags map[string]bool, binary
 	}
 
 	if strings.HasSuffix(filename, ".go") {
-		data, err = readImports(f, false, nil)
+		// The gosecure calls may be anywhere in the file, see Gosectargets.
+		data, err = ioutil.ReadAll(f)
 		if strings.HasSuffix(filename, "_test.go") {
 			binaryOnly = nil // ignore //go:binary-only-package comments in _test.go files
 		}
@@ -1152,6 +1164,31 @@ func ImportDir(dir string, mode ImportMode) (*Package, error) {
 
 var slashslash = []byte("//")
 
+var gosecEnclaveComment = "//gosec:enclave"
+
+// findGosecEnclave returns the name set by the //gosec:enclave comment of f,
+// before its package clause, and the line of the comment. The line is 0 if
+// there is none, and the name empty if the comment is malformed.
+func findGosecEnclave(fset *token.FileSet, f *ast.File) (name string, line int) {
+	for _, g := range f.Comments {
+		if g.Pos() > f.Package {
+			break
+		}
+		for _, c := range g.List {
+			rest := strings.TrimPrefix(c.Text, gosecEnclaveComment)
+			if rest == c.Text || rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+				continue
+			}
+			line = fset.Position(c.Pos()).Line
+			if fields := strings.Fields(rest); len(fields) == 1 {
+				return fields[0], line
+			}
+			return "", line
+		}
+	}
+	return "", 0
+}
+
 // Special comment denoting a binary-only package.
 // See https://golang.org/design/2775-binary-only-packages
 // for more about the design of binary-only packages.
@@ -5,6 +5,8 @@
 package build
 
 import (
+	"go/parser"
+	"go/token"
 	"internal/testenv"
 	"io"
 	"os"
@@ -13,6 +15,7 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestMatch(t *testing.T) {
@@ -109,6 +112,44 @@ func TestMultiplePackageImport(t *testing.T) {
 	}
 }
 
+func TestGosecImport(t *testing.T) {
+	p, err := Import(".", "testdata/gosec", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Gosecenclave != "keys" || !reflect.DeepEqual(p.Gosectargets, []string{"Sign"}) {
+		t.Errorf("enclave %q, targets %q; want keys, [Sign]", p.Gosecenclave, p.Gosectargets)
+	}
+	if p.Doc != "Package keys runs its gosecure targets in the enclave keys." {
+		t.Errorf("doc %q", p.Doc)
+	}
+}
+
+func TestFindGosecEnclave(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		name string
+		line int
+	}{
+		{"package p", "", 0},
+		{"//gosec:enclave keys\n\npackage p", "keys", 1},
+		{"// +build linux\n\n//gosec:enclave\tkeys\npackage p", "keys", 3},
+		{"//gosec:enclave\npackage p", "", 1},
+		{"//gosec:enclave a b\npackage p", "", 1},
+		{"//gosec:enclaves keys\npackage p", "", 0},
+		{"package p\n\n//gosec:enclave keys\nfunc f() {}", "", 0},
+	} {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "p.go", tt.src, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name, line := findGosecEnclave(fset, f); name != tt.name || line != tt.line {
+			t.Errorf("%q: %q at line %d, want %q at line %d", tt.src, name, line, tt.name, tt.line)
+		}
+	}
+}
+
 func TestLocalDirectory(t *testing.T) {
 	if runtime.GOOS == "darwin" {
 		switch runtime.GOARCH {
@@ -230,6 +271,52 @@ func TestMatchFile(t *testing.T) {
 	}
 }
 
+// TestGosecImportContext checks that the gosecure calls are read through the
+// hooks of the context.
+func TestGosecImportContext(t *testing.T) {
+	files := map[string]string{
+		"a.go": "package p\n\nimport \"fmt\"\n\nfunc f() {\n\tfmt.Println()\n\tgosecure g(1)\n}\n",
+		"b.go": "package p\n\nfunc g(int) {}\n",
+	}
+	ctxt := Default
+	ctxt.GOROOT, ctxt.GOPATH = "/goroot", ""
+	ctxt.IsDir = func(path string) bool { return path == "/p" }
+	ctxt.ReadDir = func(dir string) ([]os.FileInfo, error) {
+		var fis []os.FileInfo
+		for name, data := range files {
+			fis = append(fis, fileInfo{name, int64(len(data))})
+		}
+		return fis, nil
+	}
+	ctxt.OpenFile = func(path string) (io.ReadCloser, error) {
+		data, ok := files[strings.TrimPrefix(path, "/p/")]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return &readNopCloser{strings.NewReader(data)}, nil
+	}
+	p, err := ctxt.ImportDir("/p", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.Gosectargets, []string{"g"}) {
+		t.Errorf("targets %q, want [g]", p.Gosectargets)
+	}
+}
+
+// fileInfo is a regular file of the context of TestGosecImportContext.
+type fileInfo struct {
+	name string
+	size int64
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() os.FileMode  { return 0644 }
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() interface{}   { return nil }
+
 func TestImportCmd(t *testing.T) {
 	if runtime.GOOS == "darwin" {
 		switch runtime.GOARCH {
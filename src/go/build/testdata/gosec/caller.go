@@ -0,0 +1,5 @@
+package keys
+
+func sign() {
+	gosecure Sign(nil)
+}
@@ -1,63 +1,59 @@
 package parser
 
 import (
+	"bytes"
 	"go/ast"
 	"go/token"
 )
 
-// parseGosecCalls returns the stmt that contain the gosecure keyword.
-// These are then stored in the File describing the package.
-// We reload the source which is not efficient, but avoids modifying too much
-// of the existing code.
-// This function panics if called on a package that is not main.
-func parseGosecCalls(fset *token.FileSet, filename string) (m map[string][]string) {
-	bytes, err := readSource(filename, nil)
-	if err != nil {
-		panic(err)
+// parseGosecCalls returns the callees of the gosecure calls in the source src
+// of the file, as written in the source, e.g., "f", "pkg.F", "x.Method" or
+// "func literal". These are then stored in the File describing the package.
+// The calls after the end of src are not found: when only the imports are
+// parsed, the caller passes the whole file if it needs them, see go/build.
+func parseGosecCalls(filename string, src []byte) (calls []string) {
+	if !bytes.Contains(src, []byte(token.GOSEC.String())) {
+		return nil
 	}
 
+	// The positions are not reported, do not add the file to the caller's set.
 	var p parser
-	var s []*ast.GosecStmt
-
-	p.init(fset, filename, bytes, ImportsOnly|ParseComments)
-
-	p.expect(token.PACKAGE)
-	ident := p.parseIdent()
-
-	if ident.Name != "main" {
-		p.error(p.pos, "should not parse gosecure calls outside of package main.")
-		return
-	}
-
+	defer func() {
+		if e := recover(); e != nil {
+			// Keep the calls found before too many errors.
+			if _, ok := e.(bailout); !ok {
+				panic(e)
+			}
+		}
+	}()
+	p.init(token.NewFileSet(), filename, src, ImportsOnly)
 	for p.tok != token.EOF {
-		if p.tok == token.GOSEC {
-			a := p.parseGosecStmt().(*ast.GosecStmt)
-			s = append(s, a)
-		} else {
+		if p.tok != token.GOSEC {
 			p.next()
+			continue
 		}
-	}
-
-	if len(s) != 0 {
-		m = make(map[string][]string)
-	}
-
-	// Convert to the expected output format.
-	for _, e := range s {
-		switch v := e.Call.Fun.(type) {
-		case *ast.Ident:
-			m["main"] = append(m["main"], v.Name)
-		case *ast.SelectorExpr:
-			n, ok := v.X.(*ast.Ident)
-			if !ok || v.Sel == nil {
-				p.error(v.Pos(), "invalid selector expression for gosecure call.")
-				continue
-			}
-			m[n.Name] = append(m[n.Name], v.Sel.Name)
-		default:
-			p.error(v.Pos(), "Unable to verify the expression type for the gosecure callee.")
+		// Statements and expressions alike.
+		if _, call := p.parseGosecCall(); call != nil {
+			calls = append(calls, gosecCallee(call.Fun))
 		}
 	}
-
 	return
 }
+
+// gosecCallee returns a textual representation of the callee of a gosecure
+// call. The compiler checks that it is valid.
+func gosecCallee(e ast.Expr) string {
+	switch v := e.(type) {
+	case *ast.Ident:
+		return v.Name
+	case *ast.SelectorExpr:
+		return gosecCallee(v.X) + "." + v.Sel.Name
+	case *ast.StarExpr:
+		return "*" + gosecCallee(v.X)
+	case *ast.ParenExpr:
+		return "(" + gosecCallee(v.X) + ")"
+	case *ast.FuncLit:
+		return "func literal"
+	}
+	return "expression"
+}
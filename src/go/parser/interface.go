@@ -120,8 +120,8 @@ func ParseFile(fset *token.FileSet, filename string, src interface{}, mode Mode)
 	p.init(fset, filename, text, mode)
 	f = p.parseFile()
 
-	if f.Name != nil && f.Name.Name == "main" {
-		f.GosecCalls = parseGosecCalls(fset, filename)
+	if f != nil && f.Name != nil {
+		f.GosecCalls = parseGosecCalls(filename, text)
 	}
 
 	return
@@ -1380,6 +1380,7 @@ func (p *parser) checkExpr(x ast.Expr) ast.Expr {
 		// a type switch. Instead be lenient and test this in the type
 		// checker.
 	case *ast.CallExpr:
+	case *ast.GosecExpr:
 	case *ast.StarExpr:
 	case *ast.UnaryExpr:
 	case *ast.BinaryExpr:
@@ -1525,6 +1526,14 @@ func (p *parser) parseUnaryExpr(lhs bool) ast.Expr {
 		x := p.parseUnaryExpr(false)
 		return &ast.UnaryExpr{OpPos: pos, Op: op, X: p.checkExpr(x)}
 
+	case token.GOSEC:
+		// gosecure call as an expression
+		pos, call := p.parseGosecCall()
+		if call == nil {
+			return &ast.BadExpr{From: pos, To: p.pos}
+		}
+		return &ast.GosecExpr{Gosecure: pos, Call: call}
+
 	case token.ARROW:
 		// channel type or receive expression
 		arrow := p.pos
@@ -2455,8 +2464,7 @@ func (p *parser) parseGosecStmt() ast.Stmt {
 	if p.trace {
 		defer un(trace(p, "GosecureStmt"))
 	}
-	pos := p.expect(token.GOSEC)
-	call := p.parseCallExpr("gosecure")
+	pos, call := p.parseGosecCall()
 	p.expectSemi()
 	if call == nil {
 		return &ast.BadStmt{From: pos, To: pos + 8} // len("gosecure")
@@ -2464,6 +2472,12 @@ func (p *parser) parseGosecStmt() ast.Stmt {
 	return &ast.GosecStmt{Gosecure: pos, Call: call}
 }
 
+// parseGosecCall parses "gosecure" Call, in a statement or an expression.
+func (p *parser) parseGosecCall() (token.Pos, *ast.CallExpr) {
+	pos := p.expect(token.GOSEC)
+	return pos, p.parseCallExpr("gosecure")
+}
+
 // ----------------------------------------------------------------------------
 // Source files
 
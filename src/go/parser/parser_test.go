@@ -10,6 +10,7 @@ import (
 	"go/ast"
 	"go/token"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -546,3 +547,27 @@ type x int // comment
 		t.Errorf("got %q, want %q", comment, "// comment")
 	}
 }
+
+// TestGosecCalls checks that the gosecure calls are found in the source passed
+// by the caller, and not read from the file.
+func TestGosecCalls(t *testing.T) {
+	const src = `package p
+import "x"
+func f() {
+	gosecure g(1)
+	c := gosecure x.H(2)
+	gosecure func() {}()
+	_ = c
+}
+`
+	for _, mode := range []Mode{0, ImportsOnly} {
+		f, err := ParseFile(token.NewFileSet(), "nonexistent.go", src, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"g", "x.H", "func literal"}
+		if !reflect.DeepEqual(f.GosecCalls, want) {
+			t.Errorf("mode %v: calls %q, want %q", mode, f.GosecCalls, want)
+		}
+	}
+}
@@ -48,6 +48,9 @@ var valids = []string{
 	`package p; var _ = map[*P]int{&P{}:0, {}:1}`,
 	`package p; type T = int`,
 	`package p; type (T = p.T; _ = struct{}; x = *T)`,
+	`package p; func f() { gosecure g(1) }`,
+	`package p; func f() { c := gosecure x.g(1, 2); r := <-c; _ = r.R0 }`,
+	`package p; func f() { _ = <-gosecure func() int { return 0 }() }`,
 }
 
 func TestValid(t *testing.T) {
@@ -708,6 +708,20 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 		p.print(x.Colon, token.COLON, blank)
 		p.expr(x.Value)
 
+	case *ast.GosecExpr:
+		const prec = token.UnaryPrec
+		if prec < prec1 {
+			// parenthesis needed
+			p.print(token.LPAREN)
+			p.print(x.Gosecure, token.GOSEC, blank)
+			p.expr(x.Call)
+			p.print(token.RPAREN)
+		} else {
+			// no parenthesis needed
+			p.print(x.Gosecure, token.GOSEC, blank)
+			p.expr(x.Call)
+		}
+
 	case *ast.StarExpr:
 		const prec = token.UnaryPrec
 		if prec < prec1 {
@@ -1171,6 +1185,10 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 		p.print(token.GO, blank)
 		p.expr(s.Call)
 
+	case *ast.GosecStmt:
+		p.print(token.GOSEC, blank)
+		p.expr(s.Call)
+
 	case *ast.DeferStmt:
 		p.print(token.DEFER, blank)
 		p.expr(s.Call)
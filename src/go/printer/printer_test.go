@@ -194,6 +194,7 @@ var data = []entry{
 	{"declarations.input", "declarations.golden", 0},
 	{"statements.input", "statements.golden", 0},
 	{"slow.input", "slow.golden", idempotent},
+	{"gosecure.input", "gosecure.golden", idempotent},
 }
 
 func TestFiles(t *testing.T) {
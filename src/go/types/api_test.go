@@ -243,6 +243,24 @@ func TestTypesInfo(t *testing.T) {
 			`<-ch`,
 			`(string, bool)`,
 		},
+
+		// gosecure expressions
+		{`package g0; func f(int) (int, string); var c = gosecure f(1)`,
+			`gosecure f(1)`,
+			`<-chan struct{R0 int; R1 string; Err error}`,
+		},
+		{`package g1; func f(); var r = <-gosecure f()`,
+			`gosecure f()`,
+			`<-chan struct{Err error}`,
+		},
+		{`package g2; type T struct{}; func (T) M(...int) *T; var t T; var r = (<-gosecure t.M()).R0`,
+			`(<-gosecure t.M()).R0`,
+			`*g2.T`,
+		},
+		{`package g3; func f(...int) []int; var r = <-gosecure f()`,
+			`<-gosecure f()`,
+			`struct{R0 []int; Err error}`,
+		},
 	}
 
 	for _, test := range tests {
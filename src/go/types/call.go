@@ -9,6 +9,7 @@ package types
 import (
 	"go/ast"
 	"go/token"
+	"strconv"
 )
 
 func (check *Checker) call(x *operand, e *ast.CallExpr) exprKind {
@@ -87,6 +88,37 @@ func (check *Checker) call(x *operand, e *ast.CallExpr) exprKind {
 	}
 }
 
+// gosecExpr typechecks the gosecure expression e. As in cmd/compile, its value
+// is a receive-only channel of a struct with the results R0, ..., Rn-1 of the
+// call, and Err, which holds the panic of the call if any.
+func (check *Checker) gosecExpr(x *operand, e *ast.GosecExpr) {
+	if check.rawExpr(x, e.Call, nil) == conversion {
+		check.errorf(x.pos(), "gosecure requires function call, not conversion")
+		x.mode = invalid
+	}
+	var results []Type
+	switch {
+	case x.mode == invalid:
+		return
+	case x.mode == novalue:
+	default:
+		if t, _ := x.typ.(*Tuple); t != nil {
+			for _, v := range t.vars {
+				results = append(results, v.typ)
+			}
+		} else {
+			results = append(results, Default(x.typ))
+		}
+	}
+	fields := make([]*Var, 0, len(results)+1)
+	for i, typ := range results {
+		fields = append(fields, NewField(e.Pos(), check.pkg, "R"+strconv.Itoa(i), typ, false))
+	}
+	fields = append(fields, NewField(e.Pos(), check.pkg, "Err", Universe.Lookup("error").Type(), false))
+	x.mode = value
+	x.typ = NewChan(RecvOnly, NewStruct(fields, nil))
+}
+
 // use type-checks each argument.
 // Useful to make sure expressions are evaluated
 // (and variables are "used") in the presence of other errors.
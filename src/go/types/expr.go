@@ -1443,6 +1443,12 @@ func (check *Checker) exprInternal(x *operand, e ast.Expr, hint Type) exprKind {
 	case *ast.CallExpr:
 		return check.call(x, e)
 
+	case *ast.GosecExpr:
+		check.gosecExpr(x, e)
+		if x.mode == invalid {
+			goto Error
+		}
+
 	case *ast.StarExpr:
 		check.exprOrType(x, e.X)
 		switch x.mode {
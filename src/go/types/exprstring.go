@@ -109,6 +109,10 @@ func WriteExpr(buf *bytes.Buffer, x ast.Expr) {
 		}
 		buf.WriteByte(')')
 
+	case *ast.GosecExpr:
+		buf.WriteString("gosecure ")
+		WriteExpr(buf, x.Call)
+
 	case *ast.StarExpr:
 		buf.WriteByte('*')
 		WriteExpr(buf, x.X)
@@ -427,6 +427,9 @@ func (check *Checker) stmt(ctxt stmtContext, s ast.Stmt) {
 	case *ast.GoStmt:
 		check.suspendedCall("go", s.Call)
 
+	case *ast.GosecStmt:
+		check.suspendedCall("gosecure", s.Call)
+
 	case *ast.DeferStmt:
 		check.suspendedCall("defer", s.Call)
 
@@ -0,0 +1,103 @@
+package gosec
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// An Adversary scripts a hostile untrusted runtime, to test that the code of
+// the enclave fails closed: it can forge, drop and swap the replies to the
+// requests of the enclave, delay its futex wake ups and corrupt the arguments
+// of its gosecure calls. The zero Adversary is an honest host.
+type Adversary struct {
+	// Reply, if not nil, is called with each request of the enclave that
+	// expects a reply, once the honest host served it. It can change the
+	// reply and the untrusted memory of the request, and drops the reply,
+	// which blocks the enclave thread for good, if it returns false.
+	Reply func(req *runtime.OcallReq, res *runtime.OcallRes) bool
+
+	// Reorder, if not 0, holds the replies for that long, and delivers the
+	// ones held together crossed: each request gets the reply of the next.
+	Reorder time.Duration
+
+	// Corrupt, if not nil, is called with the untrusted buffer of the
+	// arguments of each gosecure call, before the enclave receives it. The
+	// collector of the untrusted side scans the buffer: the pointers it
+	// writes must be valid.
+	Corrupt func(buf []byte)
+
+	// FutexDelay delays the futex wake ups the enclave asks for.
+	FutexDelay time.Duration
+
+	mu   sync.Mutex
+	held []heldReply
+}
+
+type heldReply struct {
+	id  int
+	res runtime.OcallRes
+}
+
+// adversary is the untrusted runtime, if not honest.
+var adversary *Adversary
+
+// SetAdversary makes the untrusted runtime of all the enclaves behave as adv.
+// It only applies to the simulation, and must be called before the first
+// gosecure call.
+func SetAdversary(adv *Adversary) {
+	if os.Getenv("SIM") == "" {
+		panic("gosec: an adversary requires the simulation")
+	}
+	if anyLoaded() {
+		panic("gosec: SetAdversary after an enclave is loaded")
+	}
+	adversary = adv
+}
+
+// serve serves the request sys of the enclave of srv as a.
+func (a *Adversary) serve(srv *servers, sys runtime.OcallReq, send func(int, runtime.OcallRes)) {
+	if a.FutexDelay != 0 && sys.Big == runtime.S6 && sys.Trap == syscall.SYS_FUTEX {
+		go func() {
+			runtime.MarkNoFutex()
+			time.Sleep(a.FutexDelay)
+			a.reply(srv, sys, send)
+		}()
+		return
+	}
+	a.reply(srv, sys, send)
+}
+
+// reply makes the request sys, and sends its reply as a.
+func (a *Adversary) reply(srv *servers, sys runtime.OcallReq, send func(int, runtime.OcallRes)) {
+	res, ok := srv.serveOcall(sys)
+	if !ok {
+		return
+	}
+	if a.Reply != nil && !a.Reply(&sys, &res) {
+		return
+	}
+	if a.Reorder == 0 {
+		go send(sys.Id, res)
+		return
+	}
+	a.mu.Lock()
+	a.held = append(a.held, heldReply{sys.Id, res})
+	if len(a.held) == 1 {
+		time.AfterFunc(a.Reorder, func() { a.flush(send) })
+	}
+	a.mu.Unlock()
+}
+
+// flush sends the replies held, crossed.
+func (a *Adversary) flush(send func(int, runtime.OcallRes)) {
+	a.mu.Lock()
+	held := a.held
+	a.held = nil
+	a.mu.Unlock()
+	for i := range held {
+		go send(held[i].id, held[(i+1)%len(held)].res)
+	}
+}
@@ -0,0 +1,220 @@
+package gosec
+
+import (
+	"bytes"
+	"internal/testenv"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+type reply struct {
+	id  int
+	res runtime.OcallRes
+}
+
+// replies returns a send function for the adversary that collects the
+// replies in a channel.
+func replies() (func(int, runtime.OcallRes), chan reply) {
+	c := make(chan reply, 10)
+	return func(id int, res runtime.OcallRes) { c <- reply{id, res} }, c
+}
+
+// testServers serve the requests outside of an enclave.
+var testServers = newServers(nil)
+
+func getuid(id int) runtime.OcallReq {
+	return runtime.OcallReq{Big: runtime.S3, Trap: syscall.SYS_GETUID, Id: id}
+}
+
+func TestAdversaryReply(t *testing.T) {
+	send, c := replies()
+	adv := &Adversary{Reply: func(req *runtime.OcallReq, res *runtime.OcallRes) bool {
+		if req.Id == 2 {
+			return false
+		}
+		res.R1 = 4242
+		return true
+	}}
+	adv.serve(testServers, getuid(1), send)
+	adv.serve(testServers, getuid(2), send)
+	adv.serve(testServers, getuid(3), send)
+	got := map[int]uintptr{}
+	for i := 0; i < 2; i++ {
+		r := <-c
+		got[r.id] = r.res.R1
+	}
+	if len(got) != 2 || got[1] != 4242 || got[3] != 4242 {
+		t.Errorf("replies %v, want forged replies to 1 and 3", got)
+	}
+	select {
+	case r := <-c:
+		t.Errorf("dropped reply sent: %+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAdversaryReorder(t *testing.T) {
+	send, c := replies()
+	uid := uintptr(syscall.Getuid())
+	adv := &Adversary{
+		Reply: func(req *runtime.OcallReq, res *runtime.OcallRes) bool {
+			res.R1 = uintptr(req.Id)
+			return true
+		},
+		Reorder: 50 * time.Millisecond,
+	}
+	adv.serve(testServers, getuid(1), send)
+	adv.serve(testServers, getuid(2), send)
+	got := map[int]uintptr{}
+	for i := 0; i < 2; i++ {
+		r := <-c
+		got[r.id] = r.res.R1
+	}
+	if got[1] != 2 || got[2] != 1 {
+		t.Errorf("replies %v, want crossed replies", got)
+	}
+
+	// A single reply is only delayed.
+	adv.Reply = nil
+	adv.serve(testServers, getuid(3), send)
+	if r := <-c; r.id != 3 || r.res.R1 != uid {
+		t.Errorf("reply %+v, want %d", r, uid)
+	}
+}
+
+func TestAdversaryFutexDelay(t *testing.T) {
+	send, c := replies()
+	const delay = 100 * time.Millisecond
+	adv := &Adversary{FutexDelay: delay}
+	var word uint32
+	wake := runtime.OcallReq{Big: runtime.S6, Trap: syscall.SYS_FUTEX, A1: uintptr(unsafe.Pointer(&word)), A2: 1 /* FUTEX_WAKE */, A3: 1, Id: 1}
+	start := time.Now()
+	adv.serve(testServers, wake, send)
+	adv.serve(testServers, getuid(2), send)
+	if r := <-c; r.id != 2 {
+		t.Errorf("first reply to %d, want the getuid", r.id)
+	}
+	if r := <-c; r.id != 1 || r.res.Err != 0 {
+		t.Errorf("reply %+v, want the futex wake up", r)
+	}
+	if d := time.Since(start); d < delay {
+		t.Errorf("futex wake up after %v, want at least %v", d, delay)
+	}
+}
+
+const adversaryProgram = `package main
+
+import (
+	"fmt"
+	"gosec"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+func read(path string, buf []byte, done chan string) {
+	f, err := os.Open(path)
+	if err != nil {
+		done <- err.Error()
+		return
+	}
+	n, err := f.Read(buf)
+	if err != nil {
+		done <- err.Error()
+		return
+	}
+	st, err := f.Stat()
+	if err != nil {
+		done <- err.Error()
+		return
+	}
+	done <- fmt.Sprint("read ", string(buf[:n]), " of ", st.Size())
+}
+
+func main() {
+	adv := &gosec.Adversary{}
+	switch os.Getenv("GOSEC_ADVERSARY") {
+	case "read":
+		adv.Reply = func(req *runtime.OcallReq, res *runtime.OcallRes) bool {
+			if req.Trap == syscall.SYS_READ && res.Err == 0 {
+				res.R1 = req.A3 + 1
+			}
+			return true
+		}
+	case "stat":
+		adv.Reply = func(req *runtime.OcallReq, res *runtime.OcallRes) bool {
+			if req.Trap == syscall.SYS_FSTAT {
+				(*syscall.Stat_t)(unsafe.Pointer(req.A2)).Mode = 0
+			}
+			return true
+		}
+	case "args":
+		// The length of buf follows the string path.
+		adv.Corrupt = func(buf []byte) {
+			*(*int)(unsafe.Pointer(&buf[unsafe.Sizeof("")+unsafe.Sizeof(uintptr(0))])) = 1 << 20
+		}
+	}
+	gosec.SetAdversary(adv)
+	f, err := ioutil.TempFile("", "gosec")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("secret")
+	f.Close()
+	done := make(chan string)
+	gosecure read(f.Name(), make([]byte, 6), done)
+	println(<-done)
+}
+`
+
+// TestAdversarySimulation checks that the enclave aborts on forged results of
+// its system calls and on corrupted arguments of its gosecure calls.
+func TestAdversarySimulation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs an enclave")
+	}
+	testenv.MustHaveGoBuild(t)
+	dir, err := ioutil.TempDir("", "gosec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, exe := filepath.Join(dir, "main.go"), filepath.Join(dir, "main")
+	if err := ioutil.WriteFile(src, []byte(adversaryProgram), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(testenv.GoToolPath(t), "build", "-o", exe, src).CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	for _, tt := range []struct {
+		adversary, want string
+	}{
+		{"honest", "read secret of 6"},
+		{"read", "panic: gosec: untrusted result of system call 0: result 7 larger than the size 6"},
+		{"stat", "panic: gosec: untrusted result of system call 5: invalid file mode 0"},
+		{"args", "panic: gosecommon: []uint8 of length 1048576 and capacity 6"},
+	} {
+		cmd := exec.Command(exe)
+		cmd.Env = append(os.Environ(), "SIM=1", "GOSEC_ADVERSARY="+tt.adversary)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		if tt.adversary == "honest" && err != nil || tt.adversary != "honest" && err == nil {
+			t.Errorf("%s: %v\n%s", tt.adversary, err, stderr.Bytes())
+		}
+		if !strings.Contains(stderr.String(), tt.want) {
+			t.Errorf("%s: output without %q\n%s", tt.adversary, tt.want, stderr.Bytes())
+		}
+	}
+}
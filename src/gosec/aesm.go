@@ -0,0 +1,307 @@
+package gosec
+
+/* This file implements a client for the aesmd service of the Intel SGX PSW.
+ * Requests and responses are protobuf messages (aesm.message.Request and
+ * aesm.message.Response) framed by their length on the aesmd unix socket.
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// AesmSocket is the default location of the aesmd socket.
+	AesmSocket = "/var/run/aesmd/aesm.socket"
+
+	// EinitTokenSize is the size of the launch token returned by aesmd.
+	EinitTokenSize = 304
+
+	_aesmDefaultTimeout = 10 * time.Second
+	_aesmMaxMsgSize     = 1 << 20
+)
+
+// Fields of aesm.message.Request and aesm.message.Response.
+const (
+	_aesmGetLaunchTokenField = 3
+
+	_tokenReqEnclaveHash  = 1
+	_tokenReqModulus      = 2
+	_tokenReqSeAttributes = 3
+	_tokenReqTimeout      = 9
+
+	_tokenResErrorCode = 1
+	_tokenResToken     = 2
+)
+
+// Protobuf wire types.
+const (
+	_wireVarint = 0
+	_wire64     = 1
+	_wireBytes  = 2
+	_wire32     = 5
+)
+
+// AesmError is the error code returned by aesmd (aesm_error_t).
+type AesmError uint32
+
+var aesmErrors = map[AesmError]string{
+	1:  "unexpected error",
+	2:  "no device",
+	3:  "parameter error",
+	4:  "epid blob error",
+	5:  "epid revoked",
+	6:  "get license token error",
+	7:  "session invalid",
+	8:  "max num session reached",
+	9:  "psda unavailable",
+	10: "emp neg error",
+	12: "mp error",
+	13: "launch token not supported",
+	14: "out of memory",
+	15: "network error",
+	16: "network busy",
+	17: "proxy setting error",
+	18: "busy",
+	19: "psda session lost",
+	20: "pse pr not updated",
+	21: "service stopped",
+	22: "service unavailable",
+	25: "out of epc",
+	30: "update available",
+	36: "no platform cert data",
+}
+
+func (e AesmError) Error() string {
+	if s, ok := aesmErrors[e]; ok {
+		return fmt.Sprintf("aesm: %s (%d)", s, uint32(e))
+	}
+	return fmt.Sprintf("aesm: error %d", uint32(e))
+}
+
+// AesmClient talks to the aesmd service over its unix socket.
+// Every call opens its own connection, as the service expects.
+type AesmClient struct {
+	Path    string        // the socket, AesmSocket if empty.
+	Timeout time.Duration // request timeout, 10s if zero.
+}
+
+func (c *AesmClient) path() string {
+	if c.Path == "" {
+		return AesmSocket
+	}
+	return c.Path
+}
+
+func (c *AesmClient) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return _aesmDefaultTimeout
+	}
+	return c.Timeout
+}
+
+// GetLaunchToken asks the launch enclave for the EINITTOKEN of the enclave
+// described by req.
+func (c *AesmClient) GetLaunchToken(req *LaunchTokenRequest) ([]byte, error) {
+	if len(req.MrEnclave) != SGX_HASH_SIZE {
+		return nil, errors.New("aesm: invalid enclave hash size")
+	}
+	if len(req.MrSigner) != SE_KEY_SIZE {
+		return nil, errors.New("aesm: invalid signer key size")
+	}
+	if len(req.SeAttributes) != int(_attribSize) {
+		return nil, errors.New("aesm: invalid attributes size")
+	}
+	tmout := uint32(c.timeout() / time.Millisecond)
+	if req.Timeout != nil {
+		tmout = *req.Timeout
+	}
+	var inner []byte
+	inner = appendBytesField(inner, _tokenReqEnclaveHash, req.MrEnclave)
+	inner = appendBytesField(inner, _tokenReqModulus, req.MrSigner)
+	inner = appendBytesField(inner, _tokenReqSeAttributes, req.SeAttributes)
+	inner = appendVarintField(inner, _tokenReqTimeout, uint64(tmout))
+	msg := appendBytesField(nil, _aesmGetLaunchTokenField, inner)
+
+	res, err := c.transact(msg)
+	if err != nil {
+		return nil, err
+	}
+	body, err := findBytesField(res, _aesmGetLaunchTokenField)
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		return nil, errors.New("aesm: response without launch token")
+	}
+	// errorCode is required and defaults to AESM_UNEXPECTED_ERROR.
+	code := uint64(1)
+	var token []byte
+	err = walkFields(body, func(num int, wire int, v uint64, b []byte) {
+		switch {
+		case num == _tokenResErrorCode && wire == _wireVarint:
+			code = v
+		case num == _tokenResToken && wire == _wireBytes:
+			token = b
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, AesmError(code)
+	}
+	if len(token) != EinitTokenSize {
+		return nil, fmt.Errorf("aesm: invalid token size %d", len(token))
+	}
+	return token, nil
+}
+
+// transact sends a request and returns the raw response. It does not rely on
+// package net, that would link the cgo resolver in every gosec binary.
+func (c *AesmClient) transact(req []byte) ([]byte, error) {
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	conn := os.NewFile(uintptr(fd), c.path())
+	defer conn.Close()
+	// The service may take a while to provision: the receive and send
+	// deadlines of the socket allow twice the request timeout before a read
+	// or a write fails.
+	tv := syscall.NsecToTimeval(int64(2 * c.timeout()))
+	for _, opt := range []int{syscall.SO_RCVTIMEO, syscall.SO_SNDTIMEO} {
+		if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, opt, &tv); err != nil {
+			return nil, os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if err := syscall.Connect(fd, &syscall.SockaddrUnix{Name: c.path()}); err != nil {
+		return nil, fmt.Errorf("aesm: connect %s: %v", c.path(), err)
+	}
+	msg := AESM_message{size: uint32(len(req)), data: req}
+	if err := writeAesmMessage(conn, &msg); err != nil {
+		return nil, err
+	}
+	res, err := readAesmMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	return res.data, nil
+}
+
+func writeAesmMessage(w io.Writer, m *AESM_message) error {
+	var hdr [4]byte
+	binary.LittleEndian.PutUint32(hdr[:], m.size)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(m.data)
+	return err
+}
+
+func readAesmMessage(r io.Reader) (*AESM_message, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("aesm: reading response: %v", err)
+	}
+	m := &AESM_message{size: binary.LittleEndian.Uint32(hdr[:])}
+	if m.size > _aesmMaxMsgSize {
+		return nil, fmt.Errorf("aesm: response too large (%d bytes)", m.size)
+	}
+	m.data = make([]byte, m.size)
+	if _, err := io.ReadFull(r, m.data); err != nil {
+		return nil, fmt.Errorf("aesm: reading response: %v", err)
+	}
+	return m, nil
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendVarintField(b []byte, num int, v uint64) []byte {
+	b = appendVarint(b, uint64(num)<<3|_wireVarint)
+	return appendVarint(b, v)
+}
+
+func appendBytesField(b []byte, num int, v []byte) []byte {
+	b = appendVarint(b, uint64(num)<<3|_wireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func readVarint(b []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("aesm: malformed varint")
+}
+
+// walkFields calls f on every field of the protobuf message b. v holds the
+// value of varint and fixed fields, data the content of length delimited ones.
+func walkFields(b []byte, f func(num int, wire int, v uint64, data []byte)) error {
+	for len(b) > 0 {
+		key, n, err := readVarint(b)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+		num, wire := int(key>>3), int(key&7)
+		var v uint64
+		var data []byte
+		switch wire {
+		case _wireVarint:
+			if v, n, err = readVarint(b); err != nil {
+				return err
+			}
+		case _wire64:
+			if len(b) < 8 {
+				return errors.New("aesm: truncated message")
+			}
+			v, n = binary.LittleEndian.Uint64(b), 8
+		case _wire32:
+			if len(b) < 4 {
+				return errors.New("aesm: truncated message")
+			}
+			v, n = uint64(binary.LittleEndian.Uint32(b)), 4
+		case _wireBytes:
+			l, ln, err := readVarint(b)
+			if err != nil {
+				return err
+			}
+			if l > uint64(len(b)-ln) {
+				return errors.New("aesm: truncated message")
+			}
+			data, n = b[ln:ln+int(l)], ln+int(l)
+		default:
+			return fmt.Errorf("aesm: unsupported wire type %d", wire)
+		}
+		f(num, wire, v, data)
+		b = b[n:]
+	}
+	return nil
+}
+
+// findBytesField returns the last length delimited field num of b, or nil.
+func findBytesField(b []byte, num int) ([]byte, error) {
+	var res []byte
+	err := walkFields(b, func(n int, wire int, v uint64, data []byte) {
+		if n == num && wire == _wireBytes {
+			res = data
+		}
+	})
+	return res, err
+}
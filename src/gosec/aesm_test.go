@@ -0,0 +1,184 @@
+package gosec
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeAesm is a stand-in aesmd that answers launch token requests with
+// handle.
+type fakeAesm struct {
+	fd     int
+	handle func(req []byte) []byte
+	reqs   chan []byte
+	exited chan bool
+}
+
+func newFakeAesm(t *testing.T, handle func(req []byte) []byte) (*fakeAesm, *AesmClient, func()) {
+	dir, err := ioutil.TempDir("", "aesm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "aesm.socket")
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err == nil {
+		err = syscall.Bind(fd, &syscall.SockaddrUnix{Name: path})
+		if err == nil {
+			err = syscall.Listen(fd, 1)
+		}
+		if err != nil {
+			syscall.Close(fd)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	f := &fakeAesm{fd: fd, handle: handle, reqs: make(chan []byte, 1), exited: make(chan bool)}
+	go f.serve()
+	client := &AesmClient{Path: path, Timeout: time.Second}
+	return f, client, func() {
+		// Wait for serve before closing, the fd could be reused otherwise.
+		syscall.Shutdown(fd, syscall.SHUT_RDWR)
+		<-f.exited
+		syscall.Close(fd)
+		os.RemoveAll(dir)
+	}
+}
+
+func (f *fakeAesm) serve() {
+	defer close(f.exited)
+	for {
+		// Do not ask for the peer address: the client socket is unnamed.
+		nfd, _, errno := syscall.Syscall6(syscall.SYS_ACCEPT4, uintptr(f.fd), 0, 0, syscall.SOCK_CLOEXEC, 0, 0)
+		if errno != 0 {
+			return
+		}
+		conn := os.NewFile(nfd, "aesm")
+		m, err := readAesmMessage(conn)
+		if err == nil {
+			f.reqs <- m.data
+			if res := f.handle(m.data); res != nil {
+				writeAesmMessage(conn, &AESM_message{size: uint32(len(res)), data: res})
+			}
+		}
+		conn.Close()
+	}
+}
+
+func tokenResponse(code uint64, token []byte) []byte {
+	var inner []byte
+	inner = appendVarintField(inner, _tokenResErrorCode, code)
+	if token != nil {
+		inner = appendBytesField(inner, _tokenResToken, token)
+	}
+	return appendBytesField(nil, _aesmGetLaunchTokenField, inner)
+}
+
+func testTokenRequest() *LaunchTokenRequest {
+	return &LaunchTokenRequest{
+		MrEnclave:    bytes.Repeat([]byte{1}, SGX_HASH_SIZE),
+		MrSigner:     bytes.Repeat([]byte{2}, SE_KEY_SIZE),
+		SeAttributes: bytes.Repeat([]byte{3}, int(_attribSize)),
+	}
+}
+
+func TestAesmGetLaunchToken(t *testing.T) {
+	token := bytes.Repeat([]byte{0xab}, EinitTokenSize)
+	f, client, done := newFakeAesm(t, func([]byte) []byte {
+		return tokenResponse(0, token)
+	})
+	defer done()
+
+	req := testTokenRequest()
+	got, err := client.GetLaunchToken(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, token) {
+		t.Fatal("unexpected token")
+	}
+
+	// Check the request the server received.
+	raw := <-f.reqs
+	inner, err := findBytesField(raw, _aesmGetLaunchTokenField)
+	if err != nil || inner == nil {
+		t.Fatalf("missing launch token request: %v", err)
+	}
+	fields := make(map[int][]byte)
+	var tmout uint64
+	err = walkFields(inner, func(num int, wire int, v uint64, data []byte) {
+		if wire == _wireVarint && num == _tokenReqTimeout {
+			tmout = v
+		}
+		fields[num] = data
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fields[_tokenReqEnclaveHash], req.MrEnclave) ||
+		!bytes.Equal(fields[_tokenReqModulus], req.MrSigner) ||
+		!bytes.Equal(fields[_tokenReqSeAttributes], req.SeAttributes) {
+		t.Fatal("request fields do not match")
+	}
+	if tmout != 1000 {
+		t.Fatalf("timeout %d, want 1000", tmout)
+	}
+}
+
+func TestAesmErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		res  []byte
+		want string
+	}{
+		{"aesm error", tokenResponse(18, nil), "aesm: busy (18)"},
+		{"unknown error", tokenResponse(1000, nil), "aesm: error 1000"},
+		{"no body", []byte{}, "without launch token"},
+		{"missing code", appendBytesField(nil, _aesmGetLaunchTokenField, nil), "unexpected error"},
+		{"short token", tokenResponse(0, []byte{1, 2}), "invalid token size"},
+		{"truncated", []byte{_aesmGetLaunchTokenField<<3 | _wireBytes, 10, 1}, "truncated"},
+		{"closed", nil, "reading response"},
+	}
+	for _, tt := range tests {
+		res := tt.res
+		_, client, done := newFakeAesm(t, func([]byte) []byte { return res })
+		_, err := client.GetLaunchToken(testTokenRequest())
+		done()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestAesmBadRequest(t *testing.T) {
+	client := &AesmClient{Path: "/nonexistent/aesm.socket"}
+	req := testTokenRequest()
+	req.MrSigner = []byte("trying")
+	if _, err := client.GetLaunchToken(req); err == nil {
+		t.Fatal("invalid signer key accepted")
+	}
+	if _, err := client.GetLaunchToken(testTokenRequest()); err == nil {
+		t.Fatal("missing socket not reported")
+	}
+}
+
+func TestAesmTimeout(t *testing.T) {
+	block := make(chan struct{})
+	_, client, done := newFakeAesm(t, func([]byte) []byte {
+		<-block
+		return nil
+	})
+	defer done()
+	defer close(block)
+	client.Timeout = 50 * time.Millisecond
+	if _, err := client.GetLaunchToken(testTokenRequest()); err == nil {
+		t.Fatal("expected a timeout")
+	}
+}
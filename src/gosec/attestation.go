@@ -0,0 +1,155 @@
+package gosec
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gosecommon"
+	"runtime"
+)
+
+// QuotingBackend turns the reports produced by gosecu.Report into quotes that
+// can be verified by a remote party.
+type QuotingBackend interface {
+	// TargetInfo returns the target info of the quoting enclave. gosecure
+	// routines pass it to gosecu.Report.
+	TargetInfo() (gosecommon.TargetInfo, error)
+	// Quote converts a report targeted at the quoting enclave into a quote.
+	Quote(report *gosecommon.Report) ([]byte, error)
+}
+
+var quoter QuotingBackend = nil
+
+// RegisterQuotingBackend sets the backend used by QuoteTargetInfo and GetQuote.
+func RegisterQuotingBackend(q QuotingBackend) {
+	quoter = q
+}
+
+func quotingBackend() (QuotingBackend, error) {
+	if quoter != nil {
+		return quoter, nil
+	}
+	if in := defaultInstance(); in != nil && in.backend.Simulated() {
+		return SimQuoter{}, nil
+	}
+	return nil, errors.New("gosec: no quoting backend registered")
+}
+
+// QuoteTargetInfo returns the target info of the registered quoting backend.
+func QuoteTargetInfo() (gosecommon.TargetInfo, error) {
+	q, err := quotingBackend()
+	if err != nil {
+		return gosecommon.TargetInfo{}, err
+	}
+	return q.TargetInfo()
+}
+
+// GetQuote turns a report produced by gosecu.Report into a quote using the
+// registered quoting backend. In simulation mode, SimQuoter is used by default.
+func GetQuote(report *gosecommon.Report) ([]byte, error) {
+	if report == nil {
+		return nil, errors.New("gosec: nil report")
+	}
+	q, err := quotingBackend()
+	if err != nil {
+		return nil, err
+	}
+	return q.Quote(report)
+}
+
+// EnclaveIdentity returns the identity of the loaded default enclave.
+func EnclaveIdentity() (runtime.EnclaveIdentity, error) {
+	return NamedEnclaveIdentity("")
+}
+
+// NamedEnclaveIdentity returns the identity of the loaded enclave name, see
+// Options.Enclave.
+func NamedEnclaveIdentity(name string) (runtime.EnclaveIdentity, error) {
+	enclaves.Lock()
+	defer enclaves.Unlock()
+	e := enclaveNamed(name)
+	if e.inst == nil || e.inst.encl == nil {
+		return runtime.EnclaveIdentity{}, fmt.Errorf("gosec: the enclave %s is not loaded", e)
+	}
+	return e.inst.cprt.Identity, nil
+}
+
+// registerIdentity publishes the identity of the enclave of metadata meta to
+// its cooperative runtime c. Reports and sealing keys are derived from it in
+// simulation mode.
+func registerIdentity(c *runtime.CooperativeRuntime, meta *metadata_t, secs *secs_t) {
+	id := &c.Identity
+	id.MrEnclave = meta.Enclave_css.Enclave_hash.M
+	id.MrSigner = sha256.Sum256(meta.Enclave_css.Modulus[:])
+	binary.LittleEndian.PutUint64(id.Attributes[:8], secs.attributes)
+	binary.LittleEndian.PutUint64(id.Attributes[8:], secs.xfrm)
+	id.IsvProdID = meta.Enclave_css.Isv_prod_id
+	id.IsvSvn = meta.Enclave_css.Isv_svn
+}
+
+// The simulated quote is the MACed report body:
+// magic (8 bytes) | report body (384 bytes) | hmac-sha256 (32 bytes)
+const (
+	simQuoteMagic = "GOSECSQ1"
+	simQuoteSize  = len(simQuoteMagic) + gosecommon.ReportBodySize + sha256.Size
+)
+
+var (
+	simQELabel       = []byte("gosec-sim-quoting-enclave")
+	simQuoteKeyLabel = []byte("gosec-sim-quote-key")
+)
+
+// SimQuoter is the quoting backend for the simulation mode. It produces
+// deterministic quotes that are checked by VerifySimQuote.
+type SimQuoter struct{}
+
+// TargetInfo returns the target info of the simulated quoting enclave.
+func (SimQuoter) TargetInfo() (gosecommon.TargetInfo, error) {
+	ti := gosecommon.TargetInfo{}
+	ti.MrEnclave = sha256.Sum256(simQELabel)
+	return ti, nil
+}
+
+// Quote checks that the report targets the simulated quoting enclave and
+// signs its body.
+func (s SimQuoter) Quote(report *gosecommon.Report) ([]byte, error) {
+	ti, _ := s.TargetInfo()
+	if !gosecommon.VerifySimReport(report, ti.MrEnclave) {
+		return nil, errors.New("gosec: invalid report mac")
+	}
+	quote := make([]byte, 0, simQuoteSize)
+	quote = append(quote, simQuoteMagic...)
+	quote = append(quote, report.Body.Bytes()...)
+	quote = append(quote, simQuoteMac(quote)...)
+	return quote, nil
+}
+
+func simQuoteMac(data []byte) []byte {
+	m := hmac.New(sha256.New, simQuoteKeyLabel)
+	m.Write(data)
+	return m.Sum(nil)
+}
+
+// VerifySimQuote checks a quote produced by SimQuoter and returns the report
+// body it carries.
+func VerifySimQuote(quote []byte) (*gosecommon.ReportBody, error) {
+	if len(quote) != simQuoteSize {
+		return nil, errors.New("gosec: invalid simulated quote size")
+	}
+	if !bytes.Equal(quote[:len(simQuoteMagic)], []byte(simQuoteMagic)) {
+		return nil, errors.New("gosec: invalid simulated quote magic")
+	}
+	signed := quote[:simQuoteSize-sha256.Size]
+	if !hmac.Equal(simQuoteMac(signed), quote[len(signed):]) {
+		return nil, errors.New("gosec: invalid simulated quote signature")
+	}
+	body := &gosecommon.ReportBody{}
+	err := binary.Read(bytes.NewReader(signed[len(simQuoteMagic):]), binary.LittleEndian, body)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
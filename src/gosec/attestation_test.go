@@ -0,0 +1,62 @@
+package gosec
+
+import (
+	"gosecommon"
+	"testing"
+)
+
+func TestSimQuoteRoundTrip(t *testing.T) {
+	var q SimQuoter
+	ti, err := q.TargetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := gosecommon.ReportBody{IsvSvn: 42}
+	body.MrEnclave[0] = 0xaa
+	copy(body.ReportData[:], "nonce")
+	report := gosecommon.SimReport(body, &ti)
+
+	quote, err := q.Quote(&report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := VerifySimQuote(quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != body {
+		t.Fatalf("quote carries %+v, want %+v", got, body)
+	}
+
+	quote[len(simQuoteMagic)] ^= 1
+	if _, err := VerifySimQuote(quote); err == nil {
+		t.Fatal("tampered quote was accepted")
+	}
+}
+
+func TestSimQuoteRejectsWrongTarget(t *testing.T) {
+	var q SimQuoter
+	other := gosecommon.TargetInfo{}
+	report := gosecommon.SimReport(gosecommon.ReportBody{}, &other)
+	if _, err := q.Quote(&report); err == nil {
+		t.Fatal("report targeted at another enclave was quoted")
+	}
+}
+
+func TestReportLayout(t *testing.T) {
+	r := gosecommon.Report{}
+	if n := len(r.Bytes()); n != gosecommon.ReportSize {
+		t.Fatalf("report size %d, want %d", n, gosecommon.ReportSize)
+	}
+	if n := len(r.Body.Bytes()); n != gosecommon.ReportBodySize {
+		t.Fatalf("report body size %d, want %d", n, gosecommon.ReportBodySize)
+	}
+	ti := gosecommon.TargetInfo{}
+	if n := len(ti.Bytes()); n != gosecommon.TargetInfoSize {
+		t.Fatalf("target info size %d, want %d", n, gosecommon.TargetInfoSize)
+	}
+	r.Body.IsvSvn = 3
+	if b := r.Bytes(); b[258] != 3 {
+		t.Fatal("isv svn is not at offset 258")
+	}
+}
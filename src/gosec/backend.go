@@ -0,0 +1,89 @@
+package gosec
+
+/* This file defines the backends that create and run the enclaves: the sgx
+ * driver of the kernel, /dev/sgx_enclave, the out-of-tree isgx driver,
+ * /dev/isgx, and the simulation. loadProgram drives the backend of an
+ * instance, which holds what the backend needs of it.
+ *
+ * The backend of an enclave is the simulation with SIM or Options.Simulation,
+ * and else the one of its manifest, see cmd/internal/enclave, or else the
+ * default backend of the program: isgx, or sgx if the program is built with
+ * the gosec_sgx tag.
+ */
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Backend creates and runs an enclave. loadProgram calls Create, AddPages for
+// each region of the enclave, Init, and Enter for its first thread. Spawn and
+// Resume serve the requests of the threads of the enclave, without g, and
+// must be nosplit. Teardown releases what the backend holds once the enclave
+// halted, or failed to load, under loadMu.
+type Backend interface {
+	// Name is the name of the backend in the manifest.
+	Name() string
+
+	// Simulated reports whether the enclave runs without sgx, in the
+	// address space of the program.
+	Simulated() bool
+
+	// Create creates the enclave of the range of secs.
+	Create(in *instance, secs *secs_t) error
+
+	// AddPages adds the n bytes at dest to the enclave, with the content of
+	// the staging area at src, the protection prot and the page type tpe,
+	// SGX_SECINFO_REG or SGX_SECINFO_TCS. dest, src and n are page aligned.
+	AddPages(in *instance, dest, src, n, prot uintptr, tpe uint64) error
+
+	// Init signs the enclave once its pages are added and measured, and
+	// initializes it, after which it cannot be extended.
+	Init(in *instance, secs *secs_t) error
+
+	// Enter starts the first thread of the enclave, in the tcs dest whose
+	// staging copy is src.
+	Enter(in *instance, dest, src *sgx_tcs_info) error
+
+	// Spawn starts a thread of the enclave in the tcs req.Did.
+	Spawn(in *instance, req *runtime.OExitRequest)
+
+	// Resume returns to the thread of the tcs id once its request is
+	// served.
+	Resume(in *instance, id uint64)
+
+	// Teardown releases the backend.
+	Teardown(in *instance)
+}
+
+// Names of the backends.
+const (
+	backendSgx  = "sgx"
+	backendIsgx = "isgx"
+	backendSim  = "sim"
+)
+
+// newBackend returns a backend named name, for a new instance.
+func newBackend(name string) (Backend, error) {
+	switch name {
+	case backendSgx:
+		return &sgxBackend{}, nil
+	case backendIsgx:
+		return &isgxBackend{}, nil
+	case backendSim:
+		return &simBackend{}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q", name)
+}
+
+// backendOf returns the name of the backend of the enclave of layout, started
+// with opts.
+func backendOf(layout runtime.EnclaveLayout, opts Options) string {
+	switch {
+	case opts.simulation():
+		return backendSim
+	case layout.Backend != "":
+		return layout.Backend
+	}
+	return defaultBackend
+}
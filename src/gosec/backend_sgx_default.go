@@ -0,0 +1,7 @@
+// +build gosec_sgx
+
+package gosec
+
+// defaultBackend is the backend of the enclaves without one in their
+// manifest.
+const defaultBackend = backendSgx
@@ -0,0 +1,273 @@
+package gosec
+
+import (
+	"bytes"
+	"fmt"
+	"internal/testenv"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestBackendOf(t *testing.T) {
+	if sim, ok := os.LookupEnv("SIM"); ok {
+		os.Unsetenv("SIM")
+		defer os.Setenv("SIM", sim)
+	}
+	for _, tt := range []struct {
+		manifest string
+		sim      bool
+		want     string
+	}{
+		{"", false, defaultBackend},
+		{"", true, backendSim},
+		{"backend=sgx", false, backendSgx},
+		{"backend=isgx", false, backendIsgx},
+		{"backend=sgx", true, backendSim},
+	} {
+		l, _ := runtime.ParseEnclaveManifest(tt.manifest)
+		got := backendOf(l, Options{Simulation: tt.sim})
+		if got != tt.want {
+			t.Errorf("manifest %q, simulation %v: backend %s, want %s", tt.manifest, tt.sim, got, tt.want)
+		}
+		b, err := newBackend(got)
+		if err != nil || b.Name() != got {
+			t.Errorf("newBackend(%s): %v", got, err)
+		}
+	}
+	if _, err := newBackend("tdx"); err == nil {
+		t.Errorf("newBackend(tdx) succeeded")
+	}
+}
+
+// TestSimMachineSecret checks that the machine secret of the simulation is
+// read from GOSEC_SIM_SECRET, is zero without it, and is never generated.
+func TestSimMachineSecret(t *testing.T) {
+	if secret, ok := os.LookupEnv("GOSEC_SIM_SECRET"); ok {
+		defer os.Setenv("GOSEC_SIM_SECRET", secret)
+	}
+	defer os.Unsetenv("GOSEC_SIM_SECRET")
+	dir, err := ioutil.TempDir("", "gosec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secret")
+	os.Unsetenv("GOSEC_SIM_SECRET")
+	if secret, err := simMachineSecret(); err != nil || secret != [32]uint8{} {
+		t.Errorf("without GOSEC_SIM_SECRET: %x, %v, want a zero secret", secret, err)
+	}
+	os.Setenv("GOSEC_SIM_SECRET", path)
+	if _, err := simMachineSecret(); err == nil {
+		t.Errorf("missing secret accepted")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the missing secret was generated")
+	}
+	for _, b := range [][]byte{[]byte("short"), make([]byte, 32)} {
+		if err := ioutil.WriteFile(path, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := simMachineSecret(); err == nil {
+			t.Errorf("secret %q accepted", b)
+		}
+	}
+	want := [32]uint8{1, 2, 3}
+	if err := ioutil.WriteFile(path, want[:], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := simMachineSecret(); err != nil || secret != want {
+		t.Errorf("simMachineSecret = %x, %v, want %x", secret, err, want)
+	}
+}
+
+// backendDevices are the devices of the backends, which the conformance
+// suite skips without them.
+var backendDevices = map[string]string{
+	backendSgx:  SGX_ENCLAVE_PATH,
+	backendIsgx: SGX_PATH,
+}
+
+func TestBackendConformance(t *testing.T) {
+	for _, name := range []string{backendSim, backendIsgx, backendSgx} {
+		t.Run(name, func(t *testing.T) {
+			if dev := backendDevices[name]; dev != "" {
+				if _, err := os.Stat(dev); err != nil {
+					t.Skipf("no %s: %v", dev, err)
+				}
+			}
+			b, err := newBackend(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.Simulated() {
+				t.Run("pages", func(t *testing.T) { testBackendPages(t, b) })
+			}
+			t.Run("program", func(t *testing.T) { testBackendProgram(t, name) })
+		})
+	}
+}
+
+// testBackendPages checks that AddPages adds the content of the staging area
+// with its protection, in a backend where the program reads the enclave.
+func testBackendPages(t *testing.T, b Backend) {
+	const n = 4 * PSIZE
+	dest, err := syscall.Mmap(-1, 0, int(n), _PROT_NONE, _MAP_PRIVATE|_MAP_ANON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Munmap(dest)
+	stage, err := syscall.Mmap(-1, 0, int(n), _PROT_READ|_PROT_WRITE, _MAP_PRIVATE|_MAP_ANON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Munmap(stage)
+	for i := range stage {
+		stage[i] = byte(i / int(PSIZE))
+	}
+	base := uintptr(unsafe.Pointer(&dest[0]))
+	secs := &secs_t{baseAddr: uint64(base), size: uint64(n)}
+	in := &instance{backend: b, encl: &sgx_wrapper{base: base, siz: n}}
+	if err := b.Create(in, secs); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer b.Teardown(in)
+	src := uintptr(unsafe.Pointer(&stage[0]))
+	if err := b.AddPages(in, base, src, 2*PSIZE, _PROT_READ, SGX_SECINFO_REG); err != nil {
+		t.Fatalf("AddPages: %v", err)
+	}
+	if err := b.AddPages(in, base+2*PSIZE, src+2*PSIZE, 2*PSIZE, _PROT_READ|_PROT_WRITE, SGX_SECINFO_REG); err != nil {
+		t.Fatalf("AddPages: %v", err)
+	}
+	for i := 0; i < int(n); i += int(PSIZE) {
+		if got, want := dest[i], byte(i/int(PSIZE)); got != want {
+			t.Errorf("page %d: %d, want %d", i/int(PSIZE), got, want)
+		}
+	}
+	dest[n-1] = 1
+	maps, err := ioutil.ReadFile("/proc/self/maps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []string{fmt.Sprintf("%x-%x r--p", base, base+2*PSIZE), fmt.Sprintf("%x-%x rw-p", base+2*PSIZE, base+n)} {
+		if !bytes.Contains(maps, []byte(m)) {
+			t.Errorf("no mapping %s in\n%s", m, maps)
+		}
+	}
+}
+
+const backendProgram = `package main
+
+import (
+	"context"
+	"fmt"
+	"gosec"
+	"runtime"
+)
+
+func sum(n int, out chan int) {
+	// The goroutines of the enclave block and wake each other up.
+	c := make(chan int)
+	for i := 1; i <= n; i++ {
+		go func(i int) {
+			runtime.Gosched()
+			c <- i
+		}(i)
+	}
+	s := 0
+	for i := 0; i < n; i++ {
+		s += <-c
+	}
+	out <- s
+}
+
+func backend(out chan string) {
+	out <- runtime.CurrentEnclaveLayout().Backend
+}
+
+func main() {
+	for i := 0; i < 2; i++ {
+		out := make(chan int)
+		gosecure sum(100, out)
+		fmt.Println("sum", <-out)
+		name := make(chan string)
+		gosecure backend(name)
+		fmt.Println("backend", <-name)
+		id, err := gosec.EnclaveIdentity()
+		fmt.Printf("mrenclave %x %v\n", id.MrEnclave, err)
+		fmt.Println("shutdown", gosec.Shutdown(context.Background()))
+	}
+}
+`
+
+// testBackendProgram runs a program whose enclave has the backend name in its
+// manifest, and checks that it runs, that its enclave restarts, and that the
+// backend measures it as MeasureEnclave does.
+func testBackendProgram(t *testing.T, name string) {
+	if testing.Short() {
+		t.Skip("builds and runs an enclave")
+	}
+	dir, err := ioutil.TempDir("", "gosec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	exe := buildProgram(t, dir, backendProgram, fmt.Sprintf(`{"backend": %q}`, name))
+	encl, err := ReadEnclave(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := MeasureEnclave(encl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(exe)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "SIM=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	var lines []string
+	for _, l := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(l, "sum ") || strings.HasPrefix(l, "backend ") || strings.HasPrefix(l, "mrenclave ") || strings.HasPrefix(l, "shutdown ") {
+			lines = append(lines, l)
+		}
+	}
+	once := []string{"sum 5050", "backend " + name, fmt.Sprintf("mrenclave %x <nil>", m.MrEnclave), "shutdown <nil>"}
+	want := strings.Join(append(once, once...), "\n")
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Errorf("got\n%s\nwant\n%s\nin\n%s", got, want, out)
+	}
+}
+
+// buildProgram builds the program src, with the enclave manifest manifest if
+// any, in dir, and returns its executable.
+func buildProgram(t *testing.T, dir, src, manifest string) string {
+	testenv.MustHaveGoBuild(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if manifest != "" {
+		if err := ioutil.WriteFile(filepath.Join(dir, "enclave.json"), []byte(manifest), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exe := filepath.Join(dir, "main")
+	build := exec.Command(testenv.GoToolPath(t), "build", "-o", exe, "main.go")
+	build.Dir = dir
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	return exe
+}
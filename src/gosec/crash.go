@@ -0,0 +1,158 @@
+package gosec
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+/* This file prints the crash reports of the enclave, see runtime/gosecrash.go.
+ * The enclave only hands over the pcs of the crashed goroutine: they are
+ * symbolized against the executable of the enclave embedded in the program.
+ */
+
+// crashLabel returns the label of the lines of the crash report of the
+// enclave name.
+func crashLabel(name string) string {
+	if name == "" {
+		return "[enclave] "
+	}
+	return "[enclave " + name + "] "
+}
+
+// reportCrash waits for the enclave name of cprt, whose executable is encl,
+// to crash, prints its report and exits as the runtime does after a fatal
+// panic. It returns if the enclave is shut down.
+func reportCrash(cprt *runtime.CooperativeRuntime, name string, encl []byte) {
+	c := runtime.WaitEnclaveCrash(cprt)
+	if c == nil {
+		return
+	}
+	tab, err := enclaveSymbols(encl)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sunable to symbolize the stack: %v\n", crashLabel(name), err)
+	}
+	printCrash(os.Stderr, name, c, tab)
+	os.Exit(2)
+}
+
+// enclaveSymbols returns the symbol table of the executable encl.
+func enclaveSymbols(encl []byte) (*gosym.Table, error) {
+	file, err := elf.NewFile(bytes.NewReader(encl))
+	if err != nil {
+		return nil, err
+	}
+	text, pclntab := file.Section(".text"), file.Section(".gopclntab")
+	if text == nil || pclntab == nil {
+		return nil, fmt.Errorf("no .text or .gopclntab section")
+	}
+	pcln, err := pclntab.Data()
+	if err != nil {
+		return nil, err
+	}
+	var symtab []byte
+	if s := file.Section(".gosymtab"); s != nil {
+		if symtab, err = s.Data(); err != nil {
+			return nil, err
+		}
+	}
+	return gosym.NewTable(symtab, gosym.NewLineTable(pcln, text.Addr))
+}
+
+// printCrash prints the crash report c of the enclave name to w the way the
+// runtime prints a traceback, each line labeled [enclave], or [enclave name]
+// for a named enclave. tab symbolizes the pcs, it may be nil.
+func printCrash(w io.Writer, name string, c *runtime.EnclaveCrash, tab *gosym.Table) {
+	crashLabel := crashLabel(name)
+	what := "panic"
+	if c.Throw {
+		what = "fatal error"
+	}
+	if c.Redacted {
+		fmt.Fprintf(w, "%s%s in goroutine %d, redacted by the manifest of the enclave\n", crashLabel, what, c.Goid)
+		return
+	}
+	msg := strings.TrimRight(string(c.Msg[:c.MsgLen]), "\n")
+	for _, line := range strings.Split(msg, "\n") {
+		if line != "" {
+			line = crashLabel + line
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintln(w)
+	if c.System {
+		fmt.Fprintf(w, "%sruntime stack:\n", crashLabel)
+	} else {
+		fmt.Fprintf(w, "%sgoroutine %d [running]:\n", crashLabel, c.Goid)
+	}
+
+	// All the frames of a fatal error are shown, as by the runtime.
+	all := c.Throw || c.System
+	nprint := 0
+	callee := ""
+	for _, p := range c.PCs[:c.NPCs] {
+		pc := uint64(p)
+		var fn *gosym.Func
+		if tab != nil {
+			fn = tab.PCToFunc(pc)
+		}
+		if fn == nil {
+			fmt.Fprintf(w, "%s?()\n%s\tpc=%#x\n", crashLabel, crashLabel, pc)
+			nprint++
+			callee = ""
+			continue
+		}
+		// Back up to the call, unless the frame faulted.
+		file, line := callLine(tab, fn, pc, callee == "runtime.sigpanic")
+		if all || showFrame(fn.Name, file, nprint == 0, callee) {
+			name := fn.Name
+			if name == "runtime.gopanic" {
+				name = "panic"
+			}
+			fmt.Fprintf(w, "%s%s(...)\n%s\t%s:%d +%#x\n", crashLabel, name, crashLabel, file, line, pc-fn.Entry)
+			nprint++
+		}
+		callee = fn.Name
+	}
+	if c.NPCs == len(c.PCs) {
+		fmt.Fprintf(w, "%s...additional frames elided...\n", crashLabel)
+	}
+	if gopc := uint64(c.Gopc); gopc != 0 && c.Goid != 1 && tab != nil {
+		if fn := tab.PCToFunc(gopc); fn != nil && (all || showFrame(fn.Name, "", false, "")) {
+			file, line := callLine(tab, fn, gopc, false)
+			fmt.Fprintf(w, "%screated by %s\n%s\t%s:%d +%#x\n", crashLabel, fn.Name, crashLabel, file, line, gopc-fn.Entry)
+		}
+	}
+}
+
+// callLine returns the line of the call that returns to pc in fn, or the one
+// of pc if exact.
+func callLine(tab *gosym.Table, fn *gosym.Func, pc uint64, exact bool) (string, int) {
+	if !exact && pc > fn.Entry {
+		pc--
+	}
+	file, line, _ := tab.PCToLine(pc)
+	return file, line
+}
+
+// showFrame mirrors runtime.showframe: it reports whether the frame of the
+// function name in file is shown in the traceback of a panic. callee is the
+// function called by the frame.
+func showFrame(name, file string, first bool, callee string) bool {
+	if !first && file == "<autogenerated>" && callee != "runtime.gopanic" && callee != "runtime.sigpanic" && callee != "runtime.panicwrap" {
+		return false
+	}
+	if name == "runtime.gopanic" && !first {
+		return true
+	}
+	const rt = "runtime."
+	if !strings.HasPrefix(name, rt) {
+		return strings.Contains(name, ".")
+	}
+	return len(name) > len(rt) && 'A' <= name[len(rt)] && name[len(rt)] <= 'Z'
+}
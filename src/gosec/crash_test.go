@@ -0,0 +1,62 @@
+package gosec
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+//go:noinline
+func crashingFrame(c *runtime.EnclaveCrash) {
+	c.NPCs = runtime.Callers(1, c.PCs[:])
+}
+
+func TestPrintCrash(t *testing.T) {
+	exe, err := ioutil.ReadFile(os.Args[0])
+	if err != nil {
+		t.Skip(err)
+	}
+	tab, err := enclaveSymbols(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &runtime.EnclaveCrash{Goid: 7}
+	c.MsgLen = copy(c.Msg[:], "panic: boom\n[signal SIGSEGV: segmentation violation]\n")
+	crashingFrame(c)
+
+	var buf bytes.Buffer
+	printCrash(&buf, "", c, tab)
+	out := buf.String()
+	for _, want := range []string{
+		"[enclave] panic: boom\n[enclave] [signal SIGSEGV: segmentation violation]\n\n",
+		"[enclave] goroutine 7 [running]:\n",
+		"[enclave] gosec.crashingFrame(...)\n[enclave] \t",
+		"crash_test.go:14 +0x",
+		"[enclave] gosec.TestPrintCrash(...)\n",
+		"[enclave] testing.tRunner(...)\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "runtime.goexit") {
+		t.Errorf("runtime frame in the traceback of a panic\n%s", out)
+	}
+
+	buf.Reset()
+	c.Throw = true
+	printCrash(&buf, "", c, tab)
+	if out := buf.String(); !strings.Contains(out, "[enclave] runtime.goexit(...)\n") {
+		t.Errorf("runtime frame missing in the traceback of a fatal error\n%s", out)
+	}
+
+	buf.Reset()
+	c.Redacted = true
+	printCrash(&buf, "", c, tab)
+	if out, want := buf.String(), "[enclave] fatal error in goroutine 7, redacted by the manifest of the enclave\n"; out != want {
+		t.Errorf("redacted report %q, want %q", out, want)
+	}
+}
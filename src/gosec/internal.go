@@ -1,121 +1,263 @@
 package gosec
 
 import (
-	"bytes"
-	"debug/elf"
+	"errors"
+	"fmt"
 	"gosecommon"
 	"log"
 	"os"
 	"reflect"
 	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"unsafe"
 )
 
-func check(e error) {
-	if e != nil {
-		panic(e.Error())
-	}
-}
-
 type funcval struct {
 	fn uintptr
 	// variable-size, fn-specific data here
 }
 
-var initOnce sync.Once
-
 func asm_oentry(req *runtime.OExitRequest)
 
+// LoadEnclave starts the enclave embedded in the program, and exits if it
+// cannot.
+//
+// Deprecated: use Start.
 func LoadEnclave() {
-	p, err := elf.Open(os.Args[0])
-	check(err)
-
-	enclave := p.Section(".encl")
-	defer func() { check(p.Close()) }()
-	if enclave == nil {
-		log.Fatalf("Binary %v does not contain an enclave section.", os.Args[0])
+	if err := Start(Options{}); err != nil {
+		log.Fatalln(err)
 	}
+}
 
-	bts, err := enclave.Data()
-	check(err)
+// loadMu protects loaded, the staging areas of the instances and the
+// backends.
+var loadMu sync.Mutex
 
-	// Remove the header by seeking the magic bytes.
-	magic := []byte{0x7F, 0x45, 0x4C, 0x46}
-	var i = 0
-	for i = 0; i < len(bts)-len(magic); i++ {
-		if bytes.Compare(bts[i:i+len(magic)], magic) == 0 {
-			break
+// loadEnclave sets up the cooperative runtime for the layout of the enclave
+// name embedded in the program, starts the servers of its requests, and loads
+// the enclave.
+func loadEnclave(name string, opts Options) (in *instance, err error) {
+	bts, err := ReadNamedEnclave(os.Args[0], name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := parseEnclave(name, bts)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := enclaveLayout(file)
+	if err != nil {
+		return nil, err
+	}
+	if layout.Name != name {
+		return nil, fmt.Errorf("the enclave section %s holds the enclave %q", enclaveSection(name), layout.Name)
+	}
+	b, err := newBackend(backendOf(layout, opts))
+	if err != nil {
+		return nil, err
+	}
+	in = &instance{name: name, layout: layout, backend: b}
+	if err := in.reserve(); err != nil {
+		return nil, err
+	}
+	c := in.cprt
+	in.srv = newServers(c)
+	defer func() {
+		if err != nil {
+			in.release()
+			in = nil
 		}
+	}()
+	workers := ocallWorkers
+	if opts.OcallWorkers != 0 {
+		workers = opts.OcallWorkers
 	}
-	if i >= len(bts)-len(magic) {
-		log.Fatalf("Unable to find the start of the executable in the encl section.")
+	if workers > 0 {
+		c.Ring = runtime.NewOcallRing(runtime.OcallRingSize)
+		startOcallWorkers(c.Ring, workers, in.srv)
 	}
-	bts = bts[i:]
-	name := "enclavebin"
-	encl, err := os.Create(name)
-	check(err)
+	// Server to allocate requests & service system calls for the enclave.
+	in.srv.wg.Add(1)
+	go oCallServer(c, in.srv)
 
 	//Mmap debugging region
 	//prot := _PROT_READ | _PROT_WRITE
 	//manon := _MAP_PRIVATE | _MAP_ANON | _MAP_FIXED
 	//_, err = syscall.RMmap(runtime.DEBUGMASK, 0x1000, prot, manon, -1, 0)
 	//check(err)
-	defer func() { check(encl.Close()) }()
-
-	check(os.Chmod(name, 0755))
-
-	_, err = encl.Write(bts)
-	check(err)
 
 	//Setup the OEntry in Cooprt for extra threads
-	runtime.Cooprt.OEntry = reflect.ValueOf(asm_oentry).Pointer()
+	c.OEntry = reflect.ValueOf(asm_oentry).Pointer()
 
-	// Set the deep copier
+	// Set the deep copier, and the types for the interfaces that cross.
 	runtime.SetCopier(gosecommon.DeepCopier, gosecommon.CanShallowCopy)
+	c.Types = gosecommon.CrossTypes(runtime.GosecureTargets())
+
+	go reportCrash(c, name, bts)
+
+	in.threads.alive = make([]uint32, layout.Tcs)
+	in.threads.sleepers = make([]uintptr, layout.Tcs)
 
 	//Start loading the program within the correct address space.
-	if s := os.Getenv("SIM"); s != "" {
-		simLoadProgram(name)
-	} else {
-		sgxLoadProgram(name)
+	return in, loadProgram(in, file)
+}
+
+// reserve creates the cooperative runtime of in, and reserves its staging
+// area, once its layout is checked against the enclaves loaded.
+func (in *instance) reserve() error {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	l, n := in.layout, 0
+	for _, o := range loaded {
+		if o == nil {
+			continue
+		}
+		n++
+		if l.Base < o.layout.Base+o.layout.Size && o.layout.Base < l.Base+l.Size {
+			return fmt.Errorf("the enclave %q overlaps the enclave %q at %#x", l.Name, o.name, o.layout.Base)
+		}
+	}
+	if n == len(loaded) {
+		return fmt.Errorf("too many enclaves, at most %d are loaded at once", len(loaded))
+	}
+	stage, err := stagingArea(l.Size)
+	if err != nil {
+		return err
+	}
+	in.stage = stage
+	in.cprt = runtime.NewCooperativeRuntime(l)
+	loaded[in.cprt.Eid] = in
+	return nil
+}
+
+// stagingArea returns the start of a free staging area of size siz, above
+// MMMASK, see transposeOut. loadMu is held.
+func stagingArea(siz uintptr) (uintptr, error) {
+	for stage := uintptr(MMMASK); stage+siz <= 1<<47; stage += siz {
+		free := true
+		for _, o := range loaded {
+			if o != nil && stage < o.stage+o.layout.Size && o.stage < stage+siz {
+				free = false
+				break
+			}
+		}
+		if free {
+			return stage, nil
+		}
+	}
+	return 0, errors.New("no staging area left for the enclave")
+}
+
+// servers are the goroutines that serve the requests of an enclave.
+type servers struct {
+	cprt *runtime.CooperativeRuntime
+	done chan struct{}  // closed to stop the servers.
+	wg   sync.WaitGroup // the servers, and the replies they send.
+
+	// malRegions are the buffers mapped for MAL requests and not freed
+	// yet. The buffers of an enclave that is shut down are unmapped.
+	malRegions struct {
+		sync.Mutex
+		m map[uintptr]uintptr // size by address.
 	}
 }
 
-func oCallServer() {
+// newServers returns the servers of the enclave of c, nil in tests.
+func newServers(c *runtime.CooperativeRuntime) *servers {
+	return &servers{cprt: c, done: make(chan struct{})}
+}
+
+// stop asks the servers to return.
+func (srv *servers) stop() {
+	close(srv.done)
+}
+
+func (srv *servers) stopped() bool {
+	select {
+	case <-srv.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func oCallServer(c *runtime.CooperativeRuntime, srv *servers) {
+	defer srv.wg.Done()
 	runtime.MarkNoFutex()
 	for {
-		sys := <-runtime.Cooprt.Ocall
-		var r1 uintptr
-		var r2 uintptr
-		var err syscall.Errno
-		switch sys.Big {
-		case runtime.S3:
-			r1, r2, err = syscall.Syscall(sys.Trap, sys.A1, sys.A2, sys.A3)
-		case runtime.S6:
-			r1, r2, err = syscall.Syscall6(sys.Trap, sys.A1, sys.A2, sys.A3, sys.A4, sys.A5, sys.A6)
-		case runtime.RS3:
-			r1, r2, err = syscall.RawSyscall(sys.Trap, sys.A1, sys.A2, sys.A3)
-		case runtime.RS6:
-			r1, r2, err = syscall.RawSyscall6(sys.Trap, sys.A1, sys.A2, sys.A3, sys.A4, sys.A5, sys.A6)
-		case runtime.MAL:
-			manon := int32(_MAP_PRIVATE | _MAP_ANON | _MAP_NORESERVE)
-			ur1, e := runtime.RMmap(nil, sys.A2, _PROT_READ|_PROT_WRITE, manon, -1, 0)
-			if e != 0 {
-				log.Fatalln("Unable to mmap big buffer size:", sys.A2, " and error: ", syscall.Errno(e))
-			}
-			r1 = uintptr(ur1)
-		case runtime.FRE:
-			runtime.RMunmap(unsafe.Pointer(sys.A1), sys.A2)
+		var sys runtime.OcallReq
+		select {
+		case sys = <-c.Ocall:
+		case <-srv.done:
+			return
+		}
+		if adversary != nil {
+			adversary.serve(srv, sys, c.SysSend)
 			continue
-		default:
-			panic("Unsupported syscall forwarding.")
 		}
-		res := runtime.OcallRes{r1, r2, uintptr(err)}
-		go runtime.Cooprt.SysSend(sys.Id, res)
+		if res, ok := srv.serveOcall(sys); ok {
+			srv.wg.Add(1)
+			go func(id int) {
+				c.SysSend(id, res)
+				srv.wg.Done()
+			}(sys.Id)
+		}
+	}
+}
+
+// unmapMalRegions unmaps the buffers of malRegions.
+func (srv *servers) unmapMalRegions() {
+	srv.malRegions.Lock()
+	defer srv.malRegions.Unlock()
+	for addr, size := range srv.malRegions.m {
+		runtime.MunmapUnsafe(unsafe.Pointer(addr), size)
+	}
+	srv.malRegions.m = nil
+}
+
+// serveOcall makes the request sys of the enclave, and returns the reply to
+// send, if the request expects one.
+func (srv *servers) serveOcall(sys runtime.OcallReq) (runtime.OcallRes, bool) {
+	var r1 uintptr
+	var r2 uintptr
+	var err syscall.Errno
+	switch sys.Big {
+	case runtime.S3:
+		r1, r2, err = syscall.Syscall(sys.Trap, sys.A1, sys.A2, sys.A3)
+	case runtime.S6:
+		r1, r2, err = syscall.Syscall6(sys.Trap, sys.A1, sys.A2, sys.A3, sys.A4, sys.A5, sys.A6)
+	case runtime.RS3:
+		r1, r2, err = syscall.RawSyscall(sys.Trap, sys.A1, sys.A2, sys.A3)
+	case runtime.RS6:
+		r1, r2, err = syscall.RawSyscall6(sys.Trap, sys.A1, sys.A2, sys.A3, sys.A4, sys.A5, sys.A6)
+	case runtime.MAL:
+		ur1, e := runtime.MmapUnsafe(sys.A2)
+		if e != 0 {
+			log.Fatalln("Unable to mmap big buffer size:", sys.A2, " and error: ", syscall.Errno(e))
+		}
+		r1 = uintptr(ur1)
+		srv.malRegions.Lock()
+		if srv.malRegions.m == nil {
+			srv.malRegions.m = make(map[uintptr]uintptr)
+		}
+		srv.malRegions.m[r1] = sys.A2
+		srv.malRegions.Unlock()
+	case runtime.FRE:
+		srv.malRegions.Lock()
+		delete(srv.malRegions.m, sys.A1)
+		srv.malRegions.Unlock()
+		runtime.MunmapUnsafe(unsafe.Pointer(sys.A1), sys.A2)
+		return runtime.OcallRes{}, false
+	case runtime.GRW:
+		srv.cprt.GrowSysPool()
+		return runtime.OcallRes{}, false
+	default:
+		panic("Unsupported syscall forwarding.")
 	}
-	panic("Should never exit")
+	return runtime.OcallRes{r1, r2, uintptr(err)}, true
 }
 
 func bufcopy(dest []uint8, src *uint8, size int32) {
@@ -130,64 +272,144 @@ func bufcopy(dest []uint8, src *uint8, size int32) {
 // It creates the enclave if it does not exist yet, and write to the cooperative channel.
 //go:nosplit
 func Gosecload(size int32, fn *funcval, b uint8) {
+	// The enclave is built from the same packages. A gosecure call from a
+	// target is already in the enclave, it is a plain go.
+	buf := argsCopy(size, &b)
+	if runtime.IsEnclave() {
+		checkLocal(fn)
+		var argp *uint8
+		if size > 0 {
+			argp = &buf[0]
+		}
+		runtime.Newproc(fn.fn, argp, size)
+		return
+	}
+	gosecload(size, fn, buf, nil)
+}
+
+// GosecloadResult is Gosecload for a gosecure expression, the results of the
+// call are sent on the channel res.
+//go:nosplit
+func GosecloadResult(size int32, fn *funcval, res unsafe.Pointer, b uint8) {
+	buf := argsCopy(size, &b)
+	if runtime.IsEnclave() {
+		checkLocal(fn)
+		localResult(fn, buf, res)
+		return
+	}
+	gosecload(size, fn, buf, gosecommon.Forward(res))
+}
+
+// argsCopy copies the arguments of a gosecure call, before the stack moves.
+//go:nosplit
+func argsCopy(size int32, argp *uint8) []uint8 {
+	if size == 0 {
+		return nil
+	}
+	buf := make([]uint8, size, size)
+	bufcopy(buf, argp, size)
+	return buf
+}
+
+// checkLocal panics if the target fn of a gosecure call made in the enclave
+// runs in another enclave: the enclaves do not call each other.
+func checkLocal(fn *funcval) {
+	pc := runtime.FuncForPC(fn.fn)
+	if pc == nil {
+		return
+	}
+	if name := gosecommon.EnclaveOf(pc.Name()); name != runtime.CurrentEnclaveLayout().Name {
+		panic("gosec: gosecure call to " + pc.Name() + " in the enclave " + strconv.Quote(name) + " from another enclave")
+	}
+}
+
+// localResult runs the call of a gosecure expression inside the enclave.
+func localResult(fn *funcval, buf []uint8, res unsafe.Pointer) {
+	f := target(fn)
+	var argp *uint8
+	if len(buf) > 0 {
+		argp = &buf[0]
+	}
+	go gosecommon.Reply(f, gosecommon.FrameArgs(f.Type(), argp), gosecommon.Forward(res))
+}
+
+// target returns the gosecure target fn.
+func target(fn *funcval) reflect.Value {
+	for _, t := range runtime.GosecureTargets() {
+		if f := reflect.ValueOf(t); f.Pointer() == fn.fn {
+			return f
+		}
+	}
+	log.Fatalln("Unable to find the gosecure target at address ", fn.fn)
+	return reflect.Value{}
+}
+
+// gosecload sends the ecall for the call to fn with the arguments in buf.
+// res is the channel of the reply for a gosecure expression, nil otherwise.
+func gosecload(size int32, fn *funcval, buf []uint8, res unsafe.Pointer) {
+	// Only buf refers to the arguments until the enclave copies them, and
+	// loading the enclave allocates.
+	buf = gosecommon.ScannedFrame(target(fn).Type(), buf)
 	pc := runtime.FuncForPC(fn.fn)
 	if pc == nil {
 		log.Fatalln("Unable to find the name for the func at address ", fn.fn)
 	}
 
-	initOnce.Do(func() {
-		runtime.InitCooperativeRuntime()
-		LoadEnclave()
-		// Server to allocate requests & service system calls for the enclave.
-		go oCallServer()
-	})
+	in, err := acquire(gosecommon.EnclaveOf(pc.Name()))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer in.sent()
 
 	//Copy the stack frame inside a buffer.
-	attrib := runtime.EcallReq{Name: pc.Name(), Siz: size, Buf: nil, Argp: nil}
+	attrib := runtime.EcallReq{Name: pc.Name(), Siz: size, Buf: buf, Argp: nil, Res: res}
 	if size > 0 {
-		attrib.Buf = make([]uint8, size, size)
-		bufcopy(attrib.Buf, &b, size)
 		attrib.Argp = (*uint8)(unsafe.Pointer(&(attrib.Buf[0])))
 	}
-	runtime.GosecureSend(attrib)
+	// The enclave counts the call as completed, see Shutdown.
+	atomic.AddInt64(&in.cprt.Ecalls, 1)
+	// The enclave copies the arguments once it received them, the adversary
+	// corrupts them before.
+	if adversary != nil && adversary.Corrupt != nil && len(buf) > 0 {
+		adversary.Corrupt(buf)
+	}
+	runtime.GosecureSend(in.cprt, attrib)
 }
 
 // executes without g, m, or p, so might need to do better.
 //go:nosplit
 func spawnEnclaveThread(req *runtime.OExitRequest) {
-	if !enclWrap.tcss[req.Did].Used {
+	in := loaded[req.Eid]
+	if !in.encl.tcss[req.Did].Used {
 		panic("Error, tcs is not reserved.")
 	}
-	src := &srcWrap.tcss[req.Did]
-	dest := &enclWrap.tcss[req.Did]
+	src := &in.src.tcss[req.Did]
+	dest := &in.encl.tcss[req.Did]
 	src.Used, dest.Used = true, true
 
-	sgxEEnter(uint64(req.Did), dest, src, req)
-	// In the simulation we just return.
-	if enclWrap.isSim {
-		return
+	in.backend.Spawn(in, req)
+	resume(in, req.Sid)
+	// In sgx, eresume does not return.
+	if !in.backend.Simulated() {
+		panic("gosec: unable to find an available tcs")
 	}
-	// For sgx, we call eresume
-	sgxEResume(req.Sid)
-	panic("gosec: unable to find an available tcs")
 }
 
 //go:nosplit
 func FutexSleep(req *runtime.OExitRequest) {
-	runtime.FutexsleepE(unsafe.Pointer(req.Addr), req.Val)
-	if enclWrap.isSim {
-		return
+	in := loaded[req.Eid]
+	atomic.StoreUintptr(&in.threads.sleepers[req.Sid], req.Addr)
+	if atomic.LoadUint32(&in.threads.halting) == 0 {
+		runtime.FutexsleepE(unsafe.Pointer(req.Addr), req.Val, req.Ns)
 	}
-	sgxEResume(req.Sid)
+	atomic.StoreUintptr(&in.threads.sleepers[req.Sid], 0)
+	resume(in, req.Sid)
 }
 
 //go:nosplit
 func FutexWakeup(req *runtime.OExitRequest) {
 	runtime.FutexwakeupE(unsafe.Pointer(req.Addr), req.Val)
-	if enclWrap.isSim {
-		return
-	}
-	sgxEResume(req.Sid)
+	resume(loaded[req.Eid], req.Sid)
 }
 
 //go:nosplit
@@ -195,15 +417,15 @@ func EpollPWait(req *runtime.OExitRequest) {
 	request := (*runtime.OcallReq)(unsafe.Pointer(req.EWReq))
 	result := (*runtime.OcallRes)(unsafe.Pointer(req.EWRes))
 	runtime.EpollPWait(request, result)
-	if enclWrap.isSim {
-		return
-	}
-	sgxEResume(req.Sid)
+	resume(loaded[req.Eid], req.Sid)
 }
 
+// resume returns to the thread of the tcs id of the enclave of in once its
+// request is served, or makes the thread exit if the enclave halted.
 //go:nosplit
-func sgxEResume(id uint64) {
-	tcs := runtime.Cooprt.Tcss[id]
-	xcpt := runtime.Cooprt.ExceptionHandler
-	asm_eresume(uint64(tcs.Tcs), xcpt)
+func resume(in *instance, id uint64) {
+	if atomic.LoadUint32(&in.threads.halting) != 0 {
+		runtime.ExitEnclaveThread(&in.threads.alive[id])
+	}
+	in.backend.Resume(in, id)
 }
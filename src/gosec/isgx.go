@@ -2,6 +2,7 @@ package gosec
 
 import (
 	"debug/elf"
+	"encoding/binary"
 	"fmt"
 	"runtime"
 )
@@ -44,12 +45,11 @@ const (
 
 // Sizes for the different elements
 const (
-	STACK_SIZE  = 0x8000
-	TCS_SIZE    = PSIZE
-	SSA_SIZE    = PSIZE
-	MSGX_SIZE   = PSIZE
-	TLS_SIZE    = PSIZE
-	MEMBUF_SIZE = runtime.MEMBUF_SIZE //(PSIZE * 300)
+	STACK_SIZE = 0x8000
+	TCS_SIZE   = PSIZE
+	SSA_SIZE   = PSIZE
+	MSGX_SIZE  = PSIZE
+	TLS_SIZE   = PSIZE
 )
 
 // Offsets are of the form FROM_TO_OFF = VALUE
@@ -64,6 +64,7 @@ const (
 // Elf.Symbol.Name to find addresses
 const (
 	mtlsArrayName = "runtime.enclaveMsgxTlsArr"
+	manifestName  = "runtime.enclaveManifest"
 )
 
 var (
@@ -87,6 +88,21 @@ type sgx_enclave_add_page struct {
 	mrmask  uint16 //bitmask for the 256 byte chunks that are to be measured
 }
 
+// sgx_enclave_add_pages adds pages with the sgx driver of the kernel, which
+// sets count to the bytes added.
+type sgx_enclave_add_pages struct {
+	src     uint64
+	offset  uint64
+	length  uint64
+	secinfo uint64
+	flags   uint64
+	count   uint64
+}
+
+type sgx_enclave_init_flc struct {
+	sigstruct uint64
+}
+
 type isgx_secinfo struct {
 	flags    uint64
 	reserved [7]uint64
@@ -99,20 +115,21 @@ type sgx_wrapper struct {
 	mhstart uintptr // 0x1000
 	mhsize  uintptr // 0x108000
 	membuf  uintptr // To satisfy map(nil) requests
+	membsiz uintptr
 	alloc   []byte
 	secs    *secs_t
-	isSim   bool
 	entry   uintptr // where to jump (asm_eenter or file.Entry)
 	mtlsarr uintptr
+	stage   uintptr // start of the staging area, see transposeOut.
 }
 
 type sgx_tcs_info = runtime.SgxTCSInfo
 
-func (s *sgx_wrapper) DumpDebugInfo() {
-	if runtime.Cooprt != nil {
-		fmt.Printf("Cooprt at %p\n", runtime.Cooprt)
-		fmt.Printf("Cooprt.Ecall %p, Cooprt.Ocall %p\n", runtime.Cooprt.EcallSrv, runtime.Cooprt.Ocall)
-		fmt.Printf("Unsafe allocation: %x, size: %x\n", runtime.Cooprt.StartUnsafe, runtime.Cooprt.SizeUnsafe)
+func (s *sgx_wrapper) DumpDebugInfo(c *runtime.CooperativeRuntime) {
+	if c != nil {
+		fmt.Printf("Cooprt at %p\n", c)
+		fmt.Printf("Cooprt.Ecall %p, Cooprt.Ocall %p\n", c.EcallSrv, c.Ocall)
+		fmt.Printf("Unsafe allocation: %x, size: %x\n", c.StartUnsafe, c.SizeUnsafe)
 	}
 	fmt.Printf("[DEBUG-INFO] wrapper at %p\n", s)
 	fmt.Printf("{base: %x, siz: %x, mhstart: %x, mhsize: %x}\n", s.base, s.siz, s.mhstart, s.mhsize)
@@ -129,39 +146,91 @@ func (s *sgx_wrapper) defaultTcs() *sgx_tcs_info {
 	return &s.tcss[0]
 }
 
-func transposeOutWrapper(wrap *sgx_wrapper) *sgx_wrapper {
+// transposeOutWrapper returns wrap in its staging area.
+func (wrap *sgx_wrapper) transposeOutWrapper() *sgx_wrapper {
 	trans := &sgx_wrapper{
-		transposeOut(wrap.base), wrap.siz, nil,
-		transposeOut(wrap.mhstart), wrap.mhsize,
-		transposeOut(wrap.membuf), nil, wrap.secs, wrap.isSim,
-		wrap.entry, transposeOut(wrap.mtlsarr)}
+		wrap.transposeOut(wrap.base), wrap.siz, nil,
+		wrap.transposeOut(wrap.mhstart), wrap.mhsize,
+		wrap.transposeOut(wrap.membuf), wrap.membsiz, nil, wrap.secs,
+		wrap.entry, wrap.transposeOut(wrap.mtlsarr), wrap.stage}
 
 	trans.tcss = make([]sgx_tcs_info, len(wrap.tcss))
 	for i := 0; i < len(wrap.tcss); i++ {
-		trans.tcss[i] = transposeOutTCS(wrap.tcss[i])
+		trans.tcss[i] = wrap.transposeOutTCS(wrap.tcss[i])
 	}
 	return trans
 }
 
-func transposeOutTCS(orig sgx_tcs_info) sgx_tcs_info {
+func (wrap *sgx_wrapper) transposeOutTCS(orig sgx_tcs_info) sgx_tcs_info {
 	return sgx_tcs_info{
-		transposeOut(orig.Stack), orig.Ssiz, transposeOut(orig.Tcs),
-		transposeOut(orig.Ssa), transposeOut(orig.Msgx), transposeOut(orig.Tls),
+		wrap.transposeOut(orig.Stack), orig.Ssiz, wrap.transposeOut(orig.Tcs),
+		wrap.transposeOut(orig.Ssa), wrap.transposeOut(orig.Msgx), wrap.transposeOut(orig.Tls),
 		orig.Rdi, orig.Rsi,
-		transposeOut(orig.Entry), orig.Used}
+		wrap.transposeOut(orig.Entry), orig.Used}
+}
+
+// enclaveLayout returns the layout of the enclave executable file, as set by
+// the manifest the linker embeds in it.
+func enclaveLayout(file *elf.File) (runtime.EnclaveLayout, error) {
+	syms, err := file.Symbols()
+	if err != nil {
+		return runtime.EnclaveLayout{}, err
+	}
+	for _, s := range syms {
+		if s.Name != manifestName {
+			continue
+		}
+		// The manifest is a string: a pointer and a length.
+		hdr, err := readVaddr(file, s.Value, 16)
+		if err != nil {
+			return runtime.EnclaveLayout{}, err
+		}
+		ptr, n := binary.LittleEndian.Uint64(hdr), binary.LittleEndian.Uint64(hdr[8:])
+		manifest, err := readVaddr(file, ptr, n)
+		if err != nil {
+			return runtime.EnclaveLayout{}, err
+		}
+		l, ok := runtime.ParseEnclaveManifest(string(manifest))
+		if !ok {
+			return l, fmt.Errorf("invalid enclave manifest %q", manifest)
+		}
+		return l, nil
+	}
+	return runtime.EnclaveLayout{}, fmt.Errorf("enclave manifest not found")
+}
+
+// readVaddr returns the n bytes at the virtual address addr of file.
+func readVaddr(file *elf.File, addr, n uint64) ([]byte, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	for _, sec := range file.Sections {
+		if sec.Flags&elf.SHF_ALLOC == 0 || addr < sec.Addr || addr+n > sec.Addr+sec.Size {
+			continue
+		}
+		if sec.Type == elf.SHT_NOBITS {
+			return make([]byte, n), nil
+		}
+		data, err := sec.Data()
+		if err != nil {
+			return nil, err
+		}
+		return data[addr-sec.Addr : addr-sec.Addr+n], nil
+	}
+	return nil, fmt.Errorf("address %#x not in the executable", addr)
 }
 
 // getMtlsArr finds the address of the array that we leverage to put MSGX | TLS
 // pages in the enclave as part of the bss segment.
-func getMtlsArr(file *elf.File) uintptr {
+func getMtlsArr(file *elf.File) (uintptr, error) {
 	syms, err := file.Symbols()
-	check(err)
+	if err != nil {
+		return 0, err
+	}
 	for _, s := range syms {
 		if s.Name == mtlsArrayName {
-			return uintptr(palign(s.Value, false))
+			return uintptr(palign(s.Value, false)), nil
 		}
 	}
-	//Unable to find the symbol.
-	panic("isgx was unable to find the enclaveMsgxTlsArr symbol address.")
-	return uintptr(0)
+	return 0, fmt.Errorf("symbol %v not found", mtlsArrayName)
 }
@@ -0,0 +1,56 @@
+package gosec
+
+import (
+	"debug/elf"
+	"internal/testenv"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestEnclaveLayout(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	dir, err := ioutil.TempDir("", "gosec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(src, []byte("package main\n\nimport \"runtime\"\n\nfunc main() { println(runtime.CurrentEnclaveLayout().Tcs) }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, manifest := range []string{"", "tcs=5,heap=0x10000000", "redact=1", "tcs=3,name=keys"} {
+		exe := filepath.Join(dir, "main")
+		// The linker sets the manifest of an enclave the same way.
+		out, err := exec.Command(testenv.GoToolPath(t), "build", "-o", exe, "-ldflags=-X runtime.enclaveManifest="+manifest, src).CombinedOutput()
+		if err != nil {
+			t.Fatalf("go build: %v\n%s", err, out)
+		}
+		file, err := elf.Open(exe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := enclaveLayout(file)
+		file.Close()
+		want, _ := runtime.ParseEnclaveManifest(manifest)
+		if err != nil || l != want {
+			t.Errorf("manifest %q: layout %+v, %v, want %+v", manifest, l, err, want)
+		}
+	}
+}
+
+func TestParseEnclaveManifest(t *testing.T) {
+	l, ok := runtime.ParseEnclaveManifest("base=0x20000000000,size=0x1000000000,heap=0x10000000,tcs=8,unsafe=0x2000,membuf=4096")
+	want := runtime.EnclaveLayout{Base: 0x20000000000, Size: 0x1000000000, Heap: 0x10000000, Tcs: 8, Unsafe: 0x2000, Membuf: 0x1000}
+	if !ok || l != want {
+		t.Errorf("got %+v, %v, want %+v", l, ok, want)
+	}
+	for _, s := range []string{"tcs=2", "tcs=9", "heap=0x1001", "base", "base=0x", "stack=1", "size=0x1g", "size=0x10000000000000000"} {
+		if _, ok := runtime.ParseEnclaveManifest(s); ok {
+			t.Errorf("ParseEnclaveManifest(%q) succeeded", s)
+		}
+	}
+}
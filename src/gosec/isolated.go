@@ -0,0 +1,318 @@
+package gosec
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+/* This file implements the isolated simulation, SIM=isolated. The enclave is
+ * loaded as in the simulation, but it then runs in a child process: the
+ * program unmaps it, and the kernel enforces what sgx enforces, that the
+ * untrusted side cannot read the memory of the enclave. The child shares with
+ * the program the memory that is untrusted, see runtime/gosecisolated.go, and
+ * its file descriptors, and runs under a seccomp filter that only lets the
+ * enclave make the system calls it makes in the simulation: all the others are
+ * requests to the untrusted side, which the program serves.
+ *
+ * The child dies with the program. The program reports the death of the child
+ * as a crash of the enclave, and kills it when the enclave is shut down.
+ */
+
+// isolatedStack is the size of the stack of the child process, on which it
+// starts the thread of the enclave and then parks.
+const isolatedStack = 64 << 10
+
+// Status of the child process that could not sandbox itself.
+const isolatedSetupFailed = 3
+
+// Of linux.
+const (
+	_ARCH_SET_FS          = 0x1002
+	_PR_SET_PDEATHSIG     = 1
+	_PR_SET_SECCOMP       = 22
+	_PR_SET_NO_NEW_PRIVS  = 38
+	_SECCOMP_MODE_FILTER  = 2
+	_SECCOMP_RET_KILL     = 0x80000000 // the whole process.
+	_SECCOMP_RET_ALLOW    = 0x7fff0000
+	_AUDIT_ARCH_X86_64    = 0xc000003e
+	_CLONE_THREAD         = 0x10000
+	_SECCOMP_DATA_NR      = 0
+	_SECCOMP_DATA_ARCH    = 4
+	_SECCOMP_DATA_ARG0_LO = 16
+)
+
+// isolatedSyscalls are the system calls that the enclave and the handlers of
+// its requests make in the simulation. clone is also allowed for threads, and
+// write for the standard output and error, where the runtime of the enclave
+// prints its debugging output.
+var isolatedSyscalls = []uintptr{
+	syscall.SYS_FUTEX,
+	syscall.SYS_MMAP,
+	syscall.SYS_MUNMAP,
+	syscall.SYS_MPROTECT,
+	syscall.SYS_MADVISE,
+	syscall.SYS_ARCH_PRCTL,
+	syscall.SYS_SIGALTSTACK,
+	syscall.SYS_RT_SIGPROCMASK,
+	syscall.SYS_RT_SIGRETURN,
+	syscall.SYS_SCHED_YIELD,
+	syscall.SYS_SCHED_GETAFFINITY,
+	syscall.SYS_NANOSLEEP,
+	syscall.SYS_SELECT,
+	syscall.SYS_PSELECT6,
+	syscall.SYS_CLOCK_GETTIME,
+	syscall.SYS_GETTIMEOFDAY,
+	syscall.SYS_EPOLL_WAIT,
+	syscall.SYS_EPOLL_PWAIT,
+	syscall.SYS_GETPID,
+	syscall.SYS_GETTID,
+	syscall.SYS_EXIT,
+	syscall.SYS_EXIT_GROUP,
+}
+
+// seccompFilter returns the seccomp filter of the child process, which kills
+// it on the system calls that are not in allowed, on clone unless it creates a
+// thread, and on write unless it writes to the standard output or error.
+func seccompFilter(allowed []uintptr) []syscall.SockFilter {
+	const (
+		ld   = syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS
+		jeq  = syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K
+		jset = syscall.BPF_JMP | syscall.BPF_JSET | syscall.BPF_K
+		ret  = syscall.BPF_RET | syscall.BPF_K
+	)
+	// The jumps are relative to the next instruction, and forward.
+	n := len(allowed)
+	kill := 5 + n
+	clone := kill + 1
+	write := clone + 2
+	allow := write + 4
+	to := func(from, dest int) uint8 { return uint8(dest - from - 1) }
+	f := []syscall.SockFilter{
+		{Code: ld, K: _SECCOMP_DATA_ARCH},
+		{Code: jeq, Jf: to(1, kill), K: _AUDIT_ARCH_X86_64},
+		{Code: ld, K: _SECCOMP_DATA_NR},
+		{Code: jeq, Jt: to(3, clone), K: syscall.SYS_CLONE},
+		{Code: jeq, Jt: to(4, write), K: syscall.SYS_WRITE},
+	}
+	for i, nr := range allowed {
+		f = append(f, syscall.SockFilter{Code: jeq, Jt: to(5+i, allow), K: uint32(nr)})
+	}
+	return append(f,
+		syscall.SockFilter{Code: ret, K: _SECCOMP_RET_KILL},
+		syscall.SockFilter{Code: ld, K: _SECCOMP_DATA_ARG0_LO},
+		syscall.SockFilter{Code: jset, Jt: to(clone+1, allow), Jf: to(clone+1, allow-1), K: _CLONE_THREAD},
+		syscall.SockFilter{Code: ld, K: _SECCOMP_DATA_ARG0_LO},
+		syscall.SockFilter{Code: jeq, Jt: to(write+1, allow), K: 1},
+		syscall.SockFilter{Code: jeq, Jt: to(write+2, allow), K: 2},
+		syscall.SockFilter{Code: ret, K: _SECCOMP_RET_KILL},
+		syscall.SockFilter{Code: ret, K: _SECCOMP_RET_ALLOW},
+	)
+}
+
+// forking is what the child process reads once forked, see isolatedChild. It
+// is only set by the forker.
+var forking struct {
+	in        *instance
+	dest, src *sgx_tcs_info
+	ppid      uintptr
+	tls       uintptr // the end of a page of the child.
+	prog      syscall.SockFprog
+	park      uint32
+}
+
+// sigDefault is the sigaction of SIG_DFL.
+var sigDefault [4]uint64
+
+// isolatedChild is the entry of the child process. It runs without g on the
+// stack of the child, in a copy of the memory of the program: it only writes
+// the memory of the child and the untrusted memory of the enclave.
+//go:nosplit
+func isolatedChild() {
+	f := &forking
+	// The tls of the forker thread is in its m, which is untrusted memory:
+	// nothing of the child uses it anymore.
+	syscall.RawSyscall(syscall.SYS_ARCH_PRCTL, _ARCH_SET_FS, f.tls, 0)
+	syscall.RawSyscall(syscall.SYS_PRCTL, _PR_SET_PDEATHSIG, uintptr(syscall.SIGKILL), 0)
+	if ppid, _, _ := syscall.RawSyscall(syscall.SYS_GETPPID, 0, 0, 0); ppid != f.ppid {
+		syscall.RawSyscall(syscall.SYS_EXIT_GROUP, isolatedSetupFailed, 0, 0)
+	}
+	// The handlers of the program need its runtime. The signals stay
+	// blocked, and the faults kill the child.
+	for sig := uintptr(1); sig <= 64; sig++ {
+		syscall.RawSyscall6(syscall.SYS_RT_SIGACTION, sig, uintptr(unsafe.Pointer(&sigDefault)), 0, 8, 0, 0)
+	}
+	if _, _, e := syscall.RawSyscall6(syscall.SYS_PRCTL, _PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0, 0); e != 0 {
+		syscall.RawSyscall(syscall.SYS_EXIT_GROUP, isolatedSetupFailed, 0, 0)
+	}
+	if _, _, e := syscall.RawSyscall(syscall.SYS_PRCTL, _PR_SET_SECCOMP, _SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&f.prog))); e != 0 {
+		syscall.RawSyscall(syscall.SYS_EXIT_GROUP, isolatedSetupFailed, 0, 0)
+	}
+	sgxEEnter(f.in, 0, f.dest, f.src, nil)
+	for {
+		runtime.FutexsleepE(unsafe.Pointer(&f.park), 0, -1)
+	}
+}
+
+// forkReq asks the forker to fork the child process of in, whose thread
+// enters the enclave with the tcs dest and src.
+type forkReq struct {
+	in        *instance
+	dest, src *sgx_tcs_info
+	pid       chan int // negative errno on failure.
+}
+
+var forker struct {
+	once sync.Once
+	reqs chan forkReq
+}
+
+// fork forks the child process of in.
+func (in *instance) fork(dest, src *sgx_tcs_info) (int, error) {
+	forker.once.Do(func() {
+		forker.reqs = make(chan forkReq)
+		go forkLoop()
+	})
+	req := forkReq{in: in, dest: dest, src: src, pid: make(chan int)}
+	forker.reqs <- req
+	pid := <-req.pid
+	if pid < 0 {
+		return 0, fmt.Errorf("unable to fork the process of the enclave: %v", syscall.Errno(-pid))
+	}
+	return pid, nil
+}
+
+// forkLoop serves the requests of fork. The children die with the thread
+// that forked them, see PR_SET_PDEATHSIG in prctl(2): it stays locked, and
+// never exits.
+func forkLoop() {
+	runtime.LockOSThread()
+	prog := seccompFilter(isolatedSyscalls)
+	forking.prog = syscall.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	forking.ppid = uintptr(os.Getpid())
+	fn := unsafe.Pointer(reflect.ValueOf(isolatedChild).Pointer())
+	for req := range forker.reqs {
+		stk, err := syscall.Mmap(-1, 0, isolatedStack, _PROT_READ|_PROT_WRITE, _MAP_PRIVATE|_MAP_ANON)
+		if err != nil {
+			req.pid <- -int(err.(syscall.Errno))
+			continue
+		}
+		base := uintptr(unsafe.Pointer(&stk[0]))
+		forking.in, forking.dest, forking.src = req.in, req.dest, req.src
+		forking.tls = base + PSIZE
+		pid := runtime.ForkIsolated(base+isolatedStack, fn)
+		forking.in, forking.dest, forking.src = nil, nil, nil
+		syscall.Munmap(stk)
+		req.pid <- int(pid)
+	}
+}
+
+// isolate runs the enclave of in, loaded in the simulation, in a child
+// process, and unmaps it from the program.
+func (in *instance) isolate(dest, src *sgx_tcs_info) error {
+	stk, err := syscall.Mmap(-1, 0, isolatedStack, _PROT_READ|_PROT_WRITE, _MAP_PRIVATE|_MAP_ANON)
+	if err != nil {
+		return err
+	}
+	pid, err := in.fork(dest, src)
+	if err != nil {
+		syscall.Munmap(stk)
+		return err
+	}
+	in.child = pid
+	runtime.RMunmap(unsafe.Pointer(in.layout.Base), in.layout.Size)
+	runtime.RMunmap(unsafe.Pointer(in.stage), in.layout.Size)
+
+	r := &reaper{in: in, stk: stk, running: 1}
+	r.n = copy(r.msg[:], crashLabel(in.name)+"the process of the enclave ")
+	in.reaper = r
+	top := uintptr(unsafe.Pointer(&stk[0])) + isolatedStack - 16
+	*(*uintptr)(unsafe.Pointer(top)) = uintptr(unsafe.Pointer(r))
+	runtime.StartReaperThread(top, unsafe.Pointer(reflect.ValueOf(reap).Pointer()))
+	return nil
+}
+
+// reaper waits for the child process of an enclave on a thread of its own,
+// which the scheduler does not know of: a thread of the scheduler blocked in
+// wait4 would keep the others from polling the wake ups of the enclave, see
+// stopm in runtime/proc.go.
+type reaper struct {
+	in      *instance
+	stk     []byte    // the stack of the thread.
+	running uint32    // 1 until the thread no longer uses its stack.
+	msg     [128]byte // the report of the death of the child, of n bytes.
+	n       int
+}
+
+// reap is the thread of r. Unless the child process was killed by kill, the
+// enclave crashed: reap reports it and exits as reportCrash does. It runs
+// without g.
+//go:nosplit
+func reap(r *reaper) {
+	var ws uint32
+	for {
+		_, _, e := syscall.RawSyscall6(syscall.SYS_WAIT4, uintptr(r.in.child), uintptr(unsafe.Pointer(&ws)), 0, 0, 0, 0)
+		if e != syscall.EINTR {
+			break
+		}
+	}
+	if atomic.LoadUint32(&r.in.threads.halting) == 0 {
+		r.describe(ws)
+		syscall.RawSyscall(syscall.SYS_WRITE, 2, uintptr(unsafe.Pointer(&r.msg[0])), uintptr(r.n))
+		syscall.RawSyscall(syscall.SYS_EXIT_GROUP, 2, 0, 0)
+	}
+	runtime.ExitEnclaveThread(&r.running)
+}
+
+// describe appends to the report of r how the child process died, with the
+// wait status ws.
+//go:nosplit
+func (r *reaper) describe(ws uint32) {
+	sig, status := ws&0x7f, ws>>8&0xff
+	switch {
+	case sig == uint32(syscall.SIGSYS):
+		r.n += copy(r.msg[r.n:], "made a forbidden system call")
+	case sig != 0:
+		r.n += copy(r.msg[r.n:], "was killed by signal ")
+		r.itoa(sig)
+	case status == isolatedSetupFailed:
+		r.n += copy(r.msg[r.n:], "could not sandbox itself")
+	default:
+		r.n += copy(r.msg[r.n:], "exited with status ")
+		r.itoa(status)
+	}
+	r.n += copy(r.msg[r.n:], "\n")
+}
+
+//go:nosplit
+func (r *reaper) itoa(v uint32) {
+	var b [10]byte
+	i := len(b)
+	for {
+		i--
+		b[i] = byte('0' + v%10)
+		if v /= 10; v == 0 {
+			break
+		}
+	}
+	r.n += copy(r.msg[r.n:], b[i:])
+}
+
+// kill kills the child process of in, which halted, and waits for it to die.
+// The threads of the enclave are gone with it.
+func (in *instance) kill() {
+	syscall.Kill(in.child, syscall.SIGKILL)
+	for atomic.LoadUint32(&in.reaper.running) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	syscall.Munmap(in.reaper.stk)
+	for i := range in.threads.alive {
+		atomic.StoreUint32(&in.threads.alive[i], 0)
+	}
+}
@@ -0,0 +1,137 @@
+package gosec
+
+import (
+	"bytes"
+	"internal/testenv"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// TestSeccompHelper installs the seccomp filter of the child process on its
+// thread, and makes the system call GOSEC_SECCOMP_CALL, for TestSeccompFilter.
+func TestSeccompHelper(t *testing.T) {
+	call := os.Getenv("GOSEC_SECCOMP_CALL")
+	if call == "" {
+		return
+	}
+	runtime.LockOSThread()
+	prog := seccompFilter(isolatedSyscalls)
+	fprog := syscall.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	if _, _, e := syscall.RawSyscall6(syscall.SYS_PRCTL, _PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0, 0); e != 0 {
+		t.Fatal(e)
+	}
+	if _, _, e := syscall.RawSyscall(syscall.SYS_PRCTL, _PR_SET_SECCOMP, _SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&fprog))); e != 0 {
+		t.Fatal(e)
+	}
+	b := []byte("ok")
+	switch call {
+	case "gettid":
+		syscall.RawSyscall(syscall.SYS_GETTID, 0, 0, 0)
+	case "write":
+		syscall.RawSyscall(syscall.SYS_WRITE, 2, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+	case "write3":
+		syscall.RawSyscall(syscall.SYS_WRITE, 3, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+	case "getuid":
+		syscall.RawSyscall(syscall.SYS_GETUID, 0, 0, 0)
+	case "fork":
+		syscall.RawSyscall(syscall.SYS_CLONE, uintptr(syscall.SIGCHLD), 0, 0)
+	case "x32":
+		syscall.RawSyscall(0x40000000|syscall.SYS_GETTID, 0, 0, 0)
+	}
+	syscall.RawSyscall(syscall.SYS_EXIT_GROUP, 0, 0, 0)
+}
+
+func TestSeccompFilter(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("the isolated simulation is amd64 only")
+	}
+	testenv.MustHaveExec(t)
+	for _, tt := range []struct {
+		call    string
+		allowed bool
+	}{
+		{"gettid", true},
+		{"write", true},
+		{"write3", false},
+		{"getuid", false},
+		{"fork", false},
+		{"x32", false},
+	} {
+		cmd := exec.Command(os.Args[0], "-test.run=TestSeccompHelper")
+		cmd.Env = append(os.Environ(), "GOSEC_SECCOMP_CALL="+tt.call)
+		err := cmd.Run()
+		if tt.allowed {
+			if err != nil {
+				t.Errorf("%s: %v, want it allowed", tt.call, err)
+			}
+			continue
+		}
+		ee, ok := err.(*exec.ExitError)
+		if !ok || ee.Sys().(syscall.WaitStatus).Signal() != syscall.SIGSYS {
+			t.Errorf("%s: %v, want the process killed by SIGSYS", tt.call, err)
+		}
+	}
+}
+
+const isolatedProgram = `package main
+
+import "unsafe"
+
+var secret = [6]byte{'s', 'e', 'c', 'r', 'e', 't'}
+
+func where(out chan uintptr) {
+	out <- uintptr(unsafe.Pointer(&secret))
+}
+
+func main() {
+	out := make(chan uintptr)
+	gosecure where(out)
+	p := <-out
+	println("read", string((*[6]byte)(unsafe.Pointer(p))[:]))
+}
+`
+
+// TestIsolatedSimulation checks that the untrusted side reads the memory of
+// the enclave in the simulation, and faults in the isolated simulation.
+func TestIsolatedSimulation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs an enclave")
+	}
+	if runtime.GOARCH != "amd64" {
+		t.Skip("the isolated simulation is amd64 only")
+	}
+	testenv.MustHaveGoBuild(t)
+	dir, err := ioutil.TempDir("", "gosec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, exe := filepath.Join(dir, "main.go"), filepath.Join(dir, "main")
+	if err := ioutil.WriteFile(src, []byte(isolatedProgram), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(testenv.GoToolPath(t), "build", "-o", exe, src).CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	for _, sim := range []string{"1", "isolated"} {
+		cmd := exec.Command(exe)
+		cmd.Env = append(os.Environ(), "SIM="+sim)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		read := strings.Contains(stderr.String(), "read secret")
+		switch {
+		case sim == "1" && (err != nil || !read):
+			t.Errorf("SIM=1: %v, the untrusted side did not read the enclave\n%s", err, stderr.Bytes())
+		case sim == "isolated" && (err == nil || read || !strings.Contains(stderr.String(), "unexpected fault address")):
+			t.Errorf("SIM=isolated: %v, the untrusted side did not fault on the enclave\n%s", err, stderr.Bytes())
+		}
+	}
+}
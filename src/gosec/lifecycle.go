@@ -0,0 +1,400 @@
+package gosec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+/* This file implements the lifecycle of the enclaves. The first gosecure call
+ * to an enclave starts it, unless Start did. Shutdown waits for the gosecure
+ * calls to complete and tears the enclaves down: each enclave halts, see
+ * runtime/gosechalt.go, its threads exit, and its memory, its cooperative
+ * runtime and, with the last one, the sgx device are released. The next
+ * gosecure call to an enclave, or Start, creates a fresh one.
+ *
+ * A program has a default enclave, and one per name of the //gosec:enclave
+ * comments of its packages, see gosecommon.EnclaveOf. They have their own
+ * executable, measurement, heap and threads.
+ */
+
+// Options configure the enclave started by Start.
+type Options struct {
+	// Enclave is the name of the enclave to start, empty for the default
+	// one.
+	Enclave string
+
+	// Simulation loads the enclave without sgx, as SIM does, whatever the
+	// backend of its manifest, see Backend. With SIM=isolated in the
+	// environment of the program when it starts, the simulated enclaves run
+	// in child processes, which seccomp sandboxes, and the program cannot
+	// read their memory.
+	Simulation bool
+
+	// OcallWorkers is the number of host threads serving the switchless
+	// ocalls, see SetOcallWorkers. 0 keeps the number set there, and a
+	// negative number disables the switchless ocalls.
+	OcallWorkers int
+}
+
+func (o Options) simulation() bool {
+	return o.Simulation || os.Getenv("SIM") != ""
+}
+
+// States of an enclave.
+const (
+	enclaveStopped = iota
+	enclaveRunning
+	enclaveStopping
+	enclaveBroken // a shutdown failed to tear the enclave down.
+)
+
+const (
+	// drainPoll is the interval at which Shutdown checks for the gosecure
+	// calls to complete.
+	drainPoll = 5 * time.Millisecond
+
+	// haltTimeout bounds the time an enclave takes to halt and its threads
+	// to exit, once the calls are drained or abandoned.
+	haltTimeout = 5 * time.Second
+)
+
+var errBroken = errors.New("gosec: a shutdown failed to tear down the enclave")
+
+var enclaves struct {
+	sync.Mutex
+	changed sync.Cond           // broadcast when the state of an enclave changes.
+	m       map[string]*enclave // by name.
+	opts    Options             // of the last enclave started.
+}
+
+func init() {
+	enclaves.changed.L = &enclaves.Mutex
+	enclaves.m = make(map[string]*enclave)
+}
+
+// enclave is an enclave of the program, started or not.
+type enclave struct {
+	name    string
+	state   int
+	opts    Options // of the last start, if started.
+	started bool
+	inst    *instance
+}
+
+// enclaveNamed returns the enclave name. The lock of enclaves is held.
+func enclaveNamed(name string) *enclave {
+	e := enclaves.m[name]
+	if e == nil {
+		e = &enclave{name: name}
+		enclaves.m[name] = e
+	}
+	return e
+}
+
+// instance is an enclave that was started.
+type instance struct {
+	name    string
+	cprt    *runtime.CooperativeRuntime
+	layout  runtime.EnclaveLayout
+	backend Backend
+	hash    *enclaveHash // the measurement and the signature of the enclave.
+	srv     *servers
+	sends   int // gosecure calls being sent, under the lock of enclaves.
+
+	encl  *sgx_wrapper // the enclave.
+	src   *sgx_wrapper // the enclave in its staging area, see transposeOut.
+	stage uintptr      // start of the staging area.
+
+	// The child process of the enclave in the isolated simulation, and the
+	// thread that waits for it, see isolated.go.
+	child  int
+	reaper *reaper
+
+	// The threads of the enclave, for Shutdown to make them exit. They are
+	// indexed by tcs, and used without g by the handlers of the requests of
+	// the enclave threads.
+	threads struct {
+		halting  uint32    // the enclave halted, its threads exit.
+		alive    []uint32  // 1 while the thread runs.
+		sleepers []uintptr // the futex the thread sleeps on, if any.
+	}
+}
+
+// loaded are the instances by the Eid of their cooperative runtime, for the
+// handlers of the requests of the enclave threads.
+var loaded [runtime.MaxEnclaves]*instance
+
+// Start creates and loads the enclave opts.Enclave embedded in the program
+// with opts. It fails if the enclave is already started.
+func Start(opts Options) error {
+	enclaves.Lock()
+	defer enclaves.Unlock()
+	e := enclaveNamed(opts.Enclave)
+	for e.state == enclaveStopping {
+		enclaves.changed.Wait()
+	}
+	switch e.state {
+	case enclaveRunning:
+		return fmt.Errorf("gosec: the enclave %s is already started", e)
+	case enclaveBroken:
+		return errBroken
+	}
+	return e.start(opts)
+}
+
+func (e *enclave) String() string {
+	if e.name == "" {
+		return "default"
+	}
+	return strconv.Quote(e.name)
+}
+
+// start starts e with opts. The lock of enclaves is held.
+func (e *enclave) start(opts Options) error {
+	in, err := loadEnclave(e.name, opts)
+	if err != nil {
+		return fmt.Errorf("gosec: %v", err)
+	}
+	e.inst, e.opts, e.started, e.state = in, opts, true, enclaveRunning
+	enclaves.opts = opts
+	enclaves.changed.Broadcast()
+	return nil
+}
+
+// acquire returns the running enclave name, and counts a gosecure call being
+// sent to it until sent is called. It waits for a shutdown to complete, and
+// starts the enclave if it is not running, with the options of its last start
+// or else of the last enclave started.
+func acquire(name string) (*instance, error) {
+	enclaves.Lock()
+	defer enclaves.Unlock()
+	e := enclaveNamed(name)
+	for e.state == enclaveStopping {
+		enclaves.changed.Wait()
+	}
+	switch e.state {
+	case enclaveBroken:
+		return nil, errBroken
+	case enclaveStopped:
+		opts := enclaves.opts
+		if e.started {
+			opts = e.opts
+		}
+		opts.Enclave = name
+		if err := e.start(opts); err != nil {
+			return nil, err
+		}
+	}
+	e.inst.sends++
+	return e.inst, nil
+}
+
+// defaultInstance returns the default enclave, or nil if it is not running.
+func defaultInstance() *instance {
+	enclaves.Lock()
+	defer enclaves.Unlock()
+	return enclaveNamed("").inst
+}
+
+// anyLoaded reports whether an enclave is loaded.
+func anyLoaded() bool {
+	enclaves.Lock()
+	defer enclaves.Unlock()
+	for _, e := range enclaves.m {
+		if e.inst != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// simulated reports whether the default enclave runs in the simulation, or
+// will if it is not running.
+func simulated() bool {
+	enclaves.Lock()
+	defer enclaves.Unlock()
+	e := enclaveNamed("")
+	switch {
+	case e.inst != nil:
+		return e.inst.backend.Simulated()
+	case e.started:
+		return e.opts.simulation()
+	}
+	return enclaves.opts.simulation()
+}
+
+// sent ends what acquire started.
+func (in *instance) sent() {
+	enclaves.Lock()
+	in.sends--
+	enclaves.Unlock()
+}
+
+// Shutdown tears the enclaves down once the gosecure calls sent to them are
+// completed, and returns nil if no enclave is running. If ctx is done first,
+// the enclaves are torn down anyway, the calls that did not complete are
+// abandoned, and Shutdown returns the error of ctx. The gosecure calls made
+// during the shutdown wait for it, and then start a fresh enclave.
+//
+// The goroutines that the gosecure calls started and that are still blocked
+// on channels of the program must not be woken up once the enclaves are shut
+// down. Shutdown fails while the default enclave is traced.
+func Shutdown(ctx context.Context) error {
+	ins, err := stopping()
+	for _, in := range ins {
+		if derr := in.drain(ctx); derr != nil && err == nil {
+			err = derr
+		}
+	}
+	for _, in := range ins {
+		state := enclaveStopped
+		if herr := in.halt(); herr != nil {
+			err, state = herr, enclaveBroken
+		} else {
+			in.release()
+		}
+		enclaves.Lock()
+		e := enclaves.m[in.name]
+		e.inst, e.state = nil, state
+		enclaves.changed.Broadcast()
+		enclaves.Unlock()
+	}
+	return err
+}
+
+// stopping marks the running enclaves as stopping and returns them, along
+// with errBroken if an enclave is broken.
+func stopping() ([]*instance, error) {
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	enclaves.Lock()
+	defer enclaves.Unlock()
+	for {
+		busy := false
+		for _, e := range enclaves.m {
+			busy = busy || e.state == enclaveStopping
+		}
+		if !busy {
+			break
+		}
+		enclaves.changed.Wait()
+	}
+	var ins []*instance
+	var err error
+	for _, e := range enclaves.m {
+		switch e.state {
+		case enclaveBroken:
+			err = errBroken
+		case enclaveRunning:
+			ins = append(ins, e.inst)
+		}
+	}
+	if len(ins) > 0 && tracer.on {
+		return nil, errors.New("gosec: shutdown of the enclave while it is traced")
+	}
+	for _, in := range ins {
+		enclaves.m[in.name].state = enclaveStopping
+	}
+	return ins, err
+}
+
+// drain waits for the gosecure calls sent to the enclave to complete, or for
+// ctx to be done.
+func (in *instance) drain(ctx context.Context) error {
+	t := time.NewTicker(drainPoll)
+	defer t.Stop()
+	for {
+		enclaves.Lock()
+		sends := in.sends
+		enclaves.Unlock()
+		// A call is counted by the enclave before it is sent.
+		if sends == 0 && atomic.LoadInt64(&in.cprt.Ecalls) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// halt halts the enclave of in and makes its threads exit.
+func (in *instance) halt() error {
+	close(in.cprt.Stop)
+	halted := make(chan struct{})
+	go func() {
+		runtime.WaitEnclaveHalt(in.cprt)
+		close(halted)
+	}()
+	deadline := time.NewTimer(haltTimeout)
+	defer deadline.Stop()
+	select {
+	case <-halted:
+	case <-deadline.C:
+		return errors.New("gosec: the enclave did not halt")
+	}
+
+	// The threads of the enclave sleep on the untrusted side: wake them
+	// up, until they all exited. In the isolated simulation, they sleep in
+	// the child process, which goes away with them.
+	atomic.StoreUint32(&in.threads.halting, 1)
+	if in.child != 0 {
+		in.kill()
+		return nil
+	}
+	for {
+		running := 0
+		for i := range in.threads.alive {
+			if atomic.LoadUint32(&in.threads.alive[i]) == 0 {
+				continue
+			}
+			running++
+			if addr := atomic.LoadUintptr(&in.threads.sleepers[i]); addr != 0 {
+				runtime.FutexwakeupE(unsafe.Pointer(addr), 1<<30)
+			}
+		}
+		if running == 0 {
+			return nil
+		}
+		select {
+		case <-deadline.C:
+			return errors.New("gosec: the threads of the enclave did not exit")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// release releases what the enclave of in, which halted or never ran, holds
+// on the untrusted side. The lock of enclaves is not held, the enclaves are
+// loaded or released under it.
+func (in *instance) release() {
+	c, l := in.cprt, in.layout
+	in.srv.stop()
+	runtime.CancelEnclaveCrash(c)
+	runtime.RMunmap(unsafe.Pointer(l.Base), l.Size)
+	runtime.RMunmap(unsafe.Pointer(in.stage), l.Size)
+	in.srv.unmapMalRegions()
+	loadMu.Lock()
+	loaded[c.Eid] = nil
+	in.backend.Teardown(in)
+	c.Release()
+	loadMu.Unlock()
+
+	// The servers may still reply to the enclave in its unsafe memory.
+	go func() {
+		in.srv.wg.Wait()
+		if c.Ring != nil {
+			c.Ring.Free()
+		}
+		runtime.MunmapUnsafe(unsafe.Pointer(c.StartUnsafe), c.SizeUnsafe)
+	}()
+}
@@ -0,0 +1,67 @@
+package gosec
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestShutdownStopped(t *testing.T) {
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown without an enclave: %v", err)
+	}
+}
+
+func TestStartWithoutEnclave(t *testing.T) {
+	// The test binary embeds no enclave.
+	for i := 0; i < 2; i++ {
+		err := Start(Options{Simulation: true})
+		if err == nil || !strings.HasPrefix(err.Error(), "gosec: ") {
+			t.Fatalf("Start: %v, want a gosec error", err)
+		}
+	}
+	e := enclaveNamed("")
+	if e.state != enclaveStopped || e.inst != nil {
+		t.Errorf("the enclave is in state %d after a failed start", e.state)
+	}
+	if err := Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown after a failed start: %v", err)
+	}
+}
+
+func TestStartNamedWithoutEnclave(t *testing.T) {
+	err := Start(Options{Enclave: "keys", Simulation: true})
+	if err == nil || !strings.Contains(err.Error(), ".encl.keys") {
+		t.Fatalf("Start: %v, want an error about the section .encl.keys", err)
+	}
+	if _, err := NamedEnclaveIdentity("keys"); err == nil {
+		t.Errorf("NamedEnclaveIdentity of an enclave that failed to start succeeded")
+	}
+	if err := Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown after a failed start: %v", err)
+	}
+}
+
+func TestStagingArea(t *testing.T) {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	saved := loaded
+	defer func() { loaded = saved }()
+	const size = 1 << 36
+	for i := range loaded {
+		loaded[i] = nil
+	}
+	loaded[3] = &instance{stage: MMMASK, layout: runtime.EnclaveLayout{Size: size}}
+	loaded[5] = &instance{stage: MMMASK + 2*size, layout: runtime.EnclaveLayout{Size: size}}
+	for i, want := range []uintptr{MMMASK + size, MMMASK + 3*size} {
+		stage, err := stagingArea(size)
+		if err != nil || stage != want {
+			t.Fatalf("stagingArea: %#x, %v, want %#x", stage, err, want)
+		}
+		loaded[i] = &instance{stage: stage, layout: runtime.EnclaveLayout{Size: size}}
+	}
+	if _, err := stagingArea(1 << 46); err == nil {
+		t.Errorf("stagingArea found room for an enclave of %#x", uint64(1<<46))
+	}
+}
@@ -0,0 +1,236 @@
+package gosec
+
+/* This file replays the loading of an enclave executable to compute its
+ * measurement (MRENCLAVE) without SGX, e.g., to sign it offline.
+ * It must follow the order of EADDs performed by loadProgram.
+ */
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"fmt"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// ReadEnclave returns the default enclave executable embedded in the .encl
+// section of the binary at path.
+func ReadEnclave(path string) ([]byte, error) {
+	return ReadNamedEnclave(path, "")
+}
+
+// ReadNamedEnclave returns the executable of the enclave name embedded in
+// the binary at path, in the .encl.name section. The name of the default
+// enclave is empty.
+func ReadNamedEnclave(path, name string) ([]byte, error) {
+	p, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+
+	enclave := p.Section(enclaveSection(name))
+	if enclave == nil {
+		return nil, fmt.Errorf("binary %v does not contain an enclave section %s", path, enclaveSection(name))
+	}
+	bts, err := enclave.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove the header by seeking the magic bytes.
+	magic := []byte{0x7F, 0x45, 0x4C, 0x46}
+	var i = 0
+	for i = 0; i < len(bts)-len(magic); i++ {
+		if bytes.Compare(bts[i:i+len(magic)], magic) == 0 {
+			break
+		}
+	}
+	if i >= len(bts)-len(magic) {
+		return nil, fmt.Errorf("unable to find the start of the executable in the %s section of %v", enclaveSection(name), path)
+	}
+	return bts[i:], nil
+}
+
+// parseEnclave parses the executable encl of the enclave name, as returned
+// by ReadNamedEnclave.
+func parseEnclave(name string, encl []byte) (*elf.File, error) {
+	file, err := elf.NewFile(bytes.NewReader(encl))
+	if err != nil {
+		return nil, fmt.Errorf("malformed enclave executable in the %s section: %v", enclaveSection(name), err)
+	}
+	return file, nil
+}
+
+// enclaveSection returns the ELF section of the enclave name, see the
+// -lkenclave flag of the linker.
+func enclaveSection(name string) string {
+	if name == "" {
+		return ".encl"
+	}
+	return ".encl." + name
+}
+
+// enclaveImage is the content of the source region, page by page.
+// Missing pages are zero.
+type enclaveImage map[uintptr][]byte
+
+var zeroPage = make([]byte, PSIZE)
+
+func (img enclaveImage) page(addr uintptr) []byte {
+	if p, ok := img[addr]; ok {
+		return p
+	}
+	return zeroPage
+}
+
+func (img enclaveImage) write(addr uintptr, data []byte) {
+	for len(data) > 0 {
+		base := addr &^ (PSIZE - 1)
+		p, ok := img[base]
+		if !ok {
+			p = make([]byte, PSIZE)
+			img[base] = p
+		}
+		n := copy(p[addr-base:], data)
+		data = data[n:]
+		addr += uintptr(n)
+	}
+}
+
+// EnclavePage describes a page added to the enclave.
+type EnclavePage struct {
+	Offset   uint64             // from the base of the enclave.
+	Flags    uint64             // secinfo flags.
+	Region   string             // sections, stack, heap, membuf, tcs or ssa.
+	Extended bool               // whether the content is measured.
+	Digest   [sha256.Size]uint8 // sha256 of the content of extended pages.
+}
+
+// Measurement is the MRENCLAVE of an enclave and the pages it covers, in
+// the order they are added by the loader.
+type Measurement struct {
+	MrEnclave [SGX_HASH_SIZE]uint8
+	Base      uint64
+	Size      uint64
+	Pages     []EnclavePage
+}
+
+// MeasureEnclave computes the measurement of the enclave executable encl, as
+// extracted by ReadEnclave, without SGX.
+func MeasureEnclave(encl []byte) (*Measurement, error) {
+	return measureEnclave(encl)
+}
+
+// measureRegion performs the hashing of sgxAddRegion.
+func (m *Measurement) measureRegion(h *enclaveHash, secs *secs_t, img enclaveImage, region string, addr, siz, prot uintptr, tpe uint64) {
+	for x := addr; x < addr+siz; x += PSIZE {
+		secinfo := &isgx_secinfo{}
+		secinfo.flags = sgxSecinfoFlags(prot, tpe)
+		page := img.page(x)
+		sgxHashEadd(h, secs, secinfo, x, page)
+
+		// Keep in sync with sgxHashEadd.
+		p := EnclavePage{Offset: uint64(x) - secs.baseAddr, Flags: secinfo.flags, Region: region}
+		if secinfo.flags&SGX_SECINFO_W == 0 || secinfo.flags&SGX_SECINFO_TCS != 0 {
+			p.Extended = true
+			p.Digest = sha256.Sum256(page)
+		}
+		m.Pages = append(m.Pages, p)
+	}
+}
+
+// measureSections performs the hashing of sgxMapSections.
+func (m *Measurement) measureSections(h *enclaveHash, secs *secs_t, sections []*elf.Section, img enclaveImage) error {
+	if len(sections) == 0 {
+		return nil
+	}
+	start := uintptr(palign(uint64(sections[0].Addr), true))
+	last := sections[len(sections)-1]
+	end := uintptr(palign(uint64(last.Addr+last.Size), false))
+	var names []string
+	for _, sec := range sections {
+		names = append(names, sec.Name)
+		if sec.Type == elf.SHT_NOBITS {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			return err
+		}
+		img.write(uintptr(sec.Addr), data)
+	}
+	prot := uintptr(_PROT_READ)
+	if (sections[0].Flags & elf.SHF_WRITE) == elf.SHF_WRITE {
+		prot |= _PROT_WRITE
+	}
+	if (sections[0].Flags & elf.SHF_EXECINSTR) == elf.SHF_EXECINSTR {
+		prot |= _PROT_EXEC
+	}
+	m.measureRegion(h, secs, img, strings.Join(names, ","), start, end-start, prot, SGX_SECINFO_REG)
+	return nil
+}
+
+// measureEnclave replays loadProgram on encl and returns its measurement.
+func measureEnclave(encl []byte) (m *Measurement, err error) {
+	// The loader's helpers panic on malformed binaries.
+	defer func() {
+		if r := recover(); r != nil {
+			m, err = nil, fmt.Errorf("gosec: unable to measure the enclave: %v", r)
+		}
+	}()
+	file, err := elf.NewFile(bytes.NewReader(encl))
+	if err != nil {
+		return nil, err
+	}
+	h := sgxHashInit()
+	secs, wrap, err := sgxCreateSecs(file)
+	if err != nil {
+		return nil, err
+	}
+	sgxHashEcreate(h, secs)
+	m = &Measurement{Base: secs.baseAddr, Size: secs.size}
+
+	img := make(enclaveImage)
+	sort.Sort(SortedElfSections(file.Sections))
+	var aggreg []*elf.Section
+	for _, sec := range file.Sections {
+		if sec.Flags&elf.SHF_ALLOC != elf.SHF_ALLOC {
+			continue
+		}
+		if len(aggreg) == 0 || aggreg[len(aggreg)-1].Flags == sec.Flags {
+			aggreg = append(aggreg, sec)
+			continue
+		}
+		if err := m.measureSections(h, secs, aggreg, img); err != nil {
+			return nil, err
+		}
+		aggreg = nil
+		aggreg = append(aggreg, sec)
+	}
+	if err := m.measureSections(h, secs, aggreg, img); err != nil {
+		return nil, err
+	}
+
+	// Stacks, heap and membuf, as in sgxEaddPrealloc.
+	prot := uintptr(_PROT_READ | _PROT_WRITE)
+	for i, tcs := range wrap.tcss {
+		m.measureRegion(h, secs, img, fmt.Sprintf("stack%d", i), tcs.Stack, tcs.Ssiz, prot, SGX_SECINFO_REG)
+	}
+	m.measureRegion(h, secs, img, "heap", wrap.mhstart, wrap.mhsize, prot, SGX_SECINFO_REG)
+	m.measureRegion(h, secs, img, "membuf", wrap.membuf, wrap.membsiz, prot, SGX_SECINFO_REG)
+
+	// The TCSs, as in sgxRegisterTCSs.
+	for i := range wrap.tcss {
+		tcs := &wrap.tcss[i]
+		page := make([]byte, PSIZE)
+		sgxSetupTCS((*tcs_t)(unsafe.Pointer(&page[0])), uint64(tcs.Entry), secs, tcs)
+		img[tcs.Tcs] = page
+		m.measureRegion(h, secs, img, fmt.Sprintf("tcs%d", i), tcs.Tcs, PSIZE, prot, SGX_SECINFO_TCS)
+		m.measureRegion(h, secs, img, fmt.Sprintf("ssa%d", i), tcs.Ssa, SSA_SIZE, prot, SGX_SECINFO_REG)
+	}
+	m.MrEnclave = sha256.Sum256(h.data)
+	return m, nil
+}
@@ -0,0 +1,99 @@
+package gosec
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// SGX_ENCLAVE_PATH is the device of the sgx driver of the kernel.
+const SGX_ENCLAVE_PATH = "/dev/sgx_enclave"
+
+// sgxBackend runs the enclave with the sgx driver of the kernel, since Linux
+// 5.11. The driver requires flexible launch control, and takes no launch
+// token. Each enclave has its own open file of the device.
+type sgxBackend struct {
+	dev *os.File
+}
+
+func (b *sgxBackend) Name() string    { return backendSgx }
+func (b *sgxBackend) Simulated() bool { return false }
+
+func (b *sgxBackend) Create(in *instance, secs *secs_t) error {
+	dev, err := os.OpenFile(SGX_ENCLAVE_PATH, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	b.dev = dev
+	return sgxEcreate(b.dev, secs)
+}
+
+// AddPages adds the pages in as few ioctls as the driver allows: it may add
+// part of them and be interrupted.
+func (b *sgxBackend) AddPages(in *instance, dest, src, n, prot uintptr, tpe uint64) error {
+	if err := sgxMprotect(dest, n, prot); err != nil {
+		return err
+	}
+	secinfo := &isgx_secinfo{}
+	secinfo.flags = sgxSecinfoFlags(prot, tpe)
+	flags := uint64(SGX_PAGE_MEASURE)
+	if prot&_PROT_WRITE != 0 && tpe != SGX_SECINFO_TCS {
+		flags = 0
+	}
+	for n > 0 {
+		add := &sgx_enclave_add_pages{}
+		add.src = uint64(src)
+		add.offset = uint64(dest - in.encl.base)
+		add.length = uint64(n)
+		add.secinfo = uint64(uintptr(unsafe.Pointer(secinfo)))
+		add.flags = flags
+		_, _, ret := syscall.Syscall(syscall.SYS_IOCTL, b.dev.Fd(), uintptr(SGX_IOC_ENCLAVE_ADD_PAGES), uintptr(unsafe.Pointer(add)))
+		if ret != 0 && ret != syscall.EINTR && ret != syscall.EAGAIN {
+			return fmt.Errorf("unable to add the page at %#x: %v", dest+uintptr(add.count), ret)
+		}
+		c := uintptr(add.count)
+		dest, src, n = dest+c, src+c, n-c
+	}
+	return nil
+}
+
+// Init signs the enclave, then calls the ioctl.
+func (b *sgxBackend) Init(in *instance, secs *secs_t) error {
+	if err := sgxSignEnclave(in.hash.meta); err != nil {
+		return err
+	}
+	parm := &sgx_enclave_init_flc{}
+	parm.sigstruct = uint64(uintptr(unsafe.Pointer(&in.hash.meta.Enclave_css)))
+	p1, _, ret := syscall.Syscall(syscall.SYS_IOCTL, b.dev.Fd(), uintptr(SGX_IOC_ENCLAVE_INIT_FLC), uintptr(unsafe.Pointer(parm)))
+	if ret != 0 || p1 != 0 {
+		return fmt.Errorf("einit failed with return code %v, status %#x", ret, p1)
+	}
+	sgxSetEntry(in)
+	return nil
+}
+
+func (b *sgxBackend) Enter(in *instance, dest, src *sgx_tcs_info) error {
+	sgxEEnter(in, uint64(0), dest, src, nil)
+	return nil
+}
+
+//go:nosplit
+func (b *sgxBackend) Spawn(in *instance, req *runtime.OExitRequest) {
+	sgxEEnter(in, uint64(req.Did), &in.encl.tcss[req.Did], &in.src.tcss[req.Did], req)
+}
+
+//go:nosplit
+func (b *sgxBackend) Resume(in *instance, id uint64) {
+	sgxEResume(in, id)
+}
+
+// Teardown closes the device, and the driver destroys the enclave once it is
+// unmapped.
+func (b *sgxBackend) Teardown(in *instance) {
+	if b.dev != nil {
+		b.dev.Close()
+		b.dev = nil
+	}
+}
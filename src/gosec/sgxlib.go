@@ -2,11 +2,14 @@ package gosec
 
 import (
 	"debug/elf"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"reflect"
 	"runtime"
 	"sort"
+	"sync/atomic"
 	"syscall"
 	"unsafe"
 )
@@ -14,18 +17,17 @@ import (
 const (
 	SGX_PATH = "/dev/isgx"
 	PSIZE    = uintptr(0x1000)
-	//TODO @aghosn this must be exactly the same as in amd64/obj.go
-	ENCLMASK = 0x040000000000
-	ENCLSIZE = 0x001000000000
 
+	// The layout of the enclave is set by its manifest, see enclaveLayout.
+	// The loader maps the enclave in a staging area above MMMASK before
+	// adding it, see stagingArea.
 	MMMASK  = 0x050000000000
 	SIM_OFF = 0x08
 
 	SIM_FLAG  = 0x050000000008
 	MSGX_ADDR = 0x050000000020
 	//TLS is m0+m_tls+8
-	TLS_MSGX_OFF = (0x98 + 8)            // TODO this depends on m_tls which is bad.
-	NBTCS        = runtime.EnclaveMaxTls // how many tcs do we provide.
+	TLS_MSGX_OFF = (0x98 + 8) // TODO this depends on m_tls which is bad.
 )
 
 type SortedElfSections []*elf.Section
@@ -42,11 +44,9 @@ func (s SortedElfSections) Less(i, j int) bool {
 	return s[i].Addr < s[j].Addr
 }
 
-var (
-	sgxFd    *os.File = nil
-	enclWrap *sgx_wrapper
-	srcWrap  *sgx_wrapper
-)
+// sgxFd is the sgx device, open while an enclave is loaded with sgx, see
+// loadMu.
+var sgxFd *os.File = nil
 
 // asm_eenter calls the enclu.
 func asm_eenter(tcs, xcpt, rdi, rsi uint64)
@@ -57,25 +57,33 @@ func asm_eresume(tcs, xcpt uint64)
 // asm_exception does an eresume
 func asm_exception()
 
-func sgxLoadProgram(path string) {
-	sgxInit()
-	file, err := elf.Open(path)
-	check(err)
-	var secs *secs_t
-	secs, enclWrap = sgxCreateSecs(file)
-	enclWrap.isSim = false
-
-	// ECREATE & mmap enclave
-	sgxEcreate(secs)
+// loadProgram loads the enclave executable parsed in file with the backend of
+// in, and starts its first thread.
+func loadProgram(in *instance, file *elf.File) error {
+	b := in.backend
+	in.hash = sgxHashInit()
+	secs, enclWrap, err := sgxCreateSecs(file)
+	if err != nil {
+		return err
+	}
+	enclWrap.stage = in.stage
+	in.encl = enclWrap
+	if err := b.Create(in, secs); err != nil {
+		return err
+	}
+	sgxHashEcreate(in.hash, secs)
 
 	// Allocate the equivalent region for the eadd page.
-	srcWrap = transposeOutWrapper(enclWrap)
+	srcWrap := enclWrap.transposeOutWrapper()
+	in.src = srcWrap
 
 	src := srcWrap.base
 	prot := int(_PROT_READ | _PROT_WRITE)
-	srcptr, ret := syscall.RMmap(src, int(srcWrap.siz), prot,
+	srcptr, err := syscall.RMmap(src, int(srcWrap.siz), prot,
 		_MAP_NORESERVE|_MAP_ANON|_MAP_FIXED|_MAP_PRIVATE, -1, 0)
-	check(ret)
+	if err != nil {
+		return err
+	}
 	srcWrap.alloc = srcptr
 
 	// Check that the sections are sorted now.
@@ -92,40 +100,48 @@ func sgxLoadProgram(path string) {
 			continue
 		}
 
-		sgxMapSections(secs, aggreg, enclWrap, srcWrap)
+		if err := sgxMapSections(in, secs, aggreg); err != nil {
+			return err
+		}
 		aggreg = nil
 		aggreg = append(aggreg, sec)
 	}
-	sgxMapSections(secs, aggreg, enclWrap, srcWrap)
+	if err := sgxMapSections(in, secs, aggreg); err != nil {
+		return err
+	}
 
 	//Setup the stack arguments and Cooprt heap.
 	//This allows to make the argv part of the measurement.
 	stcs := srcWrap.defaultTcs()
-	_ = runtime.SetupEnclSysStack(stcs.Stack+stcs.Ssiz, enclWrap.mhstart)
+	_ = in.cprt.SetupEnclSysStack(stcs.Stack+stcs.Ssiz, enclWrap.mhstart)
 
 	// Mprotect and EADD stack and preallocated.
-	sgxEaddPrealloc(secs, enclWrap, srcWrap)
+	if err := sgxEaddPrealloc(in, secs); err != nil {
+		return err
+	}
 	// initialize the TCSs and Eadd their elements.
-	sgxRegisterTCSs(enclWrap, srcWrap)
+	if err := sgxRegisterTCSs(in); err != nil {
+		return err
+	}
 
-	// EINIT: first get the token, then call the ioctl.
-	sgxHashFinalize()
-	tok := sgxTokenGetAesm(secs)
-	sgxEinit(secs, &tok)
+	// Sign and initialize the enclave.
+	sgxHashFinalize(in.hash)
+	if err := b.Init(in, secs); err != nil {
+		return err
+	}
+	registerIdentity(in.cprt, in.hash.meta, secs)
+	in.hash.data = nil
 
 	//unmap the srcRegion
-	err = syscall.Munmap(srcptr)
-	check(err)
-
-	//transpstack := transposeIn(pstack)
-	fn := unsafe.Pointer(reflect.ValueOf(asm_eenter).Pointer())
-	enclWrap.entry = uintptr(fn)
-	runtime.Cooprt.Tcss = enclWrap.tcss
-	runtime.Cooprt.ExceptionHandler = uint64(reflect.ValueOf(asm_exception).Pointer())
+	if err := syscall.Munmap(srcptr); err != nil {
+		return err
+	}
+
+	in.cprt.Tcss = enclWrap.tcss
 	stcs = srcWrap.defaultTcs()
 	dtcs := enclWrap.defaultTcs()
 	stcs.Used, dtcs.Used = true, true
-	sgxEEnter(uint64(0), dtcs, stcs, nil)
+	return b.Enter(in, dtcs, stcs)
 }
 
 // palign does a page align.
@@ -145,7 +161,12 @@ func palign(addr uint64, lower bool) uint64 {
 // It goes through the elf and computes the range of addresses needed for the
 // enclave ELRANGE. That includes the heap and the system stack.
 // It should not mmap anything. This will be done later on.
-func sgxCreateSecs(file *elf.File) (*secs_t, *sgx_wrapper) {
+func sgxCreateSecs(file *elf.File) (*secs_t, *sgx_wrapper, error) {
+	layout, err := enclaveLayout(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	base, size := uint64(layout.Base), uint64(layout.Size)
 	var aggreg []*elf.Section
 	fnFilter := func(e *elf.Section) bool {
 		return e.Flags&elf.SHF_ALLOC == elf.SHF_ALLOC
@@ -155,7 +176,7 @@ func sgxCreateSecs(file *elf.File) (*secs_t, *sgx_wrapper) {
 			aggreg = append(aggreg, sec)
 		}
 	}
-	var baseAddr = uint64(ENCLMASK * 2)
+	var baseAddr = base + size
 	var endAddr = uint64(0x0)
 	for _, sec := range aggreg {
 		if sec.Addr < baseAddr {
@@ -167,16 +188,16 @@ func sgxCreateSecs(file *elf.File) (*secs_t, *sgx_wrapper) {
 	}
 	// We can create the bounds that we want for the enclave as long as it contains
 	// the values from the binary.
-	if baseAddr < ENCLMASK {
-		log.Fatalf("gosec: < binary outside of enclave region: %x\n", baseAddr)
+	if baseAddr < base {
+		return nil, nil, fmt.Errorf("binary starts outside of the enclave region: %#x", baseAddr)
 	}
 
-	if endAddr > ENCLMASK+ENCLSIZE {
-		log.Fatalf("gosec: > binary outside of enclave region: %x\n", endAddr)
+	if endAddr > base+size {
+		return nil, nil, fmt.Errorf("binary ends outside of the enclave region: %#x", endAddr)
 	}
 	secs := &secs_t{}
-	secs.baseAddr = uint64(ENCLMASK)
-	secs.size = uint64(ENCLSIZE)
+	secs.baseAddr = base
+	secs.size = size
 	secs.xfrm = 0x7
 	secs.ssaFrameSize = 1
 	secs.attributes = 0x06
@@ -184,9 +205,11 @@ func sgxCreateSecs(file *elf.File) (*secs_t, *sgx_wrapper) {
 	wrapper := &sgx_wrapper{}
 	wrapper.base = uintptr(secs.baseAddr)
 	wrapper.siz = uintptr(secs.size)
-	wrapper.tcss = make([]sgx_tcs_info, NBTCS)
-	wrapper.mtlsarr = getMtlsArr(file)
-	for i := 0; i < NBTCS; i++ {
+	wrapper.tcss = make([]sgx_tcs_info, layout.Tcs)
+	if wrapper.mtlsarr, err = getMtlsArr(file); err != nil {
+		return nil, nil, err
+	}
+	for i := range wrapper.tcss {
 		ptcs := &wrapper.tcss[i]
 		ptcs.Stack = uintptr(palign(endAddr, false)) + 2*PSIZE
 		ptcs.Ssiz = uintptr(STACK_SIZE)
@@ -199,44 +222,68 @@ func sgxCreateSecs(file *elf.File) (*secs_t, *sgx_wrapper) {
 		ptcs.Used = false
 	}
 	wrapper.mhstart = uintptr(endAddr) + TLS_MHSTART_OFF
-	wrapper.mhsize = runtime.EnclHeapSizeToAllocate()
-	wrapper.membuf = ENCLMASK + ENCLSIZE - PSIZE - MEMBUF_SIZE
+	wrapper.mhsize = layout.Heap - 1
+	wrapper.membsiz = layout.Membuf
+	wrapper.membuf = wrapper.base + wrapper.siz - PSIZE - wrapper.membsiz
 	if wrapper.membuf < wrapper.mhstart+wrapper.mhsize {
-		panic("gosec: reduce the amount of pages in membuf.")
+		return nil, nil, errors.New("reduce the amount of pages in membuf")
 	}
 	wrapper.alloc = nil
-	if wrapper.mhstart+wrapper.mhsize > ENCLMASK+ENCLSIZE {
-		log.Printf("enclave limit: %x - end: %x\n", ENCLMASK+ENCLSIZE, wrapper.mhstart+wrapper.mhsize)
-		panic("gosec: Required size is out of enclave limits.")
+	if wrapper.mhstart+wrapper.mhsize > wrapper.base+wrapper.siz {
+		return nil, nil, fmt.Errorf("the heap ends at %#x, out of the enclave limit %#x", wrapper.mhstart+wrapper.mhsize, wrapper.base+wrapper.siz)
 	}
 	wrapper.secs = secs
-	return secs, wrapper
+	return secs, wrapper, nil
 }
 
-//sgxTCSPrealloc eadds all preallocated memory (stacks, heap and membuf)
-func sgxEaddPrealloc(secs *secs_t, dest, src *sgx_wrapper) {
+// sgxTCSPrealloc eadds all preallocated memory (stacks, heap and membuf)
+func sgxEaddPrealloc(in *instance, secs *secs_t) error {
+	dest, src := in.encl, in.src
 	prot := uintptr(_PROT_READ | _PROT_WRITE)
 	for i, dtcs := range dest.tcss {
 		stcs := &src.tcss[i]
-		sgxAddRegion(secs, dtcs.Stack, stcs.Stack, dtcs.Ssiz, prot, SGX_SECINFO_REG)
+		if err := sgxAddRegion(in, secs, dtcs.Stack, stcs.Stack, dtcs.Ssiz, prot, SGX_SECINFO_REG); err != nil {
+			return err
+		}
 	}
 	//eadd heap and membuf
-	sgxAddRegion(secs, dest.mhstart, src.mhstart, dest.mhsize, prot, SGX_SECINFO_REG)
-	sgxAddRegion(secs, dest.membuf, src.membuf, MEMBUF_SIZE, prot, SGX_SECINFO_REG)
+	if err := sgxAddRegion(in, secs, dest.mhstart, src.mhstart, dest.mhsize, prot, SGX_SECINFO_REG); err != nil {
+		return err
+	}
+	return sgxAddRegion(in, secs, dest.membuf, src.membuf, dest.membsiz, prot, SGX_SECINFO_REG)
 }
 
-func sgxRegisterTCSs(dest, src *sgx_wrapper) {
+func sgxRegisterTCSs(in *instance) error {
+	dest, src := in.encl, in.src
 	if dest.secs == nil || dest.tcss == nil || len(dest.tcss) != len(src.tcss) {
 		panic("Uninitialized parameters.")
 	}
 
 	for i := range dest.tcss {
-		sgxInitEaddTCS(uint64(dest.tcss[i].Entry), dest.secs, &dest.tcss[i], &src.tcss[i])
+		if err := sgxInitEaddTCS(in, uint64(dest.tcss[i].Entry), dest.secs, &dest.tcss[i], &src.tcss[i]); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func sgxInitEaddTCS(entry uint64, secs *secs_t, dest, src *sgx_tcs_info) {
-	tcs := (*tcs_t)(unsafe.Pointer(src.Tcs))
+func sgxInitEaddTCS(in *instance, entry uint64, secs *secs_t, dest, src *sgx_tcs_info) error {
+	sgxSetupTCS((*tcs_t)(unsafe.Pointer(src.Tcs)), entry, secs, dest)
+
+	// Add the TCS
+	if err := sgxAddRegion(in, secs, dest.Tcs, src.Tcs, PSIZE, _PROT_READ|_PROT_WRITE,
+		SGX_SECINFO_TCS); err != nil {
+		return err
+	}
+
+	// Add the SSA and FS.
+	// TLS and MSGX are already mapped in BSS.
+	return sgxAddRegion(in, secs, dest.Ssa, src.Ssa,
+		SSA_SIZE, _PROT_READ|_PROT_WRITE, SGX_SECINFO_REG)
+}
+
+// sgxSetupTCS fills the tcs for the thread dest.
+func sgxSetupTCS(tcs *tcs_t, entry uint64, secs *secs_t, dest *sgx_tcs_info) {
 	tcs.reserved1 = uint64(0)
 	tcs.flags = uint64(0)
 	tcs.ossa = uint64(dest.Ssa) - secs.baseAddr
@@ -252,57 +299,52 @@ func sgxInitEaddTCS(entry uint64, secs *secs_t, dest, src *sgx_tcs_info) {
 	for i := range tcs.reserved3 {
 		tcs.reserved3[i] = uint64(0)
 	}
-
-	// Add the TCS
-	sgxAddRegion(secs, dest.Tcs, src.Tcs, PSIZE, _PROT_READ|_PROT_WRITE,
-		SGX_SECINFO_TCS)
-
-	// Add the SSA and FS.
-	sgxAddRegion(secs, dest.Ssa, src.Ssa,
-		SSA_SIZE, _PROT_READ|_PROT_WRITE, SGX_SECINFO_REG)
-
-	// TLS and MSGX are already mapped in BSS.
 }
 
-func sgxAddRegion(secs *secs_t, addr, src, siz, prot uintptr, tpe uint64) {
-	// First do the mprotect.
-	_, _, ret := syscall.Syscall(syscall.SYS_MPROTECT, addr, siz, prot)
-	if ret != 0 {
-		log.Println("gosec: sgxAddRegion mprotect failed ", ret)
-		panic("stopping execution.")
-	}
+// sgxAddRegion measures the siz bytes at src in the staging area, and adds
+// them at addr to the enclave of in.
+func sgxAddRegion(in *instance, secs *secs_t, addr, src, siz, prot uintptr, tpe uint64) error {
+	siz = uintptr(palign(uint64(siz), false))
 	for x, y := addr, src; x < addr+siz; x, y = x+PSIZE, y+PSIZE {
-		sgxEadd(secs, x, y, prot, tpe)
+		secinfo := &isgx_secinfo{}
+		secinfo.flags = sgxSecinfoFlags(prot, tpe)
+		sgxHashEadd(in.hash, secs, secinfo, x, (*[PSIZE]byte)(unsafe.Pointer(y))[:])
 	}
+	return in.backend.AddPages(in, addr, src, siz, prot, tpe)
 }
 
-func transposeOut(addr uintptr) uintptr {
-	if addr < ENCLMASK || addr > ENCLMASK+ENCLSIZE {
+// transposeOut returns the address in the staging area of the address addr
+// of the enclave s.
+func (s *sgx_wrapper) transposeOut(addr uintptr) uintptr {
+	if addr < s.base || addr > s.base+s.siz {
 		log.Fatalln("gosec: transpose out invalid address: ", addr)
 	}
-	return (addr - ENCLMASK + MMMASK)
+	return (addr - s.base + s.stage)
 }
 
-func transposeIn(addr uintptr) uintptr {
-	if addr < MMMASK || addr > MMMASK+ENCLSIZE {
+// transposeIn returns the address in the enclave s of the address addr of
+// its staging area.
+func (s *sgx_wrapper) transposeIn(addr uintptr) uintptr {
+	if addr < s.stage || addr > s.stage+s.siz {
 		log.Fatalln("gosec: transpose in invalid address: ", addr)
 	}
-	return (addr - MMMASK + ENCLMASK)
+	return (addr - s.stage + s.base)
 }
 
-func sgxMapSections(sgxsec *secs_t, secs []*elf.Section, wrap, srcRegion *sgx_wrapper) {
+func sgxMapSections(in *instance, sgxsec *secs_t, secs []*elf.Section) error {
+	wrap, srcRegion := in.encl, in.src
 	if len(secs) == 0 {
-		return
+		return nil
 	}
 
 	start := uintptr(palign(uint64(secs[0].Addr), true))
 	end := uintptr(palign(uint64(secs[len(secs)-1].Addr+secs[len(secs)-1].Size), false))
 	size := int(end - start)
 	if start >= end {
-		log.Fatalf("Error, sections are not ordered: %#x - %#x", start, end)
+		return fmt.Errorf("sections are not ordered: %#x - %#x", start, end)
 	}
 	if start < wrap.base || end > wrap.base+wrap.siz {
-		panic("gosec: section is outside of the enclave region.")
+		return fmt.Errorf("section at %#x is outside of the enclave region", start)
 	}
 
 	for _, sec := range secs {
@@ -310,7 +352,9 @@ func sgxMapSections(sgxsec *secs_t, secs []*elf.Section, wrap, srcRegion *sgx_wr
 			continue
 		}
 		data, err := sec.Data()
-		check(err)
+		if err != nil {
+			return err
+		}
 		offset := int(sec.Addr - uint64(wrap.base))
 		for i := range data {
 			srcRegion.alloc[offset+i] = data[i]
@@ -325,46 +369,53 @@ func sgxMapSections(sgxsec *secs_t, secs []*elf.Section, wrap, srcRegion *sgx_wr
 		prot |= _PROT_EXEC
 	}
 
-	sgxAddRegion(sgxsec, start, transposeOut(start), uintptr(size), uintptr(prot), SGX_SECINFO_REG)
+	return sgxAddRegion(in, sgxsec, start, wrap.transposeOut(start), uintptr(size), uintptr(prot), SGX_SECINFO_REG)
 }
 
-func sgxInit() int {
+func sgxInit() error {
 	if sgxFd != nil {
-		return 0
+		return nil
 	}
 	var err error
-	sgxFd, err = os.OpenFile(SGX_PATH, os.O_RDWR, 0)
-	check(err)
-	// Initialize the signature.
-	sgxHashInit()
-	return 0
+	if sgxFd, err = os.OpenFile(SGX_PATH, os.O_RDWR, 0); err != nil {
+		sgxFd = nil
+		return err
+	}
+	return nil
 }
 
-// sgxEcreate calls the IOCTL to create the enclave.
+// sgxEcreate calls the IOCTL to create the enclave, the same for both drivers.
 // It first performs an mmap of the entire region that we use for the enclave.
-func sgxEcreate(secs *secs_t) {
+func sgxEcreate(dev *os.File, secs *secs_t) error {
 	prot := int32(_PROT_NONE)
 	mprot := int32(_MAP_SHARED | _MAP_FIXED)
-	fd := int32(sgxFd.Fd())
+	fd := int32(dev.Fd())
 	addr := uintptr(secs.baseAddr)
 	ptr, err := runtime.RMmap(unsafe.Pointer(addr), uintptr(secs.size), prot, mprot, fd, 0)
 	if err != 0 || addr != uintptr(ptr) {
-		log.Fatalln("gosec: unable to mmap the enclave: ", err)
+		return fmt.Errorf("unable to mmap the enclave: %v", syscall.Errno(err))
 	}
 
 	parms := &sgx_enclave_create{}
 	parms.src = uint64(uintptr(unsafe.Pointer(secs)))
 	ptr2 := uintptr(unsafe.Pointer(parms))
-	_, _, ret := syscall.Syscall(syscall.SYS_IOCTL, uintptr(sgxFd.Fd()), uintptr(SGX_IOC_ENCLAVE_CREATE), ptr2)
+	_, _, ret := syscall.Syscall(syscall.SYS_IOCTL, dev.Fd(), uintptr(SGX_IOC_ENCLAVE_CREATE), ptr2)
 	if ret != 0 {
-		log.Println("The secs: ", secs)
-		log.Fatalln("Failed in call to ecreate: ", ret)
+		return fmt.Errorf("ecreate: %v", ret)
 	}
+	return nil
+}
 
-	sgxHashEcreate(secs)
+// sgxMprotect sets the protection of the pages of the enclave that are added.
+func sgxMprotect(addr, siz, prot uintptr) error {
+	_, _, ret := syscall.Syscall(syscall.SYS_MPROTECT, addr, siz, prot)
+	if ret != 0 {
+		return fmt.Errorf("mprotect of the region at %#x: %v", addr, ret)
+	}
+	return nil
 }
 
-func sgxEadd(secs *secs_t, daddr, oaddr, prot uintptr, tpe uint64) {
+func sgxEadd(daddr, oaddr, prot uintptr, tpe uint64) error {
 	eadd := &sgx_enclave_add_page{}
 	eadd.addr = uint64(daddr)
 	eadd.src = uint64(uintptr(oaddr))
@@ -374,33 +425,35 @@ func sgxEadd(secs *secs_t, daddr, oaddr, prot uintptr, tpe uint64) {
 	}
 
 	secinfo := &isgx_secinfo{}
-	secinfo.flags = tpe
-	if prot&_PROT_EXEC != 0 {
-		secinfo.flags |= SGX_SECINFO_X
-	}
-	if prot&_PROT_READ != 0 {
-		secinfo.flags |= SGX_SECINFO_R
-	}
-	if prot&_PROT_WRITE != 0 {
-		secinfo.flags |= SGX_SECINFO_W
+	secinfo.flags = sgxSecinfoFlags(prot, tpe)
+	eadd.secinfo = uint64(uintptr(unsafe.Pointer(secinfo)))
+	_, _, ret := syscall.Syscall(syscall.SYS_IOCTL, uintptr(sgxFd.Fd()), uintptr(SGX_IOC_ENCLAVE_ADD_PAGE), uintptr(unsafe.Pointer(eadd)))
+	if ret != 0 {
+		return fmt.Errorf("unable to add the page at %#x: %v", daddr, ret)
 	}
+	return nil
+}
 
+// sgxSecinfoFlags returns the secinfo flags of a page of type tpe.
+func sgxSecinfoFlags(prot uintptr, tpe uint64) uint64 {
 	// Special case for the TCS, no protections.
 	if tpe == SGX_SECINFO_TCS {
-		secinfo.flags = SGX_SECINFO_TCS
+		return SGX_SECINFO_TCS
 	}
-	eadd.secinfo = uint64(uintptr(unsafe.Pointer(secinfo)))
-	_, _, ret := syscall.Syscall(syscall.SYS_IOCTL, uintptr(sgxFd.Fd()), uintptr(SGX_IOC_ENCLAVE_ADD_PAGE), uintptr(unsafe.Pointer(eadd)))
-	if ret != 0 {
-		log.Println("Unable to add a page: ", daddr)
-		panic("Stopping execution before adding a page.")
+	flags := tpe
+	if prot&_PROT_EXEC != 0 {
+		flags |= SGX_SECINFO_X
 	}
-
-	// Add it to the hash.
-	sgxHashEadd(secs, secinfo, daddr)
+	if prot&_PROT_READ != 0 {
+		flags |= SGX_SECINFO_R
+	}
+	if prot&_PROT_WRITE != 0 {
+		flags |= SGX_SECINFO_W
+	}
+	return flags
 }
 
-func sgxEinit(secs *secs_t, tok *TokenGob) {
+func sgxEinit(secs *secs_t, tok *TokenGob) error {
 	parm := &sgx_enclave_init{}
 	parm.addr = secs.baseAddr
 
@@ -417,15 +470,99 @@ func sgxEinit(secs *secs_t, tok *TokenGob) {
 	p1, _, ret := syscall.Syscall(syscall.SYS_IOCTL, uintptr(sgxFd.Fd()), uintptr(SGX_IOC_ENCLAVE_INIT), ptr)
 
 	if ret != 0 || p1 != 0 {
-		log.Println("gosec: sgxEinit failed with return code ", ret, "-- p1: ", p1)
-		panic("Stopping the execution before performing einit.")
+		return fmt.Errorf("einit failed with return code %v, status %#x", ret, p1)
+	}
+	return nil
+}
+
+// isgxBackend runs the enclave with the out-of-tree isgx driver, which needs
+// a launch token from aesmd. The enclaves share sgxFd.
+type isgxBackend struct{}
+
+func (b *isgxBackend) Name() string    { return backendIsgx }
+func (b *isgxBackend) Simulated() bool { return false }
+
+func (b *isgxBackend) Create(in *instance, secs *secs_t) error {
+	if err := sgxInit(); err != nil {
+		return err
 	}
+	return sgxEcreate(sgxFd, secs)
+}
+
+func (b *isgxBackend) AddPages(in *instance, dest, src, n, prot uintptr, tpe uint64) error {
+	if err := sgxMprotect(dest, n, prot); err != nil {
+		return err
+	}
+	for x, y := dest, src; x < dest+n; x, y = x+PSIZE, y+PSIZE {
+		if err := sgxEadd(x, y, prot, tpe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Init gets the launch token, then calls the ioctl.
+func (b *isgxBackend) Init(in *instance, secs *secs_t) error {
+	if err := sgxSignEnclave(in.hash.meta); err != nil {
+		return err
+	}
+	tok, err := sgxTokenGetAesm(in.hash.meta, secs)
+	if err != nil {
+		return fmt.Errorf("unable to get a launch token: %v", err)
+	}
+	if err := sgxEinit(secs, &tok); err != nil {
+		return err
+	}
+	sgxSetEntry(in)
+	return nil
+}
+
+func (b *isgxBackend) Enter(in *instance, dest, src *sgx_tcs_info) error {
+	sgxEEnter(in, uint64(0), dest, src, nil)
+	return nil
+}
+
+//go:nosplit
+func (b *isgxBackend) Spawn(in *instance, req *runtime.OExitRequest) {
+	sgxEEnter(in, uint64(req.Did), &in.encl.tcss[req.Did], &in.src.tcss[req.Did], req)
+}
+
+//go:nosplit
+func (b *isgxBackend) Resume(in *instance, id uint64) {
+	sgxEResume(in, id)
+}
+
+// Teardown closes sgxFd with the last enclave that uses it.
+func (b *isgxBackend) Teardown(in *instance) {
+	for _, o := range loaded {
+		if o != nil && o.backend.Name() == backendIsgx {
+			return
+		}
+	}
+	if sgxFd != nil {
+		sgxFd.Close()
+		sgxFd = nil
+	}
+}
+
+// sgxSetEntry makes the threads of the enclave of in enter it with eenter, and
+// its exceptions eresume it.
+func sgxSetEntry(in *instance) {
+	in.encl.entry = reflect.ValueOf(asm_eenter).Pointer()
+	in.cprt.ExceptionHandler = uint64(reflect.ValueOf(asm_exception).Pointer())
+}
+
+//go:nosplit
+func sgxEResume(in *instance, id uint64) {
+	tcs := in.cprt.Tcss[id]
+	xcpt := in.cprt.ExceptionHandler
+	asm_eresume(uint64(tcs.Tcs), xcpt)
 }
 
-//TODO @aghosn, this is bad, we should use the address from source,
+// TODO @aghosn, this is bad, we should use the address from source,
 // we should also change the way the assembly works (maybe later).
 //go:nosplit
-func sgxEEnter(id uint64, dest, src *sgx_tcs_info, req *runtime.OExitRequest) {
+func sgxEEnter(in *instance, id uint64, dest, src *sgx_tcs_info, req *runtime.OExitRequest) {
 	prot := int32(_PROT_READ | _PROT_WRITE)
 	manon := int32(_MAP_ANON | _MAP_FIXED | _MAP_PRIVATE)
 
@@ -470,7 +607,7 @@ func sgxEEnter(id uint64, dest, src *sgx_tcs_info, req *runtime.OExitRequest) {
 
 	// isSim flag - 40 RSP
 	simFlag := uint64(0)
-	if enclWrap.isSim {
+	if in.backend.Simulated() {
 		simFlag = uint64(1)
 	}
 	swsptr -= unsafe.Sizeof(uint64(0))
@@ -493,7 +630,7 @@ func sgxEEnter(id uint64, dest, src *sgx_tcs_info, req *runtime.OExitRequest) {
 	*ptrs = uint64(uintptr(unsafe.Pointer(&dest.Rdi)))
 
 	// Xception - 8 RSP
-	xcpt := runtime.Cooprt.ExceptionHandler
+	xcpt := in.cprt.ExceptionHandler
 	swsptr -= unsafe.Sizeof(uint64(0))
 	ptrs = (*uint64)(unsafe.Pointer(swsptr))
 	*ptrs = xcpt
@@ -503,7 +640,8 @@ func sgxEEnter(id uint64, dest, src *sgx_tcs_info, req *runtime.OExitRequest) {
 	ptrs = (*uint64)(unsafe.Pointer(swsptr))
 	*ptrs = uint64(dest.Tcs)
 
-	runtime.StartEnclaveOSThread(swsptr, unsafe.Pointer(enclWrap.entry))
+	atomic.StoreUint32(&in.threads.alive[id], 1)
+	runtime.StartEnclaveOSThread(swsptr, unsafe.Pointer(in.encl.entry))
 }
 
 func testEntry() {
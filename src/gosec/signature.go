@@ -1,19 +1,14 @@
 package gosec
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
-	"encoding/gob"
-	"io/ioutil"
 	"os"
-	"os/exec"
 	"time"
 	"unsafe"
 )
 
 const (
-	target           = "/tmp/gobdump.dat"
 	_enclaveCssSize  = uintptr(1808)
 	_miscselectSize  = uintptr(4)
 	_attribSize      = uintptr(16)
@@ -21,8 +16,13 @@ const (
 	_tcs_t_size      = uintptr(0x1000)
 )
 
-var data2hash []byte = nil
-var meta *metadata_t
+// enclaveHash is the measurement of an enclave being loaded, or measured by
+// MeasureEnclave: the data of its ECREATE, EADD and EEXTEND, which MRENCLAVE
+// hashes, and its metadata, with its SIGSTRUCT. Each load has its own.
+type enclaveHash struct {
+	data []byte
+	meta *metadata_t
+}
 
 func checkStructSize() {
 	if unsafe.Sizeof(enclave_css_t{}) != _enclaveCssSize {
@@ -46,10 +46,9 @@ func checkStructSize() {
 	}
 }
 
-func sgxHashInit() {
+func sgxHashInit() *enclaveHash {
 	checkStructSize()
-	data2hash = make([]byte, 0)
-	meta = &metadata_t{}
+	meta := &metadata_t{}
 	setHeader(&meta.Enclave_css)
 	setBody(&meta.Enclave_css)
 	meta.Magic_num = METADATA_MAGIC
@@ -58,7 +57,7 @@ func sgxHashInit() {
 	meta.Max_save_buffer_size = 2632
 	meta.Desired_misc_select = 0
 	meta.Tcs_min_pool = 1
-
+	return &enclaveHash{meta: meta}
 }
 
 func setHeader(e *enclave_css_t) {
@@ -84,7 +83,8 @@ func setBody(e *enclave_css_t) {
 	e.Isv_svn = 42
 }
 
-func sgxHashEcreate(secs *secs_t) {
+func sgxHashEcreate(h *enclaveHash, secs *secs_t) {
+	meta := h.meta
 	meta.Enclave_size = secs.size
 	meta.Attributes.Flags = secs.attributes
 	meta.Attributes.Xfrm = secs.xfrm
@@ -117,10 +117,11 @@ func sgxHashEcreate(secs *secs_t) {
 	}
 
 	// Append it to the hash.
-	data2hash = append(data2hash, tmp...)
+	h.data = append(h.data, tmp...)
 }
 
-func sgxHashEadd(secs *secs_t, secinfo *isgx_secinfo, daddr uintptr) {
+// sgxHashEadd extends the measurement with an EADD of page at daddr.
+func sgxHashEadd(h *enclaveHash, secs *secs_t, secinfo *isgx_secinfo, daddr uintptr, page []byte) {
 	if daddr < uintptr(secs.baseAddr) {
 		panic("gosec: invalid daddr out of range.")
 	}
@@ -151,15 +152,15 @@ func sgxHashEadd(secs *secs_t, secinfo *isgx_secinfo, daddr uintptr) {
 		tmp[i] = *val
 	}
 	// Add it to the signature.
-	data2hash = append(data2hash, tmp...)
+	h.data = append(h.data, tmp...)
 
 	if secinfo.flags&SGX_SECINFO_W == 0 || secinfo.flags&SGX_SECINFO_TCS != 0 {
-		sgxHashEExtendRegion(secs, daddr)
+		sgxHashEExtendRegion(h, secs, daddr, page)
 	}
 
 }
 
-func sgxHashEExtend(secs *secs_t, daddr uintptr) {
+func sgxHashEExtend(h *enclaveHash, secs *secs_t, daddr uintptr, chunk []byte) {
 	if daddr < uintptr(secs.baseAddr) || daddr > uintptr(secs.baseAddr)+uintptr(secs.size) {
 		panic("gosec: invalid daddr out of range.")
 	}
@@ -181,35 +182,28 @@ func sgxHashEExtend(secs *secs_t, daddr uintptr) {
 
 	// TODO 48 0 bytes.
 	offset += 48
-	base := transposeOut(daddr)
-	for i := uintptr(0); i < uintptr(256); i++ {
-		val := (*byte)(unsafe.Pointer(base + i))
-		tmp[int(i)+offset] = *val
-	}
-	data2hash = append(data2hash, tmp...)
+	copy(tmp[offset:], chunk[:256])
+	h.data = append(h.data, tmp...)
 }
 
 // Adds a full page to the eextend
-func sgxHashEExtendRegion(secs *secs_t, daddr uintptr) {
+func sgxHashEExtendRegion(h *enclaveHash, secs *secs_t, daddr uintptr, page []byte) {
 	for i := uintptr(0); i < PSIZE; i += uintptr(256) {
-		sgxHashEExtend(secs, daddr+i)
+		sgxHashEExtend(h, secs, daddr+i, page[i:])
 	}
 }
 
-func sgxHashFinalize() {
-	sig := sha256.Sum256(data2hash)
+func sgxHashFinalize(h *enclaveHash) {
+	sig := sha256.Sum256(h.data)
 	for i := 0; i < SGX_HASH_SIZE; i++ {
-		meta.Enclave_css.Enclave_hash.M[i] = sig[i]
+		h.meta.Enclave_css.Enclave_hash.M[i] = sig[i]
 	}
-
-	//Do a dump of the measurement here.
-	err := ioutil.WriteFile("/tmp/gosec_measurement.dat", data2hash, 0644)
-	check(err)
 }
 
-func sgxTokenGetRequest(secs *secs_t) *LaunchTokenRequest {
+func sgxTokenGetRequest(meta *metadata_t, secs *secs_t) *LaunchTokenRequest {
 	tokenreq := &LaunchTokenRequest{}
-	tokenreq.MrSigner = []byte("trying") // key modulus.
+	// aesmd expects the signer's key modulus and derives MRSIGNER itself.
+	tokenreq.MrSigner = meta.Enclave_css.Modulus[:]
 	tokenreq.MrEnclave = meta.Enclave_css.Enclave_hash.M[:]
 
 	seattrib := make([]byte, 0)
@@ -226,35 +220,15 @@ func sgxTokenGetRequest(secs *secs_t) *LaunchTokenRequest {
 	return tokenreq
 }
 
-func sgxTokenGetAesm(secs *secs_t) TokenGob {
-	request := sgxTokenGetRequest(secs)
-
-	f, err := os.Create("/tmp/gobdump_meta.dat")
-	check(err)
-
-	enc := gob.NewEncoder(f)
-	err = enc.Encode(meta)
-	check(err)
-
-	f2, err := os.Create("/tmp/gobdump_req.dat")
-	check(err)
-	enc = gob.NewEncoder(f2)
-	err = enc.Encode(request)
-	check(err)
-
-	cmd := exec.Command("serializer", "")
-	err = cmd.Run()
-	check(err)
-
-	// Read the token.
-	b, err := ioutil.ReadFile("/tmp/go_enclave.token")
-	check(err)
-
-	dec := gob.NewDecoder(bytes.NewReader(b))
-	var token TokenGob
-	err = dec.Decode(&token)
-	check(err)
-	return token
+// sgxTokenGetAesm requests the launch token from aesmd. The socket can be
+// overridden with the GOSEC_AESM_SOCKET environment variable.
+func sgxTokenGetAesm(meta *metadata_t, secs *secs_t) (TokenGob, error) {
+	client := &AesmClient{Path: os.Getenv("GOSEC_AESM_SOCKET")}
+	tok, err := client.GetLaunchToken(sgxTokenGetRequest(meta, secs))
+	if err != nil {
+		return TokenGob{}, err
+	}
+	return TokenGob{Token: tok, Meta: *meta}, nil
 }
 
 func memcpy_s(dst, src []byte, off, s int) {
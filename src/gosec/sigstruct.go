@@ -0,0 +1,393 @@
+package gosec
+
+/* This file implements the signature of enclaves (SIGSTRUCT, ref 38.13).
+ * The header and body of the enclave_css_t are signed with RSA-3072, public
+ * exponent 3, PKCS#1 v1.5 and SHA-256. Big numbers are stored little endian.
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+)
+
+const (
+	// SigStructSize is the size of an encoded SIGSTRUCT.
+	SigStructSize = int(_enclaveCssSize)
+	// SigningDataSize is the size of the data covered by the signature.
+	SigningDataSize = _cssHeaderSize + _cssBodySize
+	// SigStructSuffix is appended to a binary's path to find its signature.
+	SigStructSuffix = ".sigstruct"
+
+	_cssHeaderSize = 128
+	_cssBodyOffset = 900
+	_cssBodySize   = 128
+	_sigExponent   = 3
+)
+
+// SigStruct is the signature of an enclave, checked by EINIT.
+type SigStruct struct {
+	css enclave_css_t
+}
+
+// NewSigStruct returns the unsigned SIGSTRUCT of the enclave executable encl,
+// as extracted by ReadEnclave.
+func NewSigStruct(encl []byte) (*SigStruct, error) {
+	m, err := measureEnclave(encl)
+	if err != nil {
+		return nil, err
+	}
+	s := &SigStruct{}
+	setHeader(&s.css)
+	setBody(&s.css)
+	s.css.Enclave_hash.M = m.MrEnclave
+	return s, nil
+}
+
+// ParseSigStruct decodes an encoded SIGSTRUCT.
+func ParseSigStruct(b []byte) (*SigStruct, error) {
+	if len(b) != SigStructSize {
+		return nil, errors.New("gosec: invalid sigstruct size")
+	}
+	s := &SigStruct{}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &s.css); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Bytes returns the encoding of the SIGSTRUCT, as expected by EINIT.
+func (s *SigStruct) Bytes() []byte {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, &s.css); err != nil {
+		panic(err.Error())
+	}
+	return buf.Bytes()
+}
+
+// MrEnclave returns the measurement of the signed enclave.
+func (s *SigStruct) MrEnclave() [SGX_HASH_SIZE]uint8 {
+	return s.css.Enclave_hash.M
+}
+
+// MrSigner returns the identity of the signer, the hash of the modulus.
+func (s *SigStruct) MrSigner() [SGX_HASH_SIZE]uint8 {
+	return sha256.Sum256(s.css.Modulus[:])
+}
+
+// PublicKey returns the key of the signer.
+func (s *SigStruct) PublicKey() *rsa.PublicKey {
+	return &rsa.PublicKey{
+		N: leToInt(s.css.Modulus[:]),
+		E: int(binary.LittleEndian.Uint32(s.css.Exponent[:])),
+	}
+}
+
+// SigningData returns the header and body of the SIGSTRUCT, i.e., the data
+// that is signed. It can be signed externally, e.g., by an HSM, and the
+// signature imported with SetSignature.
+func (s *SigStruct) SigningData() []byte {
+	b := s.Bytes()
+	data := make([]byte, 0, SigningDataSize)
+	data = append(data, b[:_cssHeaderSize]...)
+	return append(data, b[_cssBodyOffset:_cssBodyOffset+_cssBodySize]...)
+}
+
+// SetSigningData replaces the header and body with data, as previously
+// returned by SigningData for the same enclave.
+func (s *SigStruct) SetSigningData(data []byte) error {
+	if len(data) != SigningDataSize {
+		return errors.New("gosec: invalid signing data size")
+	}
+	b := s.Bytes()
+	copy(b[:_cssHeaderSize], data[:_cssHeaderSize])
+	copy(b[_cssBodyOffset:_cssBodyOffset+_cssBodySize], data[_cssHeaderSize:])
+	n, err := ParseSigStruct(b)
+	if err != nil {
+		return err
+	}
+	if n.css.Enclave_hash != s.css.Enclave_hash {
+		return errors.New("gosec: the signing data is for another enclave")
+	}
+	*s = *n
+	return nil
+}
+
+// Sign signs the SIGSTRUCT with key, which must be an RSA-3072 key with
+// public exponent 3.
+func (s *SigStruct) Sign(key *rsa.PrivateKey) error {
+	if err := checkSigningKey(&key.PublicKey); err != nil {
+		return err
+	}
+	hashed := sha256.Sum256(s.SigningData())
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+	return s.SetSignature(&key.PublicKey, sig)
+}
+
+// SetSignature checks the big endian PKCS#1 v1.5 signature sig of the
+// signing data with pub, and stores it in the SIGSTRUCT.
+func (s *SigStruct) SetSignature(pub *rsa.PublicKey, sig []byte) error {
+	if err := checkSigningKey(pub); err != nil {
+		return err
+	}
+	hashed := sha256.Sum256(s.SigningData())
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], sig); err != nil {
+		return errors.New("gosec: invalid signature for the sigstruct")
+	}
+	sint := new(big.Int).SetBytes(sig)
+	q1, q2 := sigQs(sint, pub.N)
+	intToLe(s.css.Modulus[:], pub.N)
+	binary.LittleEndian.PutUint32(s.css.Exponent[:], uint32(pub.E))
+	intToLe(s.css.Signature[:], sint)
+	intToLe(s.css.Q1[:], q1)
+	intToLe(s.css.Q2[:], q2)
+	return nil
+}
+
+// Verify checks the signature of the SIGSTRUCT the way EINIT does.
+func (s *SigStruct) Verify() error {
+	pub := s.PublicKey()
+	if err := checkSigningKey(pub); err != nil {
+		return err
+	}
+	sint := leToInt(s.css.Signature[:])
+	sig := make([]byte, SE_KEY_SIZE)
+	copy(sig[SE_KEY_SIZE-len(sint.Bytes()):], sint.Bytes())
+	hashed := sha256.Sum256(s.SigningData())
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], sig); err != nil {
+		return errors.New("gosec: invalid sigstruct signature")
+	}
+	q1, q2 := sigQs(sint, pub.N)
+	if q1.Cmp(leToInt(s.css.Q1[:])) != 0 || q2.Cmp(leToInt(s.css.Q2[:])) != 0 {
+		return errors.New("gosec: invalid sigstruct q1 or q2")
+	}
+	return nil
+}
+
+// GenerateSigningKey generates an RSA-3072 key with public exponent 3.
+func GenerateSigningKey(random io.Reader) (*rsa.PrivateKey, error) {
+	for {
+		p, err := sigPrime(random)
+		if err != nil {
+			return nil, err
+		}
+		q, err := sigPrime(random)
+		if err != nil {
+			return nil, err
+		}
+		n := new(big.Int).Mul(p, q)
+		if p.Cmp(q) == 0 || n.BitLen() != SE_KEY_SIZE*8 {
+			continue
+		}
+		one := big.NewInt(1)
+		phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: n, E: _sigExponent},
+			D:         new(big.Int).ModInverse(big.NewInt(_sigExponent), phi),
+			Primes:    []*big.Int{p, q},
+		}
+		if err := key.Validate(); err != nil {
+			continue
+		}
+		key.Precompute()
+		return key, nil
+	}
+}
+
+// sigPrime returns a prime p for a signing key, i.e., such that p-1 is
+// coprime with the exponent.
+func sigPrime(random io.Reader) (*big.Int, error) {
+	three := big.NewInt(_sigExponent)
+	for {
+		p, err := rand.Prime(random, SE_KEY_SIZE*4)
+		if err != nil {
+			return nil, err
+		}
+		if new(big.Int).Mod(p, three).Int64() == 2 {
+			return p, nil
+		}
+	}
+}
+
+func checkSigningKey(pub *rsa.PublicKey) error {
+	if pub.N == nil || pub.N.BitLen() != SE_KEY_SIZE*8 {
+		return errors.New("gosec: the signing key must be 3072 bits long")
+	}
+	if pub.E != _sigExponent {
+		return errors.New("gosec: the signing key must have a public exponent of 3")
+	}
+	return nil
+}
+
+// sigQs computes the values used by EINIT to check the signature:
+// q1 = floor(s^2 / n) and q2 = floor((s^3 - q1 * s * n) / n).
+func sigQs(s, n *big.Int) (*big.Int, *big.Int) {
+	s2 := new(big.Int).Mul(s, s)
+	q1 := new(big.Int).Div(s2, n)
+	s3 := new(big.Int).Mul(s2, s)
+	t := new(big.Int).Mul(q1, s)
+	t.Mul(t, n)
+	q2 := new(big.Int).Sub(s3, t)
+	q2.Div(q2, n)
+	return q1, q2
+}
+
+// leToInt decodes a little endian integer.
+func leToInt(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+// intToLe encodes v in dst as a little endian integer.
+func intToLe(dst []byte, v *big.Int) {
+	be := v.Bytes()
+	if len(be) > len(dst) {
+		panic("gosec: integer does not fit in the sigstruct.")
+	}
+	for i := range dst {
+		dst[i] = 0
+	}
+	for i := range be {
+		dst[i] = be[len(be)-1-i]
+	}
+}
+
+// sigStructPath is where the loader finds the signature of the enclave.
+func sigStructPath() string {
+	if p := os.Getenv("GOSEC_SIGSTRUCT"); p != "" {
+		return p
+	}
+	return os.Args[0] + SigStructSuffix
+}
+
+// loadSigStruct reads and checks the signature of the enclave with
+// measurement mrenclave. It returns nil if the binary is not signed.
+func loadSigStruct(mrenclave [SGX_HASH_SIZE]uint8) (*SigStruct, error) {
+	b, err := ioutil.ReadFile(sigStructPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s, err := ParseSigStruct(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Verify(); err != nil {
+		return nil, err
+	}
+	if s.MrEnclave() != mrenclave {
+		return nil, errors.New("gosec: the sigstruct is for another enclave")
+	}
+	return s, nil
+}
+
+// sgxSignEnclave sets the signature of the measured enclave in its metadata
+// meta. Unsigned enclaves are signed with the development key named by
+// GOSEC_DEV_KEY, see devSigningKey, and fail to load without one.
+func sgxSignEnclave(meta *metadata_t) error {
+	s, err := loadSigStruct(meta.Enclave_css.Enclave_hash.M)
+	if err != nil {
+		return fmt.Errorf("unable to load the enclave signature: %v", err)
+	}
+	if s == nil {
+		path := os.Getenv("GOSEC_DEV_KEY")
+		if path == "" {
+			return fmt.Errorf("no signature found at %s and no signing key configured: sign the enclave with go tool enclavesign, or set GOSEC_DEV_KEY to a development key", sigStructPath())
+		}
+		log.Println("gosec: no signature found at", sigStructPath(), "signing with the development key", path)
+		key, err := devSigningKey(path)
+		if err != nil {
+			return fmt.Errorf("unable to load the development key: %v", err)
+		}
+		s = &SigStruct{css: meta.Enclave_css}
+		if err := s.Sign(key); err != nil {
+			return err
+		}
+	}
+	meta.Enclave_css = s.css
+	return nil
+}
+
+// devSigningKey returns the development key in the file path, which signs the
+// enclaves without a signature, so that their MRSIGNER, and what they seal
+// with it, survive a restart. The key is in the PEM format of cmd/enclavesign,
+// e.g., generated by go tool enclavesign genkey.
+func devSigningKey(path string) (*rsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseDevSigningKey(b)
+}
+
+// pkcs1PrivateKey is the ASN.1 structure of an RSA private key in PKCS#1,
+// which crypto/x509 reads. gosec does not import crypto/x509, which brings
+// package net with it.
+type pkcs1PrivateKey struct {
+	Version int
+	N       *big.Int
+	E       int
+	D       *big.Int
+	P       *big.Int
+	Q       *big.Int
+	Dp      *big.Int
+	Dq      *big.Int
+	Qinv    *big.Int
+}
+
+func parseDevSigningKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "RSA PRIVATE KEY" {
+		return nil, errors.New("gosec: no RSA private key in the development key")
+	}
+	var k pkcs1PrivateKey
+	if rest, err := asn1.Unmarshal(block.Bytes, &k); err != nil || len(rest) > 0 {
+		return nil, errors.New("gosec: malformed development key")
+	}
+	key := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: k.N, E: k.E},
+		D:         k.D,
+		Primes:    []*big.Int{k.P, k.Q},
+	}
+	if err := checkSigningKey(&key.PublicKey); err != nil {
+		return nil, err
+	}
+	if err := key.Validate(); err != nil {
+		return nil, err
+	}
+	key.Precompute()
+	return key, nil
+}
+
+// simSignEnclave loads the signature of the enclave in simulation mode in its
+// metadata meta, if there is one, so that MRSIGNER is the signer's.
+func simSignEnclave(meta *metadata_t) error {
+	s, err := loadSigStruct(meta.Enclave_css.Enclave_hash.M)
+	if err != nil {
+		return fmt.Errorf("unable to load the enclave signature: %v", err)
+	}
+	if s != nil {
+		meta.Enclave_css = s.css
+	}
+	return nil
+}
@@ -5,124 +5,91 @@ package gosec
  */
 
 import (
-	"debug/elf"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"os"
 	"runtime"
-	"sort"
 	"syscall"
-	"unsafe"
 )
 
-func simLoadProgram(path string) {
-	fmt.Println("[DEBUG] loading the program in simulation.")
-	file, err := elf.Open(path)
-	check(err)
-	_, enclWrap = sgxCreateSecs(file)
-	enclWrap.isSim = true
-	srcWrap = transposeOutWrapper(enclWrap)
-	defer func() { check(file.Close()) }()
-
-	// Check that the sections are sorted now.
-	sort.Sort(SortedElfSections(file.Sections))
-
-	var aggreg []*elf.Section
-	for _, sec := range file.Sections {
-		if sec.Flags&elf.SHF_ALLOC != elf.SHF_ALLOC {
-			continue
-		}
-		if len(aggreg) == 0 || aggreg[len(aggreg)-1].Flags == sec.Flags {
-			aggreg = append(aggreg, sec)
-			continue
-		}
-		mapSections(aggreg)
-		aggreg = nil
-		aggreg = append(aggreg, sec)
+// simMachineSecret returns the secret that replaces the CPU fused keys for
+// sealing in simulation mode: the 32 bytes of the file named by
+// GOSEC_SIM_SECRET, e.g., created with "head -c 32 /dev/urandom". Without it
+// the secret is zero, and the enclave cannot seal, see gosecu.Seal.
+func simMachineSecret() ([32]uint8, error) {
+	var secret [32]uint8
+	path := os.Getenv("GOSEC_SIM_SECRET")
+	if path == "" {
+		return secret, nil
 	}
-	mapSections(aggreg)
-
-	//For debugging.
-	enclWrap.DumpDebugInfo()
-	// Map the enclave preallocated heap.
-	simPreallocate(enclWrap)
-
-	for _, tcs := range enclWrap.tcss {
-		prot := _PROT_READ | _PROT_WRITE
-		manon := _MAP_PRIVATE | _MAP_ANON | _MAP_FIXED
-		// mmap the stack
-		_, err = syscall.RMmap(tcs.Stack, int(tcs.Ssiz), prot, manon, -1, 0)
-		check(err)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return secret, fmt.Errorf("unable to read the machine secret of GOSEC_SIM_SECRET: %v", err)
 	}
-
-	// register the heap, setup the enclave stack
-	etcs := enclWrap.defaultTcs()
-	etcs.Used = true
-	srcWrap.defaultTcs().Used = true
-	runtime.Cooprt.Tcss = enclWrap.tcss
-	_ = runtime.SetupEnclSysStack(etcs.Stack+etcs.Ssiz, enclWrap.mhstart)
-
-	// Create the thread for enclave, setups the stacks.
-	fn := unsafe.Pointer(uintptr(file.Entry))
-	enclWrap.entry = uintptr(fn)
-	dtcs, stcs := enclWrap.defaultTcs(), srcWrap.defaultTcs()
-	dtcs.Used, stcs.Used = true, true
-	sgxEEnter(uint64(0), dtcs, stcs, nil)
+	if len(b) == len(secret) {
+		copy(secret[:], b)
+	}
+	if secret == [32]uint8{} {
+		return secret, fmt.Errorf("the machine secret in %s is not %d random bytes", path, len(secret))
+	}
+	return secret, nil
 }
 
-func simPreallocate(wrap *sgx_wrapper) {
-	prot := _PROT_READ | _PROT_WRITE
-	flags := _MAP_ANON | _MAP_FIXED | _MAP_PRIVATE
+// simBackend runs the enclave without sgx, in the address space of the
+// program, or in a child process in the isolated simulation, see isolated.go.
+// The signature and the sealing keys of the enclave are simulated.
+type simBackend struct{}
 
-	// The span
-	_, err := syscall.RMmap(wrap.mhstart, int(wrap.mhsize), prot,
-		flags, -1, 0)
-	check(err)
+func (b *simBackend) Name() string    { return backendSim }
+func (b *simBackend) Simulated() bool { return true }
 
-	// The memory buffer for mmap calls.
-	_, err = syscall.RMmap(wrap.membuf, int(MEMBUF_SIZE), prot,
-		flags, -1, 0)
-	check(err)
+func (b *simBackend) Create(in *instance, secs *secs_t) error {
+	fmt.Println("[DEBUG] loading the program in simulation.")
+	return nil
 }
 
-// mapSections mmaps the elf sections.
-// If wrap nil, simple mmap. Otherwise, mmap to another address space specified
-// by wrap.mmask for SGX.
-func mapSections(secs []*elf.Section) {
-	if len(secs) == 0 {
-		return
+// AddPages moves the pages from the staging area to the enclave.
+func (b *simBackend) AddPages(in *instance, dest, src, n, prot uintptr, tpe uint64) error {
+	_, _, e := syscall.Syscall6(syscall.SYS_MREMAP, src, n, n, _MREMAP_MAYMOVE|_MREMAP_FIXED, dest, 0)
+	if e != 0 {
+		return fmt.Errorf("unable to move the region at %#x: %v", dest, e)
 	}
+	return sgxMprotect(dest, n, prot)
+}
 
-	start := uintptr(secs[0].Addr)
-	end := uintptr(secs[len(secs)-1].Addr + secs[len(secs)-1].Size)
-	size := int(end - start)
-	if start >= end {
-		log.Fatalf("Error, sections are not ordered: %#x - %#x", start, end)
-	}
-
-	prot := _PROT_READ | _PROT_WRITE
-	b, err := syscall.RMmap(start, size, prot, _MAP_PRIVATE|_MAP_ANON, -1, 0)
-	check(err)
-
-	for _, sec := range secs {
-		if sec.Type == elf.SHT_NOBITS {
-			continue
-		}
-		data, err := sec.Data()
-		check(err)
-		offset := int(sec.Addr - uint64(start))
-		for i := range data {
-			b[offset+i] = data[i]
-		}
+// Init loads the signature of the enclave, if there is one, so that MRSIGNER
+// is the signer's, and sets the secret of its sealing keys.
+func (b *simBackend) Init(in *instance, secs *secs_t) error {
+	if err := simSignEnclave(in.hash.meta); err != nil {
+		return err
 	}
-	prot = _PROT_READ
-	if (secs[0].Flags & elf.SHF_WRITE) == elf.SHF_WRITE {
-		prot |= _PROT_WRITE
+	var err error
+	if in.cprt.SimSealSecret, err = simMachineSecret(); err != nil {
+		return err
 	}
+	//For debugging.
+	in.encl.DumpDebugInfo(in.cprt)
+	in.encl.entry = in.encl.defaultTcs().Entry
+	return nil
+}
 
-	if (secs[0].Flags & elf.SHF_EXECINSTR) == elf.SHF_EXECINSTR {
-		prot |= _PROT_EXEC
+func (b *simBackend) Enter(in *instance, dest, src *sgx_tcs_info) error {
+	if runtime.IsIsolated() {
+		return in.isolate(dest, src)
 	}
+	sgxEEnter(in, uint64(0), dest, src, nil)
+	return nil
+}
+
+//go:nosplit
+func (b *simBackend) Spawn(in *instance, req *runtime.OExitRequest) {
+	sgxEEnter(in, uint64(req.Did), &in.encl.tcss[req.Did], &in.src.tcss[req.Did], req)
+}
+
+// Resume just returns to the thread, which called the untrusted side.
+//go:nosplit
+func (b *simBackend) Resume(in *instance, id uint64) {
+}
 
-	err = syscall.Mprotect(b, prot)
-	check(err)
+func (b *simBackend) Teardown(in *instance) {
 }
@@ -0,0 +1,107 @@
+package gosec
+
+import (
+	"runtime"
+	"time"
+)
+
+const (
+	// ocallBatch is the largest number of requests a worker takes at once.
+	ocallBatch = 16
+
+	// The backoff of the idle workers: they spin, then yield, then sleep
+	// for up to maxOcallSleep.
+	ocallSpins    = 64
+	ocallYields   = 128
+	maxOcallSleep = time.Millisecond
+)
+
+// ocallWorkers is the number of host threads serving the switchless ocalls.
+var ocallWorkers = 2
+
+// SetOcallWorkers sets the number of host threads that serve the system
+// calls of the enclave through the switchless ring. With 0 workers, all the
+// requests go through the Cooprt.Ocall channel. It must be called before
+// the enclaves are started, and Options.OcallWorkers overrides it. Each
+// enclave has its own workers.
+func SetOcallWorkers(n int) {
+	if n < 0 {
+		panic("gosec: negative number of ocall workers")
+	}
+	if anyLoaded() {
+		panic("gosec: SetOcallWorkers after an enclave is loaded")
+	}
+	ocallWorkers = n
+}
+
+// OcallStats returns the counters of the switchless ocalls of the default
+// enclave, which are zero if there are no workers.
+func OcallStats() runtime.OcallStats {
+	in := defaultInstance()
+	if in == nil || in.cprt.Ring == nil {
+		return runtime.OcallStats{}
+	}
+	return in.cprt.Ring.Stats()
+}
+
+// startOcallWorkers starts n workers serving the requests posted on ring,
+// until srv is stopped.
+func startOcallWorkers(ring *runtime.OcallRing, n int, srv *servers) {
+	srv.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go ocallWorker(ring, srv)
+	}
+}
+
+// ocallWorker polls ring for requests, serves them, and publishes their
+// replies once per batch.
+func ocallWorker(ring *runtime.OcallRing, srv *servers) {
+	defer srv.wg.Done()
+	runtime.LockOSThread()
+	runtime.MarkNoFutex()
+	var reqs [ocallBatch]runtime.OcallReq
+	var res [ocallBatch]runtime.OcallRes
+	idle := 0
+	for {
+		n := ring.Take(reqs[:])
+		if n == 0 {
+			if srv.stopped() {
+				return
+			}
+			ocallBackoff(idle)
+			idle++
+			continue
+		}
+		idle = 0
+		k := 0
+		for i := 0; i < n; i++ {
+			// The slots of the requests without reply are freed by
+			// Complete, so the adversary does not see them.
+			if adversary != nil && reqs[i].Big != runtime.FRE && reqs[i].Big != runtime.GRW {
+				adversary.serve(srv, reqs[i], ring.Complete)
+				continue
+			}
+			reqs[k] = reqs[i]
+			res[k], _ = srv.serveOcall(reqs[i])
+			k++
+		}
+		for i := 0; i < k; i++ {
+			ring.Complete(reqs[i].Id, res[i])
+		}
+	}
+}
+
+// ocallBackoff waits before polling the ring again, after idle empty polls.
+func ocallBackoff(idle int) {
+	switch {
+	case idle < ocallSpins:
+	case idle < ocallYields:
+		runtime.Gosched()
+	default:
+		d := time.Microsecond << uint(idle-ocallYields)
+		if idle-ocallYields > 10 || d > maxOcallSleep {
+			d = maxOcallSleep
+		}
+		time.Sleep(d)
+	}
+}
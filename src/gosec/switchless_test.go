@@ -0,0 +1,74 @@
+package gosec
+
+import (
+	"runtime"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestOcallRing(t *testing.T) {
+	ring := runtime.NewOcallRing(8)
+	srv := newServers(nil)
+	startOcallWorkers(ring, 2, srv)
+	uid := uintptr(syscall.Getuid())
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				res, ok := ring.Call(getuid(0))
+				if !ok {
+					// The ring is full, as the enclave would fall back.
+					continue
+				}
+				if res.R1 != uid || res.Err != 0 {
+					t.Errorf("getuid: %+v, want %d", res, uid)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	st := ring.Stats()
+	if st.Requests == 0 || st.Completed != st.Requests || st.Depth != 0 || st.Batches == 0 || st.Batches > st.Completed {
+		t.Errorf("stats %+v", st)
+	}
+	if st.MaxDepth == 0 || st.MaxLatency == 0 || st.Latency < st.MaxLatency {
+		t.Errorf("stats %+v", st)
+	}
+
+	// The workers exit once stopped, and the ring can be freed.
+	srv.stop()
+	srv.wg.Wait()
+	ring.Free()
+}
+
+func TestOcallRingFull(t *testing.T) {
+	ring := runtime.NewOcallRing(2)
+	// Without workers, the requests stay on the ring.
+	if !ring.Post(getuid(0)) || !ring.Post(getuid(0)) {
+		t.Fatal("Post failed on a ring with free slots")
+	}
+	if ring.Post(getuid(0)) {
+		t.Error("Post succeeded on a full ring")
+	}
+	if _, ok := ring.Call(getuid(0)); ok {
+		t.Error("Call succeeded on a full ring")
+	}
+	if st := ring.Stats(); st.Requests != 2 || st.Depth != 2 {
+		t.Errorf("stats %+v, want 2 requests waiting", st)
+	}
+
+	// The slots of requests without reply are freed once served.
+	var reqs [4]runtime.OcallReq
+	if n := ring.Take(reqs[:]); n != 2 {
+		t.Fatalf("took %d requests, want 2", n)
+	}
+	ring.Complete(reqs[0].Id, runtime.OcallRes{})
+	ring.Complete(reqs[1].Id, runtime.OcallRes{})
+	if !ring.Post(getuid(0)) {
+		t.Error("Post failed once the slots are free")
+	}
+}
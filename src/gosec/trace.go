@@ -0,0 +1,83 @@
+package gosec
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"runtime/trace"
+	"sync"
+)
+
+var tracer struct {
+	mu   sync.Mutex
+	on   bool
+	in   *instance // the enclave traced.
+	done chan error
+}
+
+// StartTrace enables the tracing of the program, as runtime/trace.Start
+// does, and of its default enclave, which it starts if needed. The trace of the
+// program is written to w, and the one of the enclave to ew. go tool trace
+// -enclave merges them. The tracer of the enclave needs the time stamp
+// counter, which the enclave can read in the simulation only.
+func StartTrace(w, ew io.Writer) error {
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if tracer.on {
+		return errors.New("gosec: tracing is already enabled")
+	}
+	if !simulated() {
+		return errors.New("gosec: tracing the enclave requires the simulation")
+	}
+	in, err := acquire("")
+	if err != nil {
+		return err
+	}
+	defer in.sent()
+	if err := trace.Start(w); err != nil {
+		return err
+	}
+	t := in.cprt.Trace
+	runtime.MarkNoFutex()
+	t.Ctl <- true
+	runtime.MarkFutex()
+	tracer.on, tracer.in = true, in
+	tracer.done = make(chan error, 1)
+	go readEnclaveTrace(t, ew, tracer.done)
+	return nil
+}
+
+// StopTrace stops the tracing started by StartTrace, and returns once the
+// traces are written.
+func StopTrace() error {
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if !tracer.on {
+		return nil
+	}
+	runtime.MarkNoFutex()
+	tracer.in.cprt.Trace.Ctl <- false
+	runtime.MarkFutex()
+	err := <-tracer.done
+	trace.Stop()
+	tracer.on, tracer.in = false, nil
+	return err
+}
+
+// readEnclaveTrace writes the trace of the enclave to w, and then the first
+// error of w, if any, to done.
+func readEnclaveTrace(t *runtime.EnclaveTrace, w io.Writer, done chan error) {
+	runtime.MarkNoFutex()
+	var err error
+	for {
+		n := <-t.Data
+		if n == 0 {
+			break
+		}
+		if err == nil {
+			_, err = w.Write(t.Buf[:n])
+		}
+		t.Ack <- true
+	}
+	done <- err
+}
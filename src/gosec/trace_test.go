@@ -0,0 +1,26 @@
+package gosec
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime/trace"
+	"testing"
+)
+
+func TestStartTraceHardware(t *testing.T) {
+	if sim, ok := os.LookupEnv("SIM"); ok {
+		defer os.Setenv("SIM", sim)
+		os.Unsetenv("SIM")
+	}
+	if err := StartTrace(ioutil.Discard, ioutil.Discard); err == nil {
+		t.Fatal("the enclave is traced out of the simulation")
+	}
+	if err := trace.Start(ioutil.Discard); err != nil {
+		t.Errorf("the program is traced after an error: %v", err)
+	} else {
+		trace.Stop()
+	}
+	if err := StopTrace(); err != nil {
+		t.Errorf("StopTrace: %v", err)
+	}
+}
@@ -15,15 +15,27 @@ const (
 	ERR_SGX_INVALID_EINIT_TOKEN = 16
 	ERR_SGX_INVALID_CPUSVN      = 32
 	ERR_SGX_INVALID_ISVSVN      = 64
-	//TODO(aghosn) for the moment I hardcode it, but should be more resilient.
+	// The ioctls of the isgx driver, see isgxBackend. The sizes are the ones
+	// of the packed structures of the driver. The sgx driver of the kernel
+	// has the same create.
 	SGX_IOC_ENCLAVE_CREATE   = ((1 << 30) | (SGX_MAGIC << 8) | (0) | (8 << 16))
 	SGX_IOC_ENCLAVE_ADD_PAGE = ((1 << 30) | (SGX_MAGIC << 8) | (0x01) | (26 << 16))
 	SGX_IOC_ENCLAVE_INIT     = ((1 << 30) | (SGX_MAGIC << 8) | (0x02) | (24 << 16))
 
+	// The ioctls of the sgx driver of the kernel, see sgxBackend.
+	SGX_IOC_ENCLAVE_ADD_PAGES = ((3 << 30) | (SGX_MAGIC << 8) | (0x01) | (48 << 16))
+	SGX_IOC_ENCLAVE_INIT_FLC  = ((1 << 30) | (SGX_MAGIC << 8) | (0x02) | (8 << 16))
+	SGX_PAGE_MEASURE          = 0x01
+
 	SGX_ATTR_MODE64BIT = 0x04
 	TCS_DBGOPTION      = 1
 )
 
+const (
+	_MREMAP_MAYMOVE = 0x1
+	_MREMAP_FIXED   = 0x2
+)
+
 type einittoken_t struct {
 	valid              uint32
 	reserved           [44]uint8
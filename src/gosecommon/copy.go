@@ -1,8 +1,10 @@
 package gosecommon
 
 import (
+	"fmt"
 	"reflect"
 	r "runtime"
+	"strconv"
 	"unsafe"
 )
 
@@ -12,7 +14,29 @@ type rslice struct {
 	c     int
 }
 
-type CA func(size uintptr) uintptr
+type rstring struct {
+	str unsafe.Pointer
+	l   int
+}
+
+type riface struct {
+	tab  unsafe.Pointer
+	data unsafe.Pointer
+}
+
+// A CopyError reports a value that cannot cross the enclave boundary. It is
+// the error of the gosecure call, or the value of the panic of the send on a
+// channel of the other domain.
+type CopyError struct {
+	Reason string
+}
+
+func (e *CopyError) Error() string {
+	return "gosecommon: " + e.Reason
+}
+
+// CA allocates n objects of type tpe.
+type CA func(tpe reflect.Type, n uintptr) unsafe.Pointer
 
 type Copy struct {
 	start uintptr
@@ -21,6 +45,11 @@ type Copy struct {
 
 type Store = map[uintptr]Copy
 
+// zerobase is the address of the zero-sized allocations.
+var zerobase uintptr
+
+var byteType = reflect.TypeOf(byte(0))
+
 func memcpy(dest, source, l uintptr) {
 	if dest == 0 || source == 0 {
 		panic("nil argument to copy")
@@ -33,45 +62,41 @@ func memcpy(dest, source, l uintptr) {
 }
 
 func CanShallowCopy(rtpe *r.DPTpe) bool {
-	tpe := reflect.ConvDPTpeToType(rtpe)
+	b, _ := needsCopy(reflect.ConvDPTpeToType(rtpe))
+	return !b
+}
+
+// needsCopy checks the given type against the supported once and returns
+// true if the type requires recursive exploring for copy.
+// Functions and unsafe pointers are explored to reject them, see
+// (*copier).copy1.
+func needsCopy(tpe reflect.Type) (bool, reflect.Kind) {
 	switch tpe.Kind() {
-	case reflect.Map:
-		panic("Trying to deep copy a map...")
 	case reflect.Array:
-		return CanShallowCopy(reflect.ConvTypeToDPTpe(tpe.Elem()))
+		if tpe.Len() == 0 {
+			return false, tpe.Kind()
+		}
+		b, _ := needsCopy(tpe.Elem())
+		return b, tpe.Kind()
 	case reflect.Struct:
-		needed := false
 		for i := 0; i < tpe.NumField(); i++ {
-			if !CanShallowCopy(reflect.ConvTypeToDPTpe(tpe.Field(i).Type)) {
-				needed = true
-				break
+			if b, _ := needsCopy(tpe.Field(i).Type); b {
+				return true, tpe.Kind()
 			}
 		}
-		return !needed
-	case reflect.UnsafePointer:
+		return false, tpe.Kind()
+	case reflect.Chan:
+		// Nested channels must be registered, see (*copier).copy1.
 		fallthrough
-	case reflect.Ptr:
-		return false
-	case reflect.Slice:
-		return false
-	}
-	return true
-}
-
-// needsCopy checks the given type against the supported once and returns
-// true if the type requires recursive exploring for copy.
-func needsCopy(tpe reflect.Type) (bool, reflect.Kind) {
-	switch tpe.Kind() {
 	case reflect.Map:
-		panic("Trying to deep copy a map...")
-	case reflect.UnsafePointer:
 		fallthrough
-	case reflect.Ptr:
+	case reflect.Func, reflect.UnsafePointer:
 		fallthrough
-	case reflect.Struct:
-		// we force the inspection from deepcopy
+	case reflect.Interface:
 		fallthrough
-	case reflect.Array:
+	case reflect.String:
+		fallthrough
+	case reflect.Ptr:
 		fallthrough
 	case reflect.Slice:
 		return true, tpe.Kind()
@@ -95,51 +120,140 @@ func extractValue(ptr uintptr) uintptr {
 	return val
 }
 
-func copyIn(size uintptr) uintptr {
-	v := make([]uint8, size)
-	return uintptr(unsafe.Pointer(&v[0]))
+// copyIn allocates on the heap, with the type so that the collector finds the
+// pointers of the copy.
+func copyIn(tpe reflect.Type, n uintptr) unsafe.Pointer {
+	if n == 1 {
+		return unsafe.Pointer(reflect.New(tpe).Pointer())
+	}
+	return unsafe.Pointer(reflect.MakeSlice(reflect.SliceOf(tpe), int(n), int(n)).Pointer())
+}
+
+// copier holds the state of a deep copy. store maps the objects already
+// copied to their copy, which preserves sharing and terminates on cycles.
+// Maps cannot be allocated with alloc, they are rebuilt on the heap of the
+// caller unless unsafe is set, i.e., the copy goes out of the enclave.
+// Otherwise, keep holds the allocations until the copy is stored, as they
+// are only referenced by addresses while it is built. In the enclave, remote
+// holds the types of the untrusted side, whose type words are those of the
+// source, or of the copy if unsafe is set. err is the first value that cannot
+// cross, which the copy leaves zero.
+type copier struct {
+	store  Store
+	alloc  CA
+	unsafe bool
+	keep   []unsafe.Pointer
+	remote *crossTypes
+	err    *CopyError
+}
+
+// fail records the error of a value that cannot cross. The copy goes on: it
+// runs in the runtime with the lock of a channel held, it cannot panic.
+func (c *copier) fail(err *CopyError) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// result returns the error of the copy, if any.
+func (c *copier) result() error {
+	if c.err == nil {
+		return nil
+	}
+	return c.err
 }
 
 func DeepCopierSend(src unsafe.Pointer, tpe *r.DPTpe) (unsafe.Pointer, r.AllocTracker) {
-	store := make(Store)
 	gtpe := reflect.ConvTypePtr(tpe)
 	var tracker r.AllocTracker
-	allocater := func(size uintptr) uintptr {
+	allocater := func(tpe reflect.Type, n uintptr) unsafe.Pointer {
+		size := tpe.Size() * n
 		res := r.UnsafeAllocator.Malloc(size)
 		tracker = append(tracker, r.TrackerEntry{res, size})
-		return res
+		return unsafe.Pointer(res)
 	}
-	cpy := unsafe.Pointer(DeepCopy(uintptr(src), gtpe, store, allocater))
-	return cpy, tracker
+	cpy, err := deepCopySend(uintptr(src), gtpe, allocater)
+	if err != nil {
+		// The runtime checks the value before the send, see CheckCopy.
+		panic("gosecommon: copy of an unchecked value: " + err.Error())
+	}
+	return unsafe.Pointer(cpy), tracker
+}
+
+// deepCopySend is DeepCopierSend with the allocator alloc of unsafe memory.
+func deepCopySend(src uintptr, tpe reflect.Type, alloc CA) (uintptr, error) {
+	c := &copier{store: make(Store), alloc: alloc, unsafe: true, remote: remoteTypes()}
+	cpy := c.copy(src, tpe)
+	return cpy, c.result()
 }
 
-func DeepCopier(src unsafe.Pointer, tpe *r.DPTpe) unsafe.Pointer {
+func DeepCopier(src unsafe.Pointer, tpe *r.DPTpe) (unsafe.Pointer, error) {
 	store := make(Store)
 	gtpe := reflect.ConvTypePtr(tpe)
-	return unsafe.Pointer(DeepCopy(uintptr(src), gtpe, store, copyIn))
+	cpy, err := DeepCopy(uintptr(src), gtpe, store, copyIn)
+	return unsafe.Pointer(cpy), err
 }
 
 // deepCopy entry point for deepCopy.
-// Takes a pointer type as element, returns pointer to the same type.
-func DeepCopy(src uintptr, tpe reflect.Type, store Store, alloc CA) uintptr {
+// Takes a pointer type as element, returns pointer to the same type, and the
+// error of a value that cannot cross.
+func DeepCopy(src uintptr, tpe reflect.Type, store Store, alloc CA) (uintptr, error) {
+	c := &copier{store: store, alloc: alloc, remote: remoteTypes()}
+	cpy := c.copy(src, tpe)
+	return cpy, c.result()
+}
+
+// CheckCopy returns the error of the value of type tpe at src if it cannot be
+// copied out of the enclave. The runtime checks the values sent on the
+// channels of the untrusted side before it locks them, see DeepCopierSend.
+func CheckCopy(src unsafe.Pointer, tpe *r.DPTpe) error {
+	c := &copier{store: make(Store), unsafe: true, remote: remoteTypes()}
+	c.check1(uintptr(src), reflect.ConvDPTpeToType(tpe))
+	return c.result()
+}
+
+// allocate returns n objects of type tpe from c.alloc, or zerobase if they
+// have no size.
+func (c *copier) allocate(tpe reflect.Type, n uintptr) uintptr {
+	if tpe.Size()*n == 0 {
+		return uintptr(unsafe.Pointer(&zerobase))
+	}
+	p := c.alloc(tpe, n)
+	if !c.unsafe {
+		c.keep = append(c.keep, p)
+	}
+	return uintptr(p)
+}
+
+// copy returns a deep copy of the object of pointer type tpe at src.
+func (c *copier) copy(src uintptr, tpe reflect.Type) uintptr {
 	if tpe.Kind() != reflect.Ptr {
 		panic("Call to deepCopy does not respect calling convention.")
 	}
-	if v, ok := store[src]; ok {
+	if src == 0 {
+		return 0
+	}
+	size := tpe.Elem().Size()
+	// A pointer to the first field of a struct has the address of the
+	// struct, the copy is only reused if it is large enough.
+	if v, ok := c.store[src]; ok && v.size >= size {
 		return v.start
 	}
 	// Initial shallow copy.
-	dest := alloc(tpe.Elem().Size()) //make([]uint8, tpe.Elem().Size())
-	memcpy(dest, src, tpe.Elem().Size())
-	store[src] = Copy{dest, tpe.Elem().Size()}
+	dest := c.allocate(tpe.Elem(), 1)
+	if size != 0 {
+		memcpy(dest, src, size)
+	}
+	c.store[src] = Copy{dest, size}
 
 	// Go into the type's deep copy
-	deepCopy1(dest, src, tpe.Elem(), store, alloc)
+	c.copy1(dest, src, tpe.Elem())
 	return dest
 }
 
-// deepCopy1 dest and src are pointers to type tpe.
-func deepCopy1(dest, src uintptr, tpe reflect.Type, store Store, alloc CA) {
+// copy1 dest and src are pointers to type tpe, and dest holds a shallow copy
+// of src.
+func (c *copier) copy1(dest, src uintptr, tpe reflect.Type) {
 	b, k := needsCopy(tpe)
 	if !b {
 		// flat type, not interesting.
@@ -148,78 +262,305 @@ func deepCopy1(dest, src uintptr, tpe reflect.Type, store Store, alloc CA) {
 	switch k {
 	case reflect.Ptr:
 		// at that point dest and ptr should be ptrs to ptrs
-		val := DeepCopy(extractValue(src), tpe, store, alloc)
+		val := c.copy(extractValue(src), tpe)
 		setPtrValue(dest, val)
 	case reflect.Struct:
-		offset := uintptr(0)
 		for i := 0; i < tpe.NumField(); i++ {
 			f := tpe.Field(i)
-			if b, _ := needsCopy(f.Type); b {
-				deepCopy1(dest+offset, src+offset, f.Type, store, alloc)
-			}
-			offset += f.Type.Size()
+			c.copy1(dest+f.Offset, src+f.Offset, f.Type)
 		}
 	case reflect.Array:
-		if b, _ := needsCopy(tpe.Elem()); !b {
-			return
+		esize := tpe.Elem().Size()
+		for i := uintptr(0); i < uintptr(tpe.Len()); i++ {
+			c.copy1(dest+i*esize, src+i*esize, tpe.Elem())
 		}
-		offset := uintptr(0)
-		for i := 0; i < tpe.Len(); i++ {
-			deepCopy1(dest+offset, src+offset, tpe.Elem(), store, alloc)
-			offset += tpe.Elem().Size()
-		}
-	// TODO handle slices.
 	case reflect.Slice:
-		rs := (*rslice)(unsafe.Pointer(src))
-		//now allocate the new array
-		ndest := alloc(uintptr(rs.c))
-		memcpy(ndest, uintptr(rs.array), uintptr(rs.l))
+		rs := *(*rslice)(unsafe.Pointer(src))
 		cs := (*rslice)(unsafe.Pointer(dest))
+		if rs.array == nil {
+			return
+		}
+		checkSlice(rs, tpe)
+		// The whole capacity is copied, it can be reached by reslicing.
+		esize := tpe.Elem().Size()
+		ndest := c.allocate(tpe.Elem(), uintptr(rs.c))
+		if rs.c != 0 && esize != 0 {
+			memcpy(ndest, uintptr(rs.array), uintptr(rs.c)*esize)
+		}
 		cs.array = unsafe.Pointer(ndest)
-		cs.l = rs.l
-		cs.c = rs.c
 		if b, _ := needsCopy(tpe.Elem()); b {
-			deepCopy1(ndest, uintptr(rs.array), reflect.ArrayOf(rs.c, tpe.Elem()), store, alloc)
+			for i := uintptr(0); i < uintptr(rs.c); i++ {
+				c.copy1(ndest+i*esize, uintptr(rs.array)+i*esize, tpe.Elem())
+			}
+		}
+	case reflect.String:
+		rs := *(*rstring)(unsafe.Pointer(src))
+		cs := (*rstring)(unsafe.Pointer(dest))
+		if rs.l == 0 {
+			cs.str = nil
+			return
 		}
-	case reflect.UnsafePointer:
-		panic("Unsafe pointers are not allowed!")
+		ndest := c.allocate(byteType, uintptr(rs.l))
+		memcpy(ndest, uintptr(rs.str), uintptr(rs.l))
+		cs.str = unsafe.Pointer(ndest)
+	case reflect.Func, reflect.UnsafePointer:
+		// There is no way to know what they refer to, see gosecureArgs in
+		// cmd/compile.
+		if extractValue(src) != 0 {
+			c.fail(&CopyError{tpe.String() + " cannot cross the enclave boundary"})
+			setPtrValue(dest, 0)
+		}
+	case reflect.Interface:
+		c.copyIface(dest, src, tpe)
+	case reflect.Map:
+		c.copyMap(dest, src, tpe)
 	case reflect.Chan:
-		panic("Must implement the channel registration")
+		// Channels are shared across domains, the enclave must know the type
+		// of their elements to copy them.
+		if cptr := extractValue(src); cptr != 0 && r.IsEnclave() {
+			r.SetChanType(cptr, reflect.ConvTypeToDPTpe(tpe.Elem()))
+		}
 	default:
 		panic("Unhandled type")
 	}
 }
 
-func DeepCopyStackFrame(size int32, argp *uint8, ftpe reflect.Type) []byte {
+// copyIface stores at dest a deep copy of the interface of type tpe at src,
+// with the type word of the domain of the copy. The concrete type of its value
+// must be registered, see Register.
+func (c *copier) copyIface(dest, src uintptr, tpe reflect.Type) {
+	ri := (*riface)(unsafe.Pointer(src))
+	ci := (*riface)(unsafe.Pointer(dest))
+	if ri.tab == nil {
+		return
+	}
+	dyn, tab, err := c.ifaceType(src, tpe)
+	if err != nil {
+		c.fail(err)
+		*ci = riface{}
+		return
+	}
+	ci.tab = tab
+	if reflect.IfaceIndir(dyn) {
+		ci.data = unsafe.Pointer(c.copy(uintptr(ri.data), reflect.PtrTo(dyn)))
+		return
+	}
+	// The data word holds the value, e.g., a pointer or a map.
+	c.copy1(uintptr(unsafe.Pointer(&ci.data)), uintptr(unsafe.Pointer(&ri.data)), dyn)
+}
+
+// ifaceType returns the concrete type, in this domain, of the value of the
+// non-nil interface of type tpe at src, and the type word of its copy.
+func (c *copier) ifaceType(src uintptr, tpe reflect.Type) (reflect.Type, unsafe.Pointer, *CopyError) {
+	tab := (*riface)(unsafe.Pointer(src)).tab
+	switch {
+	case c.remote == nil:
+		dyn, err := dynType(src, tpe, nil)
+		return dyn, tab, err
+	case c.unsafe:
+		dyn, err := dynType(src, tpe, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		tab, err = remoteWord(tpe, dyn, c.remote)
+		return dyn, tab, err
+	}
+	dyn, err := dynType(src, tpe, c.remote)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dyn, typeWord(tpe, dyn), nil
+}
+
+// checkSlice panics if the slice rs of type tpe is forged: only a forged
+// slice is longer than its capacity. The enclave aborts on forged values, it
+// does not report them like the values that cannot cross.
+func checkSlice(rs rslice, tpe reflect.Type) {
+	if rs.l < 0 || rs.l > rs.c {
+		panic("gosecommon: " + tpe.String() + " of length " + strconv.Itoa(rs.l) + " and capacity " + strconv.Itoa(rs.c))
+	}
+}
+
+// check1 records in c.err the first value, in the object of type tpe at src,
+// that cannot be copied: it explores src like copy1 without copying it. store
+// holds the objects already explored.
+func (c *copier) check1(src uintptr, tpe reflect.Type) {
+	if b, _ := needsCopy(tpe); !b || c.err != nil {
+		return
+	}
+	switch tpe.Kind() {
+	case reflect.Ptr:
+		p := extractValue(src)
+		if _, ok := c.store[p]; ok || p == 0 {
+			return
+		}
+		c.store[p] = Copy{}
+		c.check1(p, tpe.Elem())
+	case reflect.Struct:
+		for i := 0; i < tpe.NumField(); i++ {
+			f := tpe.Field(i)
+			c.check1(src+f.Offset, f.Type)
+		}
+	case reflect.Array:
+		esize := tpe.Elem().Size()
+		for i := uintptr(0); i < uintptr(tpe.Len()); i++ {
+			c.check1(src+i*esize, tpe.Elem())
+		}
+	case reflect.Slice:
+		rs := *(*rslice)(unsafe.Pointer(src))
+		if rs.array == nil {
+			return
+		}
+		checkSlice(rs, tpe)
+		if b, _ := needsCopy(tpe.Elem()); !b {
+			return
+		}
+		esize := tpe.Elem().Size()
+		for i := uintptr(0); i < uintptr(rs.c); i++ {
+			c.check1(uintptr(rs.array)+i*esize, tpe.Elem())
+		}
+	case reflect.Func, reflect.UnsafePointer:
+		if extractValue(src) != 0 {
+			c.fail(&CopyError{tpe.String() + " cannot cross the enclave boundary"})
+		}
+	case reflect.Interface:
+		ri := (*riface)(unsafe.Pointer(src))
+		if ri.tab == nil {
+			return
+		}
+		dyn, _, err := c.ifaceType(src, tpe)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		if reflect.IfaceIndir(dyn) {
+			c.check1(uintptr(unsafe.Pointer(&ri.data)), reflect.PtrTo(dyn))
+			return
+		}
+		c.check1(uintptr(unsafe.Pointer(&ri.data)), dyn)
+	case reflect.Map:
+		m := extractValue(src)
+		if _, ok := c.store[m]; ok || m == 0 {
+			return
+		}
+		c.store[m] = Copy{}
+		keys, elems := r.MapEntries(reflect.ConvTypeToDPTpe(tpe), unsafe.Pointer(m))
+		for i := range keys {
+			c.check1(uintptr(keys[i]), tpe.Key())
+			c.check1(uintptr(elems[i]), tpe.Elem())
+		}
+	}
+}
+
+// copyMap stores at dest a new map with deep copies of the keys and values of
+// the map of type tpe at src. The map is read without hashing its keys, as it
+// can belong to the other domain, whose hash differs. A copy out of the
+// enclave is an image of the map, which the untrusted side rebuilds when it
+// copies the value out of the unsafe memory, see runtime.MapImage.
+func (c *copier) copyMap(dest, src uintptr, tpe reflect.Type) {
+	m := extractValue(src)
+	if m == 0 {
+		return
+	}
+	if v, ok := c.store[m]; ok {
+		setPtrValue(dest, v.start)
+		return
+	}
+	mtpe := reflect.ConvTypeToDPTpe(tpe)
+	keys, elems := r.MapEntries(mtpe, unsafe.Pointer(m))
+	kt, et := tpe.Key(), tpe.Elem()
+	if c.unsafe {
+		alloc := func(size uintptr) unsafe.Pointer {
+			return unsafe.Pointer(c.allocate(byteType, size))
+		}
+		img, ikeys, ielems := r.MapImage(mtpe, len(keys), alloc)
+		c.store[m] = Copy{uintptr(img), 0}
+		for i := range keys {
+			c.copyAt(uintptr(ikeys[i]), uintptr(keys[i]), kt)
+			c.copyAt(uintptr(ielems[i]), uintptr(elems[i]), et)
+		}
+		setPtrValue(dest, uintptr(img))
+		return
+	}
+	dm := reflect.MakeMapWithSize(tpe, len(keys))
+	c.store[m] = Copy{dm.Pointer(), 0}
+	c.keep = append(c.keep, unsafe.Pointer(dm.Pointer()))
+	for i := range keys {
+		dm.SetMapIndex(c.value(keys[i], kt), c.value(elems[i], et))
+	}
+	setPtrValue(dest, dm.Pointer())
+}
+
+// copyAt stores at dest a deep copy of the value of type tpe at src.
+func (c *copier) copyAt(dest, src uintptr, tpe reflect.Type) {
+	if tpe.Size() != 0 {
+		memcpy(dest, src, tpe.Size())
+	}
+	c.copy1(dest, src, tpe)
+}
+
+// value returns a deep copy of the value of type tpe at p, for a map built by
+// copyMap.
+func (c *copier) value(p unsafe.Pointer, tpe reflect.Type) reflect.Value {
+	s := reflect.New(tpe)
+	s.Elem().Set(reflect.NewAt(tpe, p).Elem())
+	d := reflect.New(tpe)
+	d.Elem().Set(s.Elem())
+	c.copy1(d.Pointer(), s.Pointer(), tpe)
+	return d.Elem()
+}
+
+// DeepCopyStackFrame returns a deep copy of the size bytes of arguments at
+// argp of a call to a function of type ftpe, and the error of an argument
+// that cannot cross.
+func DeepCopyStackFrame(size int32, argp *uint8, ftpe reflect.Type) ([]byte, error) {
 	if ftpe.Kind() != reflect.Func {
 		panic("Wrong call to DeepCopyStackFrame")
 	}
 	if size == 0 {
-		return nil
+		return nil, nil
 	}
-	store := make(Store)
-	nframe := make([]byte, int(size))
+	c := &copier{store: make(Store), alloc: copyIn, remote: remoteTypes()}
+	nframe := frameOf(size, ftpe)
 	fptr := uintptr(unsafe.Pointer(&nframe[0]))
 	srcptr := uintptr(unsafe.Pointer(argp))
 	memcpy(fptr, srcptr, uintptr(size))
+	// The arguments are laid out like the fields of a struct.
+	off := uintptr(0)
 	for i := 0; i < ftpe.NumIn(); i++ {
+		t := ftpe.In(i)
+		a := uintptr(t.Align())
+		off = (off + a - 1) &^ (a - 1)
 		// handle cross-domain channels
-		if ftpe.In(i).Kind() == reflect.Chan {
-			extendUnsafeChanType(fptr, ftpe.In(i))
-			goto endloop
-		}
-		if ok, _ := needsCopy(ftpe.In(i)); !ok {
-			goto endloop
+		if t.Kind() == reflect.Chan {
+			extendUnsafeChanType(fptr+off, t)
+		} else {
+			c.copy1(fptr+off, srcptr+off, t)
 		}
-		deepCopy1(fptr, srcptr, ftpe.In(i), store, copyIn)
-	endloop:
-		fptr += ftpe.In(i).Size()
-		srcptr += ftpe.In(i).Size()
+		off += t.Size()
 	}
-	return nframe
+	return nframe, c.result()
+}
+
+// frameOf returns a frame of size bytes for the arguments of a function of
+// type ftpe. It is allocated as a struct of the arguments, so that the
+// collector finds the copies they point to.
+func frameOf(size int32, ftpe reflect.Type) []byte {
+	fields := make([]reflect.StructField, 0, ftpe.NumIn()+1)
+	for i := 0; i < ftpe.NumIn(); i++ {
+		fields = append(fields, reflect.StructField{Name: fmt.Sprintf("A%d", i), Type: ftpe.In(i)})
+	}
+	if pad := uintptr(size) - reflect.StructOf(fields).Size(); int32(pad) > 0 {
+		fields = append(fields, reflect.StructField{Name: "Pad", Type: reflect.ArrayOf(int(pad), byteType)})
+	}
+	frame := reflect.New(reflect.StructOf(fields)).Pointer()
+	return (*[1 << 30]byte)(unsafe.Pointer(frame))[:size:size]
 }
 
 func extendUnsafeChanType(cptr uintptr, ctype reflect.Type) {
+	if extractValue(cptr) == 0 {
+		return
+	}
 	rdpte := reflect.ConvTypeToDPTpe(ctype.Elem())
 	r.SetChanType(extractValue(cptr), rdpte)
 }
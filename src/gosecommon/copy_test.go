@@ -0,0 +1,464 @@
+package gosecommon
+
+import (
+	"fmt"
+	"reflect"
+	r "runtime"
+	"testing"
+	"testing/quick"
+	"unsafe"
+)
+
+type copyTestInner struct {
+	X []map[int16]string
+	Y [2][]uint32
+}
+
+type copyTestT struct {
+	B   bool
+	I8  int8
+	I   int
+	F   float64
+	C   complex128
+	S   string
+	Bs  []byte
+	Ss  []string
+	Is  []int64
+	Arr [3]*int
+	M   map[string][]int
+	P   *int
+	PP  **string
+	In  copyTestInner
+	Pi  *copyTestInner
+	Ind map[[33]int32][20]int64 // indirect keys and elements.
+}
+
+type copyTestNode struct {
+	Next  *copyTestNode
+	V     interface{}
+	A, B  *int
+	C     chan int
+	F     func() int
+	P     unsafe.Pointer
+	Kids  map[string]*copyTestNode
+	Empty []int
+}
+
+// deepCopyOf returns a deep copy of *v. It panics with the error of the copy.
+func deepCopyOf(v interface{}) interface{} {
+	pv := reflect.ValueOf(v)
+	cpy, err := DeepCopier(unsafe.Pointer(pv.Pointer()), reflect.ConvTypeToDPTpe(pv.Type().Elem()))
+	if err != nil {
+		panic(err)
+	}
+	return reflect.NewAt(pv.Type().Elem(), cpy).Elem().Interface()
+}
+
+// sendCopyOf returns a deep copy of *v out of the enclave, in memory that
+// keep holds. The memory is not scanned: keep must be alive as long as the
+// copy. It panics with the error of the copy.
+func sendCopyOf(v interface{}, keep *[][]byte) interface{} {
+	pv := reflect.ValueOf(v)
+	alloc := func(tpe reflect.Type, n uintptr) unsafe.Pointer {
+		b := make([]byte, tpe.Size()*n)
+		*keep = append(*keep, b)
+		return unsafe.Pointer(&b[0])
+	}
+	cpy, err := deepCopySend(pv.Pointer(), pv.Type(), alloc)
+	if err != nil {
+		panic(err)
+	}
+	return reflect.NewAt(pv.Type().Elem(), unsafe.Pointer(cpy)).Elem().Interface()
+}
+
+// addresses records in m the memory reachable from v, but channels, which
+// are not copied. The maps are read like the copier reads them, as they can
+// be images, see runtime.MapImage.
+func addresses(v reflect.Value, m map[uintptr]bool) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || m[v.Pointer()] {
+			return
+		}
+		m[v.Pointer()] = true
+		addresses(v.Elem(), m)
+	case reflect.Slice:
+		if v.Cap() > 0 {
+			m[v.Pointer()] = true
+		}
+		for i := 0; i < v.Len(); i++ {
+			addresses(v.Index(i), m)
+		}
+	case reflect.String:
+		if v.Len() > 0 {
+			s := v.String()
+			m[(*reflect.StringHeader)(unsafe.Pointer(&s)).Data] = true
+		}
+	case reflect.Map:
+		if v.IsNil() || m[v.Pointer()] {
+			return
+		}
+		m[v.Pointer()] = true
+		keys, elems := r.MapEntries(reflect.ConvTypeToDPTpe(v.Type()), unsafe.Pointer(v.Pointer()))
+		for i := range keys {
+			addresses(reflect.NewAt(v.Type().Key(), keys[i]).Elem(), m)
+			addresses(reflect.NewAt(v.Type().Elem(), elems[i]).Elem(), m)
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			addresses(v.Elem(), m)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			addresses(v.Field(i), m)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			addresses(v.Index(i), m)
+		}
+	}
+}
+
+// shared returns an address reachable from both *a and *b, or 0.
+func shared(a, b interface{}) uintptr {
+	ma, mb := make(map[uintptr]bool), make(map[uintptr]bool)
+	addresses(reflect.ValueOf(a).Elem(), ma)
+	addresses(reflect.ValueOf(b).Elem(), mb)
+	for p := range ma {
+		if mb[p] {
+			return p
+		}
+	}
+	return 0
+}
+
+func TestDeepCopyQuick(t *testing.T) {
+	f := func(v copyTestT) bool {
+		cpy := deepCopyOf(&v).(copyTestT)
+		if !reflect.DeepEqual(v, cpy) {
+			t.Logf("copy of %+v is %+v", v, cpy)
+			return false
+		}
+		if p := shared(&v, &cpy); p != 0 {
+			t.Logf("copy of %+v shares %#x", v, p)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestDeepCopySendQuick checks that a copy out of the enclave shares nothing
+// with the original, and that the untrusted side gets the original when it
+// copies it out of the unsafe memory.
+func TestDeepCopySendQuick(t *testing.T) {
+	f := func(v copyTestT) bool {
+		var keep [][]byte
+		sent := sendCopyOf(&v, &keep).(copyTestT)
+		cpy := deepCopyOf(&sent).(copyTestT)
+		if !reflect.DeepEqual(v, cpy) {
+			t.Logf("copy of %+v is %+v", v, cpy)
+			return false
+		}
+		if p := shared(&v, &sent); p != 0 {
+			t.Logf("copy of %+v sent out shares %#x", v, p)
+			return false
+		}
+		if p := shared(&sent, &cpy); p != 0 {
+			t.Logf("copy of %+v shares %#x with the unsafe memory", v, p)
+			return false
+		}
+		r.KeepAlive(keep)
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestDeepCopyReject checks that the values that cannot cross the enclave
+// boundary are rejected, in both directions, but their zero values, and that
+// forged values panic.
+func TestDeepCopyReject(t *testing.T) {
+	x := 1
+	forged := make([]int, 1, 2)
+	(*rslice)(unsafe.Pointer(&forged)).l = 3
+	for _, tt := range []struct {
+		n    copyTestNode
+		want string
+	}{
+		{copyTestNode{}, ""},
+		{copyTestNode{F: func() int { return 1 }}, "gosecommon: func() int cannot cross the enclave boundary"},
+		{copyTestNode{P: unsafe.Pointer(&x)}, "gosecommon: unsafe.Pointer cannot cross the enclave boundary"},
+		{copyTestNode{V: copyTestUnregistered{}}, "gosecommon: gosecommon.copyTestUnregistered cannot cross the enclave boundary, see Register"},
+		{copyTestNode{Kids: map[string]*copyTestNode{"k": {V: &copyTestUnregistered{}}}}, "gosecommon: *gosecommon.copyTestUnregistered cannot cross the enclave boundary, see Register"},
+		{copyTestNode{Empty: forged}, "gosecommon: []int of length 3 and capacity 2"},
+	} {
+		for _, send := range []bool{false, true} {
+			n := &tt.n
+			got := copyTestFailure(func() error {
+				if send {
+					var keep [][]byte
+					sendCopyOf(&n, &keep)
+					r.KeepAlive(keep)
+				} else {
+					deepCopyOf(&n)
+				}
+				return nil
+			})
+			if got != tt.want {
+				t.Errorf("copy of %+v (send %v) fails with %q, want %q", tt.n, send, got, tt.want)
+			}
+		}
+		// The runtime checks the values before it copies them out.
+		got := copyTestFailure(func() error {
+			return CheckCopy(unsafe.Pointer(&tt.n), reflect.ConvTypeToDPTpe(reflect.TypeOf(tt.n)))
+		})
+		if got != tt.want {
+			t.Errorf("CheckCopy(%+v) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+// copyTestFailure returns the error of f, or its panic, as a string.
+func copyTestFailure(f func() error) (msg string) {
+	defer func() {
+		if p := recover(); p != nil {
+			msg = fmt.Sprint(p)
+		}
+	}()
+	if err := f(); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+type copyTestUnregistered struct{}
+
+type copyTestError struct{ S string }
+
+func (e *copyTestError) Error() string  { return e.S }
+func (e *copyTestError) String() string { return e.S }
+
+func copyTestTarget(err error, c chan []fmt.Stringer) {}
+
+func init() {
+	x, y := 1, "y"
+	for _, v := range []interface{}{
+		3, "s", &x, []int{}, copyTestInner{}, map[string]interface{}{}, []interface{}{},
+		[2]string{}, map[int]int{}, &copyTestError{}, &y,
+	} {
+		Register(v)
+	}
+}
+
+// remoteCopyOf returns a deep copy of *v made in the enclave, with the types
+// of the table of the untrusted side: a copy out of the enclave, in memory
+// that keep holds, if keep is not nil. It panics with the error of the copy.
+func remoteCopyOf(v interface{}, table unsafe.Pointer, keep *[][]byte) interface{} {
+	pv := reflect.ValueOf(v)
+	c := &copier{store: make(Store), alloc: copyIn, remote: (*crossTypes)(table)}
+	if keep != nil {
+		c.unsafe = true
+		c.alloc = func(tpe reflect.Type, n uintptr) unsafe.Pointer {
+			b := make([]byte, tpe.Size()*n)
+			*keep = append(*keep, b)
+			return unsafe.Pointer(&b[0])
+		}
+	}
+	cpy := c.copy(pv.Pointer(), pv.Type())
+	if c.err != nil {
+		panic(c.err)
+	}
+	return reflect.NewAt(pv.Type().Elem(), unsafe.Pointer(cpy)).Elem().Interface()
+}
+
+// TestDeepCopyInterfaces checks the copies of the interfaces that hold values
+// of registered types, in this domain and with the table of the untrusted side,
+// which has the same type words in this test.
+func TestDeepCopyInterfaces(t *testing.T) {
+	x, y := 1, "y"
+	table := CrossTypes([]interface{}{copyTestTarget})
+	for _, v := range []interface{}{
+		nil,
+		3,
+		"s",
+		&x,
+		(*int)(nil),
+		[]int{1, 2},
+		copyTestInner{X: []map[int16]string{{1: "a"}}},
+		map[string]interface{}{"a": &y, "b": []interface{}{&x, "c"}},
+		[]interface{}{[2]string{"d", "e"}, map[int]int{}},
+		&copyTestError{"e"},
+	} {
+		n := &copyTestNode{V: v}
+		var keep [][]byte
+		sent := sendCopyOf(&n, &keep).(*copyTestNode)
+		rsent := remoteCopyOf(&n, table, &keep).(*copyTestNode)
+		for _, c := range []struct {
+			dir string
+			cpy *copyTestNode
+		}{
+			{"in", deepCopyOf(&n).(*copyTestNode)},
+			{"out", deepCopyOf(&sent).(*copyTestNode)},
+			{"remote in", remoteCopyOf(&n, table, nil).(*copyTestNode)},
+			{"remote out", deepCopyOf(&rsent).(*copyTestNode)},
+		} {
+			if !reflect.DeepEqual(n, c.cpy) {
+				t.Errorf("%s: copy of %#v is %#v", c.dir, v, c.cpy.V)
+				continue
+			}
+			if p := shared(&n, &c.cpy); p != 0 {
+				t.Errorf("%s: copy of %#v shares %#x", c.dir, v, p)
+			}
+		}
+		r.KeepAlive(keep)
+	}
+	var err error = &copyTestError{"e"}
+	if cpy := remoteCopyOf(&err, table, nil).(error); cpy.Error() != "e" || cpy == err {
+		t.Errorf("remote copy of the error %v is %v", err, cpy)
+	}
+}
+
+// TestDeepCopyUnknown checks that the copies with the table of the untrusted
+// side reject the types it does not know.
+func TestDeepCopyUnknown(t *testing.T) {
+	table := CrossTypes(nil)
+	var s fmt.Stringer = &copyTestError{"e"}
+	for _, tt := range []struct {
+		v    interface{}
+		tab  unsafe.Pointer
+		send bool
+		want string
+	}{
+		{&s, table, true, "gosecommon: *gosecommon.copyTestError as fmt.Stringer is unknown outside of the enclave"},
+		{&s, unsafe.Pointer(&crossTypes{}), true, "gosecommon: *gosecommon.copyTestError is not registered outside of the enclave, see Register"},
+		{&s, unsafe.Pointer(&crossTypes{}), false, "gosecommon: fmt.Stringer holds a type that is not registered outside of the enclave, see Register"},
+	} {
+		got := func() (msg string) {
+			defer func() {
+				if p := recover(); p != nil {
+					msg = p.(error).Error()
+				}
+			}()
+			var keep *[][]byte
+			if tt.send {
+				keep = new([][]byte)
+			}
+			remoteCopyOf(tt.v, tt.tab, keep)
+			return ""
+		}()
+		if got != tt.want {
+			t.Errorf("copy of %v (send %v) fails with %q, want %q", tt.v, tt.send, got, tt.want)
+		}
+	}
+}
+
+func TestTypeName(t *testing.T) {
+	for _, tt := range []struct {
+		v    interface{}
+		want string
+	}{
+		{0, "int"},
+		{copyTestInner{}, "gosecommon.copyTestInner"},
+		{&copyTestError{}, "*gosecommon.copyTestError"},
+		{[]error{}, "[]error"},
+		{map[string]*copyTestNode{}, "map[string]*gosecommon.copyTestNode"},
+	} {
+		if got := typeName(reflect.TypeOf(tt.v)); got != tt.want {
+			t.Errorf("typeName(%T) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+	if got := typeName(errorType); got != "error" {
+		t.Errorf("typeName(error) = %q", got)
+	}
+}
+
+func TestDeepCopySharing(t *testing.T) {
+	x := 42
+	n := &copyTestNode{A: &x, B: &x, C: make(chan int), Empty: []int{}}
+	n.Next = n
+	n.Kids = map[string]*copyTestNode{"self": n, "next": n.Next}
+	check := func(dir string, cpy *copyTestNode) {
+		switch {
+		case cpy == n:
+			t.Fatalf("%s: the node was not copied", dir)
+		case cpy.Next != cpy || cpy.Kids["self"] != cpy || cpy.Kids["next"] != cpy:
+			t.Errorf("%s: the cycle is not preserved", dir)
+		case cpy.A != cpy.B || cpy.A == &x || *cpy.A != x:
+			t.Errorf("%s: the shared pointer is not preserved", dir)
+		case cpy.C != n.C:
+			t.Errorf("%s: channels must be shared across domains", dir)
+		case cpy.Empty == nil || len(cpy.Empty) != 0:
+			t.Errorf("%s: the empty slice is %#v", dir, cpy.Empty)
+		}
+	}
+	check("in", deepCopyOf(&n).(*copyTestNode))
+	var keep [][]byte
+	sent := sendCopyOf(&n, &keep).(*copyTestNode)
+	check("out", deepCopyOf(&sent).(*copyTestNode))
+	r.KeepAlive(keep)
+}
+
+func TestCanShallowCopy(t *testing.T) {
+	for _, tt := range []struct {
+		v    interface{}
+		want bool
+	}{
+		{0, true},
+		{[4]float32{}, true},
+		{struct {
+			A int8
+			B uintptr
+		}{}, true},
+		{unsafe.Pointer(nil), false},
+		{func() {}, false},
+		{"", false},
+		{[]int{}, false},
+		{map[int]int{}, false},
+		{(*int)(nil), false},
+		{make(chan int), false},
+		{[1]interface{}{}, false},
+		{[0]string{}, true},
+		{struct{ S string }{}, false},
+	} {
+		tpe := reflect.TypeOf(tt.v)
+		if got := CanShallowCopy(reflect.ConvTypeToDPTpe(tpe)); got != tt.want {
+			t.Errorf("CanShallowCopy(%v) = %v, want %v", tpe, got, tt.want)
+		}
+	}
+}
+
+func copyTestFrame(a int8, s string, p []int, b int16) {}
+
+func TestDeepCopyStackFrame(t *testing.T) {
+	frame := struct {
+		a int8
+		s string
+		p []int
+		b int16
+	}{-1, "frame", []int{1, 2, 3}, 7}
+	ftpe := reflect.TypeOf(copyTestFrame)
+	size := int32(unsafe.Sizeof(frame))
+	cpy, err := DeepCopyStackFrame(size, (*uint8)(unsafe.Pointer(&frame)), ftpe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int32(len(cpy)) != size {
+		t.Fatalf("frame of %d bytes, want %d", len(cpy), size)
+	}
+	in := FrameArgs(ftpe, &cpy[0])
+	if in[0].Int() != -1 || in[1].String() != "frame" || !reflect.DeepEqual(in[2].Interface(), frame.p) || in[3].Int() != 7 {
+		t.Fatalf("copied frame holds %v", in)
+	}
+	c := (*struct {
+		a int8
+		s string
+		p []int
+	})(unsafe.Pointer(&cpy[0]))
+	if &c.p[0] == &frame.p[0] || (*reflect.StringHeader)(unsafe.Pointer(&c.s)).Data == (*reflect.StringHeader)(unsafe.Pointer(&frame.s)).Data {
+		t.Errorf("the copied frame shares memory with the original")
+	}
+}
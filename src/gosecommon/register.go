@@ -0,0 +1,223 @@
+package gosecommon
+
+import (
+	"reflect"
+	r "runtime"
+	"sync"
+	"unsafe"
+)
+
+// The type word of an interface belongs to the domain that created its value.
+// The copy translates it by the name of the concrete type, which the program
+// and its enclaves register. The untrusted side hands the table of its type
+// words to an enclave when it loads it, see CrossTypes.
+
+var registry struct {
+	sync.RWMutex
+	types map[string]reflect.Type
+	names map[reflect.Type]string
+}
+
+// Register records the concrete type of value, so that an interface holding a
+// value of that type can cross the enclave boundary. The program and its
+// enclaves must register the same types, e.g., in an init function of the
+// package of the gosecure targets: an enclave only knows the types registered
+// by the untrusted side before it was loaded. Register panics if two types
+// have the same name.
+func Register(value interface{}) {
+	t := reflect.TypeOf(value)
+	if t == nil {
+		panic("gosecommon: Register of a nil value")
+	}
+	name := typeName(t)
+	registry.Lock()
+	defer registry.Unlock()
+	if o, ok := registry.types[name]; ok && o != t {
+		panic("gosecommon: registering " + t.String() + " under the name " + name + " of " + o.String())
+	}
+	if registry.types == nil {
+		registry.types = make(map[string]reflect.Type)
+		registry.names = make(map[reflect.Type]string)
+	}
+	registry.types[name] = t
+	registry.names[t] = name
+}
+
+// typeName returns the name of t in every domain: the path of its package
+// and its name, or its description if it has no name.
+func typeName(t reflect.Type) string {
+	switch {
+	case t.Name() == "" && t.Kind() == reflect.Ptr:
+		return "*" + typeName(t.Elem())
+	case t.Name() == "" || t.PkgPath() == "":
+		return t.String()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+// registered returns the name under which t is registered.
+func registered(t reflect.Type) (string, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	name, ok := registry.names[t]
+	return name, ok
+}
+
+// registeredType returns the type registered under name.
+func registeredType(name string) (reflect.Type, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	t, ok := registry.types[name]
+	return t, ok
+}
+
+// crossType is a type registered by the untrusted side, with its type words:
+// typ for the empty interfaces, and the itabs of the interfaces that it
+// implements and that can cross the boundary.
+type crossType struct {
+	name  string
+	typ   unsafe.Pointer
+	itabs []crossItab
+}
+
+// crossItab is the type word of a crossType for the interface named iface.
+type crossItab struct {
+	iface string
+	tab   unsafe.Pointer
+}
+
+type crossTypes []crossType
+
+// CrossTypes returns the table of the types registered by the untrusted side,
+// for an enclave that runs the gosecure targets, see
+// runtime.CooperativeRuntime. The interfaces that can cross the boundary are
+// reachable from the arguments and results of the targets, or from the
+// registered types.
+func CrossTypes(targets []interface{}) unsafe.Pointer {
+	ifaces := make(map[reflect.Type]bool)
+	seen := make(map[reflect.Type]bool)
+	for _, f := range targets {
+		ft := reflect.TypeOf(f)
+		for i := 0; i < ft.NumIn(); i++ {
+			interfacesOf(ft.In(i), ifaces, seen)
+		}
+		for i := 0; i < ft.NumOut(); i++ {
+			interfacesOf(ft.Out(i), ifaces, seen)
+		}
+	}
+	registry.RLock()
+	defer registry.RUnlock()
+	for t := range registry.names {
+		interfacesOf(t, ifaces, seen)
+	}
+	table := make(crossTypes, 0, len(registry.names))
+	for t, name := range registry.names {
+		ct := crossType{name: name, typ: typeWord(emptyType, t)}
+		for i := range ifaces {
+			if t.Implements(i) {
+				ct.itabs = append(ct.itabs, crossItab{typeName(i), typeWord(i, t)})
+			}
+		}
+		table = append(table, ct)
+	}
+	return unsafe.Pointer(&table)
+}
+
+// interfacesOf records in ifaces the non-empty interfaces reachable from t.
+// The types in seen are already explored.
+func interfacesOf(t reflect.Type, ifaces, seen map[reflect.Type]bool) {
+	if seen[t] {
+		return
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			ifaces[t] = true
+		}
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Chan:
+		interfacesOf(t.Elem(), ifaces, seen)
+	case reflect.Map:
+		interfacesOf(t.Key(), ifaces, seen)
+		interfacesOf(t.Elem(), ifaces, seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			interfacesOf(t.Field(i).Type, ifaces, seen)
+		}
+	}
+}
+
+var emptyType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// typeWord returns the type word of an interface of type iface that holds a
+// value of type t, in this domain.
+func typeWord(iface, t reflect.Type) unsafe.Pointer {
+	v := reflect.New(iface).Elem()
+	v.Set(reflect.Zero(t))
+	return (*riface)(unsafe.Pointer(v.UnsafeAddr())).tab
+}
+
+// remoteTypes returns the table of the types of the untrusted side in the
+// enclave, nil outside of it.
+func remoteTypes() *crossTypes {
+	if !r.IsEnclave() {
+		return nil
+	}
+	if r.Cooprt.Types == nil {
+		return &crossTypes{}
+	}
+	return (*crossTypes)(r.Cooprt.Types)
+}
+
+// dynType returns the concrete type, in this domain, of the value of the
+// non-nil interface of type tpe at src, or why it cannot cross the boundary.
+// If remote is set, the type word is that of the untrusted side.
+func dynType(src uintptr, tpe reflect.Type, remote *crossTypes) (reflect.Type, *CopyError) {
+	if remote == nil {
+		dyn := reflect.NewAt(tpe, unsafe.Pointer(src)).Elem().Elem().Type()
+		if _, ok := registered(dyn); !ok {
+			return nil, &CopyError{dyn.String() + " cannot cross the enclave boundary, see Register"}
+		}
+		return dyn, nil
+	}
+	typ := (*riface)(unsafe.Pointer(src)).tab
+	if tpe.NumMethod() != 0 {
+		// The type follows the interface in an itab.
+		typ = *(*unsafe.Pointer)(unsafe.Pointer(uintptr(typ) + unsafe.Sizeof(typ)))
+	}
+	for _, t := range *remote {
+		if t.typ != typ {
+			continue
+		}
+		if dyn, ok := registeredType(t.name); ok {
+			return dyn, nil
+		}
+		return nil, &CopyError{t.name + " is not registered in the enclave, see Register"}
+	}
+	return nil, &CopyError{tpe.String() + " holds a type that is not registered outside of the enclave, see Register"}
+}
+
+// remoteWord returns the type word, for the untrusted side, of an interface
+// of type tpe that holds a value of type dyn, or why there is none.
+func remoteWord(tpe, dyn reflect.Type, remote *crossTypes) (unsafe.Pointer, *CopyError) {
+	name, ok := registered(dyn)
+	if !ok {
+		return nil, &CopyError{dyn.String() + " cannot cross the enclave boundary, see Register"}
+	}
+	for _, t := range *remote {
+		if t.name != name {
+			continue
+		}
+		if tpe.NumMethod() == 0 {
+			return t.typ, nil
+		}
+		iname := typeName(tpe)
+		for _, i := range t.itabs {
+			if i.iface == iname {
+				return i.tab, nil
+			}
+		}
+		return nil, &CopyError{name + " as " + tpe.String() + " is unknown outside of the enclave"}
+	}
+	return nil, &CopyError{name + " is not registered outside of the enclave, see Register"}
+}
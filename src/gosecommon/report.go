@@ -0,0 +1,133 @@
+package gosecommon
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// Sizes of the SGX attestation structures (ref 38.15 and 38.16).
+const (
+	ReportDataSize = 64
+	ReportBodySize = 384
+	ReportSize     = 432
+	TargetInfoSize = 512
+)
+
+// Attributes mirrors sgx_attributes_t.
+type Attributes struct {
+	Flags uint64
+	Xfrm  uint64
+}
+
+// TargetInfo is the SGX TARGETINFO structure. It identifies the enclave that
+// is able to verify a report, e.g., the quoting enclave.
+type TargetInfo struct {
+	MrEnclave  [32]uint8
+	Attributes Attributes
+	Reserved1  [4]uint8
+	MiscSelect uint32
+	Reserved2  [456]uint8
+}
+
+// ReportBody is the part of the SGX REPORT that is covered by the MAC.
+type ReportBody struct {
+	CpuSvn     [16]uint8
+	MiscSelect uint32
+	Reserved1  [28]uint8
+	Attributes Attributes
+	MrEnclave  [32]uint8
+	Reserved2  [32]uint8
+	MrSigner   [32]uint8
+	Reserved3  [96]uint8
+	IsvProdID  uint16
+	IsvSvn     uint16
+	Reserved4  [60]uint8
+	ReportData [ReportDataSize]uint8
+}
+
+// Report is the SGX REPORT structure produced by EREPORT.
+type Report struct {
+	Body  ReportBody
+	KeyID [32]uint8
+	Mac   [16]uint8
+}
+
+// Bytes returns the little endian encoding of the target info.
+func (t *TargetInfo) Bytes() []byte {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, t); err != nil {
+		panic(err.Error())
+	}
+	return buf.Bytes()
+}
+
+// Bytes returns the little endian encoding of the report body.
+func (b *ReportBody) Bytes() []byte {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, b); err != nil {
+		panic(err.Error())
+	}
+	return buf.Bytes()
+}
+
+// Bytes returns the little endian encoding of the report, i.e., the layout
+// written by EREPORT.
+func (r *Report) Bytes() []byte {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, r); err != nil {
+		panic(err.Error())
+	}
+	return buf.Bytes()
+}
+
+// ParseReport decodes a report from its EREPORT layout.
+func ParseReport(b []byte) (*Report, bool) {
+	if len(b) != ReportSize {
+		return nil, false
+	}
+	r := &Report{}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, r); err != nil {
+		return nil, false
+	}
+	return r, true
+}
+
+// The simulation mode has no access to EREPORT. The report is instead
+// MACed with a key that is derived from the target's MRENCLAVE, so that
+// reports are deterministic and can be checked by the target.
+var simReportLabel = []byte("gosec-sim-report-key")
+
+// SimReportKey returns the simulated report key of the enclave with
+// measurement target.
+func SimReportKey(target [32]uint8) []byte {
+	h := sha256.New()
+	h.Write(simReportLabel)
+	h.Write(target[:])
+	return h.Sum(nil)
+}
+
+// SimReportMac computes the MAC of a simulated report body for target.
+func SimReportMac(body *ReportBody, target [32]uint8) [16]uint8 {
+	var res [16]uint8
+	m := hmac.New(sha256.New, SimReportKey(target))
+	m.Write(body.Bytes())
+	copy(res[:], m.Sum(nil))
+	return res
+}
+
+// SimReport builds the report EREPORT would produce for an enclave with the
+// given body, targeted at ti.
+func SimReport(body ReportBody, ti *TargetInfo) Report {
+	r := Report{Body: body}
+	r.Mac = SimReportMac(&r.Body, ti.MrEnclave)
+	return r
+}
+
+// VerifySimReport checks that r was produced in simulation mode for the
+// enclave with measurement target.
+func VerifySimReport(r *Report, target [32]uint8) bool {
+	mac := SimReportMac(&r.Body, target)
+	return hmac.Equal(mac[:], r.Mac[:])
+}
@@ -0,0 +1,184 @@
+package gosecommon
+
+import (
+	"fmt"
+	"reflect"
+	r "runtime"
+	"unsafe"
+)
+
+// A gosecure expression evaluates to a channel of a struct with the results
+// R0, ..., Rn-1 of its call and an error Err. A result of an interface type
+// crosses the boundary if the type of its value is registered, see Register.
+// The enclave replies with the panic of the call, or the reason why its
+// arguments or results cannot cross, as a string, and the error is created
+// outside, when the reply is forwarded.
+
+// PanicError is the error of a gosecure expression whose call panicked.
+type PanicError struct {
+	Value string // the value passed to panic, formatted with %v.
+}
+
+func (e *PanicError) Error() string {
+	return "gosecure: panic: " + e.Value
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// ResultType returns the element type of the channel of a gosecure
+// expression that calls a function of type ftpe.
+func ResultType(ftpe reflect.Type) reflect.Type {
+	fields := make([]reflect.StructField, 0, ftpe.NumOut()+1)
+	for i := 0; i < ftpe.NumOut(); i++ {
+		fields = append(fields, reflect.StructField{Name: fmt.Sprintf("R%d", i), Type: ftpe.Out(i)})
+	}
+	fields = append(fields, reflect.StructField{Name: "Err", Type: errorType})
+	return reflect.StructOf(fields)
+}
+
+// ReplyType returns the type of the reply of the enclave to a gosecure
+// expression whose channel has element type rtpe.
+func ReplyType(rtpe reflect.Type) reflect.Type {
+	return reflect.StructOf([]reflect.StructField{
+		{Name: "Res", Type: rtpe},
+		{Name: "Panic", Type: reflect.TypeOf("")},
+		{Name: "Panicked", Type: reflect.TypeOf(false)},
+		{Name: "Uncopied", Type: reflect.TypeOf("")},
+	})
+}
+
+// FrameArgs returns copies of the arguments of a call to a function of type
+// ftpe, laid out at argp as in the stack frame of the call.
+func FrameArgs(ftpe reflect.Type, argp *uint8) []reflect.Value {
+	in := make([]reflect.Value, ftpe.NumIn())
+	off := uintptr(0)
+	for i := range in {
+		t := ftpe.In(i)
+		a := uintptr(t.Align())
+		off = (off + a - 1) &^ (a - 1)
+		in[i] = reflect.New(t).Elem()
+		in[i].Set(reflect.NewAt(t, unsafe.Pointer(uintptr(unsafe.Pointer(argp))+off)).Elem())
+		off += t.Size()
+	}
+	return in
+}
+
+// ScannedFrame returns a copy of the frame buf of a call to a function of type
+// ftpe, in memory that the collector scans: the untrusted side keeps what the
+// arguments refer to alive until the enclave copied them.
+func ScannedFrame(ftpe reflect.Type, buf []uint8) []uint8 {
+	if len(buf) == 0 {
+		return buf
+	}
+	frame := frameOf(int32(len(buf)), ftpe)
+	copy(frame, buf)
+	// The typed copies record the pointers of the arguments for the collector.
+	off := uintptr(0)
+	for i := 0; i < ftpe.NumIn(); i++ {
+		t := ftpe.In(i)
+		a := uintptr(t.Align())
+		off = (off + a - 1) &^ (a - 1)
+		if t.Size() == 0 {
+			continue
+		}
+		src := reflect.NewAt(t, unsafe.Pointer(&buf[off])).Elem()
+		reflect.NewAt(t, unsafe.Pointer(&frame[off])).Elem().Set(src)
+		off += t.Size()
+	}
+	return frame
+}
+
+// Reply calls f with in and sends its results, or its panic, on the channel
+// res returned by Forward. Results that cannot cross the boundary are
+// replaced by their CopyError.
+func Reply(f reflect.Value, in []reflect.Value, res unsafe.Pointer) {
+	rtpe := ReplyType(ResultType(f.Type()))
+	reply := reflect.New(rtpe).Elem()
+	call(f, in, reply)
+	out := replyChan(rtpe, res)
+	if err := trySend(out, reply); err != nil {
+		out.Send(uncopied(rtpe, err))
+	}
+}
+
+// ReplyError sends err, the error of the arguments of a call to a function of
+// type ftpe, on the channel res returned by Forward.
+func ReplyError(ftpe reflect.Type, err error, res unsafe.Pointer) {
+	rtpe := ReplyType(ResultType(ftpe))
+	replyChan(rtpe, res).Send(uncopied(rtpe, err.(*CopyError)))
+}
+
+// replyChan returns res, the channel of the replies of type rtpe.
+func replyChan(rtpe reflect.Type, res unsafe.Pointer) reflect.Value {
+	r.SetChanType(uintptr(res), reflect.ConvTypeToDPTpe(rtpe))
+	return reflect.NewAt(reflect.ChanOf(reflect.BothDir, rtpe), unsafe.Pointer(&res)).Elem()
+}
+
+// uncopied returns the reply of type rtpe for the CopyError err.
+func uncopied(rtpe reflect.Type, err *CopyError) reflect.Value {
+	reply := reflect.New(rtpe).Elem()
+	reply.Field(3).SetString(err.Reason)
+	return reply
+}
+
+// trySend sends v on ch, and returns the error of the send if v cannot cross
+// the boundary, see CheckCopy.
+func trySend(ch, v reflect.Value) (err *CopyError) {
+	defer func() {
+		if p := recover(); p != nil {
+			e, ok := p.(*CopyError)
+			if !ok {
+				panic(p)
+			}
+			err = e
+		}
+	}()
+	ch.Send(v)
+	return nil
+}
+
+// call calls f with in and stores its results, or its panic, in reply.
+func call(f reflect.Value, in []reflect.Value, reply reflect.Value) {
+	defer func() {
+		if p := recover(); p != nil {
+			reply.Field(1).SetString(fmt.Sprint(p))
+			reply.Field(2).SetBool(true)
+		}
+	}()
+	var out []reflect.Value
+	if f.Type().IsVariadic() {
+		out = f.CallSlice(in)
+	} else {
+		out = f.Call(in)
+	}
+	for i, v := range out {
+		reply.Field(0).Field(i).Set(v)
+	}
+}
+
+// Forward returns the channel on which the enclave replies to a gosecure
+// expression whose results are expected on res. A goroutine forwards the
+// reply to res, which it keeps alive until then, and turns a panic into a
+// PanicError, and arguments or results that cannot cross into a CopyError.
+func Forward(res unsafe.Pointer) unsafe.Pointer {
+	rtpe := reflect.ConvDPTpeToType(r.ChanElemType(uintptr(res)))
+	out := reflect.NewAt(reflect.ChanOf(reflect.BothDir, rtpe), unsafe.Pointer(&res)).Elem()
+	reply := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, ReplyType(rtpe)), 0)
+	go func() {
+		v, _ := reply.Recv()
+		result := reflect.New(rtpe).Elem()
+		result.Set(v.Field(0))
+		var err error
+		switch {
+		case v.Field(2).Bool():
+			err = &PanicError{v.Field(1).String()}
+		case v.Field(3).String() != "":
+			err = &CopyError{v.Field(3).String()}
+		}
+		if err != nil {
+			result.Field(rtpe.NumField() - 1).Set(reflect.ValueOf(err))
+		}
+		out.Send(result)
+	}()
+	return unsafe.Pointer(reply.Pointer())
+}
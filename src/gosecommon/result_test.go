@@ -0,0 +1,217 @@
+package gosecommon
+
+import (
+	"bytes"
+	"internal/testenv"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	r "runtime"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func resultTestDiv(a int8, b int64, s string) (int64, string) {
+	return int64(a) / b, s + "!"
+}
+
+type resultTestChan = chan struct {
+	R0  int64
+	R1  string
+	Err error
+}
+
+func TestResultType(t *testing.T) {
+	// The compiler gives the same type to the gosecure expression.
+	got := ResultType(reflect.TypeOf(resultTestDiv))
+	want := reflect.TypeOf(resultTestChan(nil)).Elem()
+	if got != want {
+		t.Fatalf("ResultType = %v, want %v", got, want)
+	}
+}
+
+func TestFrameArgs(t *testing.T) {
+	// The arguments are laid out like the fields of a struct.
+	frame := struct {
+		a int8
+		b int64
+		s string
+	}{-9, 2, "x"}
+	in := FrameArgs(reflect.TypeOf(resultTestDiv), (*uint8)(unsafe.Pointer(&frame)))
+	frame.s = "changed"
+	if len(in) != 3 || in[0].Int() != -9 || in[1].Int() != 2 || in[2].String() != "x" {
+		t.Fatalf("FrameArgs = %v", in)
+	}
+}
+
+func TestScannedFrame(t *testing.T) {
+	// The frame is copied in memory that the collector does not scan.
+	frame := &struct {
+		a int8
+		b int64
+		s string
+	}{-9, 2, strings.Repeat("x", 100)}
+	buf := make([]uint8, unsafe.Sizeof(*frame))
+	copy(buf, (*[unsafe.Sizeof(*frame)]uint8)(unsafe.Pointer(frame))[:])
+	frame = nil
+	scanned := ScannedFrame(reflect.TypeOf(resultTestDiv), buf)
+	for i := range buf {
+		buf[i] = 0
+	}
+	buf = nil
+	for i := 0; i < 3; i++ {
+		r.GC()
+		_ = strings.Repeat("y", 100)
+	}
+	in := FrameArgs(reflect.TypeOf(resultTestDiv), &scanned[0])
+	if in[0].Int() != -9 || in[1].Int() != 2 || in[2].String() != strings.Repeat("x", 100) {
+		t.Fatalf("ScannedFrame = %v", in)
+	}
+}
+
+func TestReplyForward(t *testing.T) {
+	f := reflect.ValueOf(resultTestDiv)
+	for _, tt := range []struct {
+		b     int64
+		r0    int64
+		panic bool
+	}{
+		{b: 2, r0: -4},
+		{b: 0, panic: true},
+	} {
+		in := []reflect.Value{reflect.ValueOf(int8(-9)), reflect.ValueOf(tt.b), reflect.ValueOf("x")}
+		res := make(resultTestChan, 1)
+		go Reply(f, in, Forward(*(*unsafe.Pointer)(unsafe.Pointer(&res))))
+		r := <-res
+		if tt.panic {
+			if e, ok := r.Err.(*PanicError); !ok || e.Value != "runtime error: integer divide by zero" {
+				t.Errorf("b=%d: Err = %v, want a PanicError", tt.b, r.Err)
+			}
+			continue
+		}
+		if r.Err != nil || r.R0 != tt.r0 || r.R1 != "x!" {
+			t.Errorf("b=%d: got %+v, want {%d x! <nil>}", tt.b, r, tt.r0)
+		}
+	}
+}
+
+func TestReplyError(t *testing.T) {
+	res := make(resultTestChan, 1)
+	err := &CopyError{"func() cannot cross the enclave boundary"}
+	ReplyError(reflect.TypeOf(resultTestDiv), err, Forward(*(*unsafe.Pointer)(unsafe.Pointer(&res))))
+	if r := <-res; r.R0 != 0 || r.R1 != "" || r.Err == nil || r.Err.Error() != err.Error() {
+		t.Errorf("got %+v, want the CopyError %q", r, err)
+	}
+}
+
+const crossingProgram = `package main
+
+import (
+	"errors"
+	"gosecommon"
+	"strconv"
+)
+
+type codeError struct{ code int }
+
+func (e *codeError) Error() string { return "code " + strconv.Itoa(e.code) }
+
+func init() {
+	gosecommon.Register(&codeError{})
+}
+
+func fail(n int) (int, error) {
+	if n == 0 {
+		return 0, errors.New("unregistered")
+	}
+	return n, &codeError{n}
+}
+
+func send(out chan error) {
+	defer func() {
+		println("send", recover().(error).Error())
+		out <- &codeError{2}
+	}()
+	out <- errors.New("unregistered")
+}
+
+func echo(err error) error { return err }
+
+func recv(in chan error, out chan string) {
+	func() {
+		defer func() {
+			out <- recover().(error).Error()
+		}()
+		err := <-in
+		out <- err.Error()
+	}()
+	out <- (<-in).Error()
+}
+
+func main() {
+	r := <-gosecure fail(1)
+	println("fail", r.R0, r.R1.Error(), r.Err == nil)
+	r = <-gosecure fail(0)
+	println("fail", r.R0, r.R1 == nil, r.Err.Error())
+	out := make(chan error)
+	gosecure send(out)
+	println("send", (<-out).Error())
+	e := <-gosecure echo(errors.New("arg"))
+	println("echo", e.R0 == nil, e.Err.Error())
+	in, res := make(chan error), make(chan string)
+	gosecure recv(in, res)
+	in <- errors.New("unregistered")
+	println("recv", <-res)
+	in <- &codeError{3}
+	println("recv", <-res)
+}
+`
+
+// TestCrossingSimulation checks that values of unregistered types fail the
+// gosecure call, or the send or the receive of the enclave, without killing
+// it.
+func TestCrossingSimulation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs an enclave")
+	}
+	testenv.MustHaveGoBuild(t)
+	dir, err := ioutil.TempDir("", "gosecommon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, exe := filepath.Join(dir, "main.go"), filepath.Join(dir, "main")
+	if err := ioutil.WriteFile(src, []byte(crossingProgram), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(testenv.GoToolPath(t), "build", "-o", exe, src).CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), "SIM=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("%v\n%s", err, stderr.Bytes())
+	}
+	const (
+		unregistered = "gosecommon: *errors.errorString cannot cross the enclave boundary, see Register"
+		outside      = "gosecommon: error holds a type that is not registered outside of the enclave, see Register"
+	)
+	for _, want := range []string{
+		"fail 1 code 1 true",
+		"fail 0 true " + unregistered,
+		"send " + unregistered,
+		"send code 2",
+		"echo true " + outside,
+		"recv " + outside,
+		"recv code 3",
+	} {
+		if !strings.Contains(stderr.String(), want+"\n") {
+			t.Errorf("missing %q in\n%s", want, stderr.Bytes())
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package gosecommon
+
+import "strings"
+
+// routes maps the packages of the program annotated with //gosec:enclave to
+// the name of their enclave, in the form "pkg=name;pkg=name". go build sets it
+// with the -X flag of the linker, in the program and in its enclaves. The
+// packages are in the form of the symbols of the program.
+var routes string
+
+// EnclaveOf returns the name of the enclave that runs the function fname,
+// as named by runtime.FuncForPC, or "" for the default enclave.
+func EnclaveOf(fname string) string {
+	return enclaveOf(routes, fname)
+}
+
+func enclaveOf(routes, fname string) string {
+	var pkg, name string
+	for _, r := range strings.Split(routes, ";") {
+		i := strings.Index(r, "=")
+		if i < 0 || len(r[:i]) <= len(pkg) {
+			continue
+		}
+		// The functions of pkg, and not of a package in a directory
+		// pkg.x: the rest of the name has no slash.
+		p := r[:i]
+		if strings.HasPrefix(fname, p+".") && !strings.Contains(fname[len(p)+1:], "/") {
+			pkg, name = p, r[i+1:]
+		}
+	}
+	return name
+}
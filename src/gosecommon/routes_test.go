@@ -0,0 +1,28 @@
+package gosecommon
+
+import "testing"
+
+func TestEnclaveOf(t *testing.T) {
+	const routes = "main=app;keys=keys;example.com/x/keys=xkeys;example.com/x/keys/v%2e2=v2"
+	for _, tt := range []struct {
+		fname, enclave string
+	}{
+		{"main.main.func1", "app"},
+		{"keys.Sign", "keys"},
+		{"keys.(*Signer).Sign", "keys"},
+		{"keys.Sign.func2", "keys"},
+		{"keysx.Sign", ""},
+		{"example.com/x/keys.Sign", "xkeys"},
+		{"example.com/x/keys/v%2e2.Sign", "v2"},
+		{"example.com/x/keys/other.Sign", ""},
+		{"example.com/x/keys.T.Method-fm", "xkeys"},
+		{"fmt.Println", ""},
+	} {
+		if got := enclaveOf(routes, tt.fname); got != tt.enclave {
+			t.Errorf("enclaveOf(%q) = %q, want %q", tt.fname, got, tt.enclave)
+		}
+	}
+	if got := enclaveOf("", "keys.Sign"); got != "" {
+		t.Errorf("without routes, enclaveOf = %q, want the default enclave", got)
+	}
+}
@@ -0,0 +1,169 @@
+package gosecommon
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+)
+
+// Key names and policies for EGETKEY (ref 38.18 KEYREQUEST).
+const (
+	KeyNameSeal = 0x4
+
+	KeyPolicyMrEnclave = 0x1
+	KeyPolicyMrSigner  = 0x2
+
+	KeyRequestSize = 512
+	SealKeySize    = 16
+
+	// Default masks used by the Intel SDK for sealing.
+	sealFlagsMask = uint64(0xFF0000000000000B)
+	sealMiscMask  = uint32(0xF0000000)
+
+	sealNonceSize = 12
+)
+
+// KeyRequest is the SGX KEYREQUEST structure passed to EGETKEY.
+type KeyRequest struct {
+	KeyName       uint16
+	KeyPolicy     uint16
+	IsvSvn        uint16
+	Reserved1     uint16
+	CpuSvn        [16]uint8
+	AttributeMask Attributes
+	KeyID         [32]uint8
+	MiscMask      uint32
+	Reserved2     [436]uint8
+}
+
+// NewSealKeyRequest returns the request for a seal key bound to policy.
+// The caller provides its own svns and a fresh keyid.
+func NewSealKeyRequest(policy uint16, isvsvn uint16, cpusvn [16]uint8, keyid [32]uint8) (*KeyRequest, error) {
+	if policy != KeyPolicyMrEnclave && policy != KeyPolicyMrSigner {
+		return nil, errors.New("gosecommon: invalid seal policy")
+	}
+	req := &KeyRequest{}
+	req.KeyName = KeyNameSeal
+	req.KeyPolicy = policy
+	req.IsvSvn = isvsvn
+	req.CpuSvn = cpusvn
+	req.AttributeMask.Flags = sealFlagsMask
+	req.KeyID = keyid
+	req.MiscMask = sealMiscMask
+	return req, nil
+}
+
+// Bytes returns the little endian encoding of the key request.
+func (k *KeyRequest) Bytes() []byte {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, k); err != nil {
+		panic(err.Error())
+	}
+	return buf.Bytes()
+}
+
+var simSealLabel = []byte("gosec-sim-seal-key")
+
+// SimSealKey derives the seal key EGETKEY would return for req in an enclave
+// with identity id. The machine secret replaces the fused CPU keys.
+func SimSealKey(secret [32]uint8, id *runtime.EnclaveIdentity, req *KeyRequest) ([SealKeySize]uint8, error) {
+	var key [SealKeySize]uint8
+	if req.KeyName != KeyNameSeal {
+		return key, errors.New("gosecommon: invalid key name")
+	}
+	if req.IsvSvn > id.IsvSvn {
+		return key, errors.New("gosecommon: invalid isvsvn in key request")
+	}
+	m := hmac.New(sha256.New, secret[:])
+	m.Write(simSealLabel)
+	switch req.KeyPolicy {
+	case KeyPolicyMrEnclave:
+		m.Write(id.MrEnclave[:])
+	case KeyPolicyMrSigner:
+		m.Write(id.MrSigner[:])
+	default:
+		return key, errors.New("gosecommon: invalid key policy")
+	}
+	ids := make([]byte, 4)
+	binary.LittleEndian.PutUint16(ids, id.IsvProdID)
+	binary.LittleEndian.PutUint16(ids[2:], req.IsvSvn)
+	m.Write(ids)
+	m.Write(req.CpuSvn[:])
+	m.Write(req.KeyID[:])
+	copy(key[:], m.Sum(nil))
+	return key, nil
+}
+
+// A sealed blob has the following layout:
+// key request (512 bytes) | nonce (12 bytes) | aad length (4 bytes) | aad | ciphertext + tag
+// Everything before the ciphertext is authenticated.
+const sealHeaderSize = KeyRequestSize + sealNonceSize + 4
+
+// SealData encrypts plaintext with key, that was obtained for req.
+func SealData(req *KeyRequest, key [SealKeySize]uint8, plaintext, aad []byte, rand io.Reader) ([]byte, error) {
+	gcm, err := newSealGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	blob := make([]byte, sealHeaderSize, sealHeaderSize+len(aad)+len(plaintext)+gcm.Overhead())
+	copy(blob, req.Bytes())
+	if _, err := io.ReadFull(rand, blob[KeyRequestSize:KeyRequestSize+sealNonceSize]); err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(blob[KeyRequestSize+sealNonceSize:], uint32(len(aad)))
+	blob = append(blob, aad...)
+	nonce := blob[KeyRequestSize : KeyRequestSize+sealNonceSize]
+	return gcm.Seal(blob, nonce, plaintext, blob), nil
+}
+
+// ParseSealedKeyRequest returns the key request stored in a sealed blob.
+func ParseSealedKeyRequest(blob []byte) (*KeyRequest, error) {
+	if len(blob) < sealHeaderSize {
+		return nil, errors.New("gosecommon: sealed blob too short")
+	}
+	req := &KeyRequest{}
+	err := binary.Read(bytes.NewReader(blob[:KeyRequestSize]), binary.LittleEndian, req)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// UnsealData decrypts a blob produced by SealData with the key obtained for
+// its key request. It returns the plaintext and the additional data.
+func UnsealData(blob []byte, key [SealKeySize]uint8) ([]byte, []byte, error) {
+	if len(blob) < sealHeaderSize {
+		return nil, nil, errors.New("gosecommon: sealed blob too short")
+	}
+	gcm, err := newSealGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aadlen := binary.LittleEndian.Uint32(blob[KeyRequestSize+sealNonceSize:])
+	if uint64(aadlen) > uint64(len(blob)-sealHeaderSize) {
+		return nil, nil, errors.New("gosecommon: invalid aad length in sealed blob")
+	}
+	end := sealHeaderSize + int(aadlen)
+	nonce := blob[KeyRequestSize : KeyRequestSize+sealNonceSize]
+	plaintext, err := gcm.Open(nil, nonce, blob[end:], blob[:end])
+	if err != nil {
+		return nil, nil, errors.New("gosecommon: unable to unseal blob")
+	}
+	aad := make([]byte, aadlen)
+	copy(aad, blob[sealHeaderSize:end])
+	return plaintext, aad, nil
+}
+
+func newSealGCM(key [SealKeySize]uint8) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
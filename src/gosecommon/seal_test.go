@@ -0,0 +1,81 @@
+package gosecommon
+
+import (
+	"bytes"
+	"crypto/rand"
+	"runtime"
+	"testing"
+)
+
+func sealTestIdentity() *runtime.EnclaveIdentity {
+	id := &runtime.EnclaveIdentity{IsvProdID: 1, IsvSvn: 2}
+	id.MrEnclave[0] = 0xaa
+	id.MrSigner[0] = 0xbb
+	return id
+}
+
+func sealTestKey(t *testing.T, id *runtime.EnclaveIdentity, policy uint16) (*KeyRequest, [SealKeySize]uint8) {
+	req, err := NewSealKeyRequest(policy, id.IsvSvn, [16]uint8{}, [32]uint8{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := SimSealKey([32]uint8{7}, id, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req, key
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	id := sealTestIdentity()
+	req, key := sealTestKey(t, id, KeyPolicyMrEnclave)
+	blob, err := SealData(req, key, []byte("secret"), []byte("label"), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := ParseSealedKeyRequest(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, err := SimSealKey([32]uint8{7}, id, r2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, aad, err := UnsealData(blob, key2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pt, []byte("secret")) || !bytes.Equal(aad, []byte("label")) {
+		t.Fatalf("got %q %q", pt, aad)
+	}
+	// Any modification of the blob, including the aad, must be detected.
+	for _, i := range []int{0, sealHeaderSize, len(blob) - 1} {
+		bad := append([]byte(nil), blob...)
+		bad[i] ^= 1
+		if _, _, err := UnsealData(bad, key2); err == nil {
+			t.Errorf("tampering at byte %d not detected", i)
+		}
+	}
+}
+
+func TestSealPolicy(t *testing.T) {
+	id := sealTestIdentity()
+	_, kenc := sealTestKey(t, id, KeyPolicyMrEnclave)
+	_, ksig := sealTestKey(t, id, KeyPolicyMrSigner)
+	if kenc == ksig {
+		t.Fatal("mrenclave and mrsigner keys are equal")
+	}
+	// Another enclave from the same signer gets the same mrsigner key only.
+	other := sealTestIdentity()
+	other.MrEnclave[0] = 0xcc
+	_, kenc2 := sealTestKey(t, other, KeyPolicyMrEnclave)
+	_, ksig2 := sealTestKey(t, other, KeyPolicyMrSigner)
+	if kenc == kenc2 || ksig != ksig2 {
+		t.Fatal("unexpected key binding")
+	}
+	// Requesting a key for a newer svn must fail.
+	req, _ := NewSealKeyRequest(KeyPolicyMrSigner, id.IsvSvn+1, [16]uint8{}, [32]uint8{})
+	if _, err := SimSealKey([32]uint8{7}, id, req); err == nil {
+		t.Fatal("key for a higher isvsvn was derived")
+	}
+}
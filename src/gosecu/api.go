@@ -5,12 +5,13 @@ import (
 	"gosecommon"
 	"reflect"
 	"runtime"
+	"sync/atomic"
 	"unsafe"
 )
 
 // Slice of gosecure targets.
 var (
-	secureMap map[string]func(size int32, argp *uint8)
+	secureMap map[string]func(call runtime.EcallReq)
 )
 
 func freeServer() {
@@ -20,6 +21,40 @@ func freeServer() {
 	}
 }
 
+// traceServer starts and stops the tracer of the enclave for the untrusted
+// side.
+func traceServer() {
+	t := runtime.Cooprt.Trace
+	for start := range t.Ctl {
+		if !start {
+			runtime.StopTrace()
+			continue
+		}
+		if err := runtime.StartTrace(); err != nil {
+			panic("gosecu: " + err.Error())
+		}
+		go traceReader(t)
+	}
+}
+
+// traceReader hands the trace of the enclave to the untrusted side, chunk by
+// chunk, until the tracer is stopped.
+func traceReader(t *runtime.EnclaveTrace) {
+	for {
+		data := runtime.ReadTrace()
+		if data == nil {
+			t.Data <- 0
+			return
+		}
+		for len(data) > 0 {
+			n := copy(t.Buf, data)
+			data = data[n:]
+			t.Data <- n
+			<-t.Ack
+		}
+	}
+}
+
 // We cannot use reflect to get the value of the arguments. Instead, we give
 // a pointer to a buffer allocated inside the ecall attribute and use it to pass
 // the arguments from the stack frame.
@@ -27,9 +62,10 @@ func privateServer(c chan runtime.EcallReq) {
 	success := 0
 	for {
 		call := <-c
+		runtime.TraceEcall(&call)
 		if fn := secureMap[call.Name]; fn != nil {
 			success++
-			go fn(call.Siz, call.Argp)
+			go serve(fn, call)
 		} else {
 			panic("gosecu: illegal gosecure call.")
 		}
@@ -38,12 +74,27 @@ func privateServer(c chan runtime.EcallReq) {
 	panic("Closing the shit")
 }
 
+// serve runs the gosecure call with fn, and then counts it as completed, see
+// gosec.Shutdown.
+func serve(fn func(call runtime.EcallReq), call runtime.EcallReq) {
+	defer atomic.AddInt64(&runtime.Cooprt.Ecalls, -1)
+	fn(call)
+}
+
+// stopServer halts the enclave once the untrusted side closes Cooprt.Stop.
+func stopServer() {
+	<-runtime.Cooprt.Stop
+	runtime.HaltEnclave()
+}
+
 // EcallServer keeps polling the Cooprt.Ecall queue for incoming private ecall
 // server requests.
 func EcallServer() {
 	// Init the cross domain ref pointer for crossed routines.
 	//runtime.InitAllcg()
 	go freeServer()
+	go traceServer()
+	go stopServer()
 	for {
 		req := <-runtime.Cooprt.EcallSrv
 		if req == nil || req.PrivChan == nil {
@@ -55,13 +106,27 @@ func EcallServer() {
 	}
 }
 
+// RegisterSecureFunctions registers the targets of the gosecure keyword that
+// run in this enclave, see gosecommon.EnclaveOf. The enclave executable calls
+// it at the begining of its execution.
+func RegisterSecureFunctions() {
+	name := runtime.CurrentEnclaveLayout().Name
+	for _, f := range runtime.GosecureTargets() {
+		pc := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+		if pc != nil && gosecommon.EnclaveOf(pc.Name()) != name {
+			continue
+		}
+		RegisterSecureFunction(f)
+	}
+}
+
 // RegisterSecureFunction is called automatically at the begining of the enclave
 // execution, and registers all the functions that are a target of the gosecure
 // keyword.
 func RegisterSecureFunction(f interface{}) {
 	if secureMap == nil {
-		secureMap = make(map[string]func(size int32, argp *uint8))
-		runtime.SetCopiers(gosecommon.DeepCopier, gosecommon.DeepCopierSend, gosecommon.CanShallowCopy)
+		secureMap = make(map[string]func(call runtime.EcallReq))
+		runtime.SetCopiers(gosecommon.DeepCopier, gosecommon.DeepCopierSend, gosecommon.CheckCopy, gosecommon.CanShallowCopy)
 	}
 
 	ptr := reflect.ValueOf(f).Pointer()
@@ -73,14 +138,34 @@ func RegisterSecureFunction(f interface{}) {
 
 	//TODO @aghosn that will not be enough probably. Should have a pointer instead?
 	// or copy memory in a buffer inside the anonymous function?
-	secureMap[pc.Name()] = func(size int32, argp *uint8) {
-		// TODO deep copy the stack frame
-		if size == 0 {
-			runtime.Newproc(ptr, argp, size)
+	secureMap[pc.Name()] = func(call runtime.EcallReq) {
+		size, argp := call.Siz, call.Argp
+		fv := reflect.ValueOf(f)
+		if size != 0 {
+			sl, err := gosecommon.DeepCopyStackFrame(size, argp, fv.Type())
+			if err != nil {
+				// Only the call fails, a gosecure statement has no caller
+				// to report to.
+				if call.Res != nil {
+					gosecommon.ReplyError(fv.Type(), err, call.Res)
+				} else {
+					println("gosecu: gosecure call to", call.Name, "dropped:", err.Error())
+				}
+				return
+			}
+			argp = (*uint8)(unsafe.Pointer(&sl[0]))
+		}
+		in := gosecommon.FrameArgs(fv.Type(), argp)
+		// A gosecure expression expects the results on call.Res.
+		if call.Res != nil {
+			gosecommon.Reply(fv, in, call.Res)
 			return
 		}
-		sl := gosecommon.DeepCopyStackFrame(size, argp, reflect.ValueOf(f).Type())
-		argpcpy := (*uint8)(unsafe.Pointer(&sl[0]))
-		runtime.Newproc(ptr, argpcpy, size)
+		// The call runs in the goroutine of serve, which counts it.
+		if fv.Type().IsVariadic() {
+			fv.CallSlice(in)
+		} else {
+			fv.Call(in)
+		}
 	}
 }
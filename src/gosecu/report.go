@@ -0,0 +1,69 @@
+package gosecu
+
+import (
+	"encoding/binary"
+	"errors"
+	"gosecommon"
+	"runtime"
+	"unsafe"
+)
+
+// Alignments required by EREPORT for its operands.
+const (
+	_targetInfoAlign = 512
+	_reportDataAlign = 128
+	_reportAlign     = 512
+)
+
+// asm_ereport executes ENCLU[EREPORT], defined in report_amd64.s
+func asm_ereport(tinfo, rdata, report uintptr)
+
+// Report produces an SGX REPORT for the calling enclave. The report is
+// targeted at the enclave described by targetInfo, e.g., the quoting enclave,
+// and binds the caller provided userData.
+// In simulation mode, the report is derived from the identity computed by the
+// loader and can be checked with gosecommon.VerifySimReport.
+func Report(targetInfo gosecommon.TargetInfo, userData [gosecommon.ReportDataSize]byte) (gosecommon.Report, error) {
+	if !runtime.IsEnclave() {
+		return gosecommon.Report{}, errors.New("gosecu: Report called outside of the enclave")
+	}
+	if runtime.IsSimulation() {
+		return gosecommon.SimReport(identityBody(userData), &targetInfo), nil
+	}
+
+	ti := alignedBuf(gosecommon.TargetInfoSize, _targetInfoAlign)
+	copy(ti, targetInfo.Bytes())
+	rdata := alignedBuf(gosecommon.ReportDataSize, _reportDataAlign)
+	copy(rdata, userData[:])
+	rep := alignedBuf(gosecommon.ReportSize, _reportAlign)
+	asm_ereport(uintptr(unsafe.Pointer(&ti[0])), uintptr(unsafe.Pointer(&rdata[0])),
+		uintptr(unsafe.Pointer(&rep[0])))
+
+	r, ok := gosecommon.ParseReport(rep)
+	if !ok {
+		return gosecommon.Report{}, errors.New("gosecu: malformed report")
+	}
+	return *r, nil
+}
+
+// identityBody fills a report body with the identity registered by the loader.
+func identityBody(userData [gosecommon.ReportDataSize]byte) gosecommon.ReportBody {
+	id := &runtime.Cooprt.Identity
+	body := gosecommon.ReportBody{}
+	body.MrEnclave = id.MrEnclave
+	body.MrSigner = id.MrSigner
+	body.Attributes.Flags = binary.LittleEndian.Uint64(id.Attributes[:8])
+	body.Attributes.Xfrm = binary.LittleEndian.Uint64(id.Attributes[8:])
+	body.IsvProdID = id.IsvProdID
+	body.IsvSvn = id.IsvSvn
+	body.ReportData = userData
+	return body
+}
+
+// alignedBuf returns a buffer of size bytes whose address is a multiple of
+// align. The enclave heap does not move objects.
+func alignedBuf(size, align int) []byte {
+	b := make([]byte, size+align)
+	off := int(uintptr(align)-uintptr(unsafe.Pointer(&b[0]))%uintptr(align)) % align
+	return b[off : off+size]
+}
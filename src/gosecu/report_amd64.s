@@ -0,0 +1,10 @@
+#include "textflag.h"
+
+// func asm_ereport(tinfo, rdata, report uintptr)
+TEXT gosecu·asm_ereport(SB),NOSPLIT,$0-24
+	MOVQ $0, AX				//EREPORT
+	MOVQ tinfo+0(FP), BX
+	MOVQ rdata+8(FP), CX
+	MOVQ report+16(FP), DX
+	BYTE $0x0f; BYTE $0x01; BYTE $0xd7 //ENCLU EREPORT
+	RET
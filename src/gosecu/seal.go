@@ -0,0 +1,110 @@
+package gosecu
+
+import (
+	"crypto/rand"
+	"errors"
+	"gosecommon"
+	"io"
+	"runtime"
+	"strconv"
+	"unsafe"
+)
+
+// Seal policies, i.e., which part of the enclave identity the key is bound to.
+const (
+	SealToMrEnclave = gosecommon.KeyPolicyMrEnclave
+	SealToMrSigner  = gosecommon.KeyPolicyMrSigner
+)
+
+// Alignments required by EGETKEY for its operands.
+const (
+	_keyRequestAlign = 512
+	_keyAlign        = 16
+)
+
+// asm_egetkey executes ENCLU[EGETKEY], defined in seal_amd64.s
+func asm_egetkey(keyreq, key uintptr) uint64
+
+// Seal encrypts plaintext with an AES-GCM key bound to the enclave identity.
+// With SealToMrEnclave, only the same enclave can unseal the blob.
+// With SealToMrSigner, any enclave from the same signer and product with an
+// equal or higher ISVSVN can unseal it.
+// aad is authenticated and stored in clear in the blob.
+// In simulation, the machine secret named by GOSEC_SIM_SECRET replaces the
+// keys of the CPU, and Seal fails without it.
+func Seal(policy uint16, plaintext, aad []byte) ([]byte, error) {
+	if !runtime.IsEnclave() {
+		return nil, errors.New("gosecu: Seal called outside of the enclave")
+	}
+	var keyid [32]uint8
+	if _, err := io.ReadFull(rand.Reader, keyid[:]); err != nil {
+		return nil, err
+	}
+	isvsvn, cpusvn, err := selfSvns()
+	if err != nil {
+		return nil, err
+	}
+	req, err := gosecommon.NewSealKeyRequest(policy, isvsvn, cpusvn, keyid)
+	if err != nil {
+		return nil, err
+	}
+	key, err := getKey(req)
+	if err != nil {
+		return nil, err
+	}
+	return gosecommon.SealData(req, key, plaintext, aad, rand.Reader)
+}
+
+// Unseal decrypts a blob produced by Seal. It returns the plaintext and the
+// additional authenticated data.
+func Unseal(blob []byte) ([]byte, []byte, error) {
+	if !runtime.IsEnclave() {
+		return nil, nil, errors.New("gosecu: Unseal called outside of the enclave")
+	}
+	req, err := gosecommon.ParseSealedKeyRequest(blob)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := getKey(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return gosecommon.UnsealData(blob, key)
+}
+
+// selfSvns returns the ISVSVN and CPUSVN of the enclave. On hardware they are
+// obtained from a report targeted at ourselves.
+func selfSvns() (uint16, [16]uint8, error) {
+	if runtime.IsSimulation() {
+		return runtime.Cooprt.Identity.IsvSvn, [16]uint8{}, nil
+	}
+	r, err := Report(gosecommon.TargetInfo{}, [gosecommon.ReportDataSize]uint8{})
+	if err != nil {
+		return 0, [16]uint8{}, err
+	}
+	return r.Body.IsvSvn, r.Body.CpuSvn, nil
+}
+
+// getKey returns the key for req, from EGETKEY or derived from the loader's
+// identity and machine secret in simulation mode.
+func getKey(req *gosecommon.KeyRequest) ([gosecommon.SealKeySize]uint8, error) {
+	var key [gosecommon.SealKeySize]uint8
+	if runtime.IsSimulation() {
+		if runtime.Cooprt.SimSealSecret == ([32]uint8{}) {
+			return key, errors.New("gosecu: no machine secret to seal in simulation, set GOSEC_SIM_SECRET")
+		}
+		return gosecommon.SimSealKey(runtime.Cooprt.SimSealSecret, &runtime.Cooprt.Identity, req)
+	}
+	kr := alignedBuf(gosecommon.KeyRequestSize, _keyRequestAlign)
+	copy(kr, req.Bytes())
+	out := alignedBuf(gosecommon.SealKeySize, _keyAlign)
+	if ret := asm_egetkey(uintptr(unsafe.Pointer(&kr[0])), uintptr(unsafe.Pointer(&out[0]))); ret != 0 {
+		return key, errors.New("gosecu: EGETKEY failed with error " + strconv.FormatUint(ret, 10))
+	}
+	copy(key[:], out)
+	// Do not leave the key around in the heap.
+	for i := range out {
+		out[i] = 0
+	}
+	return key, nil
+}
@@ -0,0 +1,10 @@
+#include "textflag.h"
+
+// func asm_egetkey(keyreq, key uintptr) uint64
+TEXT gosecu·asm_egetkey(SB),NOSPLIT,$0-24
+	MOVQ $1, AX				//EGETKEY
+	MOVQ keyreq+0(FP), BX
+	MOVQ key+8(FP), CX
+	BYTE $0x0f; BYTE $0x01; BYTE $0xd7 //ENCLU EGETKEY
+	MOVQ AX, ret+16(FP)
+	RET
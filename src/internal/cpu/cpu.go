@@ -11,6 +11,7 @@ var X86 x86
 // The booleans in x86 contain the correspondingly named cpuid feature bit.
 // HasAVX and HasAVX2 are only set if the OS does support XMM and YMM registers
 // in addition to the cpuid feature bit being set.
+// They are all false in the enclave, which cannot execute cpuid.
 // The struct is padded to avoid false sharing.
 type x86 struct {
 	_            [CacheLineSize]byte
@@ -25,6 +26,8 @@ type x86 struct {
 	HasOSXSAVE   bool
 	HasPCLMULQDQ bool
 	HasPOPCNT    bool
+	HasRDRAND    bool
+	HasRDSEED    bool
 	HasSSE2      bool
 	HasSSE3      bool
 	HasSSSE3     bool
@@ -0,0 +1,95 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+)
+
+// EnclaveID is added to the ids of the goroutines and of the stacks of the
+// enclave in a trace merged by ParseEnclave, so that they do not collide with
+// the ones of the program.
+const EnclaveID = 1 << 40
+
+// ParseEnclave parses the trace of a program in r and the trace of its
+// enclave, taken at the same time (see gosec.StartTrace), in enclave, and
+// merges them. The events of the enclave are marked Enclave, and the gosecure
+// calls of the program are linked to their dispatch in the enclave.
+func ParseEnclave(r, enclave io.Reader, bin string) (ParseResult, error) {
+	res, err := Parse(r, bin)
+	if err != nil {
+		return ParseResult{}, err
+	}
+	encl, err := Parse(enclave, "")
+	if err != nil {
+		return ParseResult{}, fmt.Errorf("enclave trace: %v", err)
+	}
+	return mergeEnclave(res, encl), nil
+}
+
+// mergeEnclave merges the trace of the enclave encl into the one of the
+// program res.
+func mergeEnclave(res, encl ParseResult) ParseResult {
+	// Both traces count the same cpu ticks: make them start with the
+	// first one.
+	start := res.ticksStart
+	if encl.ticksStart < start {
+		start = encl.ticksStart
+	}
+	shift := func(r ParseResult) {
+		d := int64(float64(r.ticksStart-start) * r.tickNs)
+		for _, ev := range r.Events {
+			ev.Ts += d
+		}
+	}
+	shift(res)
+	shift(encl)
+
+	stacks := make(map[uint64][]*Frame, len(res.Stacks)+len(encl.Stacks))
+	for id, stk := range res.Stacks {
+		stacks[id] = stk
+	}
+	for id, stk := range encl.Stacks {
+		stacks[id+EnclaveID] = stk
+	}
+
+	ecalls := make(map[uint64]*Event)
+	for _, ev := range res.Events {
+		if ev.Type == EvGosecEcall {
+			ecalls[ev.Args[0]] = ev
+		}
+	}
+	for _, ev := range encl.Events {
+		ev.Enclave = true
+		if ev.G != 0 {
+			ev.G += EnclaveID
+		}
+		if ev.StkID != 0 {
+			ev.StkID += EnclaveID
+		}
+		switch ev.Type {
+		case EvGoCreate:
+			ev.Args[0] += EnclaveID
+			ev.Args[1] += EnclaveID
+		case EvGoStart, EvGoStartLabel, EvGoUnblock, EvGoSysExit, EvGoWaiting, EvGoInSyscall:
+			ev.Args[0] += EnclaveID
+		case EvGosecDispatch:
+			if ecall := ecalls[ev.Args[0]]; ecall != nil {
+				ecall.Link = ev
+			}
+		}
+	}
+
+	// Both lists are sorted by time.
+	events := make([]*Event, 0, len(res.Events)+len(encl.Events))
+	i, j := 0, 0
+	for i < len(res.Events) || j < len(encl.Events) {
+		if j == len(encl.Events) || i < len(res.Events) && res.Events[i].Ts <= encl.Events[j].Ts {
+			events = append(events, res.Events[i])
+			i++
+		} else {
+			events = append(events, encl.Events[j])
+			j++
+		}
+	}
+	return ParseResult{Events: events, Stacks: stacks, ticksStart: start, tickNs: res.tickNs}
+}
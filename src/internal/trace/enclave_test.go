@@ -0,0 +1,71 @@
+package trace
+
+import (
+	"testing"
+)
+
+// newWriter110 returns a test trace writer for the 1.10 format, which has the
+// gosec events.
+func newWriter110() *Writer {
+	w := new(Writer)
+	w.Write([]byte("go 1.10 trace\x00\x00\x00"))
+	return w
+}
+
+func (w *Writer) emitString(id uint64, s string) {
+	w.Emit(EvString, id, uint64(len(s)))
+	w.WriteString(s)
+}
+
+func TestParseEnclave(t *testing.T) {
+	host := newWriter110()
+	host.Emit(EvBatch, 0, 1000)
+	host.Emit(EvFrequency, 1e9)
+	host.emitString(1, "main.f")
+	host.Emit(EvGosecEcall, 10, 1, 1, 0)
+
+	encl := newWriter110()
+	encl.Emit(EvBatch, 0, 500)
+	encl.Emit(EvFrequency, 1e9)
+	encl.emitString(1, "main.f")
+	encl.Emit(EvGoCreate, 1, 1, 0, 0)
+	encl.Emit(EvGoStart, 1, 1, 1)
+	encl.Emit(EvGosecDispatch, 600, 1, 1)
+	encl.Emit(EvGosecOcall, 10, 1, 202)
+	encl.Emit(EvGosecOcallDone, 20)
+
+	res, err := ParseEnclave(host, encl, "")
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	var types []byte
+	for i, ev := range res.Events {
+		if i > 0 && ev.Ts < res.Events[i-1].Ts {
+			t.Errorf("event %v at %v before event %v at %v", i, ev.Ts, i-1, res.Events[i-1].Ts)
+		}
+		types = append(types, ev.Type)
+	}
+	want := []byte{EvGoCreate, EvGoStart, EvGosecEcall, EvGosecDispatch, EvGosecOcall, EvGosecOcallDone}
+	if string(types) != string(want) {
+		t.Fatalf("got events %v, want %v", types, want)
+	}
+	create, ecall, dispatch, ocall := res.Events[0], res.Events[2], res.Events[3], res.Events[4]
+	if ecall.Enclave || !dispatch.Enclave || !create.Enclave {
+		t.Errorf("wrong domains: ecall %v, dispatch %v, create %v", ecall.Enclave, dispatch.Enclave, create.Enclave)
+	}
+	if create.Ts != 0 || ecall.Ts != 1010-501 || dispatch.Ts != 1102-501 {
+		t.Errorf("got times %v, %v, %v, want 0, 509, 601", create.Ts, ecall.Ts, dispatch.Ts)
+	}
+	if ecall.Link != dispatch {
+		t.Errorf("the ecall is not linked to its dispatch")
+	}
+	if ecall.SArgs[0] != "main.f" || dispatch.SArgs[0] != "main.f" {
+		t.Errorf("got names %q and %q, want main.f", ecall.SArgs, dispatch.SArgs)
+	}
+	if dispatch.G != EnclaveID+1 || create.Args[0] != EnclaveID+1 {
+		t.Errorf("got enclave goroutine %v created as %v, want %v", dispatch.G, create.Args[0], EnclaveID+1)
+	}
+	if ocall.Link == nil || ocall.Link.Ts-ocall.Ts != 20 {
+		t.Errorf("the ocall is not linked to its reply")
+	}
+}
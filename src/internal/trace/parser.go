@@ -55,7 +55,12 @@ type Event struct {
 	// for blocking GoSysCall: the associated GoSysExit
 	// for GoSysExit: the next GoStart
 	// for GCMarkAssistStart: the associated GCMarkAssistDone
+	// for GosecOcall: the associated GosecOcallDone
+	// for GosecEcall: the GosecDispatch in the enclave (see ParseEnclave)
 	Link *Event
+	// Enclave is set for the events of the enclave in a trace merged
+	// by ParseEnclave.
+	Enclave bool
 }
 
 // Frame is a frame in stack traces.
@@ -81,6 +86,11 @@ type ParseResult struct {
 	Events []*Event
 	// Stacks is the stack traces keyed by stack IDs from the trace.
 	Stacks map[uint64][]*Frame
+
+	// The timestamp 0 is at the cpu tick ticksStart, and a tick lasts
+	// tickNs nanoseconds, to merge traces.
+	ticksStart int64
+	tickNs     float64
 }
 
 // Parse parses, post-processes and verifies the trace.
@@ -102,7 +112,7 @@ func parse(r io.Reader, bin string) (int, ParseResult, error) {
 	if err != nil {
 		return 0, ParseResult{}, err
 	}
-	events, stacks, err := parseEvents(ver, rawEvents, strings)
+	events, stacks, ticksStart, tickNs, err := parseEvents(ver, rawEvents, strings)
 	if err != nil {
 		return 0, ParseResult{}, err
 	}
@@ -125,7 +135,7 @@ func parse(r io.Reader, bin string) (int, ParseResult, error) {
 			return 0, ParseResult{}, err
 		}
 	}
-	return ver, ParseResult{Events: events, Stacks: stacks}, nil
+	return ver, ParseResult{Events: events, Stacks: stacks, ticksStart: ticksStart, tickNs: tickNs}, nil
 }
 
 // rawEvent is a helper type used during parsing.
@@ -290,7 +300,7 @@ func parseHeader(buf []byte) (int, error) {
 
 // Parse events transforms raw events into events.
 // It does analyze and verify per-event-type arguments.
-func parseEvents(ver int, rawEvents []rawEvent, strings map[uint64]string) (events []*Event, stacks map[uint64][]*Frame, err error) {
+func parseEvents(ver int, rawEvents []rawEvent, strings map[uint64]string) (events []*Event, stacks map[uint64][]*Frame, minTs int64, freq float64, err error) {
 	var ticksPerSec, lastSeq, lastTs int64
 	var lastG uint64
 	var lastP int
@@ -396,6 +406,8 @@ func parseEvents(ver int, rawEvents []rawEvent, strings map[uint64]string) (even
 				if raw.typ == EvGoStartLabel {
 					e.SArgs = []string{strings[e.Args[2]]}
 				}
+			case EvGosecEcall, EvGosecDispatch:
+				e.SArgs = []string{strings[e.Args[1]]}
 			case EvGCSTWStart:
 				e.G = 0
 				switch e.Args[0] {
@@ -448,9 +460,9 @@ func parseEvents(ver int, rawEvents []rawEvent, strings map[uint64]string) (even
 	}
 
 	// Translate cpu ticks to real time.
-	minTs := events[0].Ts
+	minTs = events[0].Ts
 	// Use floating point to avoid integer overflows.
-	freq := 1e9 / float64(ticksPerSec)
+	freq = 1e9 / float64(ticksPerSec)
 	for _, ev := range events {
 		ev.Ts = int64(float64(ev.Ts-minTs) * freq)
 		// Move timers and syscalls to separate fake Ps.
@@ -541,6 +553,7 @@ func postProcessTrace(ver int, events []*Event) error {
 		evStart      *Event
 		evCreate     *Event
 		evMarkAssist *Event
+		evOcall      *Event
 	}
 	type pdesc struct {
 		running bool
@@ -637,6 +650,14 @@ func postProcessTrace(ver int, events []*Event) error {
 				g.evMarkAssist.Link = ev
 				g.evMarkAssist = nil
 			}
+		case EvGosecOcall:
+			g.evOcall = ev
+		case EvGosecOcallDone:
+			// The request may be sent before tracing starts.
+			if g.evOcall != nil {
+				g.evOcall.Link = ev
+				g.evOcall = nil
+			}
 		case EvGCSweepDone:
 			if p.evSweep == nil {
 				return fmt.Errorf("bogus sweeping end (offset %v, time %v)", ev.Off, ev.Ts)
@@ -967,7 +988,14 @@ const (
 	EvGoBlockGC         = 42 // goroutine blocks on GC assist [timestamp, stack]
 	EvGCMarkAssistStart = 43 // GC mark assist start [timestamp, stack]
 	EvGCMarkAssistDone  = 44 // GC mark assist done [timestamp]
-	EvCount             = 45
+	EvGosecEcall        = 45 // gosecure call sent to the enclave [timestamp, seq, name string id, stack]
+	EvGosecDispatch     = 46 // gosecure call dispatched in the enclave [timestamp, seq, name string id]
+	EvGosecOcall        = 47 // enclave request to the untrusted side [timestamp, type, trap]
+	EvGosecOcallDone    = 48 // reply to the enclave request [timestamp]
+	EvGosecCrossWakeup  = 49 // goroutine of the other domain made ready [timestamp, enclave, stack]
+	EvGosecMigrate      = 50 // goroutines made ready by the other domain are queued [timestamp, count]
+	EvGosecSpawn        = 51 // enclave thread spawned [timestamp, tcs id]
+	EvCount             = 52
 )
 
 var EventDescriptions = [EvCount]struct {
@@ -1021,4 +1049,11 @@ var EventDescriptions = [EvCount]struct {
 	EvGoBlockGC:         {"GoBlockGC", 1008, true, []string{}},
 	EvGCMarkAssistStart: {"GCMarkAssistStart", 1009, true, []string{}},
 	EvGCMarkAssistDone:  {"GCMarkAssistDone", 1009, false, []string{}},
+	EvGosecEcall:        {"GosecEcall", 1010, true, []string{"seq", "name"}},
+	EvGosecDispatch:     {"GosecDispatch", 1010, false, []string{"seq", "name"}},
+	EvGosecOcall:        {"GosecOcall", 1010, false, []string{"type", "trap"}},
+	EvGosecOcallDone:    {"GosecOcallDone", 1010, false, []string{}},
+	EvGosecCrossWakeup:  {"GosecCrossWakeup", 1010, true, []string{"enclave"}},
+	EvGosecMigrate:      {"GosecMigrate", 1010, false, []string{"count"}},
+	EvGosecSpawn:        {"GosecSpawn", 1010, false, []string{"tcs"}},
 }
@@ -19,3 +19,9 @@ func ConvDPTpeToType(tpe *r.DPTpe) Type {
 	rtpe := (*rtype)(u.Pointer(tpe))
 	return rtpe
 }
+
+// IfaceIndir reports whether an interface holding a value of type tpe stores
+// a pointer to the value rather than the value itself.
+func IfaceIndir(tpe Type) bool {
+	return ifaceIndir(tpe.(*rtype))
+}
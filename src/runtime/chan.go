@@ -177,6 +177,10 @@ func chansend(c *hchan, ep unsafe.Pointer, block bool, callerpc uintptr) bool {
 		return false
 	}
 
+	if err := checkSend(c, ep); err != nil {
+		panic(err)
+	}
+
 	var t0 int64
 	if blockprofilerate > 0 {
 		t0 = cputicks()
@@ -318,7 +322,7 @@ func send(c *hchan, sg *sudog, ep unsafe.Pointer, unlockf func(), skip int) {
 		if !isEnclave && sg.releasetime != 0 {
 			sg.releasetime = cputicks()
 		}
-		Cooprt.crossGoready(sg)
+		cooprtOf(sg).crossGoready(sg, true)
 		return
 	}
 
@@ -383,6 +387,8 @@ func closechan(c *hchan) {
 	c.closed = 1
 
 	var glist *g
+	// @aghosn sudogs from the other domain, chained through schednext.
+	var crosslist *sudog
 
 	// release all readers
 	for {
@@ -397,6 +403,11 @@ func closechan(c *hchan) {
 		if sg.releasetime != 0 {
 			sg.releasetime = cputicks()
 		}
+		if !isReschedulable(sg) {
+			sg.schednext.set(crosslist)
+			crosslist = sg
+			continue
+		}
 		gp := sg.g
 		gp.param = nil
 		if raceenabled {
@@ -416,6 +427,11 @@ func closechan(c *hchan) {
 		if sg.releasetime != 0 {
 			sg.releasetime = cputicks()
 		}
+		if !isReschedulable(sg) {
+			sg.schednext.set(crosslist)
+			crosslist = sg
+			continue
+		}
 		gp := sg.g
 		gp.param = nil
 		if raceenabled {
@@ -426,6 +442,14 @@ func closechan(c *hchan) {
 	}
 	unlock(&c.lock)
 
+	// Cross-domain routines are put back one by one, as their targets differ.
+	for crosslist != nil {
+		sg := crosslist
+		crosslist = crosslist.schednext.ptr()
+		sg.schednext = 0
+		cooprtOf(sg).crossGoready(sg, false)
+	}
+
 	// Ready all Gs now that we've dropped the channel lock.
 	for glist != nil {
 		gp := glist
@@ -576,15 +600,21 @@ func chanrecv(c *hchan, ep unsafe.Pointer, block bool) (selected, received bool)
 		blockevent(mysg.releasetime-t0, 2)
 	}
 	// perform a deep copy
+	var err error
 	if mysg.needcpy {
-		doCopy(mysg, ep, c)
+		err = doCopy(mysg, ep, c)
 	}
 
-	closed := gp.param == nil
+	// A cross-domain wakeup cannot set gp.param, needcpy tells us a value
+	// was received.
+	closed := gp.param == nil && !mysg.needcpy
 	gp.param = nil
 	mysg.c = nil
 
 	crossReleaseSudog(mysg, c.elemsize)
+	if err != nil {
+		panic(err)
+	}
 	return true, !closed
 }
 
@@ -635,7 +665,7 @@ func recv(c *hchan, sg *sudog, ep unsafe.Pointer, unlockf func(), skip int) {
 		c.sendx = c.recvx // c.sendx = (c.sendx+1) % c.dataqsiz
 	}
 	// Do we need to copy
-	doCopy(sg, ep, c)
+	err := doCopy(sg, ep, c)
 
 	sg.elem = nil
 	if !isReschedulable(sg) {
@@ -644,17 +674,19 @@ func recv(c *hchan, sg *sudog, ep unsafe.Pointer, unlockf func(), skip int) {
 		if sg.releasetime != 0 {
 			sg.releasetime = cputicks()
 		}
-		Cooprt.crossGoready(sg)
-		return
+		cooprtOf(sg).crossGoready(sg, true)
+	} else {
+		gp := sg.g
+		unlockf()
+		gp.param = unsafe.Pointer(sg)
+		if sg.releasetime != 0 {
+			sg.releasetime = cputicks()
+		}
+		goready(gp, skip+1)
 	}
-
-	gp := sg.g
-	unlockf()
-	gp.param = unsafe.Pointer(sg)
-	if sg.releasetime != 0 {
-		sg.releasetime = cputicks()
+	if err != nil {
+		panic(err)
 	}
-	goready(gp, skip+1)
 }
 
 // compiler implements
@@ -792,8 +824,10 @@ func (q *waitq) dequeue() *sudog {
 		// We use a flag in the G struct to tell us when someone
 		// else has won the race to signal this goroutine but the goroutine
 		// hasn't removed itself from the queue yet.
+		// @aghosn the flag is reached through the sudog, as cross-domain
+		// selects keep it in unsafe memory.
 		if sgp.isSelect {
-			if !atomic.Cas(&sgp.g.selectDone, 0, 1) {
+			if !atomic.Cas(sgp.selectdone, 0, 1) {
 				continue
 			}
 		}
@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"runtime/internal/sys"
 	"unsafe"
 )
 
@@ -13,12 +14,14 @@ type TrackerEntry struct {
 
 type AllocTracker = []TrackerEntry
 
-type CopyTpe func(unsafe.Pointer, *DPTpe) unsafe.Pointer
+type CopyTpe func(unsafe.Pointer, *DPTpe) (unsafe.Pointer, error)
 type CopyTpe2 func(unsafe.Pointer, *DPTpe) (unsafe.Pointer, AllocTracker)
+type CheckTpe func(unsafe.Pointer, *DPTpe) error
 
 var (
 	DeepCopier     CopyTpe
 	DeepCopierSend CopyTpe2
+	CheckCopier    CheckTpe
 	CanShallowCopy func(*DPTpe) bool
 )
 
@@ -27,9 +30,12 @@ func SetCopier(cp CopyTpe, csc func(*DPTpe) bool) {
 	CanShallowCopy = csc
 }
 
-func SetCopiers(cp CopyTpe, cp2 CopyTpe2, csc func(*DPTpe) bool) {
+// SetCopiers sets the copiers of the enclave. chk returns the error of a
+// value that cannot be copied out of the enclave by cp2.
+func SetCopiers(cp CopyTpe, cp2 CopyTpe2, chk CheckTpe, csc func(*DPTpe) bool) {
 	DeepCopier = cp
 	DeepCopierSend = cp2
+	CheckCopier = chk
 	CanShallowCopy = csc
 }
 
@@ -42,15 +48,18 @@ func copyIn(size uintptr) uintptr {
 	return uintptr(unsafe.Pointer(&src[0]))
 }
 
-func doCopy(sg *sudog, dest unsafe.Pointer, c *hchan) {
+// doCopy replaces the value received at dest by a deep copy, and returns the
+// error of a value that cannot cross, which leaves dest zero. The caller
+// panics with it once it released the channel.
+func doCopy(sg *sudog, dest unsafe.Pointer, c *hchan) error {
 	notInit := (DeepCopier == nil || dest == nil)
 	missingInfo := isEnclave && c.encltpe == nil
 	rcvDirect := (isEnclave && sg.id == -1) || (!isEnclave && sg.id != -1)
 	if notInit || missingInfo {
-		return
+		return nil
 	}
 	if !sg.needcpy && !rcvDirect {
-		return
+		return nil
 	}
 	// now you need to do a copy
 	tpe := c.elemtype
@@ -60,18 +69,33 @@ func doCopy(sg *sudog, dest unsafe.Pointer, c *hchan) {
 
 	// by default that's handled
 	if CanShallowCopy(tpe) {
-		return
+		return nil
 	}
-	//extract address
-	orig := *(*uintptr)(unsafe.Pointer(uintptr(dest)))
-
-	typedmemmove(tpe, dest, DeepCopier(dest, tpe))
-	// non-enclave does a non-direct recv
+	cpy, err := DeepCopier(dest, tpe)
+	if err != nil {
+		typedmemclr(tpe, dest)
+	} else {
+		typedmemmove(tpe, dest, cpy)
+	}
+	// non-enclave does a non-direct recv, the enclave frees the copy it
+	// registered for sg in sendCopy.
 	if !isEnclave && sg.id == -1 {
+		orig, c := uintptr(unsafe.Pointer(sg)), cooprtOf(sg)
 		go func() {
-			Cooprt.Uach <- uintptr(orig)
+			c.Uach <- orig
 		}()
 	}
+	return err
+}
+
+// checkSend returns the error of the value at ep if it cannot be copied out
+// of the enclave on c. The send checks it before it locks c, and panics with
+// it like a send on a closed channel, see sendCopy.
+func checkSend(c *hchan, ep unsafe.Pointer) error {
+	if !isEnclave || c.isencl || c.encltpe == nil || ep == nil || CheckCopier == nil || CanShallowCopy(c.encltpe) {
+		return nil
+	}
+	return CheckCopier(ep, c.encltpe)
 }
 
 func sendCopy(dest *sudog, src unsafe.Pointer, c *hchan) bool {
@@ -84,7 +108,91 @@ func sendCopy(dest *sudog, src unsafe.Pointer, c *hchan) bool {
 		return false
 	}
 	r, tracker := DeepCopierSend(src, c.encltpe)
-	UnsafeAllocator.registerTracker(tracker)
+	UnsafeAllocator.registerTracker(uintptr(unsafe.Pointer(dest)), tracker)
 	typedmemmove(c.encltpe, dest.elem, r)
 	return true
 }
+
+// MapEntries returns the keys and the elements of the entries of the map h of
+// type t. It reads the buckets without hashing the keys: h can be a map of the
+// other domain, whose hash differs, see gosecommon/copy.go.
+func MapEntries(t *DPTpe, h unsafe.Pointer) (keys, elems []unsafe.Pointer) {
+	mt := (*maptype)(unsafe.Pointer(t))
+	m := (*hmap)(h)
+	if m == nil || m.count == 0 {
+		return nil, nil
+	}
+	keys, elems = mapCells(mt, m.buckets, bucketShift(m.B), keys, elems)
+	if m.oldbuckets != nil {
+		keys, elems = mapCells(mt, m.oldbuckets, m.noldbuckets(), keys, elems)
+	}
+	return keys, elems
+}
+
+// mapCells appends to keys and elems the filled cells of the n buckets at
+// buckets, and of their overflow buckets. The cells of an evacuated bucket
+// are not filled, their entries are in the new buckets.
+func mapCells(t *maptype, buckets unsafe.Pointer, n uintptr, keys, elems []unsafe.Pointer) ([]unsafe.Pointer, []unsafe.Pointer) {
+	if buckets == nil {
+		return keys, elems
+	}
+	for i := uintptr(0); i < n; i++ {
+		for b := (*bmap)(add(buckets, i*uintptr(t.bucketsize))); b != nil; b = b.overflow(t) {
+			for j := uintptr(0); j < bucketCnt; j++ {
+				if b.tophash[j] < minTopHash {
+					continue
+				}
+				k := add(unsafe.Pointer(b), dataOffset+j*uintptr(t.keysize))
+				if t.indirectkey {
+					k = *(*unsafe.Pointer)(k)
+				}
+				v := add(unsafe.Pointer(b), dataOffset+bucketCnt*uintptr(t.keysize)+j*uintptr(t.valuesize))
+				if t.indirectvalue {
+					v = *(*unsafe.Pointer)(v)
+				}
+				keys, elems = append(keys, k), append(elems, v)
+			}
+		}
+	}
+	return keys, elems
+}
+
+// MapImage lays out a map of type t with n entries in memory from alloc, and
+// returns it with the cells of their keys and elements, to fill. The keys are
+// not hashed, they all are in the first bucket: the map can only be read with
+// MapEntries, by the domain that rebuilds it, see gosecommon/copy.go. The
+// pointers are stored without write barriers, alloc must not return memory
+// of the heap that the collector scans.
+func MapImage(t *DPTpe, n int, alloc func(size uintptr) unsafe.Pointer) (h unsafe.Pointer, keys, elems []unsafe.Pointer) {
+	mt := (*maptype)(unsafe.Pointer(t))
+	h = alloc(unsafe.Sizeof(hmap{}))
+	memclrNoHeapPointers(h, unsafe.Sizeof(hmap{}))
+	(*hmap)(h).count = n
+	link := unsafe.Pointer(&(*hmap)(h).buckets)
+	keys, elems = make([]unsafe.Pointer, n), make([]unsafe.Pointer, n)
+	for i := 0; i < n; i += bucketCnt {
+		b := (*bmap)(alloc(uintptr(mt.bucketsize)))
+		memclrNoHeapPointers(unsafe.Pointer(b), uintptr(mt.bucketsize))
+		*(*uintptr)(link) = uintptr(unsafe.Pointer(b))
+		link = add(unsafe.Pointer(b), uintptr(mt.bucketsize)-sys.PtrSize)
+		for j := 0; j < bucketCnt && i+j < n; j++ {
+			b.tophash[j] = minTopHash
+			k := add(unsafe.Pointer(b), dataOffset+uintptr(j)*uintptr(mt.keysize))
+			if mt.indirectkey {
+				p := alloc(mt.key.size)
+				memclrNoHeapPointers(p, mt.key.size)
+				*(*uintptr)(k) = uintptr(p)
+				k = p
+			}
+			v := add(unsafe.Pointer(b), dataOffset+bucketCnt*uintptr(mt.keysize)+uintptr(j)*uintptr(mt.valuesize))
+			if mt.indirectvalue {
+				p := alloc(mt.elem.size)
+				memclrNoHeapPointers(p, mt.elem.size)
+				*(*uintptr)(v) = uintptr(p)
+				v = p
+			}
+			keys[i+j], elems[i+j] = k, v
+		}
+	}
+	return h, keys, elems
+}
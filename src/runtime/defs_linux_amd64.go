@@ -13,11 +13,14 @@ const (
 	_PROT_WRITE = 0x2
 	_PROT_EXEC  = 0x4
 
-	_MAP_ANON    = 0x20
-	_MAP_PRIVATE = 0x2
-	_MAP_FIXED   = 0x10
+	_MAP_SHARED    = 0x1
+	_MAP_ANON      = 0x20
+	_MAP_PRIVATE   = 0x2
+	_MAP_FIXED     = 0x10
+	_MAP_NORESERVE = 0x4000
 
 	_MADV_DONTNEED   = 0x4
+	_MADV_REMOVE     = 0x9
 	_MADV_HUGEPAGE   = 0xe
 	_MADV_NOHUGEPAGE = 0xf
 
@@ -0,0 +1,69 @@
+// Export guts of the gosec support for testing.
+
+package runtime
+
+const SysChunkSize = sysChunkSize
+
+type SysPool struct {
+	p sysPool
+}
+
+func NewSysPool() *SysPool {
+	p := new(SysPool)
+	p.p.grow()
+	return p
+}
+
+func (p *SysPool) Acquire(onEmpty func()) (int, chan OcallRes) { return p.p.acquire(onEmpty) }
+func (p *SysPool) Release(id int)                              { p.p.release(id) }
+func (p *SysPool) Send(id int, r OcallRes)                     { p.p.lookup(id, "reply").c <- r }
+func (p *SysPool) Grow()                                       { p.p.grow() }
+func (p *SysPool) Len() int                                    { return int(p.p.nchunks) * sysChunkSize }
+
+// Uledger is an allocator of unsafe memory over a single region.
+type Uledger struct {
+	u uledger
+}
+
+func NewUledger(size uintptr) *Uledger {
+	p, err := mmap(nil, size, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_PRIVATE, -1, 0)
+	if err != 0 {
+		panic("mmap failed")
+	}
+	l := new(Uledger)
+	l.u.growsize = size
+	l.u.addRegion(uintptr(p), size)
+	l.u.nsites = 1
+	return l
+}
+
+func (l *Uledger) Malloc(size uintptr) uintptr { return l.u.malloc(size, getcallerpc()) }
+func (l *Uledger) Free(ptr, size uintptr)      { l.u.Free(ptr, size) }
+func (l *Uledger) Region() (start, size uintptr) {
+	return l.u.regions[0].start, l.u.regions[0].npages * _psize
+}
+
+func (l *Uledger) Sites() []UnsafeSite {
+	var sites []UnsafeSite
+	for i := 1; i < l.u.nsites; i++ {
+		st := &l.u.sites[i]
+		sites = append(sites, UnsafeSite{st.pc, st.allocs, st.frees, st.bytes, st.live, st.liveBytes})
+	}
+	return sites
+}
+
+// UnsafePool is the unsafe pool of the isolated simulation, over an address
+// range that it does not map.
+type UnsafePool struct {
+	u unsafePool
+}
+
+func NewUnsafePool(start, size uintptr) *UnsafePool {
+	p := new(UnsafePool)
+	p.u.init(start, size)
+	return p
+}
+
+func (p *UnsafePool) Alloc(n uintptr) uintptr { return p.u.alloc(n) }
+func (p *UnsafePool) Release(ptr, n uintptr)  { p.u.release(ptr, n) }
+func (p *UnsafePool) FreeRuns() int           { return p.u.nfree }
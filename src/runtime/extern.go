@@ -95,6 +95,9 @@ It is a comma-separated list of name=val pairs setting these named variables:
 		released: #  MB released to the system
 		consumed: #  MB allocated from the system
 
+	gosecleak: setting gosecleak=1 causes the enclave to report, when it exits,
+	the buffers of unsafe memory it did not free, per call site.
+
 	memprofilerate: setting memprofilerate=X will update the value of runtime.MemProfileRate.
 	When set to 0 memory profiling is disabled.  Refer to the description of
 	MemProfileRate for the default value.
@@ -1,9 +1,6 @@
 package runtime
 
-import (
-	"runtime/internal/atomic"
-	"unsafe"
-)
+import "unsafe"
 
 type SysType int
 
@@ -14,6 +11,7 @@ const (
 	RS6 SysType = 3
 	MAL SysType = 4
 	FRE SysType = 5
+	GRW SysType = 6 // more reply channels, see gosecpool.go.
 )
 
 // For epoll from the enclave.
@@ -33,11 +31,15 @@ type EcallServerReq struct {
 //Siz is the size of the argument buffer.
 //Argp all the arguments.
 //Buf an extra slice buffer.
+//Res the channel of the results for a gosecure expression, nil otherwise.
+//Seq numbers the call, to match it in the traces, see gosectrace.go.
 type EcallReq struct {
 	Name string
 	Siz  int32
 	Argp *uint8 //TODO @aghosn not sure about this one.
 	Buf  []uint8
+	Res  unsafe.Pointer
+	Seq  uint64
 }
 
 type OcallReq struct {
@@ -58,12 +60,6 @@ type OcallRes struct {
 	Err uintptr
 }
 
-type poolSysChan struct {
-	id        int
-	available uint32
-	c         chan OcallRes
-}
-
 // Request types for OExitRequest.
 const (
 	SpawnRequest       = uint64(1)
@@ -86,6 +82,7 @@ type OExitRequest struct {
 	Ns    int64   // nano sleep timeout
 	EWReq uintptr // address of the epoll request
 	EWRes uintptr // address of the epoll result
+	Eid   uint64  // the enclave of the requester, see NewCooperativeRuntime
 }
 
 //SgxTCSInfo describes a tcs related information, such as tls.
@@ -102,19 +99,30 @@ type SgxTCSInfo struct {
 	Used  bool
 }
 
+//EnclaveIdentity is the identity of the enclave as computed by the loader.
+//It replaces what EREPORT and EGETKEY provide in simulation mode.
+type EnclaveIdentity struct {
+	MrEnclave  [32]uint8
+	MrSigner   [32]uint8
+	Attributes [16]uint8
+	IsvProdID  uint16
+	IsvSvn     uint16
+}
+
 //CooperativeRuntime information and channels for runtime cooperation.
 type CooperativeRuntime struct {
 	EcallSrv chan *EcallServerReq
 	Ocall    chan OcallReq
+	Ring     *OcallRing // the switchless ocalls, nil if there are no workers.
 
 	argc int32
 	argv **byte
 
-	readyE slqueue //Ready to be rescheduled in the enclave
-	readyO slqueue //Ready to be rescheduled outside of the enclave
+	readyE slqueue  //Ready to be rescheduled in the enclave
+	readyO *slqueue //Ready to be rescheduled outside of the enclave, crossReadyO.
 
 	//pool of answer channels.
-	sysPool []*poolSysChan
+	sysPool sysPool
 
 	membuf_head uintptr
 
@@ -131,61 +139,131 @@ type CooperativeRuntime struct {
 	ExceptionHandler uint64
 
 	Uach chan uintptr
+
+	Trace *EnclaveTrace // drives the tracer of the enclave.
+	Crash *EnclaveCrash // the crash report of the enclave, see gosecrash.go.
+
+	Ecalls int64     // gosecure calls sent to the enclave and not completed.
+	Stop   chan bool // closed by the untrusted side to halt the enclave, see gosechalt.go.
+	halted uint32
+	gen    uint32 // numbers the cooperative runtimes, see GosecureSend.
+
+	Eid    uint64        // the index of the enclave in cooprts.
+	layout EnclaveLayout // the layout of the enclave, outside of it.
+
+	leakcheck bool // GODEBUG=gosecleak=1, see UnsafeLeakReport.
+
+	Identity      EnclaveIdentity // set by the loader once the enclave is measured.
+	SimSealSecret [32]uint8       // per-machine secret for sealing keys in simulation.
+
+	Types unsafe.Pointer // the types registered by the untrusted side, see gosecommon.CrossTypes.
 }
 
 const (
-	//TODO @aghosn this must be exactly the same as in amd64/obj.go
-	PSIZE       = 0x1000
-	ENCLMASK    = 0x040000000000
-	ENCLSIZE    = 0x001000000000
-	MMMASK      = 0x050000000000
-	MEMBUF_SIZE = uintptr(PSIZE * 1400)
+	// The layout of the enclave is in enclLayout, see gosecmanifest.go.
+	PSIZE  = 0x1000
+	MMMASK = 0x050000000000
 
 	SG_BUF_SIZE = 100 // size in bytes
 
-	POOL_INIT_SIZE = 5000 //Default size for the pools.
-
-	// TODO this must be the same as in the gosec package.
-	// Later move all of these within a separate package and share it.
-	MEMBUF_START = (ENCLMASK + ENCLSIZE - PSIZE - MEMBUF_SIZE)
-	_unsafeSize  = PSIZE * 500
+	// MaxEnclaves is the number of enclaves a program can load at once.
+	MaxEnclaves = 16
 )
 
 var (
 	UnsafeAllocator uledger // manages unsafe memory from the enclave.
 	workEnclave     uintptr // replica for the gc in unsafe memory
 	schedEnclave    uintptr // replica for notesleeps on the sched.
+	timersEnclave   uintptr // replica for the notesleeps of the timer goroutines.
+	cooprtGen       uint32  // the generation of the last cooperative runtime.
+
+	// The cooperative runtimes of the enclaves the program loaded, by Eid.
+	cooprts     [MaxEnclaves]*CooperativeRuntime
+	cooprtsLock mutex
+
+	// crossReadyO is the queue of the routines of the program made ready by
+	// the enclaves: all of them share it, see migrateCrossDomain. It is in
+	// the heap, which the isolated simulation shares with the enclaves.
+	crossReadyO *slqueue
 )
 
 // entry point for an ocall, defined in asm in runtime/asmsgx_amd64.s
 func sgx_ocall(trgt, args, nstk, rbp uintptr)
 
-//InitCooperativeRuntime initializes the global variable Cooprt.
-func InitCooperativeRuntime() {
-	if Cooprt != nil {
-		return
-	}
-
-	Cooprt = &CooperativeRuntime{}
-	Cooprt.EcallSrv = make(chan *EcallServerReq)
-	Cooprt.argc, Cooprt.argv = -1, argv
-	Cooprt.Ocall = make(chan OcallReq)
-	Cooprt.sysPool = make([]*poolSysChan, POOL_INIT_SIZE)
-	for i := range Cooprt.sysPool {
-		Cooprt.sysPool[i] = &poolSysChan{i, 1, make(chan OcallRes)}
+//NewCooperativeRuntime creates the cooperative runtime of an enclave with the
+//layout l, which the program loads. It throws if the program already loaded
+//MaxEnclaves enclaves.
+func NewCooperativeRuntime(l EnclaveLayout) *CooperativeRuntime {
+	if isEnclave {
+		throw("NewCooperativeRuntime in the enclave.")
 	}
-	Cooprt.membuf_head = uintptr(MEMBUF_START)
-	Cooprt.eHeap = 0
-	cprtQ = &(Cooprt.readyO)
+	c := &CooperativeRuntime{}
+	c.EcallSrv = make(chan *EcallServerReq)
+	c.argc, c.argv = -1, argv
+	c.Ocall = make(chan OcallReq)
+	c.sysPool.grow()
+	c.layout = l
+	c.membuf_head = l.membufStart()
+	c.eHeap = 0
 
 	//Allocate the unsafe zone for the enclave.
-	ptr, err := mmap(nil, _unsafeSize, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_PRIVATE, -1, 0)
+	ptr, err := MmapUnsafe(l.Unsafe)
 	if err != 0 {
 		panic("Error allocating the unsafe zone.")
 	}
-	Cooprt.StartUnsafe = uintptr(ptr)
-	Cooprt.SizeUnsafe = _unsafeSize
-	Cooprt.Uach = make(chan uintptr)
+	c.StartUnsafe = uintptr(ptr)
+	c.SizeUnsafe = l.Unsafe
+	c.Uach = make(chan uintptr)
+	c.Trace = newEnclaveTrace()
+	c.Crash = new(EnclaveCrash)
+	c.Stop = make(chan bool)
+	c.leakcheck = debug.gosecleak != 0
+
+	lock(&cooprtsLock)
+	cooprtGen++
+	c.gen = cooprtGen
+	c.Eid = MaxEnclaves
+	for i := range cooprts {
+		if cooprts[i] == nil {
+			c.Eid = uint64(i)
+			break
+		}
+	}
+	if c.Eid == MaxEnclaves {
+		unlock(&cooprtsLock)
+		throw("too many enclaves")
+	}
+	if crossReadyO == nil {
+		crossReadyO = new(slqueue)
+	}
+	c.readyO = crossReadyO
+	atomicstorep(unsafe.Pointer(&cooprts[c.Eid]), unsafe.Pointer(c))
+	unlock(&cooprtsLock)
+	cprtQ = crossReadyO
+	return c
+}
+
+// cooprtOf returns the cooperative runtime of the enclave on the other side
+// of sg: the enclave of its routine, or the one that woke it up.
+//go:nosplit
+func cooprtOf(sg *sudog) *CooperativeRuntime {
+	if isEnclave {
+		return Cooprt
+	}
+	if sg.id == -1 {
+		if c := cooprts[sg.crosseid]; c != nil {
+			return c
+		}
+		throw("no enclave woke up the routine")
+	}
+	gp := uintptr(unsafe.Pointer(sg.g))
+	for i := range cooprts {
+		if c := cooprts[i]; c != nil && c.layout.contains(gp) {
+			return c
+		}
+	}
+	throw("no enclave for the routine")
+	return nil
 }
 
 // SetHeapValue allows to let Cooprt register enclave heap value.
@@ -211,7 +289,7 @@ func (c *CooperativeRuntime) TranslateNote(n *note) *note {
 	// Calling futex on something outside of the enclave.
 	// Should not happen so throw exception for the moment
 	// TODO support this later
-	if nptr < ENCLMASK || nptr > ENCLMASK+ENCLSIZE {
+	if !inEnclaveRange(nptr) {
 		throw("Calling futex from enclave on a non enclave note")
 		return n
 	}
@@ -237,6 +315,13 @@ func (c *CooperativeRuntime) TranslateNote(n *note) *note {
 		return res
 	}
 
+	// A note of a timer bucket, e.g., for time.After.
+	tmptr := uintptr(unsafe.Pointer(&timers))
+	tmsize := unsafe.Sizeof(timers)
+	if nptr > tmptr && nptr < tmptr+tmsize {
+		return (*note)(unsafe.Pointer(timersEnclave + (nptr - tmptr)))
+	}
+
 	println("nptr: ", nptr)
 	for _, tcs := range c.Tcss {
 		println("tls: ", tcs.Tls, " Msgx: ", tcs.Msgx)
@@ -274,30 +359,25 @@ func checkEnclaveBounds(addr uintptr) {
 		// Enclave has access to everything.
 		return
 	}
-	if addr >= ENCLMASK && addr < ENCLMASK+ENCLSIZE {
+	if inEnclaveRange(addr) {
 		print("pre-panic: addr ", hex(addr), "\n")
 		panic("runtime: illegal address used outside of the enclave.")
 	}
 }
 
+// panicGosec panics with a. In the enclave, the panic ends up in the crash
+// report, see gosecrash.go.
 func panicGosec(a string) {
-	if isEnclave {
-		marker := (*uint64)(unsafe.Pointer(uintptr(0x050000000000)))
-		*marker = uint64(0x666)
-	}
 	panic(a)
 }
 
 // sysFutex allows to do a wakeup call on a futex while going through the
 // interposition mechanism.
 func sysFutex(addr *uint32, cnt uint32) {
-	syscid, csys := Cooprt.AcquireSysPool()
 	sys_futex := uintptr(202)
 	req := OcallReq{S6, sys_futex, uintptr(unsafe.Pointer(addr)),
-		uintptr(_FUTEX_WAKE), uintptr(cnt), 0, 0, 0, syscid}
-	Cooprt.Ocall <- req
-	_ = <-csys
-	Cooprt.ReleaseSysPool(syscid)
+		uintptr(_FUTEX_WAKE), uintptr(cnt), 0, 0, 0, 0}
+	Cooprt.SysRequest(req)
 	// TODO aghosn Don't care about the result for now
 }
 
@@ -333,6 +413,9 @@ func migrateCrossDomain(locked bool) {
 		println("Oh mighty fucks: ", size)
 		throw("Crashy crash")
 	}
+	if trace.enabled {
+		traceGosecMigrate(size)
+	}
 	for i := 0; i < size; i++ {
 		sg := sgq
 		gp := sg.g
@@ -362,6 +445,72 @@ func crossReleaseSudog(sg *sudog, size uint16) {
 	UnsafeAllocator.ReleaseUnsafeSudog(sg, size)
 }
 
+// selectIsCrossDomain returns true if one of the select cases uses a channel
+// that belongs to the other domain.
+func selectIsCrossDomain(gp *g, scases []scase) bool {
+	for i := range scases {
+		cas := &scases[i]
+		if cas.kind == caseNil || cas.kind == caseDefault {
+			continue
+		}
+		if checkinterdomain(gp.isencl, cas.c.isencl) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquireSelectSudog is the select equivalent of the unsafe sudog acquisition
+// in chansend and chanrecv. The g is registered once by selectgo.
+func acquireSelectSudog(cas *scase) (*sudog, unsafe.Pointer) {
+	c := cas.c
+	if !isEnclave {
+		panicGosec("Acquiring a select sudog from the pool in wrong environment.")
+	}
+	if cas.kind == caseSend && c.encltpe != nil {
+		return UnsafeAllocator.acquireUnsafeSudogSend(cas.elem, c.elemsize, c.encltpe)
+	}
+	return UnsafeAllocator.acquireUnsafeSudog(cas.elem, cas.kind == caseRecv, c.elemsize, c.elemtype)
+}
+
+// selectCrossWinner finds the sudog that was woken up by the other domain.
+// crossGoready marks it with needcpy as it cannot set gp.param.
+func selectCrossWinner(waiting *sudog) *sudog {
+	for sg := waiting; sg != nil; sg = sg.waitlink {
+		if sg.needcpy {
+			return sg
+		}
+	}
+	return nil
+}
+
+// selectCrossElem returns the buffer that received the value for the winning
+// case cas, i.e., the unsafe buffer for a pool sudog.
+func selectCrossElem(sg *sudog, cas *scase) unsafe.Pointer {
+	if cas.elem == nil {
+		return nil
+	}
+	if sg.id != -1 {
+		return (*sgentry)(unsafe.Pointer(sg)).buff
+	}
+	return cas.elem
+}
+
+// crossReleaseSelectSudog is the select version of crossReleaseSudog.
+// Only the winning sudog writes its buffer back to the select case, as several
+// cases may share the same destination.
+func crossReleaseSelectSudog(sg *sudog, size uint16, won bool) {
+	sg.needcpy = false
+	if isReschedulable(sg) {
+		releaseSudog(sg)
+		return
+	}
+	if !won {
+		(*sgentry)(unsafe.Pointer(sg)).orig = nil
+	}
+	UnsafeAllocator.releaseUnsafeSudog(sg, size)
+}
+
 // isReschedulable checks if a sudog can be directly rescheduled.
 // For that, we require the sudog to not belong to the pool and for the unblocking
 // routine to belong to the same domain as this sudog.
@@ -374,7 +523,10 @@ func isReschedulable(sg *sudog) bool {
 
 // crossGoready takes a sudog and makes it ready to be rescheduled.
 // This method should be called only once the isReschedulable returned false.
-func (c *CooperativeRuntime) crossGoready(sg *sudog) {
+// needcpy is false when the sudog is woken up by a close, i.e., no value was
+// transferred. The woken up routine relies on it in place of gp.param that
+// cannot be written across domains.
+func (c *CooperativeRuntime) crossGoready(sg *sudog, needcpy bool) {
 	// We are about to make ready a sudog that is not from the pool.
 	// This can happen only when non-trusted has blocked on a channel.
 	target := &c.readyE
@@ -382,42 +534,20 @@ func (c *CooperativeRuntime) crossGoready(sg *sudog) {
 		if sg.g.isencl || sg.g.isencl == isEnclave {
 			panicGosec("Misspredicted the crossdomain scenario.")
 		}
-		target = &c.readyO
+		target = c.readyO
+		sg.crosseid = c.Eid
+	}
+	if trace.enabled {
+		traceGosecCrossWakeup(target == &c.readyE)
 	}
 	// warn that it needs copy
-	sg.needcpy = true
+	sg.needcpy = needcpy
 	slqput(target, sg)
 }
 
-func (c *CooperativeRuntime) AcquireSysPool() (int, chan OcallRes) {
-	for i, s := range c.sysPool {
-		if s.available == 1 && atomic.Xchg(&c.sysPool[i].available, 0) == 1 {
-			c.sysPool[i].id = i
-			return i, c.sysPool[i].c
-		}
-	}
-	panicGosec("Ran out of syspool channels.")
-	return -1, nil
-}
-
-func (c *CooperativeRuntime) ReleaseSysPool(id int) {
-	if id < 0 || id >= len(c.sysPool) {
-		panicGosec("Trying to release out of range syspool")
-	}
-	if c.sysPool[id].available != 0 {
-		panicGosec("Trying to release an available channel")
-	}
-
-	//Do we need atomic here?
-	c.sysPool[id].available = 1
-}
-
-func (c *CooperativeRuntime) SysSend(id int, r OcallRes) {
-	c.sysPool[id].c <- r
-}
-
-// Sets up the stack arguments and returns the beginning of the stack address.
-func SetupEnclSysStack(stack, eS uintptr) uintptr {
+// SetupEnclSysStack sets up the stack arguments of the enclave of c, and
+// returns the beginning of the stack address.
+func (c *CooperativeRuntime) SetupEnclSysStack(stack, eS uintptr) uintptr {
 	if isEnclave {
 		panicGosec("Should not allocate enclave from the enclave.")
 	}
@@ -429,10 +559,10 @@ func SetupEnclSysStack(stack, eS uintptr) uintptr {
 	*ptrArgc = argc
 
 	// Initialize the Cooprt
-	Cooprt.SetHeapValue(eS)
+	c.SetHeapValue(eS)
 
 	ptrArgv := (***byte)(unsafe.Pointer(addrArgv))
-	*ptrArgv = (**byte)(unsafe.Pointer(Cooprt))
+	*ptrArgv = (**byte)(unsafe.Pointer(c))
 
 	return addrArgv
 }
@@ -458,28 +588,66 @@ func Newproc(ptr uintptr, argp *uint8, siz int32) {
 	})
 }
 
-//GosecureSend sends an ecall request on the p's private channel.
+// ecallChan is the private channel of a routine to an enclave.
+type ecallChan struct {
+	c   chan EcallReq
+	gen uint32 // the generation of the cooperative runtime of c.
+}
+
+//GosecureSend sends an ecall request on the private channel of the g to the
+//enclave of c.
 //TODO @aghosn: Maybe should change to avoid performing several copies!
-func GosecureSend(req EcallReq) {
+func GosecureSend(c *CooperativeRuntime, req EcallReq) {
 	gp := getg()
 	if gp == nil {
 		throw("Gosecure: un-init g.")
 	}
-	if Cooprt == nil {
+	if c == nil {
 		throw("Cooprt not initialized.")
 	}
-	if gp.ecallchan == nil {
-		gp.ecallchan = make(chan EcallReq)
-		srvreq := &EcallServerReq{gp.ecallchan}
+	if gp.ecallchans == nil {
+		gp.ecallchans = make([]ecallChan, MaxEnclaves)
+	}
+	ec := &gp.ecallchans[c.Eid]
+	// The channel of a previous enclave has no server anymore.
+	if ec.c == nil || ec.gen != c.gen {
+		ec.c, ec.gen = make(chan EcallReq), c.gen
+		srvreq := &EcallServerReq{ec.c}
 		MarkNoFutex()
-		Cooprt.EcallSrv <- srvreq
+		c.EcallSrv <- srvreq
 		MarkFutex()
 	}
+	req.Seq = nextGosecureSeq()
+	if trace.enabled {
+		traceGosecEcall(traceEvGosecEcall, &req)
+	}
 	MarkNoFutex()
-	gp.ecallchan <- req
+	ec.c <- req
 	MarkFutex()
 }
 
+var (
+	gosecureTargets []interface{} // registered by the packages' init.
+	enclaveMain     func()        // replaces main.main in the enclave.
+)
+
+// gosecureRegister is called by the init function of every package with
+// gosecure calls, once per target. fn is a func value.
+func gosecureRegister(fn interface{}) {
+	gosecureTargets = append(gosecureTargets, fn)
+}
+
+//GosecureTargets returns the targets of the gosecure calls of the program.
+func GosecureTargets() []interface{} {
+	return gosecureTargets
+}
+
+//SetEnclaveMain makes the enclave execute fn instead of the main function of
+//the program it is built from.
+func SetEnclaveMain(fn func()) {
+	enclaveMain = fn
+}
+
 //go:noescape
 func sched_setaffinity(pid, len uintptr, buf *uintptr) int32
 
@@ -503,9 +671,11 @@ func futexsleep0(addr *uint32, val uint32, ns int64) {
 	}
 	ustk := gp.m.g0.sched.usp
 	ubp := gp.m.g0.sched.ubp
-	aptr := UnsafeAllocator.Malloc(unsafe.Sizeof(OExitRequest{}))
+	// Not counted, as it is held while the thread waits.
+	aptr := UnsafeAllocator.malloc(unsafe.Sizeof(OExitRequest{}), 0)
 	args := (*OExitRequest)(unsafe.Pointer(aptr))
 	args.Cid = FutexSleepRequest
+	args.Eid = Cooprt.Eid
 	args.Sid = gp.m.procid
 	args.Addr = uintptr(unsafe.Pointer(addr))
 	args.Val = val
@@ -523,9 +693,11 @@ func futexwakeup0(addr *uint32, cnt uint32) {
 	}
 	ustk := gp.m.g0.sched.usp
 	ubp := gp.m.g0.sched.ubp
-	aptr := UnsafeAllocator.Malloc(unsafe.Sizeof(OExitRequest{}))
+	// Not counted, as it is held while the thread waits.
+	aptr := UnsafeAllocator.malloc(unsafe.Sizeof(OExitRequest{}), 0)
 	args := (*OExitRequest)(unsafe.Pointer(aptr))
 	args.Cid = FutexWakeupRequest
+	args.Eid = Cooprt.Eid
 	args.Sid = gp.m.procid
 	args.Addr = uintptr(unsafe.Pointer(addr))
 	args.Val = cnt
@@ -533,10 +705,12 @@ func futexwakeup0(addr *uint32, cnt uint32) {
 	UnsafeAllocator.Free(aptr, unsafe.Sizeof(OExitRequest{}))
 }
 
+// FutexsleepE sleeps on addr for an enclave, at most ns if it is not
+// negative, e.g., for the timers of the enclave.
 //go:nosplit
 //go:nowritebarrier
-func FutexsleepE(addr unsafe.Pointer, val uint32) {
-	futex(addr, _FUTEX_WAIT, val, nil, nil, 0)
+func FutexsleepE(addr unsafe.Pointer, val uint32, ns int64) {
+	futexsleep((*uint32)(addr), val, ns)
 }
 
 //go:nosplit
@@ -560,3 +734,9 @@ func SetChanType(cptr uintptr, tpe *DPTpe) {
 	c := (*hchan)(unsafe.Pointer(cptr))
 	c.encltpe = tpe
 }
+
+//ChanElemType returns the element type of the channel at cptr.
+func ChanElemType(cptr uintptr) *DPTpe {
+	c := (*hchan)(unsafe.Pointer(cptr))
+	return c.elemtype
+}
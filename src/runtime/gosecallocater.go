@@ -1,30 +1,67 @@
 package runtime
 
 import (
+	"runtime/internal/sys"
 	"unsafe"
 )
 
+// uledger allocates the buffers of the enclave in unsafe memory, i.e., the
+// memory it shares with the untrusted side. The memory comes in regions: the
+// first one is the unsafe zone of Cooprt, and more are asked for to the
+// untrusted side when it runs out. The regions are split in spans of pages.
+// Small buffers are rounded up to a size class, and a span holds buffers of
+// one class; larger buffers get spans of their own. All the bookkeeping is in
+// the enclave, out of reach of the untrusted side.
+//
+// The allocations are counted per call site. With GODEBUG=gosecleak=1, the
+// buffers still allocated when the enclave exits are reported.
+
 //TODO rewrite to be write barrier compatible.
 const (
-	_psize          = 0x1000
-	_uspgranularity = 64 //allocate by chunks of 64 bytes.
-	_uspfree        = uint64(0x0000000000000000)
-	_uspfull        = uint64(0xffffffffffffffff)
-	_sgfree         = uint32(0x0)
-	_sglock         = uint32(0x1)
-	_sgcachesize    = uint32(500)
-	_spansize       = _psize
+	_psize       = 0x1000
+	_sgcachesize = uint32(500)
+
+	_umaxsmall   = 8 << 10 // larger buffers get spans of their own.
+	_uspanelems  = 64      // most buffers in a span, see uspan.bits.
+	_umaxregions = 32
+	_umaxsites   = 256
 )
 
-//uspan is an unsafe span of memory from which we perform the allocation.
-//it corresponds to a page size.
+// uclasses are the size classes of the small buffers. A span of class c has
+// uclassPages(c) pages, and at most 64 buffers.
+var uclasses = [...]uintptr{64, 128, 256, 512, 1024, 2048, 4096, _umaxsmall}
+
+//go:nosplit
+func uclass(size uintptr) int {
+	for c, s := range uclasses {
+		if size <= s {
+			return c
+		}
+	}
+	return -1
+}
+
+//go:nosplit
+func uclassPages(c int) uintptr {
+	if n := uclasses[c] * 8 / _psize; n > 1 {
+		return n
+	}
+	return 1
+}
+
+//uspan is a run of pages of a region, that holds buffers of a size class, or
+//a single large buffer.
 type uspan struct {
-	id       uint32
-	bitmask  uint64  //quick check for available 64 bytes slots, bit 0 is [start;start+64[
-	start    uintptr //address of the beginning.
-	freesize uintptr //in bytes
-	prev     uspanptr
-	next     uspanptr
+	base   uintptr
+	npages uintptr
+	class  int     // -1 for a large buffer.
+	size   uintptr // size of the buffers.
+	nelems uintptr
+	nfree  uintptr
+	bits   uint64 // allocated buffers, bit i is [base+i*size;base+(i+1)*size[.
+	sites  [_uspanelems]uint16
+	prev   uspanptr
+	next   uspanptr
 }
 
 type uspanptr uintptr
@@ -39,9 +76,29 @@ func (u *uspanptr) set(us *uspan) {
 	*u = uspanptr(unsafe.Pointer(us))
 }
 
-type umentry struct {
-	size uint32
-	mask uint64
+//uregion is a region of unsafe memory. Its page map, in the enclave, gives
+//the span of each page, 0 if the page is free. The span that starts at page
+//i is uspans[i], so that allocating needs no memory from the enclave.
+type uregion struct {
+	start  uintptr
+	npages uintptr
+	spans  uintptr // [npages]uspanptr
+	uspans uintptr // [npages]uspan
+}
+
+//go:nosplit
+func (r *uregion) span(i uintptr) *uspanptr {
+	return (*uspanptr)(unsafe.Pointer(r.spans + i*sys.PtrSize))
+}
+
+//usite counts the allocations of a call site.
+type usite struct {
+	pc        uintptr
+	allocs    uint64
+	frees     uint64
+	bytes     uint64
+	live      uint64
+	liveBytes uint64
 }
 
 type sgentry struct {
@@ -60,11 +117,15 @@ type spanlist struct {
 }
 
 type uledger struct {
-	start     uintptr
-	size      uintptr
-	allspans  []uspan
-	freespans spanlist
-	fullspans spanlist
+	regions  [_umaxregions]uregion
+	nregions int
+	growsize uintptr                 // size of the regions asked for.
+	partial  [len(uclasses)]spanlist // spans with free buffers.
+
+	// sites[0] counts the allocations of the runtime that live as long as
+	// the enclave, which are not reported.
+	sites  [_umaxsites]usite
+	nsites int
 
 	poolsg  waitq //keep a pool of allocated sgs
 	psgsize uint32
@@ -72,11 +133,13 @@ type uledger struct {
 
 	inited bool
 	sl     slock
-	toFree map[uintptr]AllocTracker
+	toFree map[uintptr][]AllocTracker
 }
 
+//go:nosplit
 //go:nowritebarrier
 func (sl *spanlist) add(u *uspan) {
+	u.next = 0
 	if sl.tail == 0 {
 		if sl.head != 0 {
 			throw("empty head, non-empty tail")
@@ -84,19 +147,22 @@ func (sl *spanlist) add(u *uspan) {
 		sl.head.set(u)
 		sl.tail.set(u)
 		u.prev = 0
-		u.next = 0
+		return
 	}
 	sl.tail.ptr().next.set(u)
 	u.prev.set(sl.tail.ptr())
-	u.next = 0
 	sl.tail.set(u)
 }
 
+//go:nosplit
 //go:nowritebarrier
 func (sl *spanlist) remove(u *uspan) {
 	if u.prev != 0 {
 		u.prev.ptr().next = u.next
 	}
+	if u.next != 0 {
+		u.next.ptr().prev = u.prev
+	}
 	if sl.tail.ptr() == u {
 		sl.tail = u.prev
 	}
@@ -107,6 +173,7 @@ func (sl *spanlist) remove(u *uspan) {
 	u.prev = 0
 }
 
+//go:nosplit
 //go:nowritebarrier
 func (sl *spanlist) isEmpty() bool {
 	return sl.head == 0
@@ -117,85 +184,208 @@ func (u *uledger) Initialize(start, size uintptr) {
 	if size == 0 || size%_psize != 0 {
 		throw("uledger: bad init values")
 	}
-	u.start = start
-	u.size = size
-	nbpages := size / _psize
-	u.allspans = make([]uspan, nbpages)
-	for i := 0; i < int(nbpages); i++ {
-		sp := &u.allspans[i]
-		sp.id = uint32(i)
-		sp.bitmask = _uspfree
-		sp.start = start + uintptr(i)*_psize
-		sp.freesize = _spansize
-		u.freespans.add(sp)
-	}
+	u.growsize = size
+	u.addRegion(start, size)
+	u.nsites = 1
 	// Now initialize the workEnclave
-	workEnclave = u.Malloc(unsafe.Sizeof(work))
-	schedEnclave = u.Malloc(unsafe.Sizeof(sched))
-	u.toFree = make(map[uintptr]AllocTracker)
+	workEnclave = u.malloc(unsafe.Sizeof(work), 0)
+	schedEnclave = u.malloc(unsafe.Sizeof(sched), 0)
+	timersEnclave = u.malloc(unsafe.Sizeof(timers), 0)
+	u.toFree = make(map[uintptr][]AllocTracker)
 }
 
-//go:nosplit
-//go:nowritebarrier
-func (u *uledger) Malloc(size uintptr) uintptr {
-	//slow path, TODO check that no lock is held
-	if size >= _spansize {
-		syscid, csys := Cooprt.AcquireSysPool()
-		req := OcallReq{MAL, 0, 0, size, 0, 0, 0, 0, syscid}
-		Cooprt.Ocall <- req
-		res := <-csys
-		return res.R1
+// addRegion adds the size bytes at start to the unsafe memory.
+func (u *uledger) addRegion(start, size uintptr) {
+	if u.nregions == len(u.regions) {
+		throw("uledger: too many regions of unsafe memory")
+	}
+	npages := size / _psize
+	spans := persistentalloc(npages*sys.PtrSize, sys.PtrSize, &memstats.other_sys)
+	uspans := persistentalloc(npages*unsafe.Sizeof(uspan{}), sys.PtrSize, &memstats.other_sys)
+	u.regions[u.nregions] = uregion{start, npages, uintptr(spans), uintptr(uspans)}
+	u.nregions++
+}
+
+// grow asks the untrusted side for a region of unsafe memory with room for a
+// buffer of size bytes. It is called without u.sl.
+func (u *uledger) grow(size uintptr) {
+	n := round(size, _psize)
+	if n < u.growsize {
+		n = u.growsize
+	}
+	p := Cooprt.SysRequest(OcallReq{MAL, 0, 0, n, 0, 0, 0, 0, 0}).R1
+	// The region must not give the untrusted side access to the enclave.
+	if p == 0 || p%_psize != 0 || p+n < p || isEnclave && p < enclLayout.Base+enclLayout.Size && p+n > enclLayout.Base {
+		print("uledger: region ", hex(p), " of ", hex(n), " bytes\n")
+		throw("uledger: invalid region of unsafe memory")
 	}
 	u.sl.lock()
-	if u.freespans.isEmpty() {
-		println("Size of allspans ", len(u.allspans))
-		println("Size of a sudog ", unsafe.Sizeof(sudog{}), " - ", u.inited)
-		throw("uledger: ran out of memory")
-	}
-	for sptr := u.freespans.head; sptr != 0; sptr = sptr.ptr().next {
-		span := sptr.ptr()
-		if span.freesize >= size {
-			//We are looking for contiguous space so it might fail
-			if ptr, ok := span.allocate(size); ok {
-				//If now the span is full, move it
-				if span.freesize == 0 {
-					u.freespans.remove(span)
-					u.fullspans.add(span)
-				}
-				u.sl.unlock()
-				//set to zero
-				for i := uintptr(0); i < size; i++ {
-					bptr := (*byte)(unsafe.Pointer(ptr + i))
-					*bptr = byte(0)
-				}
-				return ptr
+	u.addRegion(p, n)
+	u.sl.unlock()
+}
+
+// allocPages returns a span of n free pages, or nil.
+//go:nosplit
+//go:nowritebarrier
+func (u *uledger) allocPages(n uintptr) *uspan {
+	for k := 0; k < u.nregions; k++ {
+		r := &u.regions[k]
+		run := uintptr(0)
+		for i := uintptr(0); i < r.npages; i++ {
+			if *r.span(i) != 0 {
+				run = 0
+				continue
+			}
+			if run++; run < n {
+				continue
+			}
+			first := i + 1 - n
+			s := (*uspan)(unsafe.Pointer(r.uspans + first*unsafe.Sizeof(uspan{})))
+			*s = uspan{}
+			s.base = r.start + first*_psize
+			s.npages = n
+			for j := first; j <= i; j++ {
+				r.span(j).set(s)
 			}
+			return s
 		}
 	}
-	u.sl.unlock()
-	throw("uledger: ran out of contiguous memory")
-	return uintptr(0)
+	return nil
 }
 
+// freePages returns the pages of s to its region.
 //go:nosplit
 //go:nowritebarrier
-func (u *uledger) Free(ptr, size uintptr) {
-	// Slow path
-	if size >= _spansize {
-		// There is no need to get an answer
-		req := OcallReq{FRE, 0, ptr, size, 0, 0, 0, 0, 0}
-		Cooprt.Ocall <- req
-		return
+func (u *uledger) freePages(s *uspan) {
+	r := u.regionOf(s.base)
+	first := (s.base - r.start) / _psize
+	for j := first; j < first+s.npages; j++ {
+		*r.span(j) = 0
+	}
+}
+
+// regionOf returns the region of ptr, or nil.
+//go:nosplit
+func (u *uledger) regionOf(ptr uintptr) *uregion {
+	for k := 0; k < u.nregions; k++ {
+		r := &u.regions[k]
+		if ptr >= r.start && ptr < r.start+r.npages*_psize {
+			return r
+		}
 	}
+	return nil
+}
+
+// site returns the index of the counters of the call site pc.
+//go:nosplit
+func (u *uledger) site(pc uintptr) uint16 {
+	if pc == 0 {
+		return 0
+	}
+	for i := 1; i < u.nsites; i++ {
+		if u.sites[i].pc == pc {
+			return uint16(i)
+		}
+	}
+	if u.nsites == len(u.sites) {
+		return 0
+	}
+	u.sites[u.nsites].pc = pc
+	u.nsites++
+	return uint16(u.nsites - 1)
+}
+
+//go:nosplit
+//go:nowritebarrier
+func (u *uledger) Malloc(size uintptr) uintptr {
+	return u.malloc(size, getcallerpc())
+}
+
+// malloc allocates size bytes for the call site pc, 0 for the runtime.
+//go:nosplit
+//go:nowritebarrier
+func (u *uledger) malloc(size, pc uintptr) uintptr {
 	u.sl.lock()
-	index := (ptr - u.start) / _spansize //find the pod
-	move, ok := u.allspans[index].deallocate(ptr, size)
-	if !ok { //Failed de-allocating
-		throw("uledger: error deallocating object!")
+	ptr := u.allocate(size, pc)
+	for ptr == 0 {
+		u.sl.unlock()
+		u.grow(size)
+		u.sl.lock()
+		ptr = u.allocate(size, pc)
 	}
-	if move { //The span was full
-		u.fullspans.remove(&u.allspans[index])
-		u.freespans.add(&u.allspans[index])
+	u.sl.unlock()
+	memclrNoHeapPointers(unsafe.Pointer(ptr), size)
+	return ptr
+}
+
+// allocate allocates size bytes, or returns 0 if there is no room.
+//go:nosplit
+//go:nowritebarrier
+func (u *uledger) allocate(size, pc uintptr) uintptr {
+	var s *uspan
+	c := uclass(size)
+	if c < 0 {
+		if s = u.allocPages(round(size, _psize) / _psize); s == nil {
+			return 0
+		}
+		s.class, s.size, s.nelems, s.nfree = -1, s.npages*_psize, 1, 1
+	} else if !u.partial[c].isEmpty() {
+		s = u.partial[c].head.ptr()
+	} else {
+		if s = u.allocPages(uclassPages(c)); s == nil {
+			return 0
+		}
+		s.class, s.size = c, uclasses[c]
+		s.nelems = s.npages * _psize / s.size
+		s.nfree = s.nelems
+		u.partial[c].add(s)
+	}
+	i := uintptr(sys.Ctz64(^s.bits))
+	s.bits |= 1 << i
+	s.nfree--
+	if s.nfree == 0 && c >= 0 {
+		u.partial[c].remove(s)
+	}
+	k := u.site(pc)
+	s.sites[i] = k
+	st := &u.sites[k]
+	st.allocs++
+	st.bytes += uint64(size)
+	st.live++
+	st.liveBytes += uint64(size)
+	return s.base + i*s.size
+}
+
+//go:nosplit
+//go:nowritebarrier
+func (u *uledger) Free(ptr, size uintptr) {
+	u.sl.lock()
+	r := u.regionOf(ptr)
+	if r == nil || *r.span((ptr - r.start) / _psize) == 0 {
+		print("uledger: free of ", hex(ptr), ", ", size, " bytes\n")
+		throw("uledger: free of unallocated unsafe memory")
+	}
+	s := r.span((ptr - r.start) / _psize).ptr()
+	i := (ptr - s.base) / s.size
+	bad := uclass(size) != s.class || s.class < 0 && round(size, _psize) != s.size
+	if bad || (ptr-s.base)%s.size != 0 || s.bits&(1<<i) == 0 {
+		print("uledger: free of ", hex(ptr), ", ", size, " bytes, in a span of ", s.size, " bytes buffers\n")
+		throw("uledger: invalid or double free of unsafe memory")
+	}
+	s.bits &^= 1 << i
+	s.nfree++
+	st := &u.sites[s.sites[i]]
+	st.frees++
+	st.live--
+	st.liveBytes -= uint64(size)
+	switch {
+	case s.nfree == s.nelems:
+		if s.class >= 0 {
+			u.partial[s.class].remove(s)
+		}
+		u.freePages(s)
+	case s.nfree == 1:
+		u.partial[s.class].add(s)
 	}
 	u.sl.unlock()
 }
@@ -206,6 +396,50 @@ func (u *uledger) FreeAll(tracker AllocTracker) {
 	}
 }
 
+//UnsafeSite are the allocations of unsafe memory of a call site.
+type UnsafeSite struct {
+	PC        uintptr
+	Allocs    uint64 // buffers allocated.
+	Frees     uint64 // buffers freed.
+	Bytes     uint64 // bytes allocated.
+	Live      uint64 // buffers still allocated.
+	LiveBytes uint64 // bytes still allocated.
+}
+
+//UnsafeSites returns the allocations of unsafe memory of the enclave, per
+//call site.
+func UnsafeSites() []UnsafeSite {
+	u := &UnsafeAllocator
+	u.sl.lock()
+	sites := make([]UnsafeSite, 0, u.nsites)
+	for i := 1; i < u.nsites; i++ {
+		st := &u.sites[i]
+		sites = append(sites, UnsafeSite{st.pc, st.allocs, st.frees, st.bytes, st.live, st.liveBytes})
+	}
+	u.sl.unlock()
+	return sites
+}
+
+//UnsafeLeakReport prints the buffers of unsafe memory still allocated, per
+//call site, if GODEBUG=gosecleak=1 was set when the enclave was created.
+func UnsafeLeakReport() {
+	if Cooprt == nil || !Cooprt.leakcheck {
+		return
+	}
+	for _, st := range UnsafeSites() {
+		if st.Live == 0 {
+			continue
+		}
+		print("gosec: ", st.Live, " unsafe buffers, ", st.LiveBytes, " bytes, leaked by ")
+		if f := findfunc(st.PC); f.valid() {
+			file, line := funcline(f, st.PC-1)
+			print(funcname(f), " (", file, ":", line, ")\n")
+		} else {
+			print("pc=", hex(st.PC), "\n")
+		}
+	}
+}
+
 // AcquireUnsafeSudog returns an unsafe sudog and an unsafe buffer as
 // requested.
 //go:nosplit
@@ -213,6 +447,25 @@ func (u *uledger) AcquireUnsafeSudog(elem unsafe.Pointer, isrcv bool, size uint1
 	if !isEnclave {
 		throw("Error in AcquireUnsafeSudog")
 	}
+	sg, buff := u.acquireUnsafeSudog(elem, isrcv, size, elemtype)
+	// register the g
+	allcgadd(getg())
+	return sg, buff
+}
+
+func (u *uledger) AcquireUnsafeSudogSend(elem unsafe.Pointer, size uint16, elemtype *_type) (*sudog, unsafe.Pointer) {
+	if !isEnclave {
+		throw("Error in AcquireUnsafeSudogSend")
+	}
+	sg, buff := u.acquireUnsafeSudogSend(elem, size, elemtype)
+	// register the g
+	allcgadd(getg())
+	return sg, buff
+}
+
+// allocUnsafeSudog takes a sudog from the pool or allocates a new one.
+//go:nosplit
+func (u *uledger) allocUnsafeSudog() *sudog {
 	var sg *sudog
 	//Quick check if the queue is empty
 	if u.psgsize > 0 {
@@ -226,14 +479,22 @@ func (u *uledger) AcquireUnsafeSudog(elem unsafe.Pointer, isrcv bool, size uint1
 
 	//Need to allocate a new one.
 	if sg == nil {
-		sg = (*sudog)(unsafe.Pointer(u.Malloc(unsafe.Sizeof(sgentry{}))))
+		// Not counted, the pool keeps them.
+		sg = (*sudog)(unsafe.Pointer(u.malloc(unsafe.Sizeof(sgentry{}), 0)))
 	}
 	sg.id = 1
+	sg.schednext = 0
+	return sg
+}
+
+// acquireUnsafeSudog is AcquireUnsafeSudog without the g registration.
+//go:nosplit
+func (u *uledger) acquireUnsafeSudog(elem unsafe.Pointer, isrcv bool, size uint16, elemtype *_type) (*sudog, unsafe.Pointer) {
+	sg := u.allocUnsafeSudog()
 	buff := unsafe.Pointer(u.Malloc(uintptr(size)))
 	if elem != nil {
 		typedmemmove(elemtype, buff, elem)
 	}
-	sg.schednext = 0
 	//book-keeping for the release.
 	sge := (*sgentry)(unsafe.Pointer(sg))
 	sge.isrcv = isrcv
@@ -241,33 +502,14 @@ func (u *uledger) AcquireUnsafeSudog(elem unsafe.Pointer, isrcv bool, size uint1
 	sge.buff = buff
 	sge.sbuff = uintptr(size)
 	sge.elemtype = elemtype
-	// register the g
-	allcgadd(getg())
+	sge.tracker = nil
 	return sg, buff
 }
 
-func (u *uledger) AcquireUnsafeSudogSend(elem unsafe.Pointer, size uint16, elemtype *_type) (*sudog, unsafe.Pointer) {
-	if !isEnclave {
-		throw("Error in AcquireUnsafeSudogSend")
-	}
-	var sg *sudog
-	//Quick check if the queue is empty
-	if u.psgsize > 0 {
-		u.psglock.lock()
-		sg = u.poolsg.dequeue()
-		if sg != nil {
-			u.psgsize--
-		}
-		u.psglock.unlock()
-	}
-
-	//Need to allocate a new one.
-	if sg == nil {
-		sg = (*sudog)(unsafe.Pointer(u.Malloc(unsafe.Sizeof(sgentry{}))))
-	}
-	sg.id = 1
+// acquireUnsafeSudogSend is AcquireUnsafeSudogSend without the g registration.
+func (u *uledger) acquireUnsafeSudogSend(elem unsafe.Pointer, size uint16, elemtype *_type) (*sudog, unsafe.Pointer) {
+	sg := u.allocUnsafeSudog()
 	buff, tracker := DeepCopierSend(elem, elemtype)
-	sg.schednext = 0
 	//book-keeping for the release.
 	sge := (*sgentry)(unsafe.Pointer(sg))
 	sge.isrcv = false
@@ -276,13 +518,19 @@ func (u *uledger) AcquireUnsafeSudogSend(elem unsafe.Pointer, size uint16, elemt
 	sge.sbuff = uintptr(size)
 	sge.elemtype = elemtype
 	sge.tracker = tracker
-	// register the g
-	allcgadd(getg())
 	return sg, buff
 }
 
 //go:nosplit
 func (u *uledger) ReleaseUnsafeSudog(sg *sudog, size uint16) {
+	u.releaseUnsafeSudog(sg, size)
+	// unregister the routine
+	allcgremove(getg())
+}
+
+// releaseUnsafeSudog is ReleaseUnsafeSudog without unregistering the g.
+//go:nosplit
+func (u *uledger) releaseUnsafeSudog(sg *sudog, size uint16) {
 	if sg.id != -1 && !isEnclave {
 		throw("Error in ReleaseUnsafeSudog")
 	}
@@ -314,89 +562,31 @@ skipfree:
 	} else {
 		u.Free(uintptr(unsafe.Pointer(sge)), unsafe.Sizeof(sgentry{}))
 	}
-	// unregister the routine
-	allcgremove(getg())
 }
 
-func (u *uledger) registerTracker(tracker AllocTracker) {
-	if len(tracker) < 2 {
-		panic("registering invalid tracker")
-	}
+// registerTracker records the allocations of a copy received with the sudog
+// sg, FreeTracker(sg) frees them. The sudog can be reused before the copy is
+// freed, the copies of a sudog are freed in order.
+func (u *uledger) registerTracker(sg uintptr, tracker AllocTracker) {
 	u.sl.lock()
-	u.toFree[tracker[1].Src] = tracker
+	u.toFree[sg] = append(u.toFree[sg], tracker)
 	u.sl.unlock()
 }
 
-func (u *uledger) FreeTracker(src uintptr) {
+func (u *uledger) FreeTracker(sg uintptr) {
 	u.sl.lock()
-	v, ok := u.toFree[src]
+	v, ok := u.toFree[sg]
 	if !ok {
 		if isSimulation {
-			println(src)
+			println(sg)
 		}
 		panic("Trying to free a tracker that does not exist")
 	}
-	delete(u.toFree, src)
-	u.sl.unlock()
-	u.FreeAll(v)
-}
-
-//go:nosplit
-//go:nowritebarrier
-func (u *uspan) allocate(size uintptr) (uintptr, bool) {
-	cbits := size / _uspgranularity
-	if size%_uspgranularity != 0 {
-		cbits++
-	}
-	fcount := uintptr(0)
-	mask := u.bitmask
-	idx := -1
-	for i := 0; i < 64 && idx == -1; i, mask = i+1, mask>>1 {
-		if (mask & 1) == 0 {
-			fcount++
-			if fcount == cbits { //we can allocate!
-				idx = i - int(cbits) + 1
-			}
-			continue
-		}
-		fcount = 0
-	}
-	if idx == -1 { //failure
-		return 0, false
-	}
-	occupied := uint64(0)
-	for i := 0; i < int(cbits); i++ {
-		occupied |= 1 << uintptr(idx+i)
-	}
-	u.bitmask |= occupied
-	ptr := u.start + uintptr(idx)*_uspgranularity
-	u.freesize -= cbits * _uspgranularity
-	return ptr, true
-}
-
-//go:nosplit
-//go:nowritebarrier
-func (u *uspan) deallocate(ptr, size uintptr) (bool, bool) {
-	cbits := size / _uspgranularity
-	if size%_uspgranularity != 0 {
-		cbits++
-	}
-	idx := (ptr - u.start) / _uspgranularity
-	if (ptr-u.start)%_uspgranularity != 0 {
-		throw("gosecallocator: assumption was wrong")
-	}
-	occupied := uint64(0)
-	for i := 0; i < int(cbits); i++ {
-		occupied |= 1 << (idx + uintptr(i))
-	}
-	move := false
-	if u.bitmask == _uspfull {
-		move = true
-	}
-	if (u.bitmask & occupied) != occupied {
-		throw("gosecallocator: mistake computing bitmask or freeing.")
+	if len(v) == 1 {
+		delete(u.toFree, sg)
+	} else {
+		u.toFree[sg] = v[1:]
 	}
-	u.bitmask ^= occupied
-	u.freesize += uintptr(cbits * _uspgranularity)
-	return move, true
+	u.sl.unlock()
+	u.FreeAll(v[0])
 }
@@ -0,0 +1,67 @@
+package runtime_test
+
+import (
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+func TestUledger(t *testing.T) {
+	const size = 64 << 12
+	l := runtime.NewUledger(size)
+	start, n := l.Region()
+	type buf struct{ p, n uintptr }
+	var bufs []buf
+	for _, n := range []uintptr{1, 64, 65, 100, 500, 4096, 5000, 32 << 10, 40 << 10, 3} {
+		p := l.Malloc(n)
+		if p < start || p+n > start+size {
+			t.Fatalf("Malloc(%d) = %#x, out of [%#x, %#x[", n, p, start, start+size)
+		}
+		for _, b := range bufs {
+			if p < b.p+b.n && b.p < p+n {
+				t.Fatalf("Malloc(%d) = %#x overlaps %#x of %d bytes", n, p, b.p, b.n)
+			}
+		}
+		for i := uintptr(0); i < n; i++ {
+			if *(*byte)(unsafe.Pointer(p + i)) != 0 {
+				t.Fatalf("Malloc(%d) is not zeroed", n)
+			}
+			*(*byte)(unsafe.Pointer(p + i)) = 0xff
+		}
+		bufs = append(bufs, buf{p, n})
+	}
+	for _, b := range bufs {
+		l.Free(b.p, b.n)
+	}
+
+	// All the pages are free again.
+	p := l.Malloc(n)
+	if p != start {
+		t.Errorf("Malloc of the region = %#x, want %#x", p, start)
+	}
+	l.Free(p, n)
+}
+
+func TestUledgerSites(t *testing.T) {
+	l := runtime.NewUledger(32 << 12)
+	a := l.Malloc(100)
+	b := l.Malloc(200)
+	l.Malloc(5000)
+	l.Free(a, 100)
+	var allocs, frees, bytes, live, liveBytes uint64
+	for _, st := range l.Sites() {
+		f := runtime.FuncForPC(st.PC)
+		if f == nil || f.Name() != "runtime_test.TestUledgerSites" {
+			t.Errorf("site %#x in %v", st.PC, f)
+		}
+		allocs += st.Allocs
+		frees += st.Frees
+		bytes += st.Bytes
+		live += st.Live
+		liveBytes += st.LiveBytes
+	}
+	if allocs != 3 || frees != 1 || bytes != 5300 || live != 2 || liveBytes != 5200 {
+		t.Errorf("sites: %d allocs, %d frees, %d bytes, %d live, %d live bytes", allocs, frees, bytes, live, liveBytes)
+	}
+	l.Free(b, 200)
+}
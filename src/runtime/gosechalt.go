@@ -0,0 +1,57 @@
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+// The teardown of the enclave, see gosec.Shutdown. Once the gosecure calls
+// are drained, the untrusted side closes Cooprt.Stop. The enclave then stops
+// its world, so that none of its goroutines runs anymore, reports that it
+// halted and parks the m that stopped it. All the threads of the enclave are
+// then asleep in a request to the untrusted side: the untrusted side makes
+// them exit instead of returning to the enclave, and releases the memory of
+// the enclave and its cooperative runtime.
+
+//HaltEnclave stops the enclave for good. The ecall server of the enclave
+//calls it once Cooprt.Stop is closed. It never returns.
+func HaltEnclave() {
+	if !isEnclave || Cooprt == nil {
+		panicGosec("HaltEnclave outside of the enclave.")
+	}
+	c := Cooprt
+	stopTheWorld("gosec halt")
+	atomic.Store(&c.halted, 1)
+	futexwakeup(&c.halted, 1)
+	for {
+		futexsleep(&c.halted, 1, -1)
+	}
+}
+
+//WaitEnclaveHalt blocks until the enclave of c halted.
+func WaitEnclaveHalt(c *CooperativeRuntime) {
+	if isEnclave || c == nil {
+		panicGosec("WaitEnclaveHalt outside of the untrusted side.")
+	}
+	waitEnclave(&c.halted, 1)
+}
+
+//ExitEnclaveThread exits the current thread, a thread of the halted enclave
+//that serves a request on the untrusted side. It stores 0 in *alive once it
+//no longer uses its stack. It runs without g.
+//go:nosplit
+func ExitEnclaveThread(alive *uint32) {
+	exitThread(alive)
+}
+
+//Release drops the cooperative runtime of an enclave, which halted or never
+//ran, so that NewCooperativeRuntime may reuse its Eid. The caller releases the
+//unsafe memory once the untrusted side no longer uses it.
+func (c *CooperativeRuntime) Release() {
+	if isEnclave || cooprts[c.Eid] != c {
+		panicGosec("Release of a cooperative runtime outside of the untrusted side.")
+	}
+	lock(&cooprtsLock)
+	atomicstorep(unsafe.Pointer(&cooprts[c.Eid]), nil)
+	unlock(&cooprtsLock)
+}
@@ -0,0 +1,209 @@
+package runtime
+
+import "unsafe"
+
+// The isolated simulation, SIM=isolated, see gosec/isolated.go. Each enclave
+// runs in a child process of the program, so that its memory is private as
+// with sgx: the untrusted side cannot read it, and the kernel enforces it.
+// The enclave, however, reads and writes the memory of the untrusted side,
+// which is all untrusted. It is handed the channels, routines and sudogs of
+// the program, its cooperative runtime, its unsafe memory and its ring: the
+// runtime of the program thus maps the arena of its heap as shared, along with
+// the unsafe pool from which the unsafe memory, the buffers of the MAL
+// requests and the rings are allocated, before any child is forked. The data
+// and bss of the program stay private, so that what the enclave reaches of
+// them is in the heap, see crossReadyO.
+//
+// SIM=isolated must be in the environment when the program starts: the arena is
+// mapped by mallocinit.
+
+// unsafePoolSize is the address space of the unsafe pool, which is only
+// backed by memory once used.
+const unsafePoolSize = 64 << 30
+
+// unsafePoolRuns is the number of free runs the unsafe pool tracks. A run
+// freed when they are all tracked is lost.
+const unsafePoolRuns = 1024
+
+var isolated struct {
+	on              bool
+	arena, arenaEnd uintptr // the arena of the heap, shared.
+	pool            unsafePool
+}
+
+// unsafePool allocates page runs of a shared mapping, first fit.
+type unsafePool struct {
+	lock  mutex
+	start uintptr
+	end   uintptr
+	nfree int
+	free  [unsafePoolRuns]struct{ p, n uintptr } // sorted by address.
+}
+
+func (u *unsafePool) init(start, n uintptr) {
+	u.start, u.end = start, start+n
+	u.free[0].p, u.free[0].n = start, n
+	u.nfree = 1
+}
+
+// alloc returns a run of n bytes, rounded up to pages, or 0 if there is none.
+func (u *unsafePool) alloc(n uintptr) uintptr {
+	n = round(n, PSIZE)
+	lock(&u.lock)
+	defer unlock(&u.lock)
+	for i := 0; i < u.nfree; i++ {
+		r := &u.free[i]
+		if r.n < n {
+			continue
+		}
+		p := r.p
+		r.p, r.n = r.p+n, r.n-n
+		if r.n == 0 {
+			copy(u.free[i:u.nfree], u.free[i+1:u.nfree])
+			u.nfree--
+		}
+		return p
+	}
+	return 0
+}
+
+// release gives back the run of n bytes at p, rounded up to pages.
+func (u *unsafePool) release(p, n uintptr) {
+	n = round(n, PSIZE)
+	if p < u.start || p+n > u.end || p&(PSIZE-1) != 0 {
+		throw("runtime: release of a run outside of the unsafe pool")
+	}
+	lock(&u.lock)
+	defer unlock(&u.lock)
+	i := 0
+	for i < u.nfree && u.free[i].p < p {
+		i++
+	}
+	if i > 0 && u.free[i-1].p+u.free[i-1].n > p || i < u.nfree && p+n > u.free[i].p {
+		throw("runtime: double release in the unsafe pool")
+	}
+	joinPrev := i > 0 && u.free[i-1].p+u.free[i-1].n == p
+	joinNext := i < u.nfree && p+n == u.free[i].p
+	switch {
+	case joinPrev && joinNext:
+		u.free[i-1].n += n + u.free[i].n
+		copy(u.free[i:u.nfree], u.free[i+1:u.nfree])
+		u.nfree--
+	case joinPrev:
+		u.free[i-1].n += n
+	case joinNext:
+		u.free[i].p, u.free[i].n = p, u.free[i].n+n
+	case u.nfree < len(u.free):
+		copy(u.free[i+1:u.nfree+1], u.free[i:u.nfree])
+		u.free[i].p, u.free[i].n = p, n
+		u.nfree++
+	}
+}
+
+// isolatedinit detects SIM=isolated in the environment, before mallocinit
+// reserves the arena, and maps the unsafe pool.
+func isolatedinit() {
+	if isEnclave {
+		return
+	}
+	for i := int32(0); argv_index(argv, argc+1+i) != nil; i++ {
+		if gostringnocopy(argv_index(argv, argc+1+i)) == "SIM=isolated" {
+			isolated.on = true
+		}
+	}
+	if !isolated.on {
+		return
+	}
+	p, err := mmap(nil, unsafePoolSize, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_SHARED|_MAP_NORESERVE, -1, 0)
+	if err != 0 {
+		print("runtime: cannot map the unsafe pool of the isolated simulation: errno ", err, "\n")
+		throw("runtime: cannot map the unsafe pool")
+	}
+	isolated.pool.init(uintptr(p), unsafePoolSize)
+}
+
+// isolatedReserve is sysReserve for the arena of the heap, which it maps as
+// shared: the mapping then reaches the children forked later on, along with
+// the pages of the arena that sysMap uses.
+func isolatedReserve(v unsafe.Pointer, n uintptr, reserved *bool) unsafe.Pointer {
+	p, err := mmap(v, n, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_SHARED|_MAP_NORESERVE, -1, 0)
+	if err != 0 {
+		return nil
+	}
+	if p != v {
+		munmap(p, n)
+		return nil
+	}
+	isolated.arena, isolated.arenaEnd = uintptr(p), uintptr(p)+n
+	*reserved = true
+	return p
+}
+
+// inIsolatedArena reports whether [v, v+n[ is in the shared arena of the heap.
+func inIsolatedArena(v unsafe.Pointer, n uintptr) bool {
+	return isolated.on && uintptr(v) >= isolated.arena && uintptr(v)+n <= isolated.arenaEnd
+}
+
+//IsIsolated reports whether the program runs its simulated enclaves in child
+//processes, see gosec/isolated.go.
+func IsIsolated() bool {
+	return isolated.on
+}
+
+//MmapUnsafe maps n bytes of unsafe memory, which the enclaves may read and
+//write: from the unsafe pool in the isolated simulation, and anonymous memory
+//otherwise. It returns the errno of the failure, if any.
+func MmapUnsafe(n uintptr) (unsafe.Pointer, int) {
+	if !isolated.on {
+		return mmap(nil, n, _PROT_READ|_PROT_WRITE, _MAP_ANON|_MAP_PRIVATE|_MAP_NORESERVE, -1, 0)
+	}
+	p := isolated.pool.alloc(n)
+	if p == 0 {
+		return nil, _ENOMEM
+	}
+	return unsafe.Pointer(p), 0
+}
+
+//MunmapUnsafe releases the n bytes at p that MmapUnsafe mapped.
+func MunmapUnsafe(p unsafe.Pointer, n uintptr) {
+	if !isolated.on {
+		munmap(p, n)
+		return
+	}
+	madvise(p, round(n, PSIZE), _MADV_REMOVE)
+	isolated.pool.release(uintptr(p), n)
+}
+
+// forkFlags are the flags of clone for the child process of an isolated
+// enclave: it shares the file descriptors of the program, where the epoll
+// instances and the sgx device are, and nothing else.
+const forkFlags = _CLONE_FILES | _SIGCHLD
+
+//ForkIsolated forks the child process of an isolated enclave, which runs fn
+//on the stack stk, without g and with all the signals blocked. The memory of
+//the child is a copy of the memory of the program, but for the arena of the
+//heap and the unsafe pool, which are shared. It returns the pid of the child,
+//or a negative errno.
+func ForkIsolated(stk uintptr, fn unsafe.Pointer) int32 {
+	if isEnclave || !isolated.on {
+		throw("ForkIsolated outside of the isolated simulation.")
+	}
+	var oset sigset
+	sigprocmask(_SIG_SETMASK, &sigset_all, &oset)
+	pid := clone(forkFlags, unsafe.Pointer(stk), nil, nil, fn)
+	sigprocmask(_SIG_SETMASK, &oset, nil)
+	return pid
+}
+
+//StartReaperThread starts a thread, which the scheduler does not know of, that
+//runs fn on the stack stk, without g and with all the signals blocked. It
+//waits for the child process of an isolated enclave.
+func StartReaperThread(stk uintptr, fn unsafe.Pointer) {
+	if isEnclave || !isolated.on {
+		throw("StartReaperThread outside of the isolated simulation.")
+	}
+	var oset sigset
+	sigprocmask(_SIG_SETMASK, &sigset_all, &oset)
+	StartEnclaveOSThread(stk, fn)
+	sigprocmask(_SIG_SETMASK, &oset, nil)
+}
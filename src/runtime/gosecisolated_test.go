@@ -0,0 +1,38 @@
+package runtime_test
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestUnsafePool(t *testing.T) {
+	const start, size = 0x100000, 16 * runtime.PSIZE
+	p := runtime.NewUnsafePool(start, size)
+	a := p.Alloc(1)
+	b := p.Alloc(2 * runtime.PSIZE)
+	c := p.Alloc(runtime.PSIZE + 1)
+	if a != start || b != a+runtime.PSIZE || c != b+2*runtime.PSIZE {
+		t.Fatalf("Alloc: %#x, %#x, %#x, want consecutive runs from %#x", a, b, c, start)
+	}
+	// The freed runs are reused first fit, and merged with their
+	// neighbors.
+	p.Release(a, 1)
+	p.Release(c, runtime.PSIZE+1)
+	if n := p.FreeRuns(); n != 2 {
+		t.Errorf("%d free runs, want 2", n)
+	}
+	if d := p.Alloc(runtime.PSIZE); d != a {
+		t.Errorf("Alloc of a page: %#x, want the freed run at %#x", d, a)
+	}
+	p.Release(a, runtime.PSIZE)
+	p.Release(b, 2*runtime.PSIZE)
+	if n := p.FreeRuns(); n != 1 {
+		t.Errorf("%d free runs once all released, want 1", n)
+	}
+	if d := p.Alloc(size); d != start {
+		t.Errorf("Alloc of the whole pool: %#x, want %#x", d, start)
+	}
+	if d := p.Alloc(1); d != 0 {
+		t.Errorf("Alloc in an exhausted pool: %#x, want 0", d)
+	}
+}
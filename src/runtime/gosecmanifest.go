@@ -0,0 +1,167 @@
+package runtime
+
+// EnclaveLayout is the layout of an enclave, as set by the manifest of the
+// program, see cmd/internal/enclave.
+type EnclaveLayout struct {
+	Base    uintptr // start of the enclave address range, aligned on Size.
+	Size    uintptr // size of the enclave address range, a power of 2.
+	Heap    uintptr // size of the enclave heap, a power of 2.
+	Tcs     int     // number of threads that can be in the enclave, at least 3.
+	Unsafe  uintptr // size of the memory shared by the enclave and the program.
+	Membuf  uintptr // size of the buffer for the mmaps of the enclave.
+	Redact  bool    // the crash reports of the enclave omit its message and stack, see gosecrash.go.
+	Name    string  // the name of the enclave, empty for the default one.
+	Backend string  // the backend that loads the enclave, see gosec.Backend, empty for the default one.
+}
+
+// enclaveManifest is the manifest of the enclave, set by the linker in the
+// enclave only.
+var enclaveManifest string
+
+// defaultEnclaveLayout is the layout of enclaves without manifest.
+var defaultEnclaveLayout = EnclaveLayout{
+	Base:   0x040000000000,
+	Size:   0x001000000000,
+	Heap:   1 << 27,
+	Tcs:    4,
+	Unsafe: PSIZE * 500,
+	Membuf: PSIZE * 1400,
+}
+
+// enclLayout is the layout of the enclave, which reads it from its manifest
+// in osinit. Outside, each cooperative runtime holds the layout of its
+// enclave.
+var enclLayout = defaultEnclaveLayout
+
+// ParseEnclaveManifest parses the manifest s of an enclave, as embedded by
+// the linker. Missing entries have their default value.
+func ParseEnclaveManifest(s string) (EnclaveLayout, bool) {
+	l := defaultEnclaveLayout
+	for s != "" {
+		kv := s
+		s = ""
+		for i := 0; i < len(kv); i++ {
+			if kv[i] == ',' {
+				kv, s = kv[:i], kv[i+1:]
+				break
+			}
+		}
+		eq := 0
+		for eq < len(kv) && kv[eq] != '=' {
+			eq++
+		}
+		if eq == len(kv) {
+			return l, false
+		}
+		switch kv[:eq] {
+		case "name":
+			l.Name = kv[eq+1:]
+			continue
+		case "backend":
+			l.Backend = kv[eq+1:]
+			continue
+		}
+		v, ok := parseManifestValue(kv[eq+1:])
+		if !ok {
+			return l, false
+		}
+		switch kv[:eq] {
+		case "base":
+			l.Base = v
+		case "size":
+			l.Size = v
+		case "heap":
+			l.Heap = v
+		case "tcs":
+			l.Tcs = int(v)
+		case "unsafe":
+			l.Unsafe = v
+		case "membuf":
+			l.Membuf = v
+		case "redact":
+			l.Redact = v != 0
+		default:
+			return l, false
+		}
+	}
+	return l, l.Tcs >= 3 && l.Tcs <= EnclaveMaxTls && l.Heap > 0 && l.Heap&(l.Heap-1) == 0
+}
+
+// parseManifestValue parses a decimal or 0x-prefixed hexadecimal number.
+func parseManifestValue(s string) (uintptr, bool) {
+	base := uintptr(10)
+	if len(s) > 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		base, s = 16, s[2:]
+	}
+	if len(s) == 0 {
+		return 0, false
+	}
+	v := uintptr(0)
+	for i := 0; i < len(s); i++ {
+		var d uintptr
+		switch c := s[i]; {
+		case '0' <= c && c <= '9':
+			d = uintptr(c - '0')
+		case base == 16 && 'a' <= c && c <= 'f':
+			d = uintptr(c-'a') + 10
+		case base == 16 && 'A' <= c && c <= 'F':
+			d = uintptr(c-'A') + 10
+		default:
+			return 0, false
+		}
+		if v > (^uintptr(0)-d)/base {
+			return 0, false
+		}
+		v = v*base + d
+	}
+	return v, true
+}
+
+// initEnclaveLayout reads the manifest of the enclave.
+func initEnclaveLayout() {
+	if !isEnclave {
+		return
+	}
+	l, ok := ParseEnclaveManifest(enclaveManifest)
+	if !ok {
+		throw("runtime: invalid enclave manifest")
+	}
+	enclLayout = l
+}
+
+// CurrentEnclaveLayout returns the layout of the enclave.
+func CurrentEnclaveLayout() EnclaveLayout {
+	return enclLayout
+}
+
+// membufStart returns the address of the buffer for the mmaps of the enclave,
+// at the end of its range.
+//go:nosplit
+func membufStart() uintptr {
+	return enclLayout.membufStart()
+}
+
+//go:nosplit
+func (l *EnclaveLayout) membufStart() uintptr {
+	return l.Base + l.Size - PSIZE - l.Membuf
+}
+
+//go:nosplit
+func (l *EnclaveLayout) contains(addr uintptr) bool {
+	return addr >= l.Base && addr < l.Base+l.Size
+}
+
+// inEnclaveRange reports whether addr is in the address range of the enclave
+// or, outside of the enclaves, of one of them.
+//go:nosplit
+func inEnclaveRange(addr uintptr) bool {
+	if isEnclave {
+		return enclLayout.contains(addr)
+	}
+	for i := range cooprts {
+		if c := cooprts[i]; c != nil && c.layout.contains(addr) {
+			return true
+		}
+	}
+	return false
+}
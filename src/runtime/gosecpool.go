@@ -0,0 +1,176 @@
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+// The pool of the channels on which the untrusted side replies to the
+// requests of the enclave. The channels are allocated by the untrusted side,
+// as it cannot send on channels of the enclave, in chunks that are never
+// freed. The free channels form a lock-free stack. When it is empty, the
+// enclave asks the untrusted side for a new chunk, and waits for a channel
+// to be released or added.
+
+const (
+	sysChunkSize = 512
+	maxSysChunks = 64
+)
+
+// States of a channel of the pool.
+const (
+	sysChanFree = iota
+	sysChanUsed
+)
+
+// poolSysChan is a channel of the pool. The id of a channel given to the
+// requests holds its generation, which changes when it is released, so that
+// a late reply on a released channel is detected.
+type poolSysChan struct {
+	state uint32
+	gen   uint32
+	next  uint32 // index+1 of the next free channel, 0 for none.
+	c     chan OcallRes
+}
+
+type sysChunk [sysChunkSize]poolSysChan
+
+// sysPool is a pool of reply channels.
+type sysPool struct {
+	head    uint64 // tag<<32 | index+1 of the first free channel.
+	chunks  [maxSysChunks]*sysChunk
+	nchunks uint32
+	growing uint32 // 1 while a new chunk is requested.
+}
+
+// sysGenMask keeps the ids positive.
+const sysGenMask = 1<<31 - 1
+
+func sysPoolId(idx, gen uint32) int { return int(uint64(gen&sysGenMask)<<32 | uint64(idx)) }
+
+func (p *sysPool) entry(idx uint32) *poolSysChan {
+	if idx/sysChunkSize >= atomic.Load(&p.nchunks) {
+		return nil
+	}
+	chunk := (*sysChunk)(atomic.Loadp(unsafe.Pointer(&p.chunks[idx/sysChunkSize])))
+	return &chunk[idx%sysChunkSize]
+}
+
+// lookup returns the channel of id, and reports why id is invalid, if it is.
+func (p *sysPool) lookup(id int, what string) *poolSysChan {
+	idx, gen := uint32(id), uint32(uint64(id)>>32)
+	s := p.entry(idx)
+	switch {
+	case id < 0 || s == nil:
+		print("syspool: ", what, " of unknown channel ", id, "\n")
+		panicGosec("syspool: " + what + " of an unknown channel")
+	case atomic.Load(&s.gen)&sysGenMask != gen:
+		print("syspool: ", what, " of channel ", idx, " generation ", gen, ", released since, now at generation ", atomic.Load(&s.gen)&sysGenMask, "\n")
+		panicGosec("syspool: " + what + " of a released channel")
+	case atomic.Load(&s.state) != sysChanUsed:
+		print("syspool: ", what, " of free channel ", idx, " generation ", gen, "\n")
+		panicGosec("syspool: " + what + " of a free channel")
+	}
+	return s
+}
+
+// push puts the channels first to last, linked by next, on the free stack.
+func (p *sysPool) push(first, last uint32) {
+	l := p.entry(last)
+	for {
+		old := atomic.Load64(&p.head)
+		atomic.Store(&l.next, uint32(old))
+		if atomic.Cas64(&p.head, old, (old>>32+1)<<32|uint64(first+1)) {
+			return
+		}
+	}
+}
+
+// pop takes a channel off the free stack, and returns its index, or false if
+// the stack is empty.
+func (p *sysPool) pop() (uint32, bool) {
+	for {
+		old := atomic.Load64(&p.head)
+		if uint32(old) == 0 {
+			return 0, false
+		}
+		idx := uint32(old) - 1
+		next := atomic.Load(&p.entry(idx).next)
+		if atomic.Cas64(&p.head, old, (old>>32+1)<<32|uint64(next)) {
+			return idx, true
+		}
+	}
+}
+
+// grow adds a chunk of channels to the pool. It is called by the untrusted
+// side only.
+func (p *sysPool) grow() {
+	n := atomic.Load(&p.nchunks)
+	if n < maxSysChunks {
+		chunk := new(sysChunk)
+		for i := range chunk {
+			chunk[i].c = make(chan OcallRes)
+			chunk[i].next = n*sysChunkSize + uint32(i) + 2
+		}
+		atomicstorep(unsafe.Pointer(&p.chunks[n]), unsafe.Pointer(chunk))
+		atomic.Store(&p.nchunks, n+1)
+		p.push(n*sysChunkSize, (n+1)*sysChunkSize-1)
+	}
+	atomic.Store(&p.growing, 0)
+}
+
+// acquire returns a free channel and its id. If there is none, it asks for
+// more with onEmpty, if not nil, and waits.
+func (p *sysPool) acquire(onEmpty func()) (int, chan OcallRes) {
+	for i := 0; ; i++ {
+		if idx, ok := p.pop(); ok {
+			s := p.entry(idx)
+			if !atomic.Cas(&s.state, sysChanFree, sysChanUsed) {
+				print("syspool: free channel ", idx, " in use\n")
+				panicGosec("syspool: corrupted free list")
+			}
+			return sysPoolId(idx, s.gen), s.c
+		}
+		if i == 0 && onEmpty != nil && atomic.Load(&p.nchunks) < maxSysChunks && atomic.Cas(&p.growing, 0, 1) {
+			onEmpty()
+		}
+		pollBackoff(i)
+	}
+}
+
+// release puts back the channel of id.
+func (p *sysPool) release(id int) {
+	s := p.lookup(id, "release")
+	if !atomic.Cas(&s.state, sysChanUsed, sysChanFree) {
+		print("syspool: concurrent release of channel ", uint32(id), "\n")
+		panicGosec("syspool: release of a free channel")
+	}
+	atomic.Xadd(&s.gen, 1)
+	p.push(uint32(id), uint32(id))
+}
+
+//AcquireSysPool returns a channel for the reply to a request of the enclave,
+//and its id for the request.
+func (c *CooperativeRuntime) AcquireSysPool() (int, chan OcallRes) {
+	return c.sysPool.acquire(c.requestSysPool)
+}
+
+// requestSysPool asks the untrusted side for more channels.
+func (c *CooperativeRuntime) requestSysPool() {
+	c.SysPost(OcallReq{GRW, 0, 0, 0, 0, 0, 0, 0, 0})
+}
+
+//ReleaseSysPool puts back the channel of id, once the reply is received.
+func (c *CooperativeRuntime) ReleaseSysPool(id int) {
+	c.sysPool.release(id)
+}
+
+//SysSend sends the reply r to the request that holds the channel of id.
+func (c *CooperativeRuntime) SysSend(id int, r OcallRes) {
+	c.sysPool.lookup(id, "reply").c <- r
+}
+
+//GrowSysPool adds channels to the pool, for a GRW request of the enclave.
+func (c *CooperativeRuntime) GrowSysPool() {
+	c.sysPool.grow()
+}
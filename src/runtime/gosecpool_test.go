@@ -0,0 +1,118 @@
+package runtime_test
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func mustPanic(t *testing.T, want string, f func()) {
+	t.Helper()
+	defer func() {
+		r := recover()
+		if s, ok := r.(string); !ok || !strings.Contains(s, want) {
+			t.Errorf("panic %v, want %q", r, want)
+		}
+	}()
+	f()
+}
+
+func TestSysPoolRelease(t *testing.T) {
+	p := runtime.NewSysPool()
+	id, c := p.Acquire(nil)
+	go p.Send(id, runtime.OcallRes{R1: 42})
+	if res := <-c; res.R1 != 42 {
+		t.Errorf("reply %+v, want 42", res)
+	}
+	p.Release(id)
+	mustPanic(t, "release of a released channel", func() { p.Release(id) })
+	mustPanic(t, "reply of a released channel", func() { p.Send(id, runtime.OcallRes{}) })
+	mustPanic(t, "release of an unknown channel", func() { p.Release(runtime.SysChunkSize) })
+
+	// The channel is reused with another id, and the old one stays invalid.
+	id2, c2 := p.Acquire(nil)
+	if id2 == id || c2 != c {
+		t.Errorf("reacquired channel %d, %v, want a new id for %v", id2, c2, c)
+	}
+	mustPanic(t, "reply of a released channel", func() { p.Send(id, runtime.OcallRes{}) })
+	p.Release(id2)
+}
+
+func TestSysPoolGrow(t *testing.T) {
+	p := runtime.NewSysPool()
+	for i := 0; i < runtime.SysChunkSize; i++ {
+		p.Acquire(nil)
+	}
+	asked := 0
+	p.Acquire(func() {
+		asked++
+		go p.Grow()
+	})
+	if asked != 1 || p.Len() != 2*runtime.SysChunkSize {
+		t.Errorf("asked %d times, %d channels, want 1 and %d", asked, p.Len(), 2*runtime.SysChunkSize)
+	}
+}
+
+func TestSysPoolBackpressure(t *testing.T) {
+	p := runtime.NewSysPool()
+	var ids []int
+	for i := 0; i < runtime.SysChunkSize; i++ {
+		id, _ := p.Acquire(nil)
+		ids = append(ids, id)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.Release(ids[7])
+	}()
+	start := time.Now()
+	p.Acquire(nil)
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Errorf("acquired a channel of a full pool after %v", d)
+	}
+	if p.Len() != runtime.SysChunkSize {
+		t.Errorf("the pool grew to %d channels", p.Len())
+	}
+}
+
+func TestSysPoolConcurrent(t *testing.T) {
+	p := runtime.NewSysPool()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			held := map[int]bool{}
+			for i := 0; i < 1000; i++ {
+				id, _ := p.Acquire(nil)
+				if held[id] {
+					t.Errorf("channel %d acquired twice", id)
+					return
+				}
+				held[id] = true
+				if i%3 != 0 {
+					p.Release(id)
+					delete(held, id)
+				}
+				if len(held) > 32 {
+					for id := range held {
+						p.Release(id)
+						delete(held, id)
+					}
+				}
+			}
+			for id := range held {
+				p.Release(id)
+			}
+		}()
+	}
+	wg.Wait()
+	// All the channels are free again.
+	for i := 0; i < runtime.SysChunkSize; i++ {
+		p.Acquire(nil)
+	}
+	if p.Len() != runtime.SysChunkSize {
+		t.Errorf("the pool grew to %d channels", p.Len())
+	}
+}
@@ -0,0 +1,163 @@
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+// Crash reports of the enclave. The enclave cannot print nor exit on its own:
+// when it panics or throws, it writes what it would print, the id of the
+// goroutine and the pcs of its stack in an EnclaveCrash that the untrusted
+// side allocated, wakes up the untrusted side, and waits for the process to
+// exit. The untrusted side symbolizes the pcs against the executable of the
+// enclave and prints the traceback, see gosec.
+//
+// An enclave whose manifest sets redact only reports the kind of the crash
+// and the goroutine, as the message and the stack may reveal its secrets.
+
+const (
+	CrashMsgSize = 1024 // bytes of the message kept in a crash report.
+	CrashMaxPCs  = 64   // frames kept in a crash report.
+)
+
+// States of an EnclaveCrash.
+const (
+	crashNone     = 0
+	crashWriting  = 1 // an m of the enclave fills the report.
+	crashReported = 2
+	crashCanceled = 3 // the enclave was shut down, see gosechalt.go.
+)
+
+//EnclaveCrash is the report of a fatal panic or throw of the enclave. It
+//holds no pointer, the enclave fills it in memory of the untrusted side.
+type EnclaveCrash struct {
+	state uint32
+	owner uintptr // the m of the enclave that fills the report.
+
+	Throw    bool  // a fatal error of the runtime rather than a panic.
+	Redacted bool  // the enclave omitted the message and the stack.
+	System   bool  // the stack is the one of the system goroutine.
+	Goid     int64 // the crashed goroutine.
+	Gopc     uintptr
+	MsgLen   int
+	Msg      [CrashMsgSize]byte // what the enclave would have printed.
+	NPCs     int
+	PCs      [CrashMaxPCs]uintptr // the return pcs of the frames, as in Callers.
+}
+
+// crashReport returns the report the current m of the enclave fills, nil if
+// it does not fill one.
+//go:nosplit
+func crashReport() *EnclaveCrash {
+	if !isEnclave || Cooprt == nil || Cooprt.Crash == nil {
+		return nil
+	}
+	c := Cooprt.Crash
+	if atomic.Load(&c.state) != crashWriting || c.owner != uintptr(unsafe.Pointer(getg().m)) {
+		return nil
+	}
+	return c
+}
+
+// gosecCrashBegin starts the crash report of the enclave, if no other m
+// started one. From then on, the output of the current m goes to the report.
+func gosecCrashBegin(throw bool) {
+	if !isEnclave || Cooprt == nil || Cooprt.Crash == nil {
+		return
+	}
+	c := Cooprt.Crash
+	if !atomic.Cas(&c.state, crashNone, crashWriting) {
+		return
+	}
+	c.owner = uintptr(unsafe.Pointer(getg().m))
+	c.Throw = throw
+	c.Redacted = enclLayout.Redact
+}
+
+// gosecCrashWrite appends b to the message of the crash report of the current
+// m and reports whether it did.
+func gosecCrashWrite(b []byte) bool {
+	c := crashReport()
+	if c == nil {
+		return false
+	}
+	if !c.Redacted {
+		c.MsgLen += copy(c.Msg[c.MsgLen:], b)
+	}
+	return true
+}
+
+// gosecCrash completes the crash report with the stack of gp at pc and sp,
+// hands it to the untrusted side and never returns. It is called by dopanic_m
+// and returns if the enclave does not report its crashes.
+func gosecCrash(gp *g, pc, sp uintptr) {
+	gosecCrashBegin(getg().m.throwing > 0)
+	c := crashReport()
+	if c == nil {
+		return
+	}
+	c.Goid = gp.goid
+	c.System = gp == gp.m.g0
+	if !c.Redacted {
+		c.Gopc = gp.gopc
+		c.NPCs = gentraceback(pc, sp, 0, gp, 0, &c.PCs[0], len(c.PCs), nil, nil, 0)
+	}
+	atomic.Store(&c.state, crashReported)
+	futexwakeup(&c.state, 1)
+	for {
+		futexsleep(&c.state, crashReported, -1)
+	}
+}
+
+//WaitEnclaveCrash blocks until the enclave of c crashes and returns its
+//report, or returns nil once the crash report is canceled.
+func WaitEnclaveCrash(c *CooperativeRuntime) *EnclaveCrash {
+	if isEnclave || c == nil {
+		panicGosec("WaitEnclaveCrash outside of the untrusted side.")
+	}
+	if waitEnclave(&c.Crash.state, crashReported) == crashCanceled {
+		return nil
+	}
+	return c.Crash
+}
+
+//CancelEnclaveCrash makes WaitEnclaveCrash return nil, unless the enclave of c
+//already started to report a crash.
+func CancelEnclaveCrash(c *CooperativeRuntime) {
+	if atomic.Cas(&c.Crash.state, crashNone, crashCanceled) {
+		futexwakeup(&c.Crash.state, 1)
+	}
+}
+
+// waitEnclave waits for the value at addr, which the enclave sets, to be at
+// least want, and returns it. The m waits in a blocking system call, where it
+// is not counted as running: otherwise, the scheduler would not keep an m
+// spinning for the goroutines that the enclave makes ready, see stopm.
+func waitEnclave(addr *uint32, want uint32) uint32 {
+	lock(&sched.lock)
+	sched.nmsys++
+	unlock(&sched.lock)
+	entersyscallblock(0)
+	s := waitState(addr, want)
+	// Count the m as running again before exitsyscall, which may stop it.
+	systemstack(func() {
+		lock(&sched.lock)
+		sched.nmsys--
+		unlock(&sched.lock)
+	})
+	exitsyscall(0)
+	return s
+}
+
+// waitState waits for the value at addr to be at least want, and returns it.
+// It runs in a blocking system call.
+//go:nosplit
+func waitState(addr *uint32, want uint32) uint32 {
+	for {
+		s := atomic.Load(addr)
+		if s >= want {
+			return s
+		}
+		futexsleep(addr, s, -1)
+	}
+}
@@ -0,0 +1,239 @@
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+// The switchless ocalls: the enclave posts its requests in the slots of a
+// ring in unsafe memory, and host workers poll the ring, serve the requests
+// and write the replies back in the slots, where the enclave polls for them.
+// No goroutine or channel is involved, and the enclave never exits.
+
+// States of a slot of the ring.
+const (
+	slotFree   = iota
+	slotBusy   // being filled by the enclave.
+	slotPosted // waiting for a worker.
+	slotTaken  // being served by a worker.
+	slotDone   // the reply is ready.
+)
+
+// OcallRingSize is the default number of slots of the ring.
+const OcallRingSize = 256
+
+// ocallSlot is a slot of the ring, of two cache lines.
+type ocallSlot struct {
+	state uint32
+	reply uint32 // 1 if the enclave waits for the reply.
+	taken int64  // nanotime when a worker took the request.
+	req   OcallReq
+	res   OcallRes
+	_     [16]byte
+}
+
+//OcallStats are the counters of the switchless ocalls, to size the ring and
+//the number of workers.
+type OcallStats struct {
+	Requests   uint64 // requests posted on the ring.
+	Fallbacks  uint64 // requests sent on Cooprt.Ocall as the ring was full.
+	Batches    uint64 // batches of requests taken by the workers.
+	Depth      uint64 // requests posted and not taken yet.
+	MaxDepth   uint64 // largest depth a worker saw.
+	Completed  uint64 // requests served.
+	Latency    uint64 // total time, in ns, the workers took to serve them.
+	MaxLatency uint64 // longest time, in ns, to serve a request.
+	Polls      uint64 // rounds the enclave waited for replies.
+}
+
+//OcallRing is the ring of the switchless ocalls.
+type OcallRing struct {
+	slots uintptr // in unsafe memory.
+	n     uint32
+	next  uint32 // where the enclave looks for a free slot first.
+	taken uint64 // requests taken by the workers.
+	stats OcallStats
+}
+
+//NewOcallRing allocates a ring of n slots in unsafe memory.
+func NewOcallRing(n int) *OcallRing {
+	if n <= 0 {
+		panic("gosec: invalid size of the ocall ring")
+	}
+	size := round(uintptr(n)*unsafe.Sizeof(ocallSlot{}), PSIZE)
+	ptr, err := MmapUnsafe(size)
+	if err != 0 {
+		panic("gosec: unable to allocate the ocall ring")
+	}
+	return &OcallRing{slots: uintptr(ptr), n: uint32(n)}
+}
+
+//Free releases the memory of the ring, once neither the enclave nor the
+//workers use it.
+func (r *OcallRing) Free() {
+	MunmapUnsafe(unsafe.Pointer(r.slots), round(uintptr(r.n)*unsafe.Sizeof(ocallSlot{}), PSIZE))
+	r.slots, r.n = 0, 0
+}
+
+func (r *OcallRing) slot(i uint32) *ocallSlot {
+	return (*ocallSlot)(unsafe.Pointer(r.slots + uintptr(i)*unsafe.Sizeof(ocallSlot{})))
+}
+
+// post puts req in a free slot, and returns it, or nil if the ring is full.
+func (r *OcallRing) post(req OcallReq, reply bool) *ocallSlot {
+	start := atomic.Xadd(&r.next, 1)
+	for i := uint32(0); i < r.n; i++ {
+		id := (start + i) % r.n
+		s := r.slot(id)
+		if atomic.Load(&s.state) != slotFree || !atomic.Cas(&s.state, slotFree, slotBusy) {
+			continue
+		}
+		req.Id = int(id)
+		s.req = req
+		s.reply = 0
+		if reply {
+			s.reply = 1
+		}
+		atomic.Xadd64(&r.stats.Requests, 1)
+		atomic.Store(&s.state, slotPosted)
+		return s
+	}
+	return nil
+}
+
+//Call posts req on the ring and waits for the reply. It returns false if the
+//ring is full.
+func (r *OcallRing) Call(req OcallReq) (OcallRes, bool) {
+	s := r.post(req, true)
+	if s == nil {
+		return OcallRes{}, false
+	}
+	for i := 0; atomic.Load(&s.state) != slotDone; i++ {
+		atomic.Xadd64(&r.stats.Polls, 1)
+		pollBackoff(i)
+	}
+	res := s.res
+	atomic.Store(&s.state, slotFree)
+	return res, true
+}
+
+//Post posts req, which expects no reply, on the ring. It returns false if the
+//ring is full.
+func (r *OcallRing) Post(req OcallReq) bool {
+	return r.post(req, false) != nil
+}
+
+// pollBackoff waits before the i-th poll, for a reply or a channel: it spins at first, and
+// then lets the other goroutines run, if the caller can be rescheduled.
+func pollBackoff(i int) {
+	gp := getg()
+	if i < 64 || gp != gp.m.curg || gp.m.locks != 0 {
+		procyield(30)
+		return
+	}
+	Gosched()
+}
+
+//Take takes up to len(reqs) requests posted on the ring, for a worker, and
+//returns how many it took. The Id of a request is its slot, for Complete.
+func (r *OcallRing) Take(reqs []OcallReq) int {
+	k := 0
+	for i := uint32(0); i < r.n && k < len(reqs); i++ {
+		s := r.slot(i)
+		if atomic.Load(&s.state) != slotPosted || !atomic.Cas(&s.state, slotPosted, slotTaken) {
+			continue
+		}
+		s.taken = nanotime()
+		reqs[k] = s.req
+		reqs[k].Id = int(i)
+		k++
+	}
+	if k == 0 {
+		return 0
+	}
+	depth := atomic.Load64(&r.stats.Requests) - atomic.Xadd64(&r.taken, int64(k)) + uint64(k)
+	atomic.Xadd64(&r.stats.Batches, 1)
+	storeMax64(&r.stats.MaxDepth, depth)
+	return k
+}
+
+//Complete writes the reply res of the request taken in the slot id, and
+//frees the slot if the enclave does not wait for it.
+func (r *OcallRing) Complete(id int, res OcallRes) {
+	if id < 0 || id >= int(r.n) {
+		panic("gosec: invalid slot of the ocall ring")
+	}
+	s := r.slot(uint32(id))
+	if atomic.Load(&s.state) != slotTaken {
+		panic("gosec: completing a request that was not taken")
+	}
+	lat := uint64(nanotime() - s.taken)
+	atomic.Xadd64(&r.stats.Completed, 1)
+	atomic.Xadd64(&r.stats.Latency, int64(lat))
+	storeMax64(&r.stats.MaxLatency, lat)
+	if s.reply == 0 {
+		atomic.Store(&s.state, slotFree)
+		return
+	}
+	s.res = res
+	atomic.Store(&s.state, slotDone)
+}
+
+//Stats returns the counters of the ring.
+func (r *OcallRing) Stats() OcallStats {
+	st := OcallStats{
+		Requests:   atomic.Load64(&r.stats.Requests),
+		Fallbacks:  atomic.Load64(&r.stats.Fallbacks),
+		Batches:    atomic.Load64(&r.stats.Batches),
+		MaxDepth:   atomic.Load64(&r.stats.MaxDepth),
+		Completed:  atomic.Load64(&r.stats.Completed),
+		Latency:    atomic.Load64(&r.stats.Latency),
+		MaxLatency: atomic.Load64(&r.stats.MaxLatency),
+		Polls:      atomic.Load64(&r.stats.Polls),
+	}
+	st.Depth = st.Requests - atomic.Load64(&r.taken)
+	return st
+}
+
+// storeMax64 sets *addr to v if v is larger.
+func storeMax64(addr *uint64, v uint64) {
+	for {
+		old := atomic.Load64(addr)
+		if v <= old || atomic.Cas64(addr, old, v) {
+			return
+		}
+	}
+}
+
+//SysRequest forwards the request req of the enclave to the untrusted side and
+//returns the reply. It goes through the ring, if there are workers, and falls
+//back to Cooprt.Ocall otherwise.
+func (c *CooperativeRuntime) SysRequest(req OcallReq) OcallRes {
+	if traceGosecOcall(&req) {
+		defer traceGosecOcallDone()
+	}
+	if c.Ring != nil {
+		if res, ok := c.Ring.Call(req); ok {
+			return res
+		}
+		atomic.Xadd64(&c.Ring.stats.Fallbacks, 1)
+	}
+	syscid, csys := c.AcquireSysPool()
+	req.Id = syscid
+	c.Ocall <- req
+	res := <-csys
+	c.ReleaseSysPool(syscid)
+	return res
+}
+
+//SysPost forwards the request req of the enclave, which expects no reply, to
+//the untrusted side.
+func (c *CooperativeRuntime) SysPost(req OcallReq) {
+	if c.Ring != nil {
+		if c.Ring.Post(req) {
+			return
+		}
+		atomic.Xadd64(&c.Ring.stats.Fallbacks, 1)
+	}
+	c.Ocall <- req
+}
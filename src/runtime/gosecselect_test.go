@@ -0,0 +1,83 @@
+package runtime_test
+
+import (
+	"bytes"
+	"internal/testenv"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const gosecSelectProgram = `package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// serve adds the requests of the untrusted side on in to the results of a
+// local worker, until no request came for a while, and sends the sum on out.
+func serve(in chan int, out chan string) {
+	local := make(chan int)
+	go func() {
+		for i := 0; i < 3; i++ {
+			local <- 100 + i
+		}
+	}()
+	n := 0
+	for {
+		select {
+		case v := <-in:
+			n += v
+		case v := <-local:
+			n += v
+		case <-time.After(100 * time.Millisecond):
+			select {
+			case out <- "sum " + strconv.Itoa(n):
+			case <-time.After(10 * time.Second):
+			}
+			return
+		}
+	}
+}
+
+func main() {
+	in, out := make(chan int), make(chan string)
+	gosecure serve(in, out)
+	for i := 1; i <= 4; i++ {
+		in <- i
+	}
+	println(<-out)
+}
+`
+
+// TestGosecSelect checks a select of the enclave over a channel of the
+// untrusted side, a channel of the enclave and timers, in the simulation.
+func TestGosecSelect(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs an enclave")
+	}
+	testenv.MustHaveGoBuild(t)
+	dir, err := ioutil.TempDir("", "gosec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, exe := filepath.Join(dir, "main.go"), filepath.Join(dir, "main")
+	if err := ioutil.WriteFile(src, []byte(gosecSelectProgram), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(testenv.GoToolPath(t), "build", "-o", exe, src).CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), "SIM=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil || !strings.Contains(stderr.String(), "sum 313") {
+		t.Fatalf("%v, want the sum 313\n%s", err, stderr.Bytes())
+	}
+}
@@ -0,0 +1,147 @@
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+// The execution tracer of the enclave. The enclave has a trace of its own,
+// which the untrusted side starts and stops along with its trace, and reads
+// through the channels of Cooprt.Trace. Both traces hold the events of the
+// boundary crossings, so that go tool trace can merge them: the gosecure
+// calls, the requests of the enclave, the cross domain wake ups and the
+// spawns of enclave threads.
+
+//TraceChunkSize is the size of the buffer through which the enclave hands its
+//trace to the untrusted side.
+const TraceChunkSize = 64 << 10
+
+//EnclaveTrace are the channels through which the untrusted side drives the
+//tracer of the enclave. They are allocated by the untrusted side.
+type EnclaveTrace struct {
+	Ctl  chan bool // true starts the tracer of the enclave, false stops it.
+	Data chan int  // length of the next chunk of the trace in Buf, 0 at the end.
+	Ack  chan bool // the chunk in Buf was consumed.
+	Buf  []byte    // of TraceChunkSize bytes.
+}
+
+func newEnclaveTrace() *EnclaveTrace {
+	return &EnclaveTrace{
+		Ctl:  make(chan bool),
+		Data: make(chan int),
+		Ack:  make(chan bool),
+		Buf:  make([]byte, TraceChunkSize),
+	}
+}
+
+// gosecureSeq numbers the gosecure calls, to match their dispatch in the
+// enclave.
+var gosecureSeq uint64
+
+// traceGosecEcall records the gosecure call req, sent to the enclave or
+// dispatched in it.
+func traceGosecEcall(ev byte, req *EcallReq) {
+	mp, pid, bufp := traceAcquireBuffer()
+	if !trace.enabled && !mp.startingtrace {
+		traceReleaseBuffer(pid)
+		return
+	}
+	var name uint64
+	name, bufp = traceString(bufp, pid, req.Name)
+	skip := -1
+	if ev == traceEvGosecEcall {
+		skip = 2
+	}
+	traceEventLocked(mp, pid, bufp, ev, skip, req.Seq, name)
+	traceReleaseBuffer(pid)
+}
+
+//TraceEcall records, in the trace of the enclave, that the gosecure call req
+//is dispatched.
+func TraceEcall(req *EcallReq) {
+	if trace.enabled {
+		traceGosecEcall(traceEvGosecDispatch, req)
+	}
+}
+
+// traceGosecOcall records that the current goroutine sends the request req
+// to the untrusted side. Only the requests of user goroutines are recorded.
+func traceGosecOcall(req *OcallReq) bool {
+	gp := getg()
+	if !trace.enabled || gp != gp.m.curg {
+		return false
+	}
+	traceEvent(traceEvGosecOcall, -1, uint64(req.Big), uint64(req.Trap))
+	return true
+}
+
+func traceGosecOcallDone() {
+	traceEvent(traceEvGosecOcallDone, -1)
+}
+
+// traceGosecCrossWakeup records that a goroutine of the other domain, of the
+// enclave if encl, is made ready.
+func traceGosecCrossWakeup(encl bool) {
+	e := uint64(0)
+	if encl {
+		e = 1
+	}
+	traceEvent(traceEvGosecCrossWakeup, 3, e)
+}
+
+// traceGosecMigrate records that n goroutines made ready by the other domain
+// are queued.
+func traceGosecMigrate(n int) {
+	traceEvent(traceEvGosecMigrate, -1, uint64(n))
+}
+
+// traceGosecSpawn records the spawn of the enclave thread of the tcs id.
+func traceGosecSpawn(id uint64) {
+	traceEvent(traceEvGosecSpawn, -1, id)
+}
+
+// nextGosecureSeq returns the sequence number of a new gosecure call.
+func nextGosecureSeq() uint64 {
+	return atomic.Xadd64(&gosecureSeq, 1)
+}
+
+// The enclave cannot give memory back, see sysFree: it keeps the buffers of
+// its tracer, which are all of traceSpareSize bytes, for the next trace.
+const traceSpareSize = 64 << 10
+
+var traceSpare struct {
+	lock mutex
+	head uintptr // linked by their first word.
+}
+
+// traceSysAlloc is sysAlloc for the tracer.
+func traceSysAlloc(n uintptr) unsafe.Pointer {
+	if isEnclave && n == traceSpareSize {
+		lock(&traceSpare.lock)
+		p := traceSpare.head
+		if p != 0 {
+			traceSpare.head = *(*uintptr)(unsafe.Pointer(p))
+		}
+		unlock(&traceSpare.lock)
+		if p != 0 {
+			memclrNoHeapPointers(unsafe.Pointer(p), n)
+			return unsafe.Pointer(p)
+		}
+	}
+	return sysAlloc(n, &memstats.other_sys)
+}
+
+// traceSysFree is sysFree for the tracer.
+func traceSysFree(p unsafe.Pointer, n uintptr) {
+	if isEnclave {
+		if n != traceSpareSize {
+			throw("trace: unexpected size of buffer")
+		}
+		lock(&traceSpare.lock)
+		*(*uintptr)(p) = traceSpare.head
+		traceSpare.head = uintptr(p)
+		unlock(&traceSpare.lock)
+		return
+	}
+	sysFree(p, n, &memstats.other_sys)
+}
@@ -169,15 +169,9 @@ const (
 	minLegalPointer uintptr = 4096
 )
 
-//TODO aghosn check this.
-// We redefine our own _MaxMemEncl and the _MHeapMap_BitsEncl and replace them in the code.
-// The original values are not used often so it should be feasible.
-// Can set them in runtime.osinit (first of bootloading sequence)
-var (
-	_MHeapMap_TotalBitsEncl uintptr = 0
-	_MHeapMap_BitsEncl      uintptr = 0
-	_MaxMemEncl             uintptr = 0
-)
+// _MaxMemEncl replaces _MaxMem in the enclave. It is set by osinit from the
+// heap size of the enclave manifest.
+var _MaxMemEncl uintptr = 0
 
 // physPageSize is the size in bytes of the OS's physical pages.
 // Mapping and unmapping operations must be done at multiples of
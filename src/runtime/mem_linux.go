@@ -60,7 +60,7 @@ func mmap_fixed(v unsafe.Pointer, n uintptr, prot, flags, fd int32, offset uint3
 func sysAlloc(n uintptr, sysStat *uint64) unsafe.Pointer {
 	if isEnclave {
 		//Cooprt.sl.Lock()
-		if Cooprt.membuf_head+n > MEMBUF_START+MEMBUF_SIZE {
+		if Cooprt.membuf_head+n > membufStart()+enclLayout.Membuf {
 			throw("Unable to sysAlloc in enclave, ran out of membuf memory")
 		}
 		res := Cooprt.membuf_head
@@ -161,6 +161,12 @@ func sysUnused(v unsafe.Pointer, n uintptr) {
 		throw("unaligned sysUnused")
 	}
 
+	// The shared arena of the isolated simulation keeps its pages
+	// otherwise, see gosecisolated.go.
+	if inIsolatedArena(v, n) {
+		madvise(v, n, _MADV_REMOVE)
+		return
+	}
 	madvise(v, n, _MADV_DONTNEED)
 }
 
@@ -212,6 +218,9 @@ func sysReserve(v unsafe.Pointer, n uintptr, reserved *bool) unsafe.Pointer {
 		}
 		panic("runtime: trying to reserve an illegal address in the enclave.")
 	}
+	if isolated.on && sys.PtrSize == 8 && uint64(n) > 1<<32 {
+		return isolatedReserve(v, n, reserved)
+	}
 
 	// On 64-bit, people with ulimit -v set complain if we reserve too
 	// much address space. Instead, assume that the reservation is okay
@@ -249,6 +258,10 @@ func sysMap(v unsafe.Pointer, n uintptr, reserved bool, sysStat *uint64) {
 		print("faulty address:", hex(uintptr(v)), "\n")
 		panic("runtime: enclave is trying to mmap a forbidden region!")
 	}
+	if inIsolatedArena(v, n) {
+		// Already mapped, see isolatedReserve.
+		return
+	}
 
 	// On 64-bit, we don't actually have v reserved, so tread carefully.
 	if !reserved {
@@ -154,37 +154,32 @@ func gosecinterpose(trap, a1, a2, a3, a4, a5, a6 uintptr) int32 {
 		panic("oh shit")
 	}
 	var r1 uintptr
-	syscid, csys := Cooprt.AcquireSysPool()
 	switch trap {
 	case _sys_closeonexec:
 		fallthrough
 	case _sys_epoll_create:
 		fallthrough
 	case _sys_epoll_create1:
-		req := OcallReq{S3, trap, a1, a2, a3, 0, 0, 0, syscid}
-		Cooprt.Ocall <- req
-		res := <-csys
+		res := Cooprt.SysRequest(OcallReq{S3, trap, a1, a2, a3, 0, 0, 0, 0})
 		r1 = res.R1
 	case _sys_epoll_ctl:
 		sev := unsafe.Sizeof(epollevent{})
 		ev := UnsafeAllocator.Malloc(sev)
 		memcpy(ev, a4, sev)
-		req := OcallReq{S6, trap, a1, a2, a3, ev, 0, 0, syscid}
-		Cooprt.Ocall <- req
-		res := <-csys
+		res := Cooprt.SysRequest(OcallReq{S6, trap, a1, a2, a3, ev, 0, 0, 0})
 		//copy back the results and free.
 		memcpy(a4, ev, sev)
 		UnsafeAllocator.Free(ev, sev)
 		r1 = res.R1
 	case _sys_epoll_wait:
-		// We need to do an exit here, so lets give up the channel.
-		Cooprt.ReleaseSysPool(syscid)
+		// We need to do an exit here. The buffers are not counted as they
+		// are held while the thread waits.
 		sev := unsafe.Sizeof(epollevent{})
-		ev := UnsafeAllocator.Malloc(sev)
-		req := (*OcallReq)(unsafe.Pointer(UnsafeAllocator.Malloc(unsafe.Sizeof(OcallReq{}))))
-		res := (*OcallRes)(unsafe.Pointer(UnsafeAllocator.Malloc(unsafe.Sizeof(OcallRes{}))))
+		ev := UnsafeAllocator.malloc(sev, 0)
+		req := (*OcallReq)(unsafe.Pointer(UnsafeAllocator.malloc(unsafe.Sizeof(OcallReq{}), 0)))
+		res := (*OcallRes)(unsafe.Pointer(UnsafeAllocator.malloc(unsafe.Sizeof(OcallRes{}), 0)))
 		memcpy(ev, a2, sev)
-		*req = OcallReq{S6, trap, a1, ev, a3, a4, a5, a6, syscid}
+		*req = OcallReq{S6, trap, a1, ev, a3, a4, a5, a6, 0}
 		sgx_ocall_epoll_pwait(req, res)
 		r1 = res.R1
 		UnsafeAllocator.Free(uintptr(unsafe.Pointer(req)), unsafe.Sizeof(*req))
@@ -195,7 +190,6 @@ func gosecinterpose(trap, a1, a2, a3, a4, a5, a6 uintptr) int32 {
 	default:
 		panic("Unsupported gosecinterpose syscall")
 	}
-	Cooprt.ReleaseSysPool(syscid)
 	return int32(r1)
 }
 
@@ -209,9 +203,10 @@ func sgx_ocall_epoll_pwait(req *OcallReq, res *OcallRes) {
 	}
 	ustk := gp.m.g0.sched.usp
 	ubp := gp.m.g0.sched.ubp
-	aptr := UnsafeAllocator.Malloc(unsafe.Sizeof(OExitRequest{}))
+	aptr := UnsafeAllocator.malloc(unsafe.Sizeof(OExitRequest{}), 0)
 	args := (*OExitRequest)(unsafe.Pointer(aptr))
 	args.Cid = EPollWaitRequest
+	args.Eid = Cooprt.Eid
 	args.Sid = gp.m.procid
 	args.EWReq = uintptr(unsafe.Pointer(req))
 	args.EWRes = uintptr(unsafe.Pointer(res))
@@ -40,7 +40,7 @@ func futexsleep(addr *uint32, val uint32, ns int64) {
 	}
 	var ts timespec
 	// TODO @aghosn: just a check for the moment. Seems we have a problem here.
-	if _ap := uintptr(unsafe.Pointer(addr)); !isEnclave && _ap >= ENCLMASK && _ap <= ENCLMASK+ENCLSIZE {
+	if _ap := uintptr(unsafe.Pointer(addr)); !isEnclave && inEnclaveRange(_ap) {
 		panic("[DEBUG] trying to futexsleep from untrusted on trusted object.")
 	}
 	// Some Linux kernels have a bug where futex of
@@ -169,10 +169,14 @@ func newosproc(mp *m, stk unsafe.Pointer) {
 	aptr := UnsafeAllocator.Malloc(unsafe.Sizeof(OExitRequest{}))
 	args := (*OExitRequest)(unsafe.Pointer(aptr))
 	args.Cid = SpawnRequest
+	args.Eid = Cooprt.Eid
 	args.Sid = gp.m.procid
 	args.Did = mp.procid
 	args.Gp = uintptr(unsafe.Pointer(mp.g0))
 	args.Mp = uintptr(unsafe.Pointer(mp))
+	if trace.enabled {
+		traceGosecSpawn(mp.procid)
+	}
 	sgx_ocall(Cooprt.OEntry, aptr, ustk, ubp)
 	UnsafeAllocator.Free(aptr, unsafe.Sizeof(OExitRequest{}))
 }
@@ -308,9 +312,8 @@ func sysauxv(auxv []uintptr) int {
 }
 
 func osinit() {
-	_MHeapMap_TotalBitsEncl = _64bit * 27 //(previous was 25)
-	_MHeapMap_BitsEncl = _MHeapMap_BitsEncl - _PageShift
-	_MaxMemEncl = 1<<_MHeapMap_TotalBitsEncl - 1
+	initEnclaveLayout()
+	_MaxMemEncl = enclLayout.Heap - 1
 	if isEnclave {
 		gomaxprocs = 0
 		ncpu = 1
@@ -547,6 +547,9 @@ func gopanic(e interface{}) {
 	// so now OK to decrement runningPanicDefers.
 	atomic.Xadd(&runningPanicDefers, -1)
 
+	if isEnclave {
+		gosecCrashBegin(false)
+	}
 	printpanics(gp._panic)
 	dopanic(0)       // should not return
 	*(*int)(nil) = 0 // not reached
@@ -607,6 +610,9 @@ func sync_throw(s string) {
 
 //go:nosplit
 func throw(s string) {
+	if isEnclave {
+		gosecCrashBegin(true)
+	}
 	print("fatal error: ", s, "\n")
 	gp := getg()
 	if gp.m.throwing == 0 {
@@ -716,6 +722,9 @@ func dopanic_m(gp *g, pc, sp uintptr) {
 		}
 		print(" code=", hex(gp.sigcode0), " addr=", hex(gp.sigcode1), " pc=", hex(gp.sigpc), "]\n")
 	}
+	if isEnclave {
+		gosecCrash(gp, pc, sp)
+	}
 
 	level, all, docrash := gotraceback()
 	_g_ := getg()
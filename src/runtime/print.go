@@ -88,6 +88,9 @@ func gwrite(b []byte) {
 		return
 	}
 	recordForPanic(b)
+	if isEnclave && gosecCrashWrite(b) {
+		return
+	}
 	gp := getg()
 	if gp == nil || gp.writebuf == nil {
 		writeErr(b)
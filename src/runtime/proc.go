@@ -207,7 +207,13 @@ skipsysmon:
 	}
 
 	fn = main_main // make an indirect call, as the linker doesn't know the address of the main package when laying down the runtime
+	if isEnclave && enclaveMain != nil {
+		fn = enclaveMain
+	}
 	fn()
+	if isEnclave {
+		UnsafeLeakReport()
+	}
 	if raceenabled {
 		racefini()
 	}
@@ -512,6 +518,7 @@ func schedinit() {
 	tracebackinit()
 	moduledataverify()
 	stackinit()
+	isolatedinit()
 	mallocinit()
 	mcommoninit(_g_.m) // TODO(aghosn) apparently the stack is allocated here.
 	alginit()          // maps must not be used before this call
@@ -3983,7 +3990,7 @@ func procresize(nprocs int32) *p {
 	if old < 0 || nprocs <= 0 {
 		throw("procresize: invalid arg")
 	}
-	if trace.enabled && !isEnclave {
+	if trace.enabled {
 		traceGomaxprocs(nprocs)
 	}
 
@@ -346,6 +346,7 @@ var debug struct {
 	gcrescanstacks   int32
 	gcstoptheworld   int32
 	gctrace          int32
+	gosecleak        int32
 	invalidptr       int32
 	sbrk             int32
 	scavenge         int32
@@ -363,6 +364,7 @@ var dbgvars = []dbgVar{
 	{"gcrescanstacks", &debug.gcrescanstacks},
 	{"gcstoptheworld", &debug.gcstoptheworld},
 	{"gctrace", &debug.gctrace},
+	{"gosecleak", &debug.gosecleak},
 	{"invalidptr", &debug.invalidptr},
 	{"sbrk", &debug.sbrk},
 	{"scavenge", &debug.scavenge},
@@ -292,12 +292,16 @@ type sudog struct {
 	g *g
 
 	// isSelect indicates g is participating in a select, so
-	// g.selectDone must be CAS'd to win the wake-up race.
+	// selectdone must be CAS'd to win the wake-up race.
 	isSelect bool
 	next     *sudog
 	prev     *sudog
 	elem     unsafe.Pointer // data element (may point to stack)
 
+	// @aghosn selectdone points to g.selectDone, or to a word in unsafe
+	// memory when the select involves cross-domain channels.
+	selectdone *uint32
+
 	// The following fields are never accessed concurrently.
 	// For channels, waitlink is only accessed by g.
 	// For semaphores, all fields (including the ones above)
@@ -306,6 +310,7 @@ type sudog struct {
 	id          int32     //TODO @aghosn id in the pool (-1) if does not apply
 	schednext   sguintptr //TODO @aghosn using this for lists compatible with WB
 	needcpy     bool      // @aghosn, for interdomain deepcopy
+	crosseid    uint64    // the Eid of the enclave that woke it up, see cooprtOf.
 	acquiretime int64
 	releasetime int64
 	ticket      uint32
@@ -405,7 +410,7 @@ type g struct {
 
 	isencl        bool
 	markednofutex bool
-	ecallchan     chan EcallReq
+	ecallchans    []ecallChan // by the Eid of the enclave, see GosecureSend.
 }
 
 type m struct {
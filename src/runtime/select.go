@@ -281,18 +281,31 @@ func selectgo(sel *hselect) int {
 		}
 	*/
 
+	// A value that cannot be copied out of the enclave fails the select
+	// before it locks the channels, like an evaluation of its cases.
+	for i := range scases {
+		if cas := &scases[i]; cas.kind == caseSend {
+			if err := checkSend(cas.c, cas.elem); err != nil {
+				panic(err)
+			}
+		}
+	}
+
 	// lock all the channels involved in the select
 	sellock(scases, lockorder)
 
 	var (
-		gp     *g
-		sg     *sudog
-		c      *hchan
-		k      *scase
-		sglist *sudog
-		sgnext *sudog
-		qp     unsafe.Pointer
-		nextp  **sudog
+		gp          *g
+		sg          *sudog
+		c           *hchan
+		k           *scase
+		sglist      *sudog
+		sgnext      *sudog
+		qp          unsafe.Pointer
+		nextp       **sudog
+		seldone     *uint32
+		crossdomain bool
+		copyerr     error
 	)
 
 loop:
@@ -355,6 +368,16 @@ loop:
 	if gp.waiting != nil {
 		throw("gp.waiting != nil")
 	}
+
+	// @aghosn if one of the channels belongs to the other domain, the
+	// wake-up race is won on a word in unsafe memory shared by all the cases.
+	seldone = &gp.selectDone
+	crossdomain = selectIsCrossDomain(gp, scases)
+	if crossdomain {
+		seldone = (*uint32)(unsafe.Pointer(UnsafeAllocator.Malloc(unsafe.Sizeof(uint32(0)))))
+		allcgadd(gp)
+	}
+
 	nextp = &gp.waiting
 	for _, casei := range lockorder {
 		casi = int(casei)
@@ -363,12 +386,19 @@ loop:
 			continue
 		}
 		c = cas.c
-		sg := acquireSudog()
+		var sg *sudog
+		elem := cas.elem
+		if checkinterdomain(gp.isencl, c.isencl) {
+			sg, elem = acquireSelectSudog(cas)
+		} else {
+			sg = acquireSudog()
+		}
 		sg.g = gp
 		sg.isSelect = true
+		sg.selectdone = seldone
 		// No stack splits between assigning elem and enqueuing
 		// sg on gp.waiting where copystack can find it.
-		sg.elem = cas.elem
+		sg.elem = elem
 		sg.releasetime = 0
 		if t0 != 0 {
 			sg.releasetime = -1
@@ -396,6 +426,10 @@ loop:
 	gp.selectDone = 0
 	sg = (*sudog)(gp.param)
 	gp.param = nil
+	if sg == nil {
+		// A cross-domain wakeup does not set gp.param.
+		sg = selectCrossWinner(gp.waiting)
+	}
 
 	// pass 3 - dequeue from unsuccessful chans
 	// otherwise they stack up on quiet channels
@@ -407,6 +441,7 @@ loop:
 	// Clear all elem before unlinking from gp.waiting.
 	for sg1 := gp.waiting; sg1 != nil; sg1 = sg1.waitlink {
 		sg1.isSelect = false
+		sg1.selectdone = nil
 		sg1.elem = nil
 		sg1.c = nil
 	}
@@ -424,6 +459,10 @@ loop:
 			// sg has already been dequeued by the G that woke us up.
 			casi = int(casei)
 			cas = k
+			// perform a deep copy
+			if sglist.needcpy && k.kind == caseRecv {
+				copyerr = doCopy(sglist, selectCrossElem(sglist, k), k.c)
+			}
 		} else {
 			c = k.c
 			if k.kind == caseSend {
@@ -434,10 +473,15 @@ loop:
 		}
 		sgnext = sglist.waitlink
 		sglist.waitlink = nil
-		releaseSudog(sglist)
+		crossReleaseSelectSudog(sglist, k.c.elemsize, sg == sglist)
 		sglist = sgnext
 	}
 
+	if crossdomain {
+		UnsafeAllocator.Free(uintptr(unsafe.Pointer(seldone)), unsafe.Sizeof(uint32(0)))
+		allcgremove(gp)
+	}
+
 	if cas == nil {
 		// We can wake up with gp.param == nil (so cas == nil)
 		// when a channel involved in the select has been closed.
@@ -477,6 +521,9 @@ loop:
 	}
 
 	selunlock(scases, lockorder)
+	if copyerr != nil {
+		panic(copyerr)
+	}
 	goto retc
 
 bufrecv:
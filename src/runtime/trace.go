@@ -64,7 +64,14 @@ const (
 	traceEvGoBlockGC         = 42 // goroutine blocks on GC assist [timestamp, stack]
 	traceEvGCMarkAssistStart = 43 // GC mark assist start [timestamp, stack]
 	traceEvGCMarkAssistDone  = 44 // GC mark assist done [timestamp]
-	traceEvCount             = 45
+	traceEvGosecEcall        = 45 // gosecure call sent to the enclave [timestamp, seq, name string id, stack]
+	traceEvGosecDispatch     = 46 // gosecure call dispatched in the enclave [timestamp, seq, name string id]
+	traceEvGosecOcall        = 47 // enclave request to the untrusted side [timestamp, type, trap]
+	traceEvGosecOcallDone    = 48 // reply to the enclave request [timestamp]
+	traceEvGosecCrossWakeup  = 49 // goroutine of the other domain made ready [timestamp, enclave, stack]
+	traceEvGosecMigrate      = 50 // goroutines made ready by the other domain are queued [timestamp, count]
+	traceEvGosecSpawn        = 51 // enclave thread spawned [timestamp, tcs id]
+	traceEvCount             = 52
 )
 
 const (
@@ -121,11 +128,12 @@ var trace struct {
 
 	// Dictionary for traceEvString.
 	//
-	// Currently this is used only at trace setup and for
-	// func/file:line info after tracing session, so we assume
-	// single-threaded access.
-	strings   map[string]uint64
-	stringSeq uint64
+	// It is used at trace setup, for func/file:line info after
+	// tracing session, and by the gosecure call events, which may
+	// run concurrently, hence the lock.
+	stringsLock mutex
+	strings     map[string]uint64
+	stringSeq   uint64
 
 	// markWorkerLabels maps gcMarkWorkerMode to string ID.
 	markWorkerLabels [len(gcMarkWorkerModeStrings)]uint64
@@ -336,7 +344,7 @@ func StopTrace() {
 	for trace.empty != 0 {
 		buf := trace.empty
 		trace.empty = buf.ptr().link
-		sysFree(unsafe.Pointer(buf), unsafe.Sizeof(*buf.ptr()), &memstats.other_sys)
+		traceSysFree(unsafe.Pointer(buf), unsafe.Sizeof(*buf.ptr()))
 	}
 	trace.strings = nil
 	trace.shutdown = false
@@ -510,6 +518,14 @@ func traceEvent(ev byte, skip int, args ...uint64) {
 		traceReleaseBuffer(pid)
 		return
 	}
+	traceEventLocked(mp, pid, bufp, ev, skip, args...)
+	traceReleaseBuffer(pid)
+}
+
+// traceEventLocked writes an event in the buffer bufp, acquired by the
+// caller with traceAcquireBuffer. skip is as for traceEvent, from the caller
+// of traceEventLocked.
+func traceEventLocked(mp *m, pid int32, bufp *traceBufPtr, ev byte, skip int, args ...uint64) {
 	buf := (*bufp).ptr()
 	const maxSize = 2 + 5*traceBytesPerNumber // event type, length, sequence, timestamp, stack id and two add params
 	if buf == nil || len(buf.arr)-buf.pos < maxSize {
@@ -544,6 +560,11 @@ func traceEvent(ev byte, skip int, args ...uint64) {
 	if skip == 0 {
 		buf.varint(0)
 	} else if skip > 0 {
+		// skip counts the frames from the caller of traceEvent, which
+		// adds a frame to the stack of the current goroutine.
+		if mp.curg == getg() {
+			skip++
+		}
 		buf.varint(traceStackID(mp, buf.stk[:], skip))
 	}
 	evSize := buf.pos - startPos
@@ -554,7 +575,6 @@ func traceEvent(ev byte, skip int, args ...uint64) {
 		// Fill in actual length.
 		*lenp = byte(evSize - 2)
 	}
-	traceReleaseBuffer(pid)
 }
 
 func traceStackID(mp *m, buf []uintptr, skip int) uint64 {
@@ -609,7 +629,7 @@ func traceFlush(buf traceBufPtr, pid int32) traceBufPtr {
 		buf = trace.empty
 		trace.empty = buf.ptr().link
 	} else {
-		buf = traceBufPtr(sysAlloc(unsafe.Sizeof(traceBuf{}), &memstats.other_sys))
+		buf = traceBufPtr(traceSysAlloc(unsafe.Sizeof(traceBuf{})))
 		if buf == 0 {
 			throw("trace: out of memory")
 		}
@@ -636,13 +656,16 @@ func traceString(bufp *traceBufPtr, pid int32, s string) (uint64, *traceBufPtr)
 	if s == "" {
 		return 0, bufp
 	}
+	lock(&trace.stringsLock)
 	if id, ok := trace.strings[s]; ok {
+		unlock(&trace.stringsLock)
 		return id, bufp
 	}
 
 	trace.stringSeq++
 	id := trace.stringSeq
 	trace.strings[s] = id
+	unlock(&trace.stringsLock)
 
 	// memory allocation in above may trigger tracing and
 	// cause *bufp changes. Following code now works with *bufp,
@@ -886,7 +909,7 @@ func (a *traceAlloc) alloc(n uintptr) unsafe.Pointer {
 		if n > uintptr(len(a.head.ptr().data)) {
 			throw("trace: alloc too large")
 		}
-		block := (*traceAllocBlock)(sysAlloc(unsafe.Sizeof(traceAllocBlock{}), &memstats.other_sys))
+		block := (*traceAllocBlock)(traceSysAlloc(unsafe.Sizeof(traceAllocBlock{})))
 		if block == nil {
 			throw("trace: out of memory")
 		}
@@ -904,7 +927,7 @@ func (a *traceAlloc) drop() {
 	for a.head != 0 {
 		block := a.head.ptr()
 		a.head.set(block.next.ptr())
-		sysFree(unsafe.Pointer(block), unsafe.Sizeof(traceAllocBlock{}), &memstats.other_sys)
+		traceSysFree(unsafe.Pointer(block), unsafe.Sizeof(traceAllocBlock{}))
 	}
 }
 
@@ -4,4 +4,94 @@
 
 package syscall
 
+import (
+	"runtime"
+	"unsafe"
+)
+
 var Itoa = itoa
+
+// CheckGosecSysTable returns the first inconsistency of sysTable, or "".
+func CheckGosecSysTable() string {
+	for trap, desc := range sysTable {
+		if !desc.allowed {
+			if desc.args != nil {
+				return "system call " + itoa(int(trap)) + ": arguments of a forbidden call"
+			}
+			continue
+		}
+		if desc.res == resLen && (len(desc.args) == 0 || desc.args[0].lenArg == 0) {
+			return "system call " + itoa(int(trap)) + ": result bounded by a size argument it does not have"
+		}
+		var seen [6]bool
+		for _, arg := range desc.args {
+			str := arg.flags&argStr != 0
+			switch {
+			case arg.idx < 0 || arg.idx >= 6 || seen[arg.idx]:
+				return "system call " + itoa(int(trap)) + ": invalid argument " + itoa(int(arg.idx))
+			case arg.flags&(argIn|argOut|argStr) == 0:
+				return "system call " + itoa(int(trap)) + ": argument " + itoa(int(arg.idx)) + " is neither in nor out"
+			case str && arg.flags != argStr:
+				return "system call " + itoa(int(trap)) + ": string argument " + itoa(int(arg.idx)) + " has flags " + itoa(int(arg.flags))
+			case arg.lenArg < 0 || arg.lenArg >= 6 || (arg.lenArg != 0 && arg.lenArg == arg.idx):
+				return "system call " + itoa(int(trap)) + ": argument " + itoa(int(arg.idx)) + " has its size in " + itoa(int(arg.lenArg))
+			case !str && (arg.lenArg != 0) == (arg.size != 0):
+				return "system call " + itoa(int(trap)) + ": argument " + itoa(int(arg.idx)) + " needs either a size or a size argument"
+			case arg.flags&argRes != 0 && (arg.flags&argOut == 0 || arg.lenArg == 0):
+				return "system call " + itoa(int(trap)) + ": argument " + itoa(int(arg.idx)) + " is bounded by the result without being a buffer"
+			}
+			seen[arg.idx] = true
+		}
+	}
+	return ""
+}
+
+// GosecArgSize returns the size of the i-th pointer argument of trap in a.
+func GosecArgSize(trap uintptr, i int, a [6]uintptr) uintptr {
+	return sysTable[trap].args[i].sizeIn(&a)
+}
+
+// tamperHost makes the system calls outside of the enclave, then lets tamper
+// change the untrusted memory and the results.
+type tamperHost struct {
+	bufs   map[uintptr][]byte
+	tamper func(u *[6]uintptr, res *runtime.OcallRes)
+}
+
+func (h *tamperHost) malloc(n uintptr) uintptr {
+	b := make([]byte, n)
+	p := uintptr(unsafe.Pointer(&b[0]))
+	h.bufs[p] = b
+	return p
+}
+
+func (h *tamperHost) free(p, n uintptr) {
+	if uintptr(len(h.bufs[p])) != n {
+		panic("tamperHost: invalid free")
+	}
+	delete(h.bufs, p)
+}
+
+func (h *tamperHost) call(tpe runtime.SysType, trap uintptr, u [6]uintptr) runtime.OcallRes {
+	r1, r2, err := SSyscall6(trap, u[0], u[1], u[2], u[3], u[4], u[5])
+	res := runtime.OcallRes{R1: r1, R2: r2, Err: uintptr(err)}
+	if h.tamper != nil {
+		h.tamper(&u, &res)
+	}
+	return res
+}
+
+// GosecOcall makes the system call trap with the arguments a as the enclave
+// does, with an untrusted side on which tamper, if not nil, changes the
+// untrusted memory of the arguments u and the results res.
+func GosecOcall(trap uintptr, a [6]uintptr, tamper func(u *[6]uintptr, res *runtime.OcallRes)) (r1, r2 uintptr, err Errno) {
+	h := &tamperHost{bufs: make(map[uintptr][]byte), tamper: tamper}
+	defer func(old sysHost) {
+		host = old
+		if len(h.bufs) != 0 {
+			panic("tamperHost: untrusted memory not freed")
+		}
+	}(host)
+	host = h
+	return ocall(runtime.S6, trap, a)
+}
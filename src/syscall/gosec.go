@@ -13,199 +13,255 @@ func memcpy(dest, source, l uintptr) {
 	}
 }
 
+// strlen returns the length of the NUL-terminated string at p.
+func strlen(p uintptr) uintptr {
+	n := uintptr(0)
+	for *(*byte)(unsafe.Pointer(p + n)) != 0 {
+		n++
+	}
+	return n
+}
+
+// Flags of the pointer arguments of the system calls of the enclave.
+const (
+	argIn  = 1 << iota // copied to the untrusted memory before the call.
+	argOut             // copied back to the enclave after a successful call.
+	argStr             // a NUL-terminated string, copied in.
+	argRes             // only the first r1 bytes are copied back.
+)
+
+// sysArg describes a pointer argument of a system call.
+type sysArg struct {
+	idx    int8    // index of the argument, from 0.
+	flags  uint8   // argIn, argOut, argStr, argRes.
+	lenArg int8    // index of the argument that holds the size, 0 if none.
+	size   uintptr // size of the memory, if neither lenArg nor argStr.
+	check  uint8   // check of the memory copied back, see checkArg.
+}
+
+// sysDesc describes a system call the enclave forwards to the untrusted side.
+type sysDesc struct {
+	allowed bool
+	res     uint8 // check of r1, see checkRes.
+	args    []sysArg
+}
+
+const (
+	sysGetrandom = 318 // missing from zsysnum_linux_amd64.go.
+
+	sizeofSocklen = unsafe.Sizeof(_Socklen(0))
+	sizeofStat    = unsafe.Sizeof(Stat_t{})
+	sizeofStatfs  = unsafe.Sizeof(Statfs_t{})
+)
+
+var sockAddr = []sysArg{
+	{idx: 1, flags: argIn | argOut, size: SizeofSockaddrAny},
+	{idx: 2, flags: argIn | argOut, size: sizeofSocklen, check: chkSocklen},
+}
+
+// sysTable describes, for the system calls the enclave can make, how their
+// pointer arguments are copied in and out of the untrusted memory, and how
+// their results are checked. The pointer arguments that are nil, or of size
+// 0, are passed as 0.
+var sysTable = [...]sysDesc{
+	// Files and directories.
+	SYS_READ:      {true, resLen, []sysArg{{idx: 1, flags: argOut | argRes, lenArg: 2}}},
+	SYS_WRITE:     {true, resLen, []sysArg{{idx: 1, flags: argIn, lenArg: 2}}},
+	SYS_PREAD64:   {true, resLen, []sysArg{{idx: 1, flags: argOut | argRes, lenArg: 2}}},
+	SYS_PWRITE64:  {true, resLen, []sysArg{{idx: 1, flags: argIn, lenArg: 2}}},
+	SYS_OPENAT:    {true, resFd, []sysArg{{idx: 1, flags: argStr}}},
+	SYS_CLOSE:     {true, resZero, nil},
+	SYS_FCNTL:     {true, resAny, nil},
+	SYS_LSEEK:     {true, resOff, nil},
+	SYS_FSYNC:     {true, resZero, nil},
+	SYS_FDATASYNC: {true, resZero, nil},
+	SYS_FTRUNCATE: {true, resZero, nil},
+	SYS_FCHMOD:    {true, resZero, nil},
+	SYS_FCHOWN:    {true, resZero, nil},
+	SYS_FCHDIR:    {true, resZero, nil},
+	SYS_FLOCK:     {true, resZero, nil},
+	SYS_DUP:       {true, resFd, nil},
+	SYS_DUP2:      {true, resFd, nil},
+	SYS_DUP3:      {true, resFd, nil},
+	SYS_FALLOCATE: {true, resZero, nil},
+	SYS_FSTAT:     {true, resZero, []sysArg{{idx: 1, flags: argOut, size: sizeofStat, check: chkStat}}},
+	SYS_FSTATFS:   {true, resZero, []sysArg{{idx: 1, flags: argOut, size: sizeofStatfs}}},
+	SYS_NEWFSTATAT: {true, resZero, []sysArg{
+		{idx: 1, flags: argStr},
+		{idx: 2, flags: argOut, size: sizeofStat, check: chkStat},
+	}},
+	SYS_LSTAT: {true, resZero, []sysArg{
+		{idx: 0, flags: argStr},
+		{idx: 1, flags: argOut, size: sizeofStat, check: chkStat},
+	}},
+	SYS_STATFS: {true, resZero, []sysArg{
+		{idx: 0, flags: argStr},
+		{idx: 1, flags: argOut, size: sizeofStatfs},
+	}},
+	SYS_GETDENTS64: {true, resLen, []sysArg{{idx: 1, flags: argOut | argRes, lenArg: 2, check: chkDirents}}},
+	SYS_GETCWD:     {true, resLen, []sysArg{{idx: 0, flags: argOut | argRes, lenArg: 1, check: chkCString}}},
+	SYS_READLINKAT: {true, resLen, []sysArg{
+		{idx: 2, flags: argOut | argRes, lenArg: 3},
+		{idx: 1, flags: argStr},
+	}},
+	SYS_MKDIRAT:   {true, resZero, []sysArg{{idx: 1, flags: argStr}}},
+	SYS_MKNODAT:   {true, resZero, []sysArg{{idx: 1, flags: argStr}}},
+	SYS_UNLINKAT:  {true, resZero, []sysArg{{idx: 1, flags: argStr}}},
+	SYS_FACCESSAT: {true, resZero, []sysArg{{idx: 1, flags: argStr}}},
+	SYS_FCHMODAT:  {true, resZero, []sysArg{{idx: 1, flags: argStr}}},
+	SYS_FCHOWNAT:  {true, resZero, []sysArg{{idx: 1, flags: argStr}}},
+	SYS_CHDIR:     {true, resZero, []sysArg{{idx: 0, flags: argStr}}},
+	SYS_TRUNCATE:  {true, resZero, []sysArg{{idx: 0, flags: argStr}}},
+	SYS_RENAMEAT: {true, resZero, []sysArg{
+		{idx: 1, flags: argStr},
+		{idx: 3, flags: argStr},
+	}},
+	SYS_LINKAT: {true, resZero, []sysArg{
+		{idx: 1, flags: argStr},
+		{idx: 3, flags: argStr},
+	}},
+	SYS_SYMLINKAT: {true, resZero, []sysArg{
+		{idx: 0, flags: argStr},
+		{idx: 2, flags: argStr},
+	}},
+	SYS_UTIMENSAT: {true, resZero, []sysArg{
+		{idx: 1, flags: argStr},
+		{idx: 2, flags: argIn, size: 2 * unsafe.Sizeof(Timespec{})},
+	}},
+
+	// Sockets.
+	SYS_SOCKET:      {true, resFd, nil},
+	SYS_LISTEN:      {true, resZero, nil},
+	SYS_SHUTDOWN:    {true, resZero, nil},
+	SYS_BIND:        {true, resZero, []sysArg{{idx: 1, flags: argIn, lenArg: 2}}},
+	SYS_CONNECT:     {true, resZero, []sysArg{{idx: 1, flags: argIn, lenArg: 2}}},
+	SYS_ACCEPT:      {true, resFd, sockAddr},
+	SYS_ACCEPT4:     {true, resFd, sockAddr},
+	SYS_GETSOCKNAME: {true, resZero, sockAddr},
+	SYS_SETSOCKOPT:  {true, resZero, []sysArg{{idx: 3, flags: argIn, lenArg: 4}}},
+	SYS_SENDTO: {true, resLen, []sysArg{
+		{idx: 1, flags: argIn, lenArg: 2},
+		{idx: 4, flags: argIn, lenArg: 5},
+	}},
+	SYS_RECVFROM: {true, resLen, []sysArg{
+		{idx: 1, flags: argOut | argRes, lenArg: 2},
+		{idx: 4, flags: argIn | argOut, size: SizeofSockaddrAny},
+		{idx: 5, flags: argIn | argOut, size: sizeofSocklen, check: chkSocklen},
+	}},
+
+	// Misc.
+	SYS_GETUID: {true, resAny, nil},
+
+	// The untrusted side would choose the random bytes: crypto/rand draws
+	// them from the cpu in the enclave, see crypto/rand/rand_enclave.go.
+	sysGetrandom: {false, resAny, nil},
+}
+
+// sizeIn returns the size of the memory of the argument arg in a.
+func (arg *sysArg) sizeIn(a *[6]uintptr) uintptr {
+	switch {
+	case arg.flags&argStr != 0:
+		return strlen(a[arg.idx]) + 1
+	case arg.lenArg != 0:
+		return a[arg.lenArg]
+	}
+	return arg.size
+}
+
+// sysHost is the untrusted side of the system calls of the enclave: it
+// allocates the untrusted memory of the arguments and makes the calls.
+type sysHost interface {
+	malloc(n uintptr) uintptr
+	free(p, n uintptr)
+	call(tpe runtime.SysType, trap uintptr, u [6]uintptr) runtime.OcallRes
+}
+
+// cooprtHost forwards the system calls through the cooperative runtime.
+type cooprtHost struct{}
+
+func (cooprtHost) malloc(n uintptr) uintptr { return runtime.UnsafeAllocator.Malloc(n) }
+
+func (cooprtHost) free(p, n uintptr) { runtime.UnsafeAllocator.Free(p, n) }
+
+func (cooprtHost) call(tpe runtime.SysType, trap uintptr, u [6]uintptr) runtime.OcallRes {
+	return runtime.Cooprt.SysRequest(runtime.OcallReq{tpe, trap, u[0], u[1], u[2], u[3], u[4], u[5], 0})
+}
+
+// host is replaced in tests by one that returns malicious results.
+var host sysHost = cooprtHost{}
+
+// ocall forwards the system call trap to the untrusted side, which makes it
+// with the function of type tpe. The pointer arguments are replaced by
+// copies in the untrusted memory, as described by sysTable. The results are
+// checked once in the enclave, and the call panics with an *IagoError if the
+// untrusted side returned results the enclave cannot have asked for.
+func ocall(tpe runtime.SysType, trap uintptr, a [6]uintptr) (r1, r2 uintptr, err Errno) {
+	if trap >= uintptr(len(sysTable)) || !sysTable[trap].allowed {
+		panic("unsupported system call in the enclave.")
+	}
+	desc := &sysTable[trap]
+	u := a
+	var sizes [6]uintptr
+	for i := range desc.args {
+		arg := &desc.args[i]
+		if a[arg.idx] == 0 {
+			continue
+		}
+		n := arg.sizeIn(&a)
+		u[arg.idx] = 0
+		if n == 0 {
+			continue
+		}
+		u[arg.idx] = host.malloc(n)
+		sizes[arg.idx] = n
+		if arg.flags&(argIn|argStr) != 0 {
+			memcpy(u[arg.idx], a[arg.idx], n)
+		}
+	}
+
+	res := host.call(tpe, trap, u)
+	r1, r2, err = res.R1, res.R2, Errno(res.Err)
+	reason := checkRes(desc, &a, r1, err)
+
+	for i := range desc.args {
+		arg := &desc.args[i]
+		n := sizes[arg.idx]
+		if n == 0 {
+			continue
+		}
+		if arg.flags&argOut != 0 && err == 0 && reason == "" {
+			m := n
+			if arg.flags&argRes != 0 {
+				m = r1
+			}
+			memcpy(a[arg.idx], u[arg.idx], m)
+			reason = checkArg(arg, a[arg.idx], m)
+		}
+		host.free(u[arg.idx], n)
+	}
+	if reason != "" {
+		panic(&IagoError{Trap: trap, Reason: reason})
+	}
+	return
+}
+
 func Syscall(trap, a1, a2, a3 uintptr) (r1, r2 uintptr, err Errno) {
 	if runtime.IsEnclave() {
-		syscid, csys := runtime.Cooprt.AcquireSysPool()
-		_tpe := runtime.S3
-		switch trap {
-		case SYS_FSTAT:
-			sstat := unsafe.Sizeof(Stat_t{})
-			statbuf := runtime.UnsafeAllocator.Malloc(sstat)
-			memcpy(statbuf, a2, sstat)
-			req := runtime.OcallReq{_tpe, trap, a1, statbuf, a3, 0, 0, 0, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			//copy back the results
-			memcpy(a2, statbuf, sstat)
-			runtime.UnsafeAllocator.Free(statbuf, sstat)
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-		case SYS_CLOSE:
-			req := runtime.OcallReq{_tpe, trap, a1, a2, a3, 0, 0, 0, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-		case SYS_FCNTL:
-			req := runtime.OcallReq{_tpe, trap, a1, a2, a3, 0, 0, 0, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-		case SYS_WRITE:
-			destptr := runtime.UnsafeAllocator.Malloc(a3)
-			memcpy(destptr, a2, a3)
-			req := runtime.OcallReq{_tpe, trap, a1, destptr, a3, 0, 0, 0, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			runtime.UnsafeAllocator.Free(destptr, a3)
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-		case uintptr(318):
-			destptr := runtime.UnsafeAllocator.Malloc(a2)
-			req := runtime.OcallReq{_tpe, trap, destptr, a2, a3, 0, 0, 0, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			memcpy(destptr, a1, a2)
-			runtime.UnsafeAllocator.Free(destptr, a2)
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-		case SYS_GETUID:
-			req := runtime.OcallReq{_tpe, trap, a1, a2, a3, 0, 0, 0, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-		// For network part.
-		case SYS_BIND:
-			sockaddr := runtime.UnsafeAllocator.Malloc(a3)
-			memcpy(sockaddr, a2, a3)
-			req := runtime.OcallReq{_tpe, trap, a1, sockaddr, a3, 0, 0, 0, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			runtime.UnsafeAllocator.Free(sockaddr, a3)
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-		case SYS_LISTEN:
-			req := runtime.OcallReq{_tpe, trap, a1, a2, a3, 0, 0, 0, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-		case SYS_ACCEPT:
-			// copy the sockaddr
-			sasize := unsafe.Sizeof(*(*RawSockaddrAny)(unsafe.Pointer(a2)))
-			sockaddr := runtime.UnsafeAllocator.Malloc(sasize)
-			memcpy(sockaddr, a2, sasize)
-			//copy the socklen
-			slsize := unsafe.Sizeof(*(*_Socklen)(unsafe.Pointer(a3)))
-			socklen := runtime.UnsafeAllocator.Malloc(slsize)
-			memcpy(socklen, a3, slsize)
-			req := runtime.OcallReq{_tpe, trap, a1, sockaddr, socklen, 0, 0, 0, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-			// copy back
-			memcpy(a2, sockaddr, sasize)
-			memcpy(a3, socklen, slsize)
-			// free
-			runtime.UnsafeAllocator.Free(sockaddr, sasize)
-			runtime.UnsafeAllocator.Free(socklen, slsize)
-		case SYS_READ:
-			buf := runtime.UnsafeAllocator.Malloc(a3)
-			req := runtime.OcallReq{_tpe, trap, a1, buf, a3, 0, 0, 0, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-			// copy back
-			memcpy(a2, buf, a3)
-			// free
-			runtime.UnsafeAllocator.Free(buf, a3)
-		default:
-			panic("unsupported system call.")
-		}
-		runtime.Cooprt.ReleaseSysPool(syscid)
-		return
+		return ocall(runtime.S3, trap, [6]uintptr{a1, a2, a3})
 	}
 	return SSyscall(trap, a1, a2, a3)
 }
 
 func SRawSyscall(trap, a1, a2, a3 uintptr) (r1, r2 uintptr, err Errno) {
 	if runtime.IsEnclave() {
-		syscid, csys := runtime.Cooprt.AcquireSysPool()
-		_tpe := runtime.RS3
-		switch trap {
-		case SYS_SOCKET:
-			req := runtime.OcallReq{_tpe, trap, a1, a2, a3, 0, 0, 0, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-		case SYS_GETSOCKNAME:
-			ssockaddr := unsafe.Sizeof(RawSockaddrAny{})
-			var _bof _Socklen = 0
-			ssocklen := unsafe.Sizeof(_bof)
-			addr := runtime.UnsafeAllocator.Malloc(ssockaddr)
-			slen := runtime.UnsafeAllocator.Malloc(ssocklen)
-			// copy to
-			memcpy(addr, a2, ssockaddr)
-			memcpy(slen, a3, ssocklen)
-			req := runtime.OcallReq{_tpe, trap, a1, addr, slen, 0, 0, 0, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-			//copy back
-			memcpy(a2, addr, ssockaddr)
-			memcpy(a3, slen, ssocklen)
-			runtime.UnsafeAllocator.Free(addr, ssockaddr)
-			runtime.UnsafeAllocator.Free(slen, ssocklen)
-		default:
-			panic("Not yet implemented")
-		}
-		runtime.Cooprt.ReleaseSysPool(syscid)
-		return
+		return ocall(runtime.RS3, trap, [6]uintptr{a1, a2, a3})
 	}
 	panic("Not the enclave, should never have come here.")
 }
 
 func Syscall6(trap, a1, a2, a3, a4, a5, a6 uintptr) (r1, r2 uintptr, err Errno) {
 	if runtime.IsEnclave() {
-		syscid, csys := runtime.Cooprt.AcquireSysPool()
-		_tpe := runtime.S6
-		switch trap {
-		case SYS_ACCEPT4:
-			// copy the sockaddr
-			sasize := unsafe.Sizeof(*(*RawSockaddrAny)(unsafe.Pointer(a2)))
-			sockaddr := runtime.UnsafeAllocator.Malloc(sasize)
-			memcpy(sockaddr, a2, sasize)
-			//copy the socklen
-			slsize := unsafe.Sizeof(*(*_Socklen)(unsafe.Pointer(a3)))
-			socklen := runtime.UnsafeAllocator.Malloc(slsize)
-			memcpy(socklen, a3, slsize)
-			req := runtime.OcallReq{_tpe, trap, a1, sockaddr, socklen, a4, a5, a6, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-			// copy back
-			memcpy(a2, sockaddr, sasize)
-			memcpy(a3, socklen, slsize)
-			// free
-			runtime.UnsafeAllocator.Free(sockaddr, sasize)
-			runtime.UnsafeAllocator.Free(socklen, slsize)
-		case SYS_SETSOCKOPT:
-			// Copy the a4 (opt pointer)
-			opt := runtime.UnsafeAllocator.Malloc(a5)
-			memcpy(opt, a4, a5)
-			req := runtime.OcallReq{_tpe, trap, a1, a2, a3, opt, a5, a6, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-			runtime.UnsafeAllocator.Free(opt, a5)
-		case SYS_SENDTO:
-			buf := runtime.UnsafeAllocator.Malloc(a3)
-			memcpy(buf, a2, a3)
-			sockaddr := runtime.UnsafeAllocator.Malloc(a6)
-			memcpy(sockaddr, a5, a6)
-			req := runtime.OcallReq{_tpe, trap, a1, buf, a3, a4, sockaddr, a6, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-			runtime.UnsafeAllocator.Free(buf, a3)
-			runtime.UnsafeAllocator.Free(sockaddr, a6)
-		case SYS_OPENAT:
-			buf := runtime.UnsafeAllocator.Malloc(a6 + 1)
-			memcpy(buf, a2, a6+1)
-			req := runtime.OcallReq{_tpe, trap, a1, buf, a3, a4, a5, 0, syscid}
-			runtime.Cooprt.Ocall <- req
-			res := <-csys
-			r1, r2, err = res.R1, res.R2, Errno(res.Err)
-			runtime.UnsafeAllocator.Free(buf, a6+1)
-		default:
-			panic("Unallowed system call inside the enclave.")
-		}
-		runtime.Cooprt.ReleaseSysPool(syscid)
-		return
+		return ocall(runtime.S6, trap, [6]uintptr{a1, a2, a3, a4, a5, a6})
 	}
 	return SSyscall6(trap, a1, a2, a3, a4, a5, a6)
 }
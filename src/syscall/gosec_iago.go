@@ -0,0 +1,120 @@
+package syscall
+
+import "unsafe"
+
+// An IagoError is the value of the panic of a system call of the enclave when
+// the untrusted side returns results that a correct kernel cannot return, for
+// instance more bytes than were asked for. The enclave must not go on with
+// such results, so these calls abort instead of returning an error.
+type IagoError struct {
+	Trap   uintptr // the system call.
+	Reason string
+}
+
+func (e *IagoError) Error() string {
+	return "gosec: untrusted result of system call " + itoa(int(e.Trap)) + ": " + e.Reason
+}
+
+// maxErrno is the largest errno the kernel returns.
+const maxErrno = 4095
+
+// Checks of r1, the result of a successful system call.
+const (
+	resAny  = iota // any value.
+	resZero        // 0.
+	resFd          // a file descriptor.
+	resLen         // at most the size of the first pointer argument.
+	resOff         // a file offset.
+)
+
+// Checks of the memory of the pointer arguments copied back.
+const (
+	chkNone    = iota
+	chkStat    // a Stat_t.
+	chkSocklen // the length of a RawSockaddrAny.
+	chkDirents // the Dirents returned by getdents64.
+	chkCString // a NUL-terminated string.
+)
+
+// checkRes returns why r1 and err, the results of the system call of desc
+// with the arguments a, are invalid, or "".
+func checkRes(desc *sysDesc, a *[6]uintptr, r1 uintptr, err Errno) string {
+	if err != 0 {
+		if err > maxErrno {
+			return "invalid errno " + uitoa(uint(err))
+		}
+		return ""
+	}
+	switch desc.res {
+	case resZero:
+		if r1 != 0 {
+			return "non-zero result " + uitoa(uint(r1))
+		}
+	case resFd:
+		if r1 > 1<<31-1 {
+			return "invalid file descriptor " + uitoa(uint(r1))
+		}
+	case resLen:
+		if r1 > a[desc.args[0].lenArg] {
+			return "result " + uitoa(uint(r1)) + " larger than the size " + uitoa(uint(a[desc.args[0].lenArg]))
+		}
+	case resOff:
+		if int64(r1) < 0 {
+			return "negative offset"
+		}
+	}
+	return ""
+}
+
+// checkArg returns why the n bytes at p, copied back for the argument arg,
+// are invalid, or "".
+func checkArg(arg *sysArg, p, n uintptr) string {
+	switch arg.check {
+	case chkStat:
+		st := (*Stat_t)(unsafe.Pointer(p))
+		switch st.Mode & S_IFMT {
+		case S_IFBLK, S_IFCHR, S_IFDIR, S_IFIFO, S_IFLNK, S_IFREG, S_IFSOCK:
+		default:
+			return "invalid file mode " + uitoa(uint(st.Mode))
+		}
+		if st.Size < 0 || st.Blocks < 0 || st.Blksize < 0 {
+			return "negative size"
+		}
+	case chkSocklen:
+		if l := *(*_Socklen)(unsafe.Pointer(p)); uintptr(l) > SizeofSockaddrAny {
+			return "socket address of " + uitoa(uint(l)) + " bytes"
+		}
+	case chkDirents:
+		const nameOff = unsafe.Offsetof(Dirent{}.Name)
+		for off := uintptr(0); off < n; {
+			if n-off < nameOff {
+				return "truncated directory entry"
+			}
+			reclen := uintptr((*Dirent)(unsafe.Pointer(p + off)).Reclen)
+			if reclen <= nameOff || reclen > n-off {
+				return "directory entry of " + uitoa(uint(reclen)) + " bytes"
+			}
+			name := p + off + nameOff
+			if strnlen(name, reclen-nameOff) == reclen-nameOff {
+				return "unterminated directory entry name"
+			}
+			off += reclen
+		}
+	case chkCString:
+		if n == 0 || *(*byte)(unsafe.Pointer(p + n - 1)) != 0 {
+			return "unterminated string"
+		}
+	}
+	return ""
+}
+
+// strnlen returns the length of the NUL-terminated string at p, or max if
+// there is no NUL in its first max bytes.
+func strnlen(p, max uintptr) uintptr {
+	for i := uintptr(0); i < max; i++ {
+		if *(*byte)(unsafe.Pointer(p + i)) == 0 {
+			return i
+		}
+	}
+	return max
+}
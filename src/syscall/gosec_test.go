@@ -0,0 +1,202 @@
+package syscall_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestGosecSysTable(t *testing.T) {
+	if err := syscall.CheckGosecSysTable(); err != "" {
+		t.Error(err)
+	}
+}
+
+func TestGosecArgSize(t *testing.T) {
+	path := []byte("/tmp/enclave\x00")
+	var st syscall.Stat_t
+	a := [6]uintptr{0, uintptr(unsafe.Pointer(&path[0])), uintptr(unsafe.Pointer(&st))}
+	if n := syscall.GosecArgSize(syscall.SYS_NEWFSTATAT, 0, a); n != uintptr(len(path)) {
+		t.Errorf("size of the path: %d, want %d", n, len(path))
+	}
+	if n := syscall.GosecArgSize(syscall.SYS_NEWFSTATAT, 1, a); n != unsafe.Sizeof(st) {
+		t.Errorf("size of the stat: %d, want %d", n, unsafe.Sizeof(st))
+	}
+	buf := make([]byte, 42)
+	a = [6]uintptr{0, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf))}
+	if n := syscall.GosecArgSize(syscall.SYS_READ, 0, a); n != uintptr(len(buf)) {
+		t.Errorf("size of the buffer: %d, want %d", n, len(buf))
+	}
+}
+
+// gosecOcall makes the system call trap as the enclave does, and returns the
+// panic of the call, if any.
+func gosecOcall(trap uintptr, a [6]uintptr, tamper func(u *[6]uintptr, res *runtime.OcallRes)) (r1 uintptr, err syscall.Errno, perr interface{}) {
+	defer func() { perr = recover() }()
+	r1, _, err = syscall.GosecOcall(trap, a, tamper)
+	return
+}
+
+func ptr(p unsafe.Pointer) uintptr { return uintptr(p) }
+
+func TestGosecOcallHonest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := []byte(filepath.Join(dir, "file") + "\x00")
+	r1, errno, perr := gosecOcall(syscall.SYS_OPENAT, [6]uintptr{_AT_FDCWD, ptr(unsafe.Pointer(&path[0])), syscall.O_RDONLY}, nil)
+	if errno != 0 || perr != nil {
+		t.Fatalf("openat: %v, %v", errno, perr)
+	}
+	fd := int(r1)
+	defer syscall.Close(fd)
+
+	buf := make([]byte, 10)
+	r1, errno, perr = gosecOcall(syscall.SYS_READ, [6]uintptr{uintptr(fd), ptr(unsafe.Pointer(&buf[0])), uintptr(len(buf))}, nil)
+	if errno != 0 || perr != nil || string(buf[:r1]) != "hello" {
+		t.Errorf("read: %q, %v, %v", buf[:r1], errno, perr)
+	}
+
+	var st syscall.Stat_t
+	_, errno, perr = gosecOcall(syscall.SYS_FSTAT, [6]uintptr{uintptr(fd), ptr(unsafe.Pointer(&st))}, nil)
+	if errno != 0 || perr != nil || st.Size != 5 || st.Mode&syscall.S_IFMT != syscall.S_IFREG {
+		t.Errorf("fstat: %+v, %v, %v", st, errno, perr)
+	}
+
+	dfd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(dfd)
+	dents := make([]byte, 4096)
+	r1, errno, perr = gosecOcall(syscall.SYS_GETDENTS64, [6]uintptr{uintptr(dfd), ptr(unsafe.Pointer(&dents[0])), uintptr(len(dents))}, nil)
+	if errno != 0 || perr != nil {
+		t.Fatalf("getdents64: %v, %v", errno, perr)
+	}
+	_, _, names := syscall.ParseDirent(dents[:r1], -1, nil)
+	if len(names) != 1 || names[0] != "file" {
+		t.Errorf("getdents64: %v", names)
+	}
+
+	// Errors go through.
+	missing := []byte(filepath.Join(dir, "missing") + "\x00")
+	_, errno, perr = gosecOcall(syscall.SYS_NEWFSTATAT, [6]uintptr{_AT_FDCWD, ptr(unsafe.Pointer(&missing[0])), ptr(unsafe.Pointer(&st))}, nil)
+	if errno != syscall.ENOENT || perr != nil {
+		t.Errorf("newfstatat: %v, %v", errno, perr)
+	}
+}
+
+func TestGosecOcallGetrandom(t *testing.T) {
+	buf := make([]byte, 16)
+	_, _, perr := gosecOcall(318, [6]uintptr{ptr(unsafe.Pointer(&buf[0])), uintptr(len(buf))}, nil)
+	if perr == nil {
+		t.Errorf("getrandom was forwarded to the untrusted side")
+	}
+}
+
+const _AT_FDCWD = ^uintptr(99) // -0x64
+
+func TestGosecOcallIago(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := []byte(filepath.Join(dir, "file") + "\x00")
+	fd, err := syscall.Open(dir+"/file", syscall.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	dfd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(dfd)
+	sfd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(sfd)
+
+	buf := make([]byte, 10)
+	dents := make([]byte, 4096)
+	cwd := make([]byte, 4096)
+	var st syscall.Stat_t
+	var rsa syscall.RawSockaddrAny
+	rsalen := uint32(unsafe.Sizeof(rsa))
+	readArgs := [6]uintptr{uintptr(fd), ptr(unsafe.Pointer(&buf[0])), uintptr(len(buf))}
+	statArgs := [6]uintptr{uintptr(fd), ptr(unsafe.Pointer(&st))}
+	for _, tt := range []struct {
+		name   string
+		trap   uintptr
+		a      [6]uintptr
+		tamper func(u *[6]uintptr, res *runtime.OcallRes)
+	}{
+		{"read too much", syscall.SYS_READ, readArgs, func(u *[6]uintptr, res *runtime.OcallRes) {
+			res.R1 = uintptr(len(buf)) + 1
+		}},
+		{"invalid errno", syscall.SYS_READ, readArgs, func(u *[6]uintptr, res *runtime.OcallRes) {
+			res.R1, res.Err = ^uintptr(0), 5000
+		}},
+		{"negative offset", syscall.SYS_LSEEK, [6]uintptr{uintptr(fd), 0, 0}, func(u *[6]uintptr, res *runtime.OcallRes) {
+			res.R1 = 1 << 63
+		}},
+		{"fd", syscall.SYS_OPENAT, [6]uintptr{_AT_FDCWD, ptr(unsafe.Pointer(&path[0])), syscall.O_RDONLY}, func(u *[6]uintptr, res *runtime.OcallRes) {
+			syscall.Close(int(res.R1))
+			res.R1 = 1 << 40
+		}},
+		{"stat mode", syscall.SYS_FSTAT, statArgs, func(u *[6]uintptr, res *runtime.OcallRes) {
+			(*syscall.Stat_t)(unsafe.Pointer(u[1])).Mode = 0
+		}},
+		{"stat size", syscall.SYS_FSTAT, statArgs, func(u *[6]uintptr, res *runtime.OcallRes) {
+			(*syscall.Stat_t)(unsafe.Pointer(u[1])).Size = -1
+		}},
+		{"stat result", syscall.SYS_FSTAT, statArgs, func(u *[6]uintptr, res *runtime.OcallRes) {
+			res.R1 = 3
+		}},
+		{"dirent reclen", syscall.SYS_GETDENTS64, [6]uintptr{uintptr(dfd), ptr(unsafe.Pointer(&dents[0])), uintptr(len(dents))}, func(u *[6]uintptr, res *runtime.OcallRes) {
+			(*syscall.Dirent)(unsafe.Pointer(u[1])).Reclen = 0xffff
+		}},
+		{"dirent name", syscall.SYS_GETDENTS64, [6]uintptr{uintptr(dfd), ptr(unsafe.Pointer(&dents[0])), uintptr(len(dents))}, func(u *[6]uintptr, res *runtime.OcallRes) {
+			d := (*syscall.Dirent)(unsafe.Pointer(u[1]))
+			for i := unsafe.Offsetof(d.Name); i < uintptr(d.Reclen); i++ {
+				*(*byte)(unsafe.Pointer(u[1] + i)) = 'x'
+			}
+		}},
+		{"cwd", syscall.SYS_GETCWD, [6]uintptr{ptr(unsafe.Pointer(&cwd[0])), uintptr(len(cwd))}, func(u *[6]uintptr, res *runtime.OcallRes) {
+			*(*byte)(unsafe.Pointer(u[0] + res.R1 - 1)) = 'x'
+		}},
+		{"socklen", syscall.SYS_GETSOCKNAME, [6]uintptr{uintptr(sfd), ptr(unsafe.Pointer(&rsa)), ptr(unsafe.Pointer(&rsalen))}, func(u *[6]uintptr, res *runtime.OcallRes) {
+			*(*uint32)(unsafe.Pointer(u[2])) = 1000
+		}},
+	} {
+		syscall.Seek(fd, 0, 0)
+		syscall.Seek(dfd, 0, 0)
+		_, _, perr := gosecOcall(tt.trap, tt.a, tt.tamper)
+		if e, ok := perr.(*syscall.IagoError); !ok || e.Trap != tt.trap {
+			t.Errorf("%s: panic %v, want an IagoError", tt.name, perr)
+		}
+	}
+
+	// The enclave cannot tell forged data from real data.
+	syscall.Seek(fd, 0, 0)
+	r1, errno, perr := gosecOcall(syscall.SYS_READ, readArgs, func(u *[6]uintptr, res *runtime.OcallRes) {
+		*(*byte)(unsafe.Pointer(u[1])) = 'j'
+	})
+	if perr != nil || errno != 0 || string(buf[:r1]) != "jello" {
+		t.Errorf("read: %q, %v, %v", buf[:r1], errno, perr)
+	}
+}